@@ -1,19 +1,33 @@
 package main
 
 import (
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"io/fs"
+	"net/url"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/JeremyProffitt/go-mcp-file-context-server/pkg/analysis"
+	"github.com/JeremyProffitt/go-mcp-file-context-server/pkg/backup"
+	"github.com/JeremyProffitt/go-mcp-file-context-server/pkg/batch"
 	"github.com/JeremyProffitt/go-mcp-file-context-server/pkg/cache"
+	"github.com/JeremyProffitt/go-mcp-file-context-server/pkg/extract"
 	"github.com/JeremyProffitt/go-mcp-file-context-server/pkg/files"
+	"github.com/JeremyProffitt/go-mcp-file-context-server/pkg/index"
 	"github.com/JeremyProffitt/go-mcp-file-context-server/pkg/logging"
 	"github.com/JeremyProffitt/go-mcp-file-context-server/pkg/mcp"
+	"github.com/JeremyProffitt/go-mcp-file-context-server/pkg/policy"
+	"github.com/JeremyProffitt/go-mcp-file-context-server/pkg/secrets"
+	"github.com/JeremyProffitt/go-mcp-file-context-server/pkg/semantic"
+	"github.com/JeremyProffitt/go-mcp-file-context-server/pkg/tokens"
 	"github.com/bmatcuk/doublestar/v4"
 )
 
@@ -33,6 +47,39 @@ const (
 	EnvRootDir         = "MCP_ROOT_DIR"
 	EnvBlockedPatterns = "MCP_BLOCKED_PATTERNS"
 	EnvAllowedPatterns = "MCP_ALLOWED_PATTERNS"
+	EnvTokenizer       = "MCP_TOKENIZER"
+	EnvTokenizerVocab  = "MCP_TOKENIZER_VOCAB"
+	EnvGitignore       = "MCP_RESPECT_GITIGNORE"
+	EnvSearchWorkers   = "MCP_SEARCH_WORKERS"
+	EnvIndexDir        = "MCP_INDEX_DIR"
+	EnvEmbeddingURL    = "MCP_EMBEDDING_URL"
+	EnvEmbeddingModel  = "MCP_EMBEDDING_MODEL"
+	EnvEmbeddingRemote = "MCP_EMBEDDING_ALLOW_REMOTE"
+	EnvBackupDir       = "MCP_BACKUP_DIR"
+	EnvBackupMaxAge    = "MCP_BACKUP_MAX_AGE"
+	EnvBackupMaxSize   = "MCP_BACKUP_MAX_SIZE"
+	EnvReadOnly        = "MCP_READ_ONLY"
+	EnvSymlinkPolicy   = "MCP_SYMLINK_POLICY"
+	EnvSecretPolicy    = "MCP_SECRET_POLICY"
+	EnvSecretRules     = "MCP_SECRET_RULES"
+	EnvMaxWriteSize    = "MCP_MAX_WRITE_SIZE"
+	EnvReadOnlyPaths   = "MCP_READ_ONLY_PATHS"
+	EnvWriteExtensions = "MCP_WRITE_EXTENSIONS"
+)
+
+// Symlink policies: how paths that resolve through a symlink are treated
+const (
+	SymlinkFollow      = "follow"            // follow links anywhere; only blocked patterns apply to the target
+	SymlinkDeny        = "deny"              // refuse paths that go through a symlink
+	SymlinkWithinRoots = "within-roots-only" // follow links whose targets stay inside the root directories
+)
+
+// Secret policies: what happens when content returned by a read matches a
+// secret rule
+const (
+	SecretRedact = "redact" // replace each secret with a [REDACTED:rule] marker
+	SecretRefuse = "refuse" // fail the read, naming the rules that matched
+	SecretOff    = "off"    // do not scan
 )
 
 // DefaultBlockedPatterns are blocked by default for security
@@ -51,9 +98,19 @@ var DefaultAllowedPatterns = []string{
 
 var fileCache *cache.Cache
 var logger *logging.Logger
-var allowedRootDirs []string // If set, restricts all file operations to these directories
-var blockedPatterns []string // Patterns to block access to
-var allowedPatterns []string // Patterns to allow (exceptions to blocked patterns)
+var allowedRootDirs []string        // If set, restricts all file operations to these directories
+var realRootDirs []string           // allowedRootDirs with symlinks resolved, in the same order
+var blockedPatterns []string        // Patterns to block access to
+var allowedPatterns []string        // Patterns to allow (exceptions to blocked patterns)
+var symlinkPolicy string            // How paths that go through a symlink are treated
+var secretScanner *secrets.Scanner  // Finds secrets in content returned by reads; nil if scanning is off
+var secretPolicy string             // What happens to content with secrets
+var writePolicy *policy.WritePolicy // Limits on the size, paths, extensions and secrets of writes
+var tokenEstimator tokens.Estimator // Estimates token counts for maxTokens budgets
+var searchIndex *index.Manager      // Trigram indexes that narrow search_context candidates
+var textIndexes *index.TextIndexes  // BM25 indexes for rank_files, built on first use
+var vectorIndexes *semantic.Indexes // Embedding indexes for semantic_search, built on first use
+var backups *backup.Store           // Copies of files taken before they are overwritten or deleted; nil if disabled
 
 func main() {
 	// Load environment variables from ~/.mcp_env if it exists
@@ -66,6 +123,24 @@ func main() {
 	rootDir := flag.String("root-dir", "", "Root directories to restrict file access, comma-separated (default: no restriction)")
 	blockedPatternsFlag := flag.String("blocked-patterns", "", "Patterns to block, comma-separated (default: .aws/*,.env,.mcp_env)")
 	allowedPatternsFlag := flag.String("allowed-patterns", "", "Patterns to allow (exceptions to blocked), comma-separated (default: .aws/terraform,.aws/terraform/*,.aws/terraform/**)")
+	tokenizerFlag := flag.String("tokenizer", "", "Token estimator: bytes, words, vocab (default: bytes)")
+	tokenizerVocabFlag := flag.String("tokenizer-vocab", "", "Vocabulary file for the vocab tokenizer (one token per line)")
+	gitignoreFlag := flag.String("respect-gitignore", "", "Skip paths ignored by .gitignore and .ignore files: true, false (default: true)")
+	searchWorkersFlag := flag.String("search-workers", "", "Number of files search_context scans concurrently (default: number of CPUs)")
+	indexDirFlag := flag.String("index-dir", "", "Directory for search index files (default: ~/go-mcp-file-context-server/index)")
+	embeddingURLFlag := flag.String("embedding-url", "", "Local embedding server URL for semantic_search (default: built-in offline embedder)")
+	embeddingModelFlag := flag.String("embedding-model", "", "Model name sent to the embedding server (default: nomic-embed-text)")
+	embeddingRemoteFlag := flag.String("embedding-allow-remote", "", "Allow an embedding URL that is not on this machine: true, false (default: false)")
+	backupDirFlag := flag.String("backup-dir", "", "Directory for backups of overwritten and deleted files, or off (default: ~/go-mcp-file-context-server/backups)")
+	backupMaxAgeFlag := flag.String("backup-max-age", "", "Delete backups older than this, e.g. 72h or 7d; 0 keeps them (default: 7d)")
+	backupMaxSizeFlag := flag.String("backup-max-size", "", "Total size of backups in MB before the oldest are deleted; 0 is unlimited (default: 1024)")
+	symlinkPolicyFlag := flag.String("symlink-policy", "", "How to treat symlinks: follow, deny, within-roots-only (default: within-roots-only)")
+	secretPolicyFlag := flag.String("secret-policy", "", "What to do with secrets in content returned by reads: redact, refuse, off (default: redact)")
+	secretRulesFlag := flag.String("secret-rules", "", "JSON file of secret detection rules and allowlist (default: built-in rules)")
+	maxWriteSizeFlag := flag.String("max-write-size", "", "Largest content in KB a single write may produce; 0 is unlimited (default: 10240)")
+	readOnlyPathsFlag := flag.String("read-only-paths", "", "Globs of paths writes may not change, comma-separated, or off (default: **/*.lock,.git/**,go.sum)")
+	writeExtensionsFlag := flag.String("write-extensions", "", "File extensions writes may create or change, comma-separated (default: any)")
+	readOnlyFlag := flag.Bool("read-only", false, "Serve only the tools that do not change files (default: false)")
 	httpMode := flag.Bool("http", false, "Run in HTTP mode instead of stdio")
 	httpPort := flag.Int("port", 3000, "HTTP port (only used with --http)")
 	httpHost := flag.String("host", "127.0.0.1", "HTTP host (only used with --http)")
@@ -150,7 +225,13 @@ func main() {
 				fmt.Fprintf(os.Stderr, "Root path is not a directory: %q\n", absRoot)
 				os.Exit(1)
 			}
+			realRoot, err := filepath.EvalSymlinks(absRoot)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Invalid root directory %q: %v\n", absRoot, err)
+				os.Exit(1)
+			}
 			allowedRootDirs = append(allowedRootDirs, absRoot)
+			realRootDirs = append(realRootDirs, realRoot)
 		}
 	}
 
@@ -202,6 +283,68 @@ func main() {
 		allowedPatterns = DefaultAllowedPatterns
 	}
 
+	// Resolve symlink policy (CLI flag > env var > default)
+	resolvedSymlinkPolicy, symlinkPolicySource := resolveSetting(*symlinkPolicyFlag, EnvSymlinkPolicy, SymlinkWithinRoots)
+
+	// Resolve secret scanning (CLI flag > env var > default)
+	resolvedSecretPolicy, secretPolicySource := resolveSetting(*secretPolicyFlag, EnvSecretPolicy, SecretRedact)
+	resolvedSecretRules, secretRulesSource := resolveSetting(*secretRulesFlag, EnvSecretRules, "")
+	if resolvedSecretRules != "" {
+		resolvedSecretRules = logging.ExpandPath(resolvedSecretRules)
+	}
+
+	// Resolve the write policy (CLI flag > env var > default)
+	resolvedMaxWriteSize, maxWriteSizeSource := resolveSetting(*maxWriteSizeFlag, EnvMaxWriteSize, strconv.Itoa(policy.DefaultMaxBytes/1024))
+	maxWriteSize, maxWriteSizeErr := strconv.ParseInt(resolvedMaxWriteSize, 10, 64)
+	resolvedReadOnlyPaths, readOnlyPathsSource := resolveSetting(*readOnlyPathsFlag, EnvReadOnlyPaths, strings.Join(policy.DefaultReadOnly, ","))
+	resolvedWriteExtensions, writeExtensionsSource := resolveSetting(*writeExtensionsFlag, EnvWriteExtensions, "")
+
+	// Resolve tokenizer (CLI flag > env var > default)
+	resolvedTokenizer, tokenizerSource := resolveSetting(*tokenizerFlag, EnvTokenizer, tokens.KindBytes)
+	resolvedTokenizerVocab, _ := resolveSetting(*tokenizerVocabFlag, EnvTokenizerVocab, "")
+	if resolvedTokenizerVocab != "" {
+		resolvedTokenizerVocab = logging.ExpandPath(resolvedTokenizerVocab)
+	}
+
+	// Resolve ignore file handling (CLI flag > env var > default)
+	resolvedGitignore, gitignoreSource := resolveSetting(*gitignoreFlag, EnvGitignore, "true")
+	respectGitignore, gitignoreErr := strconv.ParseBool(resolvedGitignore)
+
+	// Resolve search concurrency (CLI flag > env var > default)
+	resolvedSearchWorkers, searchWorkersSource := resolveSetting(*searchWorkersFlag, EnvSearchWorkers, strconv.Itoa(files.DefaultSearchWorkers))
+	searchWorkers, searchWorkersErr := strconv.Atoi(resolvedSearchWorkers)
+
+	// Resolve search index directory (CLI flag > env var > default)
+	resolvedIndexDir, indexDirSource := resolveSetting(*indexDirFlag, EnvIndexDir, index.DefaultDir(AppName))
+	resolvedIndexDir = logging.ExpandPath(resolvedIndexDir)
+
+	// Resolve embedding backend (CLI flag > env var > default)
+	resolvedEmbeddingURL, embeddingURLSource := resolveSetting(*embeddingURLFlag, EnvEmbeddingURL, "")
+	resolvedEmbeddingModel, _ := resolveSetting(*embeddingModelFlag, EnvEmbeddingModel, "nomic-embed-text")
+	resolvedEmbeddingRemote, _ := resolveSetting(*embeddingRemoteFlag, EnvEmbeddingRemote, "false")
+	embeddingRemote, embeddingRemoteErr := strconv.ParseBool(resolvedEmbeddingRemote)
+
+	// Resolve backup store settings (CLI flag > env var > default)
+	resolvedBackupDir, backupDirSource := resolveSetting(*backupDirFlag, EnvBackupDir, backup.DefaultDir(AppName))
+	if resolvedBackupDir != "off" {
+		resolvedBackupDir = logging.ExpandPath(resolvedBackupDir)
+	}
+	resolvedBackupMaxAge, _ := resolveSetting(*backupMaxAgeFlag, EnvBackupMaxAge, "7d")
+	backupMaxAge, backupMaxAgeErr := parseAge(resolvedBackupMaxAge)
+	resolvedBackupMaxSize, _ := resolveSetting(*backupMaxSizeFlag, EnvBackupMaxSize, strconv.Itoa(backup.DefaultMaxBytes/(1024*1024)))
+	backupMaxSize, backupMaxSizeErr := strconv.ParseInt(resolvedBackupMaxSize, 10, 64)
+
+	// Resolve read-only mode (CLI flag > env var > default). It is a plain
+	// switch on the command line, so only an explicit flag overrides the env.
+	readOnlyValue := ""
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "read-only" {
+			readOnlyValue = strconv.FormatBool(*readOnlyFlag)
+		}
+	})
+	resolvedReadOnly, readOnlySource := resolveSetting(readOnlyValue, EnvReadOnly, "false")
+	readOnly, readOnlyErr := strconv.ParseBool(resolvedReadOnly)
+
 	// Initialize logger
 	var err error
 	logger, err = logging.NewLogger(logging.Config{
@@ -250,6 +393,157 @@ func main() {
 	}
 	logger.Info("Cache initialized: size=%d, ttl=%s", DefaultCacheSize, DefaultCacheTTL)
 
+	// Initialize token estimator
+	tokenEstimator, err = tokens.NewEstimator(resolvedTokenizer, resolvedTokenizerVocab)
+	if err != nil {
+		logger.Error("Failed to initialize tokenizer: %v", err)
+		fmt.Fprintf(os.Stderr, "Failed to initialize tokenizer: %v\n", err)
+		os.Exit(1)
+	}
+	logger.Info("Tokenizer (%s): %s", tokenizerSource, tokenEstimator.Name())
+
+	// Configure ignore file handling for directory walks
+	if gitignoreErr != nil {
+		logger.Error("Invalid respect-gitignore value %q", resolvedGitignore)
+		fmt.Fprintf(os.Stderr, "Invalid respect-gitignore value %q: expected true or false\n", resolvedGitignore)
+		os.Exit(1)
+	}
+	files.DefaultWalkOptions.RespectGitignore = respectGitignore
+	// Ignore files above the allowed roots are outside the sandbox: never read them
+	files.DefaultWalkOptions.Roots = append(append([]string(nil), allowedRootDirs...), realRootDirs...)
+	logger.Info("Respect .gitignore (%s): %t", gitignoreSource, respectGitignore)
+
+	// Configure the symlink policy. Walks leave out symlinks whose targets
+	// could not be read directly.
+	switch resolvedSymlinkPolicy {
+	case SymlinkFollow, SymlinkDeny, SymlinkWithinRoots:
+		symlinkPolicy = resolvedSymlinkPolicy
+	default:
+		logger.Error("Invalid symlink-policy value %q", resolvedSymlinkPolicy)
+		fmt.Fprintf(os.Stderr, "Invalid symlink-policy value %q: expected follow, deny or within-roots-only\n", resolvedSymlinkPolicy)
+		os.Exit(1)
+	}
+	files.DefaultWalkOptions.Symlink = func(path string) bool {
+		_, err := validatePath(path)
+		return err == nil
+	}
+	logger.Info("Symlink policy (%s): %s", symlinkPolicySource, symlinkPolicy)
+
+	// Configure secret scanning of content returned by reads
+	switch resolvedSecretPolicy {
+	case SecretRedact, SecretRefuse:
+		secretScanner, err = secrets.Load(resolvedSecretRules)
+		if err != nil {
+			logger.Error("Failed to load secret rules: %v", err)
+			fmt.Fprintf(os.Stderr, "Failed to load secret rules: %v\n", err)
+			os.Exit(1)
+		}
+		rulesFile := resolvedSecretRules
+		if rulesFile == "" {
+			rulesFile = "built-in"
+		}
+		logger.Info("Secret scanning (%s): %s, %d rules from %s (%s)", secretPolicySource, resolvedSecretPolicy, secretScanner.Rules(), rulesFile, secretRulesSource)
+	case SecretOff:
+		logger.Info("Secret scanning (%s): off", secretPolicySource)
+	default:
+		logger.Error("Invalid secret-policy value %q", resolvedSecretPolicy)
+		fmt.Fprintf(os.Stderr, "Invalid secret-policy value %q: expected redact, refuse or off\n", resolvedSecretPolicy)
+		os.Exit(1)
+	}
+	secretPolicy = resolvedSecretPolicy
+
+	// Configure the write policy; writes are screened for secrets whenever
+	// reads are
+	if maxWriteSizeErr != nil || maxWriteSize < 0 {
+		logger.Error("Invalid max-write-size value %q", resolvedMaxWriteSize)
+		fmt.Fprintf(os.Stderr, "Invalid max-write-size value %q: expected a size in KB\n", resolvedMaxWriteSize)
+		os.Exit(1)
+	}
+	writePolicy = &policy.WritePolicy{
+		MaxBytes:   maxWriteSize * 1024,
+		Extensions: parseCommaSeparated(resolvedWriteExtensions),
+		Secrets:    secretScanner,
+	}
+	if resolvedReadOnlyPaths != "off" {
+		writePolicy.ReadOnly = parseCommaSeparated(resolvedReadOnlyPaths)
+	}
+	if err := writePolicy.Validate(); err != nil {
+		logger.Error("Invalid read-only-paths value %q: %v", resolvedReadOnlyPaths, err)
+		fmt.Fprintf(os.Stderr, "Invalid read-only-paths value %q: %v\n", resolvedReadOnlyPaths, err)
+		os.Exit(1)
+	}
+	writeExtensions := "any"
+	if len(writePolicy.Extensions) > 0 {
+		writeExtensions = strings.Join(writePolicy.Extensions, ",")
+	}
+	logger.Info("Max write size (%s): %dKB", maxWriteSizeSource, maxWriteSize)
+	logger.Info("Read-only paths (%s): %s", readOnlyPathsSource, resolvedReadOnlyPaths)
+	logger.Info("Write extensions (%s): %s", writeExtensionsSource, writeExtensions)
+
+	// Configure search concurrency
+	if searchWorkersErr != nil || searchWorkers < 1 {
+		logger.Error("Invalid search-workers value %q", resolvedSearchWorkers)
+		fmt.Fprintf(os.Stderr, "Invalid search-workers value %q: expected a positive integer\n", resolvedSearchWorkers)
+		os.Exit(1)
+	}
+	files.DefaultSearchWorkers = searchWorkers
+	logger.Info("Search workers (%s): %d", searchWorkersSource, searchWorkers)
+
+	// Search indexes are only read from disk once built
+	searchIndex = index.NewManager(resolvedIndexDir)
+	logger.Info("Search index directory (%s): %s", indexDirSource, resolvedIndexDir)
+	textIndexes = index.NewTextIndexes(index.BuildOptions{Walk: files.DefaultWalkOptions}, 0)
+
+	// Configure the embedding backend for semantic_search
+	if embeddingRemoteErr != nil {
+		logger.Error("Invalid embedding-allow-remote value %q", resolvedEmbeddingRemote)
+		fmt.Fprintf(os.Stderr, "Invalid embedding-allow-remote value %q: expected true or false\n", resolvedEmbeddingRemote)
+		os.Exit(1)
+	}
+	var embedder semantic.Embedder = semantic.NewHashEmbedder(0)
+	if resolvedEmbeddingURL != "" {
+		httpEmbedder, err := semantic.NewHTTPEmbedder(resolvedEmbeddingURL, resolvedEmbeddingModel, embeddingRemote)
+		if err != nil {
+			logger.Error("Failed to initialize embedder: %v", err)
+			fmt.Fprintf(os.Stderr, "Failed to initialize embedder: %v\n", err)
+			os.Exit(1)
+		}
+		if httpEmbedder.Remote() {
+			logger.Warn("Embedding server %s is not on this machine: semantic_search sends it the contents of indexed files", resolvedEmbeddingURL)
+		}
+		embedder = httpEmbedder
+	}
+	vectorIndexes = semantic.NewIndexes(resolvedIndexDir, embedder, semantic.Options{Walk: files.DefaultWalkOptions})
+	logger.Info("Embedder (%s): %s", embeddingURLSource, embedder.Name())
+
+	// Configure the backup store
+	if backupMaxAgeErr != nil || backupMaxAge < 0 {
+		logger.Error("Invalid backup-max-age value %q", resolvedBackupMaxAge)
+		fmt.Fprintf(os.Stderr, "Invalid backup-max-age value %q: expected a duration such as 72h or 7d\n", resolvedBackupMaxAge)
+		os.Exit(1)
+	}
+	if backupMaxSizeErr != nil || backupMaxSize < 0 {
+		logger.Error("Invalid backup-max-size value %q", resolvedBackupMaxSize)
+		fmt.Fprintf(os.Stderr, "Invalid backup-max-size value %q: expected a size in MB\n", resolvedBackupMaxSize)
+		os.Exit(1)
+	}
+	if readOnlyErr != nil {
+		logger.Error("Invalid read-only value %q", resolvedReadOnly)
+		fmt.Fprintf(os.Stderr, "Invalid read-only value %q: expected true or false\n", resolvedReadOnly)
+		os.Exit(1)
+	}
+	if resolvedBackupDir == "off" {
+		logger.Info("Backups (%s): disabled", backupDirSource)
+	} else {
+		backups = backup.NewStore(resolvedBackupDir, backup.Options{MaxAge: backupMaxAge, MaxBytes: backupMaxSize * 1024 * 1024})
+		// A read-only server still lists backups but never deletes them
+		var pruned int
+		if !readOnly {
+			pruned = backups.Prune().Removed
+		}
+		logger.Info("Backup directory (%s): %s (max age %s, max size %dMB, pruned %d)", backupDirSource, resolvedBackupDir, resolvedBackupMaxAge, backupMaxSize, pruned)
+	}
+
 	// Log root directory restriction
 	if len(allowedRootDirs) > 0 {
 		logger.Info("Root directory restriction enabled: %s", rootDirsStr)
@@ -268,7 +562,12 @@ func main() {
 	logger.Info("MCP server created: name=%s, version=%s", "file-context-server", Version)
 
 	// Register tools
-	registerTools(server)
+	if readOnly {
+		registerTools(readOnlyRegistry{server})
+		logger.Info("Read-only mode (%s): tools that change files are not registered", readOnlySource)
+	} else {
+		registerTools(server)
+	}
 	logger.Info("Tools registered successfully")
 
 	// Run the server
@@ -312,6 +611,102 @@ OPTIONS:
                         Default: .aws/*,.env,.mcp_env
                         Env: MCP_BLOCKED_PATTERNS
 
+    -symlink-policy <policy>
+                        How to treat paths that go through a symlink: follow,
+                        deny, or within-roots-only to follow links whose targets
+                        stay inside the root directories
+                        Default: within-roots-only
+                        Env: MCP_SYMLINK_POLICY
+
+    -secret-policy <policy>
+                        What to do when content returned by read_context, get_files,
+                        search_context, rank_files or semantic_search contains a
+                        secret: redact, refuse or off
+                        Default: redact
+                        Env: MCP_SECRET_POLICY
+
+    -secret-rules <path>
+                        JSON file of secret detection rules and allowlist, added to
+                        the built-in rules unless it sets "defaults": false
+                        Default: built-in rules
+                        Env: MCP_SECRET_RULES
+
+    -max-write-size <KB>
+                        Largest content a single write may produce; 0 is unlimited
+                        Default: 10240
+                        Env: MCP_MAX_WRITE_SIZE
+
+    -read-only-paths <globs>
+                        Paths writes may not create, change, move or delete
+                        (comma-separated globs), or off
+                        Default: **/*.lock,.git/**,go.sum
+                        Env: MCP_READ_ONLY_PATHS
+
+    -write-extensions <exts>
+                        File extensions writes may create or change (comma-separated,
+                        e.g. go,md,txt); a lone . allows files without one
+                        Default: any
+                        Env: MCP_WRITE_EXTENSIONS
+
+    -read-only          Serve only the tools that do not change files; write_file,
+                        modify_file, delete_file and the other write tools are not offered
+                        Env: MCP_READ_ONLY
+
+    -tokenizer <kind>   Token estimator used for maxTokens budgets: bytes, words, vocab
+                        Default: bytes
+                        Env: MCP_TOKENIZER
+
+    -tokenizer-vocab <path>
+                        Vocabulary file for the vocab tokenizer (one token per line)
+                        Env: MCP_TOKENIZER_VOCAB
+
+    -respect-gitignore <bool>
+                        Skip paths ignored by .gitignore, .ignore and .git/info/exclude
+                        in directory walks (.mcpignore is always applied)
+                        Default: true
+                        Env: MCP_RESPECT_GITIGNORE
+
+    -search-workers <n> Number of files search_context scans concurrently
+                        Default: number of CPUs
+                        Env: MCP_SEARCH_WORKERS
+
+    -index-dir <path>   Directory for search index files
+                        Default: ~/go-mcp-file-context-server/index
+                        Env: MCP_INDEX_DIR
+
+    -embedding-url <url>
+                        Local embedding server for semantic_search, e.g.
+                        http://localhost:11434/api/embed (Ollama) or an
+                        OpenAI-compatible /v1/embeddings endpoint
+                        Default: built-in offline embedder
+                        Env: MCP_EMBEDDING_URL
+
+    -embedding-model <name>
+                        Model name sent to the embedding server
+                        Default: nomic-embed-text
+                        Env: MCP_EMBEDDING_MODEL
+
+    -embedding-allow-remote <bool>
+                        Allow an embedding URL that is not localhost or a
+                        loopback address; file contents are sent to it
+                        Default: false
+                        Env: MCP_EMBEDDING_ALLOW_REMOTE
+
+    -backup-dir <path>  Directory for backups of overwritten and deleted files,
+                        or off to disable backups
+                        Default: ~/go-mcp-file-context-server/backups
+                        Env: MCP_BACKUP_DIR
+
+    -backup-max-age <duration>
+                        Delete backups older than this (e.g. 72h, 7d); 0 keeps them
+                        Default: 7d
+                        Env: MCP_BACKUP_MAX_AGE
+
+    -backup-max-size <MB>
+                        Total size of backups before the oldest are deleted; 0 is unlimited
+                        Default: 1024
+                        Env: MCP_BACKUP_MAX_SIZE
+
     -log-dir <path>     Directory for log files
                         Default: ~/go-mcp-file-context-server/logs
                         Env: MCP_LOG_DIR
@@ -329,6 +724,24 @@ ENVIRONMENT VARIABLES:
     MCP_BLOCKED_PATTERNS   Block access to files matching these patterns (comma-separated)
                            Default: .aws/*,.env,.mcp_env
                            Set to empty string to disable blocking
+    MCP_SYMLINK_POLICY     How to treat symlinks (follow, deny, within-roots-only)
+    MCP_SECRET_POLICY      What to do with secrets in content returned by reads (redact, refuse, off)
+    MCP_SECRET_RULES       JSON file of secret detection rules and allowlist
+    MCP_MAX_WRITE_SIZE     Largest content in KB a single write may produce
+    MCP_READ_ONLY_PATHS    Globs of paths writes may not change (comma-separated), or off
+    MCP_WRITE_EXTENSIONS   File extensions writes may create or change (comma-separated)
+    MCP_READ_ONLY          Serve only the tools that do not change files (true, false)
+    MCP_TOKENIZER          Token estimator (bytes, words, vocab)
+    MCP_TOKENIZER_VOCAB    Vocabulary file for the vocab tokenizer
+    MCP_RESPECT_GITIGNORE  Skip paths ignored by .gitignore files (true, false)
+    MCP_SEARCH_WORKERS     Number of files search_context scans concurrently
+    MCP_INDEX_DIR          Directory for search index files
+    MCP_EMBEDDING_URL      Local embedding server URL for semantic_search
+    MCP_EMBEDDING_MODEL    Model name sent to the embedding server
+    MCP_EMBEDDING_ALLOW_REMOTE Allow an embedding URL that is not on this machine (true, false)
+    MCP_BACKUP_DIR         Directory for backups of overwritten and deleted files, or off
+    MCP_BACKUP_MAX_AGE     Delete backups older than this (e.g. 72h, 7d)
+    MCP_BACKUP_MAX_SIZE    Total size of backups in MB before the oldest are deleted
     MCP_LOG_DIR            Override default log directory
     MCP_LOG_LEVEL          Override default log level
 
@@ -368,11 +781,30 @@ EXAMPLES:
 `, AppName, AppName, AppName, AppName, AppName, AppName, AppName, AppName, AppName, AppName)
 }
 
-func registerTools(server *mcp.Server) {
+// toolRegistry is where registerTools registers tools
+type toolRegistry interface {
+	RegisterTool(tool mcp.Tool, handler mcp.ToolHandler)
+}
+
+// readOnlyRegistry registers only the tools annotated as read-only, so a
+// read-only server does not offer the others at all
+type readOnlyRegistry struct {
+	server *mcp.Server
+}
+
+func (r readOnlyRegistry) RegisterTool(tool mcp.Tool, handler mcp.ToolHandler) {
+	if tool.Annotations == nil || tool.Annotations.ReadOnlyHint == nil || !*tool.Annotations.ReadOnlyHint {
+		logger.Debug("Read-only mode: not registering %s", tool.Name)
+		return
+	}
+	r.server.RegisterTool(tool, handler)
+}
+
+func registerTools(server toolRegistry) {
 	// list_allowed_directories tool - returns configured access restrictions
 	server.RegisterTool(mcp.Tool{
 		Name:        "list_allowed_directories",
-		Description: "Returns the list of allowed root directories and blocked patterns configured for this server. Use this tool first to understand what paths are accessible before attempting file operations.",
+		Description: "Returns the list of allowed root directories and blocked patterns configured for this server. Use this tool first to understand what paths are accessible before attempting file operations." + errorsDoc(),
 		InputSchema: mcp.JSONSchema{
 			Type:       "object",
 			Properties: map[string]mcp.Property{},
@@ -383,13 +815,13 @@ func registerTools(server *mcp.Server) {
 	// list_context_files tool
 	server.RegisterTool(mcp.Tool{
 		Name:        "list_context_files",
-		Description: "Lists files in a directory with detailed metadata (name, size, modification time, type). Use this when you need to discover what files exist in a directory and their properties. For reading actual file contents, use read_context instead. For a visual tree representation, use get_folder_structure.",
+		Description: "Lists files in a directory with detailed metadata (name, size, modification time, type). Use this when you need to discover what files exist in a directory and their properties. For reading actual file contents, use read_context instead. For a visual tree representation, use get_folder_structure. Symlinks are listed with their target and the real path it resolves to; symlinks that the server's symlink policy would refuse are left out. Archives (.zip, .jar, .tar, .tar.gz, .tgz) are listed like directories: pass the archive itself or a path inside it such as 'release.zip!/docs'." + errorsDoc(files.ErrFileNotFound, files.ErrArchiveLimit),
 		InputSchema: mcp.JSONSchema{
 			Type: "object",
 			Properties: map[string]mcp.Property{
 				"path": {
 					Type:        "string",
-					Description: "Absolute or relative path to the directory to list files from",
+					Description: "Absolute or relative path to the directory to list files from, or an archive path such as 'release.zip' or 'release.zip!/src'",
 					Examples:    []interface{}{"/home/user/project", "./src", "C:\\Users\\project"},
 				},
 				"recursive": {
@@ -408,22 +840,95 @@ func registerTools(server *mcp.Server) {
 					Items:       &mcp.Property{Type: "string"},
 					Examples:    []interface{}{[]string{"go", "ts", "py"}, []string{"json", "yaml", "yml"}},
 				},
+				"respectGitignore": {
+					Type:        "boolean",
+					Description: "Skip paths ignored by .gitignore, .ignore and .git/info/exclude files. Defaults to the server setting (true unless configured otherwise). .mcpignore files always apply.",
+				},
+				"include": {
+					Type:        "array",
+					Description: "Only visit files matching these doublestar globs, relative to path (e.g. \"**/*.go\", \"src/**\")",
+					Items:       &mcp.Property{Type: "string"},
+					Examples:    []interface{}{[]string{"pkg/**/*_test.go"}, []string{"src/**", "*.md"}},
+				},
+				"exclude": {
+					Type:        "array",
+					Description: "Skip files and directories matching these doublestar globs, relative to path",
+					Items:       &mcp.Property{Type: "string"},
+					Examples:    []interface{}{[]string{"**/generated/**"}, []string{"testdata/**", "**/*.pb.go"}},
+				},
 			},
 			Required: []string{"path"},
 		},
 		Annotations: readOnlyAnnotations(),
 	}, handleListContextFiles)
 
+	// find_files tool
+	server.RegisterTool(mcp.Tool{
+		Name:        "find_files",
+		Description: "Finds files by fuzzy-matching a query against their paths, relative to path, and returns the best matches with scores. Use this when you know roughly what a file is called but not where it lives (\"auth middleware\", \"usrctl\"). Characters must appear in order but not together; matches at the start of words and path segments, consecutive characters and matches in the file name rank higher. Space-separated terms must all match. Matching ignores case unless the query contains upper-case letters. Ignore files and blocked patterns are respected." + errorsDoc(files.ErrFileNotFound),
+		InputSchema: mcp.JSONSchema{
+			Type: "object",
+			Properties: map[string]mcp.Property{
+				"query": {
+					Type:        "string",
+					Description: "Fuzzy query, e.g. 'auth middleware' or 'cfgldr'",
+					Examples:    []interface{}{"auth middleware", "usrsvc test", "README"},
+				},
+				"path": {
+					Type:        "string",
+					Description: "Absolute or relative path to the directory to search under",
+					Examples:    []interface{}{"/home/user/project", "./src"},
+				},
+				"limit": {
+					Type:        "integer",
+					Description: "Maximum number of matches to return",
+					Default:     float64(files.DefaultFindLimit),
+					Minimum:     int64Ptr(1),
+					Maximum:     int64Ptr(1000),
+				},
+				"includeHidden": {
+					Type:        "boolean",
+					Description: "Include hidden files (files starting with '.')",
+					Default:     false,
+				},
+				"fileTypes": {
+					Type:        "array",
+					Description: "Only match files with these extensions (without leading dots)",
+					Items:       &mcp.Property{Type: "string"},
+					Examples:    []interface{}{[]string{"go", "ts", "py"}},
+				},
+				"respectGitignore": {
+					Type:        "boolean",
+					Description: "Skip paths ignored by .gitignore, .ignore and .git/info/exclude files. Defaults to the server setting (true unless configured otherwise). .mcpignore files always apply.",
+				},
+				"include": {
+					Type:        "array",
+					Description: "Only visit files matching these doublestar globs, relative to path (e.g. \"**/*.go\", \"src/**\")",
+					Items:       &mcp.Property{Type: "string"},
+					Examples:    []interface{}{[]string{"src/**"}},
+				},
+				"exclude": {
+					Type:        "array",
+					Description: "Skip files and directories matching these doublestar globs, relative to path",
+					Items:       &mcp.Property{Type: "string"},
+					Examples:    []interface{}{[]string{"**/vendor/**"}},
+				},
+			},
+			Required: []string{"query", "path"},
+		},
+		Annotations: readOnlyAnnotations(),
+	}, handleFindFiles)
+
 	// read_context tool
 	server.RegisterTool(mcp.Tool{
 		Name:        "read_context",
-		Description: "Reads and returns the actual contents of a file or directory. For a single file: returns the file content with metadata. For a directory: returns contents of all matching files. Large files are automatically chunked - use chunkNumber to paginate. Results are cached for performance. Use this when you need to examine actual file contents, not just metadata.",
+		Description: "Reads and returns the actual contents of a file or directory. For a single file: returns the file content with metadata and an estimated token count. For a directory: returns contents of all matching files. Large files are automatically chunked - use chunkNumber to paginate. Set maxTokens to chunk by tokens instead of bytes and to keep directory reads within a token budget. PDF, Word (.docx), Excel (.xlsx), PowerPoint (.pptx) and Jupyter notebook (.ipynb) files are returned as extracted text. Results are cached for performance. Use this when you need to examine actual file contents, not just metadata. Each file includes a hash of its content; pass it as expectedHash to write_file or modify_file to detect changes made since the read. Secrets such as access keys and private keys are replaced with [REDACTED:rule] markers, or the read is refused with SECRET_DETECTED, depending on the server's secret policy; do not write redacted content back over the original." + errorsDoc(files.ErrFileNotFound, files.ErrFileTooLarge, files.ErrBinaryFile, files.ErrInvalidEncoding, files.ErrArchiveLimit, files.ErrSecretDetected),
 		InputSchema: mcp.JSONSchema{
 			Type: "object",
 			Properties: map[string]mcp.Property{
 				"path": {
 					Type:        "string",
-					Description: "Absolute or relative path to the file or directory to read",
+					Description: "Absolute or relative path to the file or directory to read. Use 'archive.zip!/inner/path' to read inside .zip, .jar, .tar, .tar.gz and .tgz archives.",
 					Examples:    []interface{}{"/home/user/project/main.go", "./src/index.ts", "C:\\Users\\project\\README.md"},
 				},
 				"maxSize": {
@@ -435,9 +940,9 @@ func registerTools(server *mcp.Server) {
 				},
 				"encoding": {
 					Type:        "string",
-					Description: "Character encoding for reading text files",
-					Default:     "utf8",
-					Examples:    []interface{}{"utf8", "ascii", "latin1"},
+					Description: "Character encoding of the file. 'auto' detects byte order marks, UTF-16 and Latin-1/Windows-1252; content is always returned as UTF-8 and the detected encoding is reported.",
+					Default:     "auto",
+					Enum:        []string{"auto", "utf8", "utf16le", "utf16be", "ascii", "latin1", "windows-1252"},
 				},
 				"recursive": {
 					Type:        "boolean",
@@ -450,12 +955,40 @@ func registerTools(server *mcp.Server) {
 					Items:       &mcp.Property{Type: "string"},
 					Examples:    []interface{}{[]string{"go", "ts", "py"}, []string{"json", "yaml", "yml"}},
 				},
+				"respectGitignore": {
+					Type:        "boolean",
+					Description: "Skip paths ignored by .gitignore, .ignore and .git/info/exclude files. Defaults to the server setting (true unless configured otherwise). .mcpignore files always apply.",
+				},
+				"include": {
+					Type:        "array",
+					Description: "Only visit files matching these doublestar globs, relative to path (e.g. \"**/*.go\", \"src/**\")",
+					Items:       &mcp.Property{Type: "string"},
+					Examples:    []interface{}{[]string{"pkg/**/*_test.go"}, []string{"src/**", "*.md"}},
+				},
+				"exclude": {
+					Type:        "array",
+					Description: "Skip files and directories matching these doublestar globs, relative to path",
+					Items:       &mcp.Property{Type: "string"},
+					Examples:    []interface{}{[]string{"**/generated/**"}, []string{"testdata/**", "**/*.pb.go"}},
+				},
 				"chunkNumber": {
 					Type:        "integer",
-					Description: "For large files that exceed maxSize, specify which chunk to retrieve (0-indexed). Use get_chunk_count to determine total chunks.",
+					Description: "For large files that exceed maxSize (or maxTokens), specify which chunk to retrieve (0-indexed). Use get_chunk_count to determine total chunks.",
 					Default:     float64(0),
 					Minimum:     int64Ptr(0),
 				},
+				"binaryFormat": {
+					Type:        "string",
+					Description: "How to return binary files. 'auto' returns images as native image content and other binaries as an embedded resource blob; 'base64' returns JSON with base64 data; 'hex' returns a hexdump. Documents with text extraction (PDF, Office, notebooks) return extracted text under 'auto' and raw bytes otherwise. Binary files are skipped in directory reads.",
+					Default:     "auto",
+					Enum:        []string{"auto", "base64", "hex"},
+				},
+				"maxTokens": {
+					Type:        "integer",
+					Description: "Token budget for the response. A file larger than the budget is split into token-sized chunks (select with chunkNumber). For a directory, files are prioritized (READMEs, manifests, entry points, then smaller files) and the rest are truncated, summarized or omitted. Token counts are reported per file.",
+					Minimum:     int64Ptr(1),
+					Examples:    []interface{}{4000, 32000},
+				},
 			},
 			Required: []string{"path"},
 		},
@@ -465,7 +998,7 @@ func registerTools(server *mcp.Server) {
 	// search_context tool
 	server.RegisterTool(mcp.Tool{
 		Name:        "search_context",
-		Description: "Searches for regex patterns in file contents and returns matching lines with surrounding context. Use this to find specific code patterns, function definitions, variable usages, or any text pattern across multiple files.",
+		Description: "Searches for regex patterns in file contents and returns matching lines with surrounding context. Use this to find specific code patterns, function definitions, variable usages, or any text pattern across multiple files. Files are searched in parallel and results are ordered by path, then line. Each match reports byte offsets of the pattern within the line (submatches). Set fixedString, ignoreCase, wholeWord, multiline or invert to change how the pattern matches, and outputMode to 'filesWithMatches' or 'count' to triage before pulling context. Text extracted from PDF, Office and notebook files is searched; other binary files and files over maxFileSize are skipped. Archives can be searched by passing the archive or a path inside it ('lib.jar!/META-INF'). If build_search_index has indexed a directory containing path, files that cannot match are skipped without being read; without an index every file is scanned. Secrets in returned lines are redacted or refused like in read_context." + errorsDoc(files.ErrFileNotFound, files.ErrSecretDetected),
 		InputSchema: mcp.JSONSchema{
 			Type: "object",
 			Properties: map[string]mcp.Property{
@@ -476,7 +1009,7 @@ func registerTools(server *mcp.Server) {
 				},
 				"path": {
 					Type:        "string",
-					Description: "Absolute or relative path to the directory to search in",
+					Description: "Absolute or relative path to the directory to search in, or an archive path such as 'release.tar.gz!/src'",
 					Examples:    []interface{}{"/home/user/project", "./src", "C:\\Users\\project"},
 				},
 				"recursive": {
@@ -490,6 +1023,22 @@ func registerTools(server *mcp.Server) {
 					Items:       &mcp.Property{Type: "string"},
 					Examples:    []interface{}{[]string{"go", "ts", "py"}, []string{"json", "yaml", "yml"}},
 				},
+				"respectGitignore": {
+					Type:        "boolean",
+					Description: "Skip paths ignored by .gitignore, .ignore and .git/info/exclude files. Defaults to the server setting (true unless configured otherwise). .mcpignore files always apply.",
+				},
+				"include": {
+					Type:        "array",
+					Description: "Only visit files matching these doublestar globs, relative to path (e.g. \"**/*.go\", \"src/**\")",
+					Items:       &mcp.Property{Type: "string"},
+					Examples:    []interface{}{[]string{"pkg/**/*_test.go"}, []string{"src/**", "*.md"}},
+				},
+				"exclude": {
+					Type:        "array",
+					Description: "Skip files and directories matching these doublestar globs, relative to path",
+					Items:       &mcp.Property{Type: "string"},
+					Examples:    []interface{}{[]string{"**/generated/**"}, []string{"testdata/**", "**/*.pb.go"}},
+				},
 				"contextLines": {
 					Type:        "integer",
 					Description: "Number of lines to include before and after each match for context",
@@ -504,125 +1053,379 @@ func registerTools(server *mcp.Server) {
 					Minimum:     int64Ptr(1),
 					Maximum:     int64Ptr(10000),
 				},
+				"maxFileSize": {
+					Type:        "integer",
+					Description: "Skip files larger than this many bytes. Default: 10MB",
+					Default:     float64(files.DefaultSearchMaxFileSize),
+					Minimum:     int64Ptr(1),
+				},
+				"fixedString": {
+					Type:        "boolean",
+					Description: "Treat the pattern as a literal string instead of a regular expression",
+					Default:     false,
+				},
+				"ignoreCase": {
+					Type:        "boolean",
+					Description: "Match letters regardless of case",
+					Default:     false,
+				},
+				"wholeWord": {
+					Type:        "boolean",
+					Description: "Only match the pattern at word boundaries",
+					Default:     false,
+				},
+				"multiline": {
+					Type:        "boolean",
+					Description: "Match against whole files so patterns can span lines (use \\n in the pattern). ^ and $ match at line boundaries. Matches report line and endLine.",
+					Default:     false,
+				},
+				"invert": {
+					Type:        "boolean",
+					Description: "Return the lines that do NOT match the pattern. Cannot be combined with multiline.",
+					Default:     false,
+				},
+				"outputMode": {
+					Type:        "string",
+					Description: "'content' returns matching lines with context; 'filesWithMatches' returns only the paths of matching files; 'count' returns the number of matching lines per file. maxResults limits matches in content mode and files otherwise.",
+					Default:     files.OutputContent,
+					Enum:        []string{files.OutputContent, files.OutputFilesWithMatches, files.OutputCount},
+				},
+				"useIndex": {
+					Type:        "boolean",
+					Description: "Use a search index covering path, if one exists, to skip files that cannot match. Files changed since the index was built are always searched.",
+					Default:     true,
+				},
 			},
 			Required: []string{"pattern", "path"},
 		},
 		Annotations: readOnlyAnnotations(),
 	}, handleSearchContext)
 
-	// analyze_code tool
+	// rank_files tool
 	server.RegisterTool(mcp.Tool{
-		Name:        "analyze_code",
-		Description: "Analyzes code files and returns metrics including complexity scores, dependency counts, lines of code, and quality indicators. For directories, provides aggregate metrics across all files. Use this to assess code quality and identify complex areas.",
+		Name:        "rank_files",
+		Description: "Ranks files by how relevant their contents are to a natural-language or keyword query, using BM25 full-text scoring, and returns the best files with their most relevant lines. Use this for questions regex can't answer, like which files are most about 'rate limiting'. Identifiers are split at camelCase and snake_case boundaries and words are lightly stemmed, so 'rate limiting' matches rateLimiter and rate_limit. The index covers the allowed root directory containing path; it is built on first use and each call refreshes the files under path from their modification times. Snippets use the same shape as search_context matches." + errorsDoc(files.ErrFileNotFound, files.ErrSecretDetected),
 		InputSchema: mcp.JSONSchema{
 			Type: "object",
 			Properties: map[string]mcp.Property{
+				"query": {
+					Type:        "string",
+					Description: "Words describing what to look for",
+					Examples:    []interface{}{"rate limiting", "retry backoff http client", "parseConfig"},
+				},
 				"path": {
 					Type:        "string",
-					Description: "Absolute or relative path to the file or directory to analyze",
-					Examples:    []interface{}{"/home/user/project/main.go", "./src", "C:\\Users\\project"},
+					Description: "Absolute or relative path to the directory to rank files in",
+					Examples:    []interface{}{"/home/user/project", "./src"},
 				},
-				"recursive": {
-					Type:        "boolean",
-					Description: "If true and path is a directory, analyzes files in all subdirectories recursively. If false, only analyzes files in the immediate directory.",
-					Default:     true,
+				"limit": {
+					Type:        "integer",
+					Description: "Maximum number of files to return",
+					Default:     float64(index.DefaultRankLimit),
+					Minimum:     int64Ptr(1),
+					Maximum:     int64Ptr(100),
+				},
+				"snippets": {
+					Type:        "integer",
+					Description: "Maximum number of snippets per file, best first",
+					Default:     float64(index.DefaultRankSnippets),
+					Minimum:     int64Ptr(1),
+					Maximum:     int64Ptr(20),
+				},
+				"contextLines": {
+					Type:        "integer",
+					Description: "Number of lines to include before and after each snippet",
+					Default:     float64(2),
+					Minimum:     int64Ptr(0),
+					Maximum:     int64Ptr(50),
 				},
 				"fileTypes": {
 					Type:        "array",
-					Description: "Filter results to only analyze files with these extensions (without leading dots)",
+					Description: "Only rank files with these extensions (without leading dots)",
 					Items:       &mcp.Property{Type: "string"},
-					Examples:    []interface{}{[]string{"go", "ts", "py"}, []string{"js", "jsx", "tsx"}},
+					Examples:    []interface{}{[]string{"go", "ts", "py"}},
+				},
+				"include": {
+					Type:        "array",
+					Description: "Only rank files matching these doublestar globs, relative to path",
+					Items:       &mcp.Property{Type: "string"},
+					Examples:    []interface{}{[]string{"src/**"}},
+				},
+				"exclude": {
+					Type:        "array",
+					Description: "Skip files and directories matching these doublestar globs, relative to path",
+					Items:       &mcp.Property{Type: "string"},
+					Examples:    []interface{}{[]string{"**/*_test.go"}},
 				},
 			},
-			Required: []string{"path"},
+			Required: []string{"query", "path"},
 		},
 		Annotations: readOnlyAnnotations(),
-	}, handleAnalyzeCode)
+	}, handleRankFiles)
 
-	// generate_outline tool
+	// semantic_search tool
 	server.RegisterTool(mcp.Tool{
-		Name:        "generate_outline",
-		Description: "Generates a structural outline of a code file showing imports, classes, functions, and methods with their line numbers. Use this to quickly understand the structure of a file without reading its full contents.",
+		Name:        "semantic_search",
+		Description: "Finds the code chunks (functions, types, or line windows in other files) most similar to a natural-language query and returns their paths and line ranges with similarity scores. Files are split at the function and type boundaries generate_outline finds, and each chunk is embedded as a vector; by default with a built-in offline embedder based on hashed words and character n-grams, or with a local embedding server if the server is configured with one. The vectors are stored on disk per allowed root directory, built on first use, and each call refreshes the files under path from their modification times. Follow up with read_context to read a chunk, or set includeContent." + errorsDoc(files.ErrFileNotFound, files.ErrSecretDetected),
 		InputSchema: mcp.JSONSchema{
 			Type: "object",
 			Properties: map[string]mcp.Property{
+				"query": {
+					Type:        "string",
+					Description: "What the code you are looking for does, in plain words",
+					Examples:    []interface{}{"where are requests rate limited", "parse the configuration file", "retry with exponential backoff"},
+				},
 				"path": {
 					Type:        "string",
-					Description: "Absolute or relative path to the file to generate an outline for",
-					Examples:    []interface{}{"/home/user/project/main.go", "./src/index.ts", "C:\\Users\\project\\app.py"},
+					Description: "Absolute or relative path to the directory to search in",
+					Examples:    []interface{}{"/home/user/project", "./src"},
+				},
+				"limit": {
+					Type:        "integer",
+					Description: "Maximum number of chunks to return",
+					Default:     float64(semantic.DefaultLimit),
+					Minimum:     int64Ptr(1),
+					Maximum:     int64Ptr(100),
+				},
+				"includeContent": {
+					Type:        "boolean",
+					Description: "Include the text of each chunk",
+					Default:     false,
+				},
+				"fileTypes": {
+					Type:        "array",
+					Description: "Only search files with these extensions (without leading dots)",
+					Items:       &mcp.Property{Type: "string"},
+					Examples:    []interface{}{[]string{"go", "ts", "py"}},
+				},
+				"include": {
+					Type:        "array",
+					Description: "Only search files matching these doublestar globs, relative to path",
+					Items:       &mcp.Property{Type: "string"},
+					Examples:    []interface{}{[]string{"src/**"}},
+				},
+				"exclude": {
+					Type:        "array",
+					Description: "Skip files and directories matching these doublestar globs, relative to path",
+					Items:       &mcp.Property{Type: "string"},
+					Examples:    []interface{}{[]string{"**/*_test.go"}},
 				},
 			},
-			Required: []string{"path"},
+			Required: []string{"query", "path"},
 		},
 		Annotations: readOnlyAnnotations(),
-	}, handleGenerateOutline)
+	}, handleSemanticSearch)
 
-	// cache_stats tool
+	// build_search_index tool
 	server.RegisterTool(mcp.Tool{
-		Name:        "cache_stats",
-		Description: "Returns cache statistics including hit/miss rates, memory usage, and optionally details about cached entries. Use this to monitor cache performance and diagnose caching issues.",
+		Name:        "build_search_index",
+		Description: "Builds or updates a trigram index of a directory so search_context can skip files that cannot match a pattern. Indexes are stored on disk; rebuilding only re-reads files whose size or modification time changed. Without a path, every allowed root directory is indexed. Run this again after large changes; files changed since the last build are still searched, just without the speedup." + errorsDoc(files.ErrFileNotFound),
 		InputSchema: mcp.JSONSchema{
 			Type: "object",
 			Properties: map[string]mcp.Property{
-				"detailed": {
+				"path": {
+					Type:        "string",
+					Description: "Directory to index. Defaults to all allowed root directories.",
+					Examples:    []interface{}{"/home/user/project", "./src"},
+				},
+				"respectGitignore": {
 					Type:        "boolean",
-					Description: "If true, includes a list of all cached file entries with their sizes and ages. If false, returns only aggregate statistics.",
-					Default:     false,
+					Description: "Skip paths ignored by .gitignore, .ignore and .git/info/exclude files. Defaults to the server setting (true unless configured otherwise). .mcpignore files always apply.",
 				},
 			},
 		},
-		Annotations: readOnlyAnnotations(),
-	}, handleCacheStats)
+		Annotations: writeAnnotations(),
+	}, handleBuildSearchIndex)
 
-	// get_chunk_count tool
+	// search_index_status tool
 	server.RegisterTool(mcp.Tool{
-		Name:        "get_chunk_count",
-		Description: "Returns the total number of chunks needed to read a large file. Use this before calling read_context with chunkNumber to know how many iterations are needed to read the complete file.",
+		Name:        "search_index_status",
+		Description: "Reports on the search index of a directory: files covered, files too large to index, distinct trigrams, size on disk, when it was built, and how many files were added, modified or removed since. Without a path, reports on every allowed root directory." + errorsDoc(files.ErrFileNotFound),
 		InputSchema: mcp.JSONSchema{
 			Type: "object",
 			Properties: map[string]mcp.Property{
 				"path": {
 					Type:        "string",
-					Description: "Absolute or relative path to the file to get chunk count for",
-					Examples:    []interface{}{"/home/user/project/large-file.log", "./data/export.csv"},
-				},
-				"chunkSize": {
-					Type:        "integer",
-					Description: "Size of each chunk in bytes. Must match the chunkSize used in read_context for consistent pagination.",
-					Default:     float64(DefaultChunkSize),
-					Minimum:     int64Ptr(1024),          // 1KB minimum
-					Maximum:     int64Ptr(10 * 1024 * 1024), // 10MB maximum
+					Description: "Indexed directory. Defaults to all allowed root directories.",
+					Examples:    []interface{}{"/home/user/project"},
 				},
 			},
-			Required: []string{"path"},
 		},
 		Annotations: readOnlyAnnotations(),
-	}, handleGetChunkCount)
+	}, handleSearchIndexStatus)
 
-	// get_files tool (batch file retrieval)
+	// drop_search_index tool
 	server.RegisterTool(mcp.Tool{
-		Name:        "get_files",
-		Description: "Batch retrieve contents of multiple files in a single request. More efficient than calling read_context multiple times when you need to read several known files. Returns a map of file paths to their contents.",
+		Name:        "drop_search_index",
+		Description: "Deletes the search index of a directory. search_context falls back to scanning every file. Without a path, drops the indexes of every allowed root directory." + errorsDoc(files.ErrFileNotFound),
 		InputSchema: mcp.JSONSchema{
 			Type: "object",
 			Properties: map[string]mcp.Property{
-				"filePathList": {
-					Type:        "array",
-					Description: "List of file path objects to retrieve",
-					Items: &mcp.Property{
-						Type: "object",
-						Properties: map[string]mcp.Property{
-							"fileName": {
-								Type:        "string",
-								Description: "Absolute or relative path to the file to retrieve",
-								Examples:    []interface{}{"/home/user/project/main.go", "./src/index.ts"},
-							},
-						},
-					},
+				"path": {
+					Type:        "string",
+					Description: "Indexed directory. Defaults to all allowed root directories.",
+					Examples:    []interface{}{"/home/user/project"},
+				},
+			},
+		},
+		Annotations: destructiveAnnotations(),
+	}, handleDropSearchIndex)
+
+	// analyze_code tool
+	server.RegisterTool(mcp.Tool{
+		Name:        "analyze_code",
+		Description: "Analyzes code files and returns metrics including complexity scores, dependency counts, lines of code, and quality indicators. For directories, provides aggregate metrics across all files. Use this to assess code quality and identify complex areas." + errorsDoc(files.ErrFileNotFound, files.ErrFileTooLarge, files.ErrBinaryFile),
+		InputSchema: mcp.JSONSchema{
+			Type: "object",
+			Properties: map[string]mcp.Property{
+				"path": {
+					Type:        "string",
+					Description: "Absolute or relative path to the file or directory to analyze",
+					Examples:    []interface{}{"/home/user/project/main.go", "./src", "C:\\Users\\project"},
+				},
+				"recursive": {
+					Type:        "boolean",
+					Description: "If true and path is a directory, analyzes files in all subdirectories recursively. If false, only analyzes files in the immediate directory.",
+					Default:     true,
+				},
+				"fileTypes": {
+					Type:        "array",
+					Description: "Filter results to only analyze files with these extensions (without leading dots)",
+					Items:       &mcp.Property{Type: "string"},
+					Examples:    []interface{}{[]string{"go", "ts", "py"}, []string{"js", "jsx", "tsx"}},
+				},
+				"respectGitignore": {
+					Type:        "boolean",
+					Description: "Skip paths ignored by .gitignore, .ignore and .git/info/exclude files. Defaults to the server setting (true unless configured otherwise). .mcpignore files always apply.",
+				},
+				"include": {
+					Type:        "array",
+					Description: "Only visit files matching these doublestar globs, relative to path (e.g. \"**/*.go\", \"src/**\")",
+					Items:       &mcp.Property{Type: "string"},
+					Examples:    []interface{}{[]string{"pkg/**/*_test.go"}, []string{"src/**", "*.md"}},
+				},
+				"exclude": {
+					Type:        "array",
+					Description: "Skip files and directories matching these doublestar globs, relative to path",
+					Items:       &mcp.Property{Type: "string"},
+					Examples:    []interface{}{[]string{"**/generated/**"}, []string{"testdata/**", "**/*.pb.go"}},
+				},
+			},
+			Required: []string{"path"},
+		},
+		Annotations: readOnlyAnnotations(),
+	}, handleAnalyzeCode)
+
+	// generate_outline tool
+	server.RegisterTool(mcp.Tool{
+		Name:        "generate_outline",
+		Description: "Generates a structural outline of a code file showing imports, classes, functions, and methods with their line numbers. Use this to quickly understand the structure of a file without reading its full contents." + errorsDoc(files.ErrFileNotFound, files.ErrFileTooLarge, files.ErrBinaryFile),
+		InputSchema: mcp.JSONSchema{
+			Type: "object",
+			Properties: map[string]mcp.Property{
+				"path": {
+					Type:        "string",
+					Description: "Absolute or relative path to the file to generate an outline for",
+					Examples:    []interface{}{"/home/user/project/main.go", "./src/index.ts", "C:\\Users\\project\\app.py"},
+				},
+			},
+			Required: []string{"path"},
+		},
+		Annotations: readOnlyAnnotations(),
+	}, handleGenerateOutline)
+
+	// cache_stats tool
+	server.RegisterTool(mcp.Tool{
+		Name:        "cache_stats",
+		Description: "Returns cache statistics including hit/miss rates, memory usage, and optionally details about cached entries. Use this to monitor cache performance and diagnose caching issues." + errorsDoc(),
+		InputSchema: mcp.JSONSchema{
+			Type: "object",
+			Properties: map[string]mcp.Property{
+				"detailed": {
+					Type:        "boolean",
+					Description: "If true, includes a list of all cached file entries with their sizes and ages. If false, returns only aggregate statistics.",
+					Default:     false,
+				},
+			},
+		},
+		Annotations: readOnlyAnnotations(),
+	}, handleCacheStats)
+
+	// get_chunk_count tool
+	server.RegisterTool(mcp.Tool{
+		Name:        "get_chunk_count",
+		Description: "Returns the total number of chunks needed to read a large file. Use this before calling read_context with chunkNumber to know how many iterations are needed to read the complete file." + errorsDoc(files.ErrFileNotFound, files.ErrBinaryFile),
+		InputSchema: mcp.JSONSchema{
+			Type: "object",
+			Properties: map[string]mcp.Property{
+				"path": {
+					Type:        "string",
+					Description: "Absolute or relative path to the file to get chunk count for",
+					Examples:    []interface{}{"/home/user/project/large-file.log", "./data/export.csv"},
+				},
+				"chunkSize": {
+					Type:        "integer",
+					Description: "Size of each chunk in bytes. Must match the chunkSize used in read_context for consistent pagination.",
+					Default:     float64(DefaultChunkSize),
+					Minimum:     int64Ptr(1024),             // 1KB minimum
+					Maximum:     int64Ptr(10 * 1024 * 1024), // 10MB maximum
+				},
+				"maxTokens": {
+					Type:        "integer",
+					Description: "If set, counts chunks of at most this many tokens instead of bytes. Must match the maxTokens used in read_context. Files only: directories are paged by chunkSize.",
+					Minimum:     int64Ptr(1),
+				},
+				"respectGitignore": {
+					Type:        "boolean",
+					Description: "Skip paths ignored by .gitignore, .ignore and .git/info/exclude files. Defaults to the server setting (true unless configured otherwise). .mcpignore files always apply.",
+				},
+				"include": {
+					Type:        "array",
+					Description: "Only visit files matching these doublestar globs, relative to path (e.g. \"**/*.go\", \"src/**\")",
+					Items:       &mcp.Property{Type: "string"},
+					Examples:    []interface{}{[]string{"pkg/**/*_test.go"}, []string{"src/**", "*.md"}},
+				},
+				"exclude": {
+					Type:        "array",
+					Description: "Skip files and directories matching these doublestar globs, relative to path",
+					Items:       &mcp.Property{Type: "string"},
+					Examples:    []interface{}{[]string{"**/generated/**"}, []string{"testdata/**", "**/*.pb.go"}},
+				},
+			},
+			Required: []string{"path"},
+		},
+		Annotations: readOnlyAnnotations(),
+	}, handleGetChunkCount)
+
+	// get_files tool (batch file retrieval)
+	server.RegisterTool(mcp.Tool{
+		Name:        "get_files",
+		Description: "Batch retrieve contents of multiple files in a single request. More efficient than calling read_context multiple times when you need to read several known files. Returns a map of file paths to their contents with estimated token counts. PDF, Office and notebook files are returned as extracted text; other binary files are returned as base64 data. With maxTokens, files are taken in list order until the budget is spent; later files are truncated, summarized or omitted. Each file includes a hash of its content; pass it as expectedHash to write_file or modify_file to detect changes made since the read. Secrets such as access keys and private keys are replaced with [REDACTED:rule] markers, or the read is refused with SECRET_DETECTED, depending on the server's secret policy; do not write redacted content back over the original. Files that cannot be read are returned with an error and its code." + errorsDoc(),
+		InputSchema: mcp.JSONSchema{
+			Type: "object",
+			Properties: map[string]mcp.Property{
+				"filePathList": {
+					Type:        "array",
+					Description: "List of file path objects to retrieve",
+					Items: &mcp.Property{
+						Type: "object",
+						Properties: map[string]mcp.Property{
+							"fileName": {
+								Type:        "string",
+								Description: "Absolute or relative path to the file to retrieve",
+								Examples:    []interface{}{"/home/user/project/main.go", "./src/index.ts"},
+							},
+						},
+					},
 					Examples: []interface{}{
 						[]map[string]string{{"fileName": "./src/main.go"}, {"fileName": "./src/utils.go"}},
 					},
 				},
+				"maxTokens": {
+					Type:        "integer",
+					Description: "Total token budget across all files, spent in list order",
+					Minimum:     int64Ptr(1),
+				},
 			},
 			Required: []string{"filePathList"},
 		},
@@ -632,13 +1435,13 @@ func registerTools(server *mcp.Server) {
 	// get_folder_structure tool
 	server.RegisterTool(mcp.Tool{
 		Name:        "get_folder_structure",
-		Description: "Returns a visual tree representation of the directory structure showing folders and files hierarchically. Use this to understand project layout and organization. For detailed file metadata, use list_context_files instead.",
+		Description: "Returns a visual tree representation of the directory structure showing folders and files hierarchically. Use this to understand project layout and organization. For detailed file metadata, use list_context_files instead." + errorsDoc(files.ErrFileNotFound),
 		InputSchema: mcp.JSONSchema{
 			Type: "object",
 			Properties: map[string]mcp.Property{
 				"path": {
 					Type:        "string",
-					Description: "Absolute or relative path to the directory to visualize",
+					Description: "Absolute or relative path to the directory to visualize, or an archive path such as 'release.zip' or 'release.zip!/src'",
 					Examples:    []interface{}{"/home/user/project", "./src", "C:\\Users\\project"},
 				},
 				"maxDepth": {
@@ -648,6 +1451,22 @@ func registerTools(server *mcp.Server) {
 					Minimum:     int64Ptr(0),
 					Maximum:     int64Ptr(50),
 				},
+				"respectGitignore": {
+					Type:        "boolean",
+					Description: "Skip paths ignored by .gitignore, .ignore and .git/info/exclude files. Defaults to the server setting (true unless configured otherwise). .mcpignore files always apply.",
+				},
+				"include": {
+					Type:        "array",
+					Description: "Only visit files matching these doublestar globs, relative to path (e.g. \"**/*.go\", \"src/**\")",
+					Items:       &mcp.Property{Type: "string"},
+					Examples:    []interface{}{[]string{"pkg/**/*_test.go"}, []string{"src/**", "*.md"}},
+				},
+				"exclude": {
+					Type:        "array",
+					Description: "Skip files and directories matching these doublestar globs, relative to path",
+					Items:       &mcp.Property{Type: "string"},
+					Examples:    []interface{}{[]string{"**/generated/**"}, []string{"testdata/**", "**/*.pb.go"}},
+				},
 			},
 			Required: []string{"path"},
 		},
@@ -657,7 +1476,7 @@ func registerTools(server *mcp.Server) {
 	// write_file tool
 	server.RegisterTool(mcp.Tool{
 		Name:        "write_file",
-		Description: "Create a new file or completely overwrite an existing file with new content. Creates parent directories automatically if they do not exist. Warning: this will replace the entire file contents.",
+		Description: "Create a new file or completely overwrite an existing file with new content. Creates parent directories automatically if they do not exist. Warning: this will replace the entire file contents. Files are replaced atomically (written to a temporary file, synced and renamed), keep their permissions and owner, and symlinks are written through to their target. Pass the hash returned by read_context or get_files as expectedHash to fail with a CONFLICT error instead of overwriting changes made since the file was read; the result includes the new hash. The server's write policy refuses content over the size limit (CONTENT_TOO_LARGE), read-only paths such as lock files and .git (READ_ONLY_PATH), extensions outside its allowlist (EXTENSION_NOT_ALLOWED) and content containing secrets (SECRET_DETECTED)." + errorsDoc(files.ErrConflict, files.ErrInvalidEncoding, files.ErrReadOnlyPath, files.ErrExtension, files.ErrContentTooLarge, files.ErrSecretDetected),
 		InputSchema: mcp.JSONSchema{
 			Type: "object",
 			Properties: map[string]mcp.Property{
@@ -670,6 +1489,33 @@ func registerTools(server *mcp.Server) {
 					Type:        "string",
 					Description: "The complete content to write to the file",
 				},
+				"encoding": {
+					Type:        "string",
+					Description: "Character encoding to write. 'auto' keeps the existing file's encoding and byte order mark (UTF-8 for new files).",
+					Default:     "auto",
+					Enum:        []string{"auto", "utf8", "utf16le", "utf16be", "ascii", "latin1", "windows-1252"},
+				},
+				"lineEnding": {
+					Type:        "string",
+					Description: "Line ending style to write. 'auto' converts to the existing file's style (content is left unchanged for new files).",
+					Default:     "auto",
+					Enum:        []string{"auto", "lf", "crlf"},
+				},
+				"expectedHash": {
+					Type:        "string",
+					Description: "Hash of the file as last read (the hash field of read_context or get_files). If the file has changed or been deleted since, nothing is written and the call fails with a CONFLICT error.",
+				},
+				"mode": {
+					Type:        "string",
+					Description: "Octal permissions for a newly created file. Existing files keep their permissions.",
+					Default:     "0644",
+					Examples:    []interface{}{"0755", "0600"},
+				},
+				"dryRun": {
+					Type:        "boolean",
+					Description: "If true, returns what would be written (bytes, encoding, whether the file would be created, and a unified diff against the current file) without changing anything",
+					Default:     false,
+				},
 			},
 			Required: []string{"path", "content"},
 		},
@@ -679,7 +1525,7 @@ func registerTools(server *mcp.Server) {
 	// create_directory tool
 	server.RegisterTool(mcp.Tool{
 		Name:        "create_directory",
-		Description: "Create a new directory or ensure a directory exists. Creates all necessary parent directories automatically. Safe to call on existing directories.",
+		Description: "Create a new directory or ensure a directory exists. Creates all necessary parent directories automatically. Safe to call on existing directories." + errorsDoc(files.ErrAlreadyExists, files.ErrReadOnlyPath),
 		InputSchema: mcp.JSONSchema{
 			Type: "object",
 			Properties: map[string]mcp.Property{
@@ -688,6 +1534,11 @@ func registerTools(server *mcp.Server) {
 					Description: "Absolute or relative path to the directory to create",
 					Examples:    []interface{}{"/home/user/project/new-folder", "./src/components/ui"},
 				},
+				"dryRun": {
+					Type:        "boolean",
+					Description: "If true, lists the directories that would be created without creating them",
+					Default:     false,
+				},
 			},
 			Required: []string{"path"},
 		},
@@ -697,7 +1548,7 @@ func registerTools(server *mcp.Server) {
 	// copy_file tool
 	server.RegisterTool(mcp.Tool{
 		Name:        "copy_file",
-		Description: "Copy a file or directory from source to destination. For directories, performs a recursive copy of all contents. Creates destination parent directories if needed.",
+		Description: "Copy a file or directory from source to destination. For directories, performs a recursive copy of all contents. Creates destination parent directories if needed. Refused with READ_ONLY_PATH, EXTENSION_NOT_ALLOWED, CONTENT_TOO_LARGE or SECRET_DETECTED if any file it would write breaks the server's write policy." + errorsDoc(files.ErrFileNotFound, files.ErrReadOnlyPath, files.ErrExtension, files.ErrContentTooLarge, files.ErrSecretDetected),
 		InputSchema: mcp.JSONSchema{
 			Type: "object",
 			Properties: map[string]mcp.Property{
@@ -711,6 +1562,11 @@ func registerTools(server *mcp.Server) {
 					Description: "Absolute or relative path to the destination location",
 					Examples:    []interface{}{"/home/user/project/file-backup.txt", "./src/new-component"},
 				},
+				"dryRun": {
+					Type:        "boolean",
+					Description: "If true, lists every file and directory that would be created or overwritten, with sizes and diffs for overwritten text files, without copying anything",
+					Default:     false,
+				},
 			},
 			Required: []string{"source", "destination"},
 		},
@@ -720,7 +1576,7 @@ func registerTools(server *mcp.Server) {
 	// move_file tool
 	server.RegisterTool(mcp.Tool{
 		Name:        "move_file",
-		Description: "Move or rename a file or directory from source to destination. The source is removed after successful move. Creates destination parent directories if needed.",
+		Description: "Move or rename a file or directory from source to destination. The source is removed after successful move. Creates destination parent directories if needed. Refused with READ_ONLY_PATH, EXTENSION_NOT_ALLOWED, CONTENT_TOO_LARGE or SECRET_DETECTED if any file it would move or write breaks the server's write policy." + errorsDoc(files.ErrFileNotFound, files.ErrReadOnlyPath, files.ErrExtension, files.ErrContentTooLarge, files.ErrSecretDetected),
 		InputSchema: mcp.JSONSchema{
 			Type: "object",
 			Properties: map[string]mcp.Property{
@@ -731,75 +1587,447 @@ func registerTools(server *mcp.Server) {
 				},
 				"destination": {
 					Type:        "string",
-					Description: "Absolute or relative path to the destination location",
-					Examples:    []interface{}{"/home/user/project/new-name.txt", "./src/current"},
+					Description: "Absolute or relative path to the destination location",
+					Examples:    []interface{}{"/home/user/project/new-name.txt", "./src/current"},
+				},
+				"dryRun": {
+					Type:        "boolean",
+					Description: "If true, lists every file and directory that would arrive at the destination, with sizes and diffs for overwritten text files, without moving anything",
+					Default:     false,
+				},
+			},
+			Required: []string{"source", "destination"},
+		},
+		Annotations: writeAnnotations(),
+	}, handleMoveFile)
+
+	// delete_file tool
+	server.RegisterTool(mcp.Tool{
+		Name:        "delete_file",
+		Description: "Permanently delete a file or directory from the file system. Warning: this action cannot be undone. For non-empty directories, the recursive flag must be set to true." + errorsDoc(files.ErrFileNotFound, files.ErrNotEmpty, files.ErrReadOnlyPath),
+		InputSchema: mcp.JSONSchema{
+			Type: "object",
+			Properties: map[string]mcp.Property{
+				"path": {
+					Type:        "string",
+					Description: "Absolute or relative path to the file or directory to delete",
+					Examples:    []interface{}{"/home/user/project/temp.txt", "./build", "C:\\Users\\project\\cache"},
+				},
+				"recursive": {
+					Type:        "boolean",
+					Description: "If true, deletes directories and all their contents recursively. Required for non-empty directories. Use with caution.",
+					Default:     false,
+				},
+				"dryRun": {
+					Type:        "boolean",
+					Description: "If true, lists every file and directory that would be deleted, with sizes, without deleting anything",
+					Default:     false,
+				},
+			},
+			Required: []string{"path"},
+		},
+		Annotations: destructiveAnnotations(),
+	}, handleDeleteFile)
+
+	// modify_file tool
+	server.RegisterTool(mcp.Tool{
+		Name:        "modify_file",
+		Description: "Edits a file in place and returns a unified diff of the change with the changed line ranges (changes[].newStart/newLines give the new line numbers). Use this for targeted edits rather than rewriting entire files with write_file. Modes: 'replace' (default) finds and replaces text or a regex; set unique: true to fail with the matching line numbers unless find matches exactly once. 'replaceLines' replaces lines startLine-endLine with content, 'deleteLines' deletes them, and 'insert' inserts content after (or with position: 'before', before) line startLine or the line containing a unique anchor string. Line numbers are 1-based and inclusive; content takes on the file's line endings. Errors use codes NO_MATCH and AMBIGUOUS_MATCH when find or anchor matches zero or several times. Pass the hash from read_context or get_files as expectedHash to fail with CONFLICT if the file changed since it was read; the result includes the new hash. The edited file must pass the server's write policy: READ_ONLY_PATH, EXTENSION_NOT_ALLOWED, CONTENT_TOO_LARGE or SECRET_DETECTED otherwise." + errorsDoc(files.ErrFileNotFound, files.ErrNoMatch, files.ErrAmbiguousMatch, files.ErrConflict, files.ErrInvalidEncoding, files.ErrReadOnlyPath, files.ErrExtension, files.ErrContentTooLarge, files.ErrSecretDetected),
+		InputSchema: mcp.JSONSchema{
+			Type: "object",
+			Properties: map[string]mcp.Property{
+				"path": {
+					Type:        "string",
+					Description: "Absolute or relative path to the file to modify",
+					Examples:    []interface{}{"/home/user/project/config.json", "./src/index.ts"},
+				},
+				"mode": {
+					Type:        "string",
+					Description: "Kind of edit: 'replace' finds and replaces text, 'replaceLines' replaces a line range with content, 'insert' inserts content next to a line or anchor, 'deleteLines' deletes a line range",
+					Default:     files.ModifyReplace,
+					Enum:        []string{files.ModifyReplace, files.ModifyReplaceLines, files.ModifyInsert, files.ModifyDeleteLines},
+				},
+				"find": {
+					Type:        "string",
+					Description: "Text or regex pattern to search for in the file (replace mode)",
+					Examples:    []interface{}{"oldFunctionName", "version: \"1.0.0\"", "import\\s+.*from\\s+'lodash'"},
+				},
+				"replace": {
+					Type:        "string",
+					Description: "Text to replace matches with (replace mode). For regex mode, can include capture group references ($1, $2, etc.)",
+					Examples:    []interface{}{"newFunctionName", "version: \"2.0.0\""},
+				},
+				"all_occurrences": {
+					Type:        "boolean",
+					Description: "If true, replaces all matching occurrences in the file. If false, replaces only the first match.",
+					Default:     true,
+				},
+				"regex": {
+					Type:        "boolean",
+					Description: "If true, interprets the 'find' parameter as a regular expression pattern. If false, performs literal string matching.",
+					Default:     false,
+				},
+				"unique": {
+					Type:        "boolean",
+					Description: "Require find to match exactly once. If it matches zero or several times nothing is changed and the error lists the matching line numbers. Recommended for single edits.",
+					Default:     false,
+				},
+				"startLine": {
+					Type:        "integer",
+					Description: "First line of the range (replaceLines, deleteLines), or the line to insert next to (insert; 0 inserts at the start of the file). 1-based.",
+					Minimum:     int64Ptr(0),
+				},
+				"endLine": {
+					Type:        "integer",
+					Description: "Last line of the range, inclusive (replaceLines, deleteLines). Defaults to startLine.",
+					Minimum:     int64Ptr(1),
+				},
+				"anchor": {
+					Type:        "string",
+					Description: "Insert next to the line containing this text instead of startLine (insert mode). It must appear exactly once in the file.",
+					Examples:    []interface{}{"import (", "## Installation"},
+				},
+				"position": {
+					Type:        "string",
+					Description: "Insert after (default) or before the line (insert mode)",
+					Default:     "after",
+					Enum:        []string{"after", "before"},
+				},
+				"content": {
+					Type:        "string",
+					Description: "Lines to insert or to replace the range with (insert, replaceLines). A final newline is added if missing.",
+				},
+				"expectedHash": {
+					Type:        "string",
+					Description: "Hash of the file as last read (the hash field of read_context or get_files). If the file has changed since, it is left alone and the call fails with a CONFLICT error.",
+				},
+				"contextLines": {
+					Type:        "integer",
+					Description: "Number of unchanged lines shown around each change in the diff",
+					Default:     float64(files.DefaultDiffContext),
+					Minimum:     int64Ptr(0),
+					Maximum:     int64Ptr(50),
+				},
+				"dryRun": {
+					Type:        "boolean",
+					Description: "If true, returns the diff of the edit without changing the file",
+					Default:     false,
+				},
+			},
+			Required: []string{"path"},
+		},
+		Annotations: writeAnnotations(),
+	}, handleModifyFile)
+
+	// replace_in_files tool
+	server.RegisterTool(mcp.Tool{
+		Name:        "replace_in_files",
+		Description: "Replaces a pattern across many files in one call, such as renaming an identifier throughout a project. Files are selected and matched exactly as search_context does, so run search_context first to check what matches. By default this is a dry run that changes nothing and returns a unified diff for each file; review the diffs, then call again with dryRun: false to apply. Applying is all or nothing: if any file cannot be written, files already written are restored and an error is returned. Each file keeps its encoding and line endings; binary files, PDF/Office documents and symlinks are never modified." + errorsDoc(files.ErrFileNotFound, files.ErrInvalidEncoding, files.ErrContentTooLarge, files.ErrSecretDetected),
+		InputSchema: mcp.JSONSchema{
+			Type: "object",
+			Properties: map[string]mcp.Property{
+				"pattern": {
+					Type:        "string",
+					Description: "Regular expression pattern to replace. Unless multiline is set it is matched against each line separately, as in search_context.",
+					Examples:    []interface{}{"\\boldName\\b", "github.com/old/module", "log\\.Printf\\((.*)\\)"},
+				},
+				"replacement": {
+					Type:        "string",
+					Description: "Text to replace each match with. In regex mode, $1, $2 or ${name} insert capture groups (write $$ for a literal $); with fixedString the text is inserted literally.",
+					Examples:    []interface{}{"newName", "github.com/new/module", "logger.Info($1)"},
+				},
+				"path": {
+					Type:        "string",
+					Description: "Absolute or relative path to the directory to search in, or a single file",
+					Examples:    []interface{}{"/home/user/project", "./src"},
+				},
+				"dryRun": {
+					Type:        "boolean",
+					Description: "If true, returns per-file unified diffs without changing anything. Set to false to apply the replacements.",
+					Default:     true,
+				},
+				"recursive": {
+					Type:        "boolean",
+					Description: "If true, searches in all subdirectories recursively. If false, only searches files in the immediate directory.",
+					Default:     true,
+				},
+				"fileTypes": {
+					Type:        "array",
+					Description: "Only change files with these extensions (without leading dots)",
+					Items:       &mcp.Property{Type: "string"},
+					Examples:    []interface{}{[]string{"go"}, []string{"ts", "tsx"}},
+				},
+				"respectGitignore": {
+					Type:        "boolean",
+					Description: "Skip paths ignored by .gitignore, .ignore and .git/info/exclude files. Defaults to the server setting (true unless configured otherwise). .mcpignore files always apply.",
+				},
+				"include": {
+					Type:        "array",
+					Description: "Only visit files matching these doublestar globs, relative to path (e.g. \"**/*.go\", \"src/**\")",
+					Items:       &mcp.Property{Type: "string"},
+					Examples:    []interface{}{[]string{"pkg/**/*.go"}, []string{"src/**", "*.md"}},
+				},
+				"exclude": {
+					Type:        "array",
+					Description: "Skip files and directories matching these doublestar globs, relative to path",
+					Items:       &mcp.Property{Type: "string"},
+					Examples:    []interface{}{[]string{"**/generated/**"}, []string{"vendor/**", "**/*.pb.go"}},
+				},
+				"contextLines": {
+					Type:        "integer",
+					Description: "Number of unchanged lines shown around each change in the diffs",
+					Default:     float64(files.DefaultDiffContext),
+					Minimum:     int64Ptr(0),
+					Maximum:     int64Ptr(50),
+				},
+				"maxFileSize": {
+					Type:        "integer",
+					Description: "Skip files larger than this many bytes. Default: 10MB",
+					Default:     float64(files.DefaultSearchMaxFileSize),
+					Minimum:     int64Ptr(1),
+				},
+				"fixedString": {
+					Type:        "boolean",
+					Description: "Treat the pattern as a literal string instead of a regular expression",
+					Default:     false,
+				},
+				"ignoreCase": {
+					Type:        "boolean",
+					Description: "Match letters regardless of case",
+					Default:     false,
+				},
+				"wholeWord": {
+					Type:        "boolean",
+					Description: "Only match the pattern at word boundaries",
+					Default:     false,
+				},
+				"multiline": {
+					Type:        "boolean",
+					Description: "Match against whole files so patterns can span lines (use \\n in the pattern). ^ and $ match at line boundaries.",
+					Default:     false,
+				},
+				"useIndex": {
+					Type:        "boolean",
+					Description: "Use a search index covering path, if one exists, to skip files that cannot match. Files changed since the index was built are always read.",
+					Default:     true,
+				},
+			},
+			Required: []string{"pattern", "replacement", "path"},
+		},
+		Annotations: writeAnnotations(),
+	}, handleReplaceInFiles)
+
+	// apply_patch tool
+	server.RegisterTool(mcp.Tool{
+		Name:        "apply_patch",
+		Description: "Applies a unified diff (diff -u or git diff format) covering one or many files, including new files (--- /dev/null), deletions (+++ /dev/null) and git renames. Use this for multi-hunk or multi-file edits instead of rewriting files. Each hunk is located near the line in its header, or elsewhere if the file has shifted; with fuzz, up to that many context lines at each end of a hunk may fail to match, and with ignoreWhitespace, lines may differ in whitespace. Hunk line counts need not be exact. The patch is all or nothing: if any hunk fails, nothing is written and the error lists every hunk's outcome, with the closest partial match for failed hunks so the diff can be corrected. Every target path must be inside the allowed directories." + errorsDoc(files.ErrInvalidPatch, files.ErrNotApplied, files.ErrConflict, files.ErrContentTooLarge, files.ErrSecretDetected),
+		InputSchema: mcp.JSONSchema{
+			Type: "object",
+			Properties: map[string]mcp.Property{
+				"patch": {
+					Type:        "string",
+					Description: "Unified diff text. Paths in ---/+++ headers are relative to path; git-style a/ and b/ prefixes are removed.",
+					Examples:    []interface{}{"--- a/src/app.ts\n+++ b/src/app.ts\n@@ -10,3 +10,3 @@\n function start() {\n-  listen(80)\n+  listen(8080)\n }\n"},
+				},
+				"path": {
+					Type:        "string",
+					Description: "Directory the paths in the patch are relative to, usually the project root",
+					Examples:    []interface{}{"/home/user/project", "."},
+				},
+				"strip": {
+					Type:        "integer",
+					Description: "Remove this many leading path components from patch paths, like patch -p. By default a/ and b/ prefixes are removed when both headers use them.",
+					Minimum:     int64Ptr(0),
+				},
+				"fuzz": {
+					Type:        "integer",
+					Description: "Number of context lines at each end of a hunk that may be ignored when the full context does not match",
+					Default:     float64(files.DefaultPatchFuzz),
+					Minimum:     int64Ptr(0),
+					Maximum:     int64Ptr(10),
+				},
+				"ignoreWhitespace": {
+					Type:        "boolean",
+					Description: "If no exact match is found, let context and removed lines match lines that differ only in whitespace. Context lines keep the file's version.",
+					Default:     true,
+				},
+				"dryRun": {
+					Type:        "boolean",
+					Description: "If true, checks that every hunk applies and reports the outcome without changing any files",
+					Default:     false,
+				},
+				"expectedHashes": {
+					Type:        "object",
+					Description: "Map of file paths, relative to path, to the hash each file had when read (the hash field of read_context or get_files). A file that has changed since fails with a CONFLICT error and nothing is written.",
+					Examples:    []interface{}{map[string]interface{}{"src/app.ts": "3f2a9c0d41e8b7a65c2d19e0f4b3a871"}},
+				},
+			},
+			Required: []string{"patch", "path"},
+		},
+		Annotations: destructiveAnnotations(),
+	}, handleApplyPatch)
+
+	// batch tool
+	server.RegisterTool(mcp.Tool{
+		Name:        "batch",
+		Description: "Applies a list of write, modify, move, copy, delete and mkdir operations as one transaction, such as moving a file and updating everything that refers to it. Every operation is checked first, including that each path is inside the allowed directories, and nothing runs if any is invalid. Operations then run in order; if one fails, every operation before it is undone from backups, newest first, and the error gives each operation's status (applied, rolled_back, failed, invalid or not_run). Each operation takes the same arguments as the matching tool. When backups are enabled they are kept after the batch and named as backupId, so a committed batch can be undone with restore_backup." + errorsDoc(files.ErrNotApplied),
+		InputSchema: mcp.JSONSchema{
+			Type: "object",
+			Properties: map[string]mcp.Property{
+				"operations": {
+					Type:        "array",
+					Description: "Operations to apply, in order",
+					Items: &mcp.Property{
+						Type: "object",
+						Properties: map[string]mcp.Property{
+							"op": {
+								Type:        "string",
+								Description: "Kind of operation: write (write_file), modify (modify_file), move (move_file), copy (copy_file), delete (delete_file) or mkdir (create_directory)",
+								Enum:        []string{batch.OpWrite, batch.OpModify, batch.OpMove, batch.OpCopy, batch.OpDelete, batch.OpMkdir},
+							},
+							"path": {
+								Type:        "string",
+								Description: "File or directory to write, modify, delete or create",
+							},
+							"source": {
+								Type:        "string",
+								Description: "Path to move or copy",
+							},
+							"destination": {
+								Type:        "string",
+								Description: "Where to move or copy source to",
+							},
+							"content": {
+								Type:        "string",
+								Description: "Content to write, or to insert or replace lines with in a modify",
+							},
+							"mode": {
+								Type:        "string",
+								Description: "For write, octal permissions for a new file; for modify, the kind of edit, as in modify_file",
+							},
+							"find": {
+								Type:        "string",
+								Description: "Text to find in a modify",
+							},
+							"replace": {
+								Type:        "string",
+								Description: "Text to replace it with in a modify",
+							},
+							"recursive": {
+								Type:        "boolean",
+								Description: "Allow deleting a non-empty directory",
+							},
+							"expectedHash": {
+								Type:        "string",
+								Description: "For write and modify, the hash the file had when read; the batch fails with CONFLICT if it has changed",
+							},
+						},
+					},
+					Examples: []interface{}{[]interface{}{
+						map[string]interface{}{"op": "move", "source": "src/util.ts", "destination": "src/lib/util.ts"},
+						map[string]interface{}{"op": "modify", "path": "src/app.ts", "find": "./util", "replace": "./lib/util"},
+					}},
+				},
+				"dryRun": {
+					Type:        "boolean",
+					Description: "If true, checks every operation and returns what each would do, without changing anything. Operations on paths changed by an earlier operation in the batch are only checked when it runs.",
+					Default:     false,
+				},
+			},
+			Required: []string{"operations"},
+		},
+		Annotations: destructiveAnnotations(),
+	}, handleBatch)
+
+	// list_backups tool
+	server.RegisterTool(mcp.Tool{
+		Name:        "list_backups",
+		Description: "Lists the backups the server took before files were overwritten or deleted by write_file, modify_file, replace_in_files, apply_patch, batch, copy_file, move_file and delete_file, newest first. Each write result names its backup as backupId. Use restore_backup to undo a change." + errorsDoc(files.ErrDisabled),
+		InputSchema: mcp.JSONSchema{
+			Type: "object",
+			Properties: map[string]mcp.Property{
+				"path": {
+					Type:        "string",
+					Description: "Only list backups of this file or directory, or of anything under it",
+					Examples:    []interface{}{"./src", "/home/user/project/config.json"},
+				},
+				"limit": {
+					Type:        "integer",
+					Description: "Maximum number of backups to return",
+					Default:     float64(50),
+					Minimum:     int64Ptr(1),
 				},
 			},
-			Required: []string{"source", "destination"},
 		},
-		Annotations: writeAnnotations(),
-	}, handleMoveFile)
+		Annotations: readOnlyAnnotations(),
+	}, handleListBackups)
 
-	// delete_file tool
+	// restore_backup tool
 	server.RegisterTool(mcp.Tool{
-		Name:        "delete_file",
-		Description: "Permanently delete a file or directory from the file system. Warning: this action cannot be undone. For non-empty directories, the recursive flag must be set to true.",
+		Name:        "restore_backup",
+		Description: "Restores a file or directory from a backup listed by list_backups, to where it was taken from or to destination. An existing destination is only replaced with overwrite: true, and is backed up first so the restore can be undone too." + errorsDoc(files.ErrDisabled, files.ErrFileNotFound, files.ErrAlreadyExists, files.ErrReadOnlyPath, files.ErrExtension, files.ErrContentTooLarge, files.ErrSecretDetected),
 		InputSchema: mcp.JSONSchema{
 			Type: "object",
 			Properties: map[string]mcp.Property{
-				"path": {
+				"id": {
 					Type:        "string",
-					Description: "Absolute or relative path to the file or directory to delete",
-					Examples:    []interface{}{"/home/user/project/temp.txt", "./build", "C:\\Users\\project\\cache"},
+					Description: "Backup ID from list_backups or the backupId of a write result",
 				},
-				"recursive": {
+				"destination": {
+					Type:        "string",
+					Description: "Where to restore to. Defaults to the original path.",
+				},
+				"overwrite": {
 					Type:        "boolean",
-					Description: "If true, deletes directories and all their contents recursively. Required for non-empty directories. Use with caution.",
+					Description: "Replace the destination if it exists",
+					Default:     false,
+				},
+				"dryRun": {
+					Type:        "boolean",
+					Description: "If true, checks that the backup can be restored and reports whether the destination would be replaced, without changing anything",
 					Default:     false,
 				},
 			},
-			Required: []string{"path"},
+			Required: []string{"id"},
 		},
-		Annotations: destructiveAnnotations(),
-	}, handleDeleteFile)
+		Annotations: writeAnnotations(),
+	}, handleRestoreBackup)
 
-	// modify_file tool
+	// purge_backups tool
 	server.RegisterTool(mcp.Tool{
-		Name:        "modify_file",
-		Description: "Find and replace text within a file. Supports both literal string matching and regular expressions. Use this for targeted edits rather than rewriting entire files with write_file.",
+		Name:        "purge_backups",
+		Description: "Permanently deletes backups. Select them by ids, by path, by age with olderThan, or all of them with all: true; criteria combine. Old backups are also pruned automatically by age and total size." + errorsDoc(files.ErrDisabled),
 		InputSchema: mcp.JSONSchema{
 			Type: "object",
 			Properties: map[string]mcp.Property{
-				"path": {
-					Type:        "string",
-					Description: "Absolute or relative path to the file to modify",
-					Examples:    []interface{}{"/home/user/project/config.json", "./src/index.ts"},
+				"ids": {
+					Type:        "array",
+					Description: "Backup IDs to delete",
+					Items:       &mcp.Property{Type: "string"},
 				},
-				"find": {
+				"path": {
 					Type:        "string",
-					Description: "Text or regex pattern to search for in the file",
-					Examples:    []interface{}{"oldFunctionName", "version: \"1.0.0\"", "import\\s+.*from\\s+'lodash'"},
+					Description: "Delete backups of this file or directory, or of anything under it",
 				},
-				"replace": {
+				"olderThan": {
 					Type:        "string",
-					Description: "Text to replace matches with. For regex mode, can include capture group references ($1, $2, etc.)",
-					Examples:    []interface{}{"newFunctionName", "version: \"2.0.0\""},
+					Description: "Delete backups older than this duration, e.g. 90m, 24h or 7d",
+					Examples:    []interface{}{"24h", "7d"},
 				},
-				"all_occurrences": {
+				"all": {
 					Type:        "boolean",
-					Description: "If true, replaces all matching occurrences in the file. If false, replaces only the first match.",
-					Default:     true,
+					Description: "Delete every backup the server can access. Required when no other criterion is given.",
+					Default:     false,
 				},
-				"regex": {
+				"dryRun": {
 					Type:        "boolean",
-					Description: "If true, interprets the 'find' parameter as a regular expression pattern. If false, performs literal string matching.",
+					Description: "If true, lists the backups that would be deleted without deleting them",
 					Default:     false,
 				},
 			},
-			Required: []string{"path", "find", "replace"},
 		},
-		Annotations: writeAnnotations(),
-	}, handleModifyFile)
+		Annotations: destructiveAnnotations(),
+	}, handlePurgeBackups)
 }
 
 func handleListAllowedDirectories(args map[string]interface{}) (*mcp.CallToolResult, error) {
@@ -825,7 +2053,7 @@ func handleListAllowedDirectories(args map[string]interface{}) (*mcp.CallToolRes
 	jsonBytes, err := json.MarshalIndent(result, "", "  ")
 	if err != nil {
 		logger.Error("list_allowed_directories: failed to marshal result: %v", err)
-		return errorResult("Failed to generate result")
+		return errorResult(fmt.Errorf("failed to generate result: %w", err))
 	}
 
 	return textResult(string(jsonBytes))
@@ -842,13 +2070,19 @@ func handleListContextFiles(args map[string]interface{}) (*mcp.CallToolResult, e
 	absPath, err := validatePath(path)
 	if err != nil {
 		logger.Error("list_context_files: %v", err)
-		return errorResult(err.Error())
+		return errorResult(err)
 	}
 
-	entries, err := files.ListFiles(absPath, recursive, fileTypes, includeHidden)
+	var entries []files.FileEntry
+	if files.IsArchivePath(absPath) {
+		entries, err = files.ListArchive(absPath, recursive, fileTypes, includeHidden, walkOptions(args))
+		entries = filterBlockedEntries(entries)
+	} else {
+		entries, err = files.ListFilesWithOptions(absPath, recursive, fileTypes, includeHidden, walkOptions(args))
+	}
 	if err != nil {
 		logger.Error("list_context_files: failed to list files in %q: %v", absPath, err)
-		return errorResult(err.Error())
+		return errorResult(err)
 	}
 
 	logger.DirectoryRead(absPath, len(entries), nil)
@@ -858,40 +2092,117 @@ func handleListContextFiles(args map[string]interface{}) (*mcp.CallToolResult, e
 	return textResult(string(result))
 }
 
+func handleFindFiles(args map[string]interface{}) (*mcp.CallToolResult, error) {
+	logger.ToolCall("find_files", args)
+
+	query := getString(args, "query", "")
+	path, _ := args["path"].(string)
+	limit := getInt(args, "limit", files.DefaultFindLimit)
+	fileTypes := getStringArray(args, "fileTypes")
+
+	absPath, err := validatePath(path)
+	if err != nil {
+		logger.Error("find_files: %v", err)
+		return errorResult(err)
+	}
+
+	results, err := files.FindFiles(absPath, query, fileTypes, limit, files.FindOptions{
+		Walk:          walkOptions(args),
+		IncludeHidden: getBool(args, "includeHidden", false),
+		Keep:          func(path string) bool { return !isBlockedPath(path) },
+	})
+	if err != nil {
+		logger.Error("find_files: failed to find %q in %q: %v", query, absPath, err)
+		return errorResult(err)
+	}
+
+	logger.DirectoryRead(absPath, results.Scanned, nil)
+	logger.Debug("find_files: %d of %d files matched %q in %q", results.Total, results.Scanned, query, absPath)
+
+	result, _ := json.MarshalIndent(results, "", "  ")
+	return textResult(string(result))
+}
+
 func handleReadContext(args map[string]interface{}) (*mcp.CallToolResult, error) {
 	logger.ToolCall("read_context", args)
 
 	path, _ := args["path"].(string)
 	maxSize := getInt64(args, "maxSize", DefaultMaxSize)
+	encoding := getString(args, "encoding", files.EncodingAuto)
 	recursive := getBool(args, "recursive", true)
 	fileTypes := getStringArray(args, "fileTypes")
 	chunkNumber := getInt(args, "chunkNumber", 0)
+	maxTokens := getInt(args, "maxTokens", 0)
+	binaryFormat := getString(args, "binaryFormat", "auto")
 
 	absPath, err := validatePath(path)
 	if err != nil {
 		logger.Error("read_context: %v", err)
-		return errorResult(err.Error())
+		return errorResult(err)
+	}
+
+	encoding, err = files.NormalizeEncoding(encoding)
+	if err != nil {
+		logger.Error("read_context: %v", err)
+		return errorResult(err)
+	}
+
+	if files.IsArchivePath(absPath) {
+		return readArchivePath(absPath, recursive, fileTypes, maxSize, maxTokens, chunkNumber, walkOptions(args))
 	}
 
 	info, err := os.Stat(absPath)
 	if err != nil {
 		logger.Error("read_context: path not found %q: %v", absPath, err)
-		return errorResult(fmt.Sprintf("Path not found: %s", err.Error()))
+		return errorResult(err)
 	}
 
 	if info.IsDir() {
-		contents, err := files.ReadDirectory(absPath, recursive, fileTypes, maxSize)
+		contents, err := files.ReadDirectoryWithOptions(absPath, recursive, fileTypes, maxSize, walkOptions(args))
 		if err != nil {
 			logger.Error("read_context: failed to read directory %q: %v", absPath, err)
-			return errorResult(err.Error())
+			return errorResult(err)
 		}
 		logger.DirectoryRead(absPath, len(contents), nil)
+		if err := screenContents("read_context", contents); err != nil {
+			logger.Error("read_context: %v", err)
+			return errorResult(err)
+		}
+
+		if maxTokens > 0 {
+			report := files.ApplyTokenBudget(contents, nil, maxTokens, tokenEstimator)
+			logger.Debug("read_context: token budget %d for %q used %d (truncated=%d, summarized=%d, omitted=%d)",
+				maxTokens, absPath, report.UsedTokens, len(report.Truncated), len(report.Summarized), len(report.Omitted))
+			result, _ := json.MarshalIndent(map[string]interface{}{
+				"files":       contents,
+				"tokenBudget": report,
+			}, "", "  ")
+			return textResult(string(result))
+		}
+
+		files.CountTokens(contents, tokenEstimator)
 		result, _ := json.MarshalIndent(contents, "", "  ")
 		return textResult(string(result))
 	}
 
-	// Check cache first
-	if entry, ok := fileCache.Get(absPath); ok {
+	// Documents with a text extractor (PDF, Office, notebooks) are returned as
+	// extracted text unless raw bytes are requested with binaryFormat
+	extractable := encoding == files.EncodingAuto && binaryFormat == "auto" && extract.Supported(files.GetMimeType(absPath))
+
+	// Binary files are returned as image/resource content unless an encoding is forced
+	if encoding == files.EncodingAuto && !extractable {
+		if isBinary, _ := files.IsBinaryFile(absPath); isBinary {
+			return readBinaryFile(absPath, maxSize, binaryFormat)
+		}
+	}
+
+	if maxTokens > 0 {
+		return readFileTokenChunk(absPath, maxTokens, chunkNumber, encoding)
+	}
+
+	// Check cache first (the cache holds the rendered result of reads with
+	// auto-detected encoding only)
+	if entry, ok := fileCache.Get(absPath); ok && encoding == files.EncodingAuto {
 		if entry.ModifiedTime.Equal(info.ModTime()) || entry.ModifiedTime.After(info.ModTime()) {
 			logger.CacheHit(absPath)
 			logger.FileRead(absPath, entry.Size, nil)
@@ -901,44 +2212,200 @@ func handleReadContext(args map[string]interface{}) (*mcp.CallToolResult, error)
 	logger.CacheMiss(absPath)
 
 	// Handle large files with chunking
-	if info.Size() > maxSize {
+	if info.Size() > maxSize && !extractable {
 		content, totalChunks, err := analysis.ReadChunk(absPath, chunkNumber, DefaultChunkSize)
 		if err != nil {
 			logger.Error("read_context: failed to read chunk %d of %q: %v", chunkNumber, absPath, err)
-			return errorResult(err.Error())
+			return errorResult(err)
 		}
 
 		bytesRead := int64(len(content))
 		logger.FileRead(absPath, bytesRead, nil)
 		logger.Debug("read_context: read chunk %d/%d from %q (%d bytes)", chunkNumber+1, totalChunks, absPath, bytesRead)
+		if content, err = screenText("read_context", absPath, 0, content); err != nil {
+			logger.Error("read_context: %v", err)
+			return errorResult(err)
+		}
 
 		result := map[string]interface{}{
-			"content":      content,
-			"chunkNumber":  chunkNumber,
-			"totalChunks":  totalChunks,
-			"path":         absPath,
+			"content":     content,
+			"chunkNumber": chunkNumber,
+			"totalChunks": totalChunks,
+			"path":        absPath,
+		}
+		if hash, err := files.FileHash(absPath); err == nil {
+			result["hash"] = hash
 		}
 		data, _ := json.MarshalIndent(result, "", "  ")
 		return textResult(string(data))
 	}
 
-	content, err := files.ReadFile(absPath, maxSize)
+	content, err := files.ReadFileWithEncoding(absPath, maxSize, encoding)
 	if err != nil {
 		logger.Error("read_context: failed to read file %q: %v", absPath, err)
-		return errorResult(err.Error())
+		return errorResult(err)
 	}
 
 	logger.FileRead(absPath, content.Metadata.Size, nil)
-	logger.Debug("read_context: read file %q (%d bytes)", absPath, content.Metadata.Size)
+	logger.Debug("read_context: read file %q (%d bytes, %s)", absPath, content.Metadata.Size, content.Encoding)
+	if err := screenContent("read_context", content); err != nil {
+		logger.Error("read_context: %v", err)
+		return errorResult(err)
+	}
+
+	content.TokenCount = tokenEstimator.Count(content.Content)
+	result, _ := json.MarshalIndent(content, "", "  ")
 
 	// Update cache
-	fileCache.Set(absPath, &cache.Entry{
-		Content:      content.Content,
-		Size:         content.Metadata.Size,
-		ModifiedTime: content.Metadata.ModifiedTime,
-	})
-	logger.CacheSet(absPath, content.Metadata.Size)
+	if encoding == files.EncodingAuto {
+		fileCache.Set(absPath, &cache.Entry{
+			Content:      string(result),
+			Size:         content.Metadata.Size,
+			ModifiedTime: content.Metadata.ModifiedTime,
+		})
+		logger.CacheSet(absPath, content.Metadata.Size)
+	}
+
+	return textResult(string(result))
+}
+
+// readBinaryFile returns a binary file as native image content, an embedded
+// resource blob, base64 JSON or a hexdump, depending on format
+func readBinaryFile(absPath string, maxSize int64, format string) (*mcp.CallToolResult, error) {
+	content, err := files.ReadBinaryFile(absPath, maxSize)
+	if err != nil {
+		logger.Error("read_context: failed to read binary file %q: %v", absPath, err)
+		return errorResult(err)
+	}
+
+	logger.FileRead(absPath, content.Metadata.Size, nil)
+	logger.Debug("read_context: read binary file %q (%d bytes, %s, format=%s)", absPath, content.Metadata.Size, content.Metadata.MimeType, format)
+
+	switch format {
+	case "hex":
+		return textResult(content.HexDump())
+	case "base64":
+		data, _ := json.MarshalIndent(content.EncodeBase64(), "", "  ")
+		return textResult(string(data))
+	}
+
+	summary, _ := json.MarshalIndent(content, "", "  ")
+	if strings.HasPrefix(content.Metadata.MimeType, "image/") {
+		return imageResult(string(summary), content.Data, content.Metadata.MimeType)
+	}
+	return resourceResult(string(summary), fileURI(absPath), content.Data, content.Metadata.MimeType)
+}
+
+// readFileTokenChunk returns one token-sized chunk of a file. Files that fit
+// in maxTokens are returned whole, in the same shape as a normal read.
+func readFileTokenChunk(absPath string, maxTokens int, chunkNumber int, encoding string) (*mcp.CallToolResult, error) {
+	content, err := files.ReadFileWithEncoding(absPath, 0, encoding)
+	if err != nil {
+		logger.Error("read_context: failed to read file %q: %v", absPath, err)
+		return errorResult(err)
+	}
+
+	return tokenChunkResult(absPath, content, maxTokens, chunkNumber)
+}
+
+// tokenChunkResult returns content whole if it fits in maxTokens, otherwise
+// the requested token-sized chunk
+func tokenChunkResult(absPath string, content *files.FileContent, maxTokens int, chunkNumber int) (*mcp.CallToolResult, error) {
+	if err := screenContent("read_context", content); err != nil {
+		logger.Error("read_context: %v", err)
+		return errorResult(err)
+	}
+
+	content.TokenCount = tokenEstimator.Count(content.Content)
+	if content.TokenCount <= maxTokens {
+		logger.FileRead(absPath, content.Metadata.Size, nil)
+		result, _ := json.MarshalIndent(content, "", "  ")
+		return textResult(string(result))
+	}
+
+	chunks := tokens.Chunk(content.Content, maxTokens, tokenEstimator)
+	chunk := ""
+	if chunkNumber < len(chunks) {
+		chunk = chunks[chunkNumber]
+	}
+
+	logger.FileRead(absPath, int64(len(chunk)), nil)
+	logger.Debug("read_context: read token chunk %d/%d from %q (maxTokens=%d)", chunkNumber+1, len(chunks), absPath, maxTokens)
+
+	result := map[string]interface{}{
+		"content":     chunk,
+		"chunkNumber": chunkNumber,
+		"totalChunks": len(chunks),
+		"tokenCount":  tokenEstimator.Count(chunk),
+		"totalTokens": content.TokenCount,
+		"tokenizer":   tokenEstimator.Name(),
+		"path":        absPath,
+		"hash":        content.Hash,
+	}
+	data, _ := json.MarshalIndent(result, "", "  ")
+	return textResult(string(data))
+}
+
+// readArchivePath reads a file or directory inside an archive. Archive
+// entries are not cached and are read whole, so files larger than maxSize
+// are refused unless maxTokens is set.
+func readArchivePath(absPath string, recursive bool, fileTypes []string, maxSize int64, maxTokens int, chunkNumber int, opts files.WalkOptions) (*mcp.CallToolResult, error) {
+	metadata, err := files.StatArchive(absPath)
+	if err != nil {
+		logger.Error("read_context: %v", err)
+		return errorResult(err)
+	}
+
+	if metadata.IsDirectory {
+		contents, err := files.ReadArchiveDirectory(absPath, recursive, fileTypes, maxSize, opts)
+		if err != nil {
+			logger.Error("read_context: failed to read archive directory %q: %v", absPath, err)
+			return errorResult(err)
+		}
+		for path := range contents {
+			if isBlockedPath(path) {
+				delete(contents, path)
+			}
+		}
+		logger.DirectoryRead(absPath, len(contents), nil)
+		if err := screenContents("read_context", contents); err != nil {
+			logger.Error("read_context: %v", err)
+			return errorResult(err)
+		}
+
+		if maxTokens > 0 {
+			report := files.ApplyTokenBudget(contents, nil, maxTokens, tokenEstimator)
+			result, _ := json.MarshalIndent(map[string]interface{}{
+				"files":       contents,
+				"tokenBudget": report,
+			}, "", "  ")
+			return textResult(string(result))
+		}
+
+		files.CountTokens(contents, tokenEstimator)
+		result, _ := json.MarshalIndent(contents, "", "  ")
+		return textResult(string(result))
+	}
 
+	limit := maxSize
+	if maxTokens > 0 {
+		limit = 0
+	}
+	content, err := files.ReadArchiveFile(absPath, limit)
+	if err != nil {
+		logger.Error("read_context: failed to read archive file %q: %v", absPath, err)
+		return errorResult(err)
+	}
+	if maxTokens > 0 {
+		return tokenChunkResult(absPath, content, maxTokens, chunkNumber)
+	}
+
+	logger.FileRead(absPath, content.Metadata.Size, nil)
+	if err := screenContent("read_context", content); err != nil {
+		logger.Error("read_context: %v", err)
+		return errorResult(err)
+	}
+	content.TokenCount = tokenEstimator.Count(content.Content)
 	result, _ := json.MarshalIndent(content, "", "  ")
 	return textResult(string(result))
 }
@@ -952,21 +2419,55 @@ func handleSearchContext(args map[string]interface{}) (*mcp.CallToolResult, erro
 	fileTypes := getStringArray(args, "fileTypes")
 	contextLines := getInt(args, "contextLines", 2)
 	maxResults := getInt(args, "maxResults", 100)
+	opts := files.SearchOptions{
+		Walk:        walkOptions(args),
+		MaxFileSize: getInt64(args, "maxFileSize", files.DefaultSearchMaxFileSize),
+		FixedString: getBool(args, "fixedString", false),
+		IgnoreCase:  getBool(args, "ignoreCase", false),
+		WholeWord:   getBool(args, "wholeWord", false),
+		Multiline:   getBool(args, "multiline", false),
+		Invert:      getBool(args, "invert", false),
+		Output:      getString(args, "outputMode", files.OutputContent),
+	}
 
 	absPath, err := validatePath(path)
 	if err != nil {
 		logger.Error("search_context: %v", err)
-		return errorResult(err.Error())
+		return errorResult(err)
 	}
 
-	results, err := files.SearchFiles(absPath, pattern, recursive, fileTypes, contextLines, maxResults)
+	var results *files.SearchResult
+	if files.IsArchivePath(absPath) {
+		results, err = files.SearchArchive(absPath, pattern, recursive, fileTypes, contextLines, maxResults, opts)
+		if err == nil {
+			filterBlockedResults(results)
+		}
+	} else {
+		// An index covering the path rules out files that cannot match;
+		// inverted searches match files lacking the pattern, so scan them all
+		if getBool(args, "useIndex", true) && !opts.Invert {
+			if idx := searchIndex.Lookup(absPath); idx != nil {
+				if re, err := opts.Compile(pattern, absPath); err == nil {
+					if candidate, ok := idx.Candidates(re); ok {
+						opts.Candidate = candidate
+						logger.Debug("search_context: narrowing candidates with index of %q", idx.Root)
+					}
+				}
+			}
+		}
+		results, err = files.SearchFilesWithOptions(absPath, pattern, recursive, fileTypes, contextLines, maxResults, opts)
+	}
 	if err != nil {
 		logger.Error("search_context: failed to search in %q: %v", absPath, err)
-		return errorResult(err.Error())
+		return errorResult(err)
 	}
 
 	logger.Search(absPath, pattern, results.Total, nil)
 	logger.Debug("search_context: found %d matches for pattern %q in %q", results.Total, pattern, absPath)
+	if err := screenMatches("search_context", results.Matches); err != nil {
+		logger.Error("search_context: %v", err)
+		return errorResult(err)
+	}
 
 	result, _ := json.MarshalIndent(results, "", "  ")
 	return textResult(string(result))
@@ -982,20 +2483,20 @@ func handleAnalyzeCode(args map[string]interface{}) (*mcp.CallToolResult, error)
 	absPath, err := validatePath(path)
 	if err != nil {
 		logger.Error("analyze_code: %v", err)
-		return errorResult(err.Error())
+		return errorResult(err)
 	}
 
 	info, err := os.Stat(absPath)
 	if err != nil {
 		logger.Error("analyze_code: path not found %q: %v", absPath, err)
-		return errorResult(fmt.Sprintf("Path not found: %s", err.Error()))
+		return errorResult(err)
 	}
 
 	if info.IsDir() {
-		analyses, aggregateMetrics, err := analysis.AnalyzeDirectory(absPath, recursive, fileTypes)
+		analyses, aggregateMetrics, err := analysis.AnalyzeDirectoryWithOptions(absPath, recursive, fileTypes, walkOptions(args))
 		if err != nil {
 			logger.Error("analyze_code: failed to analyze directory %q: %v", absPath, err)
-			return errorResult(err.Error())
+			return errorResult(err)
 		}
 
 		logger.DirectoryRead(absPath, len(analyses), nil)
@@ -1009,52 +2510,279 @@ func handleAnalyzeCode(args map[string]interface{}) (*mcp.CallToolResult, error)
 		return textResult(string(data))
 	}
 
-	fileAnalysis, err := analysis.AnalyzeFile(absPath)
+	fileAnalysis, err := analysis.AnalyzeFile(absPath)
+	if err != nil {
+		logger.Error("analyze_code: failed to analyze file %q: %v", absPath, err)
+		return errorResult(err)
+	}
+
+	logger.FileRead(absPath, info.Size(), nil)
+	logger.Debug("analyze_code: analyzed file %q", absPath)
+
+	result, _ := json.MarshalIndent(fileAnalysis, "", "  ")
+	return textResult(string(result))
+}
+
+func handleGenerateOutline(args map[string]interface{}) (*mcp.CallToolResult, error) {
+	logger.ToolCall("generate_outline", args)
+
+	path, _ := args["path"].(string)
+
+	absPath, err := validatePath(path)
+	if err != nil {
+		logger.Error("generate_outline: %v", err)
+		return errorResult(err)
+	}
+
+	outline, err := analysis.GenerateOutline(absPath)
+	if err != nil {
+		logger.Error("generate_outline: failed to generate outline for %q: %v", absPath, err)
+		return errorResult(err)
+	}
+
+	logger.Debug("generate_outline: generated outline for %q", absPath)
+
+	result, _ := json.MarshalIndent(outline, "", "  ")
+	return textResult(string(result))
+}
+
+func handleCacheStats(args map[string]interface{}) (*mcp.CallToolResult, error) {
+	logger.ToolCall("cache_stats", args)
+
+	detailed := getBool(args, "detailed", false)
+	stats := fileCache.Stats(detailed)
+
+	logger.Debug("cache_stats: retrieved cache statistics (detailed=%v)", detailed)
+
+	result, _ := json.MarshalIndent(stats, "", "  ")
+	return textResult(string(result))
+}
+
+func handleRankFiles(args map[string]interface{}) (*mcp.CallToolResult, error) {
+	logger.ToolCall("rank_files", args)
+
+	query := getString(args, "query", "")
+	path, _ := args["path"].(string)
+
+	absPath, root, keep, err := indexedSearchScope(path, args)
+	if err != nil {
+		logger.Error("rank_files: %v", err)
+		return errorResult(err)
+	}
+
+	results, err := textIndexes.Get(root).Rank(query, absPath, index.RankOptions{
+		Limit:        getInt(args, "limit", index.DefaultRankLimit),
+		Snippets:     getInt(args, "snippets", index.DefaultRankSnippets),
+		ContextLines: getInt(args, "contextLines", 2),
+		Keep:         keep,
+	})
+	if err != nil {
+		logger.Error("rank_files: failed to rank %q in %q: %v", query, absPath, err)
+		return errorResult(err)
+	}
+
+	logger.Search(absPath, query, results.Total, nil)
+	logger.Debug("rank_files: %d of %d indexed files matched %q in %q (refresh: %+v)", results.Total, results.Indexed, query, absPath, *results.Refresh)
+	for _, file := range results.Files {
+		if err := screenMatches("rank_files", file.Snippets); err != nil {
+			logger.Error("rank_files: %v", err)
+			return errorResult(err)
+		}
+	}
+
+	result, _ := json.MarshalIndent(results, "", "  ")
+	return textResult(string(result))
+}
+
+func handleSemanticSearch(args map[string]interface{}) (*mcp.CallToolResult, error) {
+	logger.ToolCall("semantic_search", args)
+
+	query := getString(args, "query", "")
+	path, _ := args["path"].(string)
+
+	absPath, root, keep, err := indexedSearchScope(path, args)
+	if err != nil {
+		logger.Error("semantic_search: %v", err)
+		return errorResult(err)
+	}
+
+	results, err := vectorIndexes.Search(root, absPath, query, semantic.SearchOptions{
+		Limit:          getInt(args, "limit", semantic.DefaultLimit),
+		IncludeContent: getBool(args, "includeContent", false),
+		Keep:           keep,
+	})
+	if err != nil {
+		logger.Error("semantic_search: failed to search %q in %q: %v", query, absPath, err)
+		return errorResult(err)
+	}
+
+	logger.Search(absPath, query, len(results.Matches), nil)
+	logger.Debug("semantic_search: compared %d chunks for %q in %q (refresh: %+v)", results.Chunks, query, absPath, *results.Refresh)
+	for i := range results.Matches {
+		match := &results.Matches[i]
+		if match.Content, err = screenText("semantic_search", match.Path, match.StartLine, match.Content); err != nil {
+			logger.Error("semantic_search: %v", err)
+			return errorResult(err)
+		}
+	}
+
+	result, _ := json.MarshalIndent(results, "", "  ")
+	return textResult(string(result))
+}
+
+// indexedSearchScope validates the directory a rank_files or
+// semantic_search call covers. It returns the directory, the root whose
+// index serves it (the allowed root containing it, so one index serves
+// every directory below), and a filter applying fileTypes,
+// include/exclude and blocked patterns to indexed files.
+func indexedSearchScope(path string, args map[string]interface{}) (string, string, func(string) bool, error) {
+	absPath, err := validatePath(path)
+	if err != nil {
+		return "", "", nil, err
+	}
+	info, err := os.Stat(absPath)
+	if err != nil {
+		return "", "", nil, fmt.Errorf("path not found: %w", err)
+	}
+	if !info.IsDir() {
+		return "", "", nil, &files.FileError{Code: files.ErrInvalidPath, Message: "Path is not a directory", Path: absPath}
+	}
+
+	filter, err := files.NewPathFilter(getStringArray(args, "fileTypes"), walkOptions(args))
+	if err != nil {
+		return "", "", nil, err
+	}
+
+	root := absPath
+	for _, rootDir := range allowedRootDirs {
+		if isSubPath(rootDir, absPath) {
+			root = filepath.Clean(rootDir)
+			break
+		}
+	}
+
+	keep := func(path string) bool {
+		rel, err := filepath.Rel(absPath, path)
+		return err == nil && filter.MatchTree(filepath.ToSlash(rel)) && !isBlockedPath(path)
+	}
+	return absPath, root, keep, nil
+}
+
+func handleBuildSearchIndex(args map[string]interface{}) (*mcp.CallToolResult, error) {
+	logger.ToolCall("build_search_index", args)
+
+	roots, err := searchIndexRoots(args)
 	if err != nil {
-		logger.Error("analyze_code: failed to analyze file %q: %v", absPath, err)
-		return errorResult(err.Error())
+		logger.Error("build_search_index: %v", err)
+		return errorResult(err)
 	}
 
-	logger.FileRead(absPath, info.Size(), nil)
-	logger.Debug("analyze_code: analyzed file %q", absPath)
+	opts := index.BuildOptions{Walk: walkOptions(args)}
+	var results []*index.BuildStats
+	for _, root := range roots {
+		stats, err := searchIndex.Build(root, opts)
+		if err != nil {
+			logger.Error("build_search_index: failed to index %q: %v", root, err)
+			return errorResult(err)
+		}
+		logger.Info("build_search_index: indexed %d files in %q (%d added, %d updated, %d removed) in %s",
+			stats.Files, root, stats.Added, stats.Updated, stats.Removed, stats.Duration)
+		results = append(results, stats)
+	}
 
-	result, _ := json.MarshalIndent(fileAnalysis, "", "  ")
+	result, _ := json.MarshalIndent(results, "", "  ")
 	return textResult(string(result))
 }
 
-func handleGenerateOutline(args map[string]interface{}) (*mcp.CallToolResult, error) {
-	logger.ToolCall("generate_outline", args)
-
-	path, _ := args["path"].(string)
+func handleSearchIndexStatus(args map[string]interface{}) (*mcp.CallToolResult, error) {
+	logger.ToolCall("search_index_status", args)
 
-	absPath, err := validatePath(path)
+	roots, err := searchIndexRoots(args)
 	if err != nil {
-		logger.Error("generate_outline: %v", err)
-		return errorResult(err.Error())
+		logger.Error("search_index_status: %v", err)
+		return errorResult(err)
 	}
 
-	outline, err := analysis.GenerateOutline(absPath)
-	if err != nil {
-		logger.Error("generate_outline: failed to generate outline for %q: %v", absPath, err)
-		return errorResult(err.Error())
+	result := struct {
+		IndexDir   string          `json:"indexDir"`
+		Indexes    []*index.Status `json:"indexes"`
+		NotIndexed []string        `json:"notIndexed,omitempty"`
+	}{IndexDir: searchIndex.Dir()}
+	opts := index.BuildOptions{Walk: files.DefaultWalkOptions}
+	for _, root := range roots {
+		status, err := searchIndex.Status(root, opts)
+		if os.IsNotExist(err) {
+			result.NotIndexed = append(result.NotIndexed, root)
+			continue
+		}
+		if err != nil {
+			logger.Error("search_index_status: failed to read index of %q: %v", root, err)
+			return errorResult(err)
+		}
+		result.Indexes = append(result.Indexes, status)
 	}
 
-	logger.Debug("generate_outline: generated outline for %q", absPath)
+	logger.Debug("search_index_status: %d indexed, %d not indexed", len(result.Indexes), len(result.NotIndexed))
 
-	result, _ := json.MarshalIndent(outline, "", "  ")
-	return textResult(string(result))
+	data, _ := json.MarshalIndent(result, "", "  ")
+	return textResult(string(data))
 }
 
-func handleCacheStats(args map[string]interface{}) (*mcp.CallToolResult, error) {
-	logger.ToolCall("cache_stats", args)
+func handleDropSearchIndex(args map[string]interface{}) (*mcp.CallToolResult, error) {
+	logger.ToolCall("drop_search_index", args)
 
-	detailed := getBool(args, "detailed", false)
-	stats := fileCache.Stats(detailed)
+	roots, err := searchIndexRoots(args)
+	if err != nil {
+		logger.Error("drop_search_index: %v", err)
+		return errorResult(err)
+	}
 
-	logger.Debug("cache_stats: retrieved cache statistics (detailed=%v)", detailed)
+	var dropped, notIndexed []string
+	for _, root := range roots {
+		err := searchIndex.Drop(root)
+		if os.IsNotExist(err) {
+			notIndexed = append(notIndexed, root)
+			continue
+		}
+		if err != nil {
+			logger.Error("drop_search_index: failed to drop index of %q: %v", root, err)
+			return errorResult(err)
+		}
+		logger.Info("drop_search_index: dropped index of %q", root)
+		dropped = append(dropped, root)
+	}
 
-	result, _ := json.MarshalIndent(stats, "", "  ")
-	return textResult(string(result))
+	result := map[string]interface{}{
+		"dropped":    dropped,
+		"notIndexed": notIndexed,
+	}
+	data, _ := json.MarshalIndent(result, "", "  ")
+	return textResult(string(data))
+}
+
+// searchIndexRoots returns the directories a search index tool applies to:
+// the validated path argument, or every allowed root directory
+func searchIndexRoots(args map[string]interface{}) ([]string, error) {
+	path := getString(args, "path", "")
+	if path == "" {
+		if len(allowedRootDirs) == 0 {
+			return nil, invalidArgument("path is required when no root directories are configured")
+		}
+		return allowedRootDirs, nil
+	}
+
+	absPath, err := validatePath(path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(absPath)
+	if err != nil {
+		return nil, fmt.Errorf("path not found: %w", err)
+	}
+	if !info.IsDir() {
+		return nil, &files.FileError{Code: files.ErrInvalidPath, Message: "Path is not a directory", Path: absPath}
+	}
+	return []string{absPath}, nil
 }
 
 func handleGetChunkCount(args map[string]interface{}) (*mcp.CallToolResult, error) {
@@ -1062,17 +2790,38 @@ func handleGetChunkCount(args map[string]interface{}) (*mcp.CallToolResult, erro
 
 	path, _ := args["path"].(string)
 	chunkSize := getInt64(args, "chunkSize", DefaultChunkSize)
+	maxTokens := getInt(args, "maxTokens", 0)
 
 	absPath, err := validatePath(path)
 	if err != nil {
 		logger.Error("get_chunk_count: %v", err)
-		return errorResult(err.Error())
+		return errorResult(err)
+	}
+
+	if maxTokens > 0 {
+		count, totalTokens, err := analysis.GetTokenChunkCount(absPath, maxTokens, tokenEstimator)
+		if err != nil {
+			logger.Error("get_chunk_count: failed to get token chunk count for %q: %v", absPath, err)
+			return errorResult(err)
+		}
+
+		logger.Debug("get_chunk_count: %q has %d token chunks (maxTokens=%d)", absPath, count, maxTokens)
+
+		result := map[string]interface{}{
+			"path":        absPath,
+			"chunkCount":  count,
+			"maxTokens":   maxTokens,
+			"totalTokens": totalTokens,
+			"tokenizer":   tokenEstimator.Name(),
+		}
+		data, _ := json.MarshalIndent(result, "", "  ")
+		return textResult(string(data))
 	}
 
-	count, err := analysis.GetChunkCount(absPath, chunkSize)
+	count, err := analysis.GetChunkCountWithOptions(absPath, chunkSize, walkOptions(args))
 	if err != nil {
 		logger.Error("get_chunk_count: failed to get chunk count for %q: %v", absPath, err)
-		return errorResult(err.Error())
+		return errorResult(err)
 	}
 
 	logger.Debug("get_chunk_count: %q has %d chunks (chunkSize=%d)", absPath, count, chunkSize)
@@ -1092,11 +2841,15 @@ func handleGetFiles(args map[string]interface{}) (*mcp.CallToolResult, error) {
 	filePathList, ok := args["filePathList"].([]interface{})
 	if !ok {
 		logger.Error("get_files: invalid filePathList parameter")
-		return errorResult("Invalid filePathList")
+		return errorResult(invalidArgument("Invalid filePathList"))
 	}
 
+	maxTokens := getInt(args, "maxTokens", 0)
+
 	logger.Debug("get_files: processing %d files", len(filePathList))
 	results := make(map[string]interface{})
+	contents := make(map[string]*files.FileContent)
+	var order []string
 	var totalBytesRead int64
 
 	for _, item := range filePathList {
@@ -1114,16 +2867,30 @@ func handleGetFiles(args map[string]interface{}) (*mcp.CallToolResult, error) {
 		if err != nil {
 			logger.Error("get_files: %v", err)
 			results[fileName] = map[string]interface{}{
+				"code":  asFileError(err).Code,
 				"error": err.Error(),
 			}
 			continue
 		}
 
 		content, err := files.ReadFile(absPath, DefaultMaxSize)
+		var fileErr *files.FileError
+		if errors.As(err, &fileErr) && fileErr.Code == files.ErrBinaryFile {
+			// Binary files are returned as base64
+			binary, binErr := files.ReadBinaryFile(absPath, DefaultMaxSize)
+			if binErr == nil {
+				logger.FileRead(absPath, binary.Metadata.Size, nil)
+				totalBytesRead += binary.Metadata.Size
+				results[fileName] = binary.EncodeBase64()
+				continue
+			}
+			err = binErr
+		}
 		if err != nil {
 			logger.Error("get_files: failed to read file %q: %v", absPath, err)
 			logger.FileRead(absPath, 0, err)
 			results[fileName] = map[string]interface{}{
+				"code":  asFileError(err).Code,
 				"error": err.Error(),
 			}
 			continue
@@ -1131,10 +2898,37 @@ func handleGetFiles(args map[string]interface{}) (*mcp.CallToolResult, error) {
 
 		logger.FileRead(absPath, content.Metadata.Size, nil)
 		totalBytesRead += content.Metadata.Size
+		if err := screenContent("get_files", content); err != nil {
+			logger.Error("get_files: %v", err)
+			results[fileName] = map[string]interface{}{
+				"code":  asFileError(err).Code,
+				"error": err.Error(),
+			}
+			continue
+		}
+		contents[fileName] = content
+		order = append(order, fileName)
+	}
+
+	logger.Debug("get_files: read %d files, total %d bytes", len(contents), totalBytesRead)
+
+	var report *files.TokenBudgetReport
+	if maxTokens > 0 {
+		report = files.ApplyTokenBudget(contents, order, maxTokens, tokenEstimator)
+	} else {
+		files.CountTokens(contents, tokenEstimator)
+	}
+	for fileName, content := range contents {
 		results[fileName] = content
 	}
 
-	logger.Debug("get_files: read %d files, total %d bytes", len(results), totalBytesRead)
+	if report != nil {
+		data, _ := json.MarshalIndent(map[string]interface{}{
+			"files":       results,
+			"tokenBudget": report,
+		}, "", "  ")
+		return textResult(string(data))
+	}
 
 	data, _ := json.MarshalIndent(results, "", "  ")
 	return textResult(string(data))
@@ -1149,13 +2943,13 @@ func handleGetFolderStructure(args map[string]interface{}) (*mcp.CallToolResult,
 	absPath, err := validatePath(path)
 	if err != nil {
 		logger.Error("get_folder_structure: %v", err)
-		return errorResult(err.Error())
+		return errorResult(err)
 	}
 
-	structure, err := analysis.GetFolderStructure(absPath, maxDepth)
+	structure, err := analysis.GetFolderStructureWithOptions(absPath, maxDepth, walkOptions(args))
 	if err != nil {
 		logger.Error("get_folder_structure: failed to get structure for %q: %v", absPath, err)
-		return errorResult(err.Error())
+		return errorResult(err)
 	}
 
 	logger.Debug("get_folder_structure: generated structure for %q (maxDepth=%d)", absPath, maxDepth)
@@ -1181,6 +2975,77 @@ func parseCommaSeparated(value string) []string {
 	return result
 }
 
+// resolveSetting resolves a string setting from a CLI flag, then an environment
+// variable, then a default, and reports where the value came from
+func resolveSetting(flagValue, envName, defaultValue string) (string, logging.ConfigSource) {
+	if flagValue != "" {
+		return flagValue, logging.SourceFlag
+	}
+	if envVal := os.Getenv(envName); envVal != "" {
+		return envVal, logging.SourceEnvironment
+	}
+	return defaultValue, logging.SourceDefault
+}
+
+// parseAge parses a duration such as 90m or 72h, or a number of days such as
+// 7d
+func parseAge(value string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		n, err := strconv.ParseFloat(days, 64)
+		if err != nil {
+			return 0, err
+		}
+		return time.Duration(n * float64(24*time.Hour)), nil
+	}
+	return time.ParseDuration(value)
+}
+
+// backupHook returns the function that backs up files before operation
+// overwrites or deletes them, or nil if backups are disabled
+func backupHook(operation string) files.BackupFunc {
+	if backups == nil {
+		return nil
+	}
+	return func(path string) (string, error) {
+		return snapshot(path, operation)
+	}
+}
+
+// snapshot backs up path before operation changes it and returns the backup
+// ID. It returns "" if backups are disabled or path does not exist.
+func snapshot(path string, operation string) (string, error) {
+	return snapshotWith(path, operation, false)
+}
+
+// snapshotTarget is snapshot for an operation that writes through a symlink
+// at path, backing up the file it resolves to instead of the link
+func snapshotTarget(path string, operation string) (string, error) {
+	return snapshotWith(path, operation, true)
+}
+
+// snapshotWith backs up path, or with target the file it resolves to
+func snapshotWith(path string, operation string, target bool) (string, error) {
+	if backups == nil {
+		return "", nil
+	}
+	var b *backup.Backup
+	var err error
+	if target {
+		b, err = backups.SnapshotTarget(path, operation)
+	} else {
+		b, err = backups.Snapshot(path, operation)
+	}
+	if err != nil {
+		logger.Error("%s: failed to back up %q: %v", operation, path, err)
+		return "", err
+	}
+	if b == nil {
+		return "", nil
+	}
+	logger.Debug("%s: backed up %q as %s (%d bytes)", operation, path, b.ID, b.Size)
+	return b.ID, nil
+}
+
 // isAllowedPath checks if the given absolute path matches any allowed pattern (exceptions to blocked)
 func isAllowedPath(absPath string) bool {
 	if len(allowedPatterns) == 0 {
@@ -1256,29 +3121,146 @@ func isBlockedPath(absPath string) bool {
 // It checks blocked patterns first (deny takes precedence), then root directories.
 // Returns the absolute path if valid, or an error if access is denied.
 func validatePath(path string) (string, error) {
+	// Paths inside archives ("release.zip!/docs") are checked as the archive
+	// file, then the inner path is checked against blocked patterns
+	if archivePath, innerPath, ok := files.SplitArchivePath(path); ok {
+		absArchive, err := validatePath(archivePath)
+		if err != nil {
+			return "", err
+		}
+		if innerPath != "" && isBlockedPath(filepath.Join(absArchive, filepath.FromSlash(innerPath))) {
+			return "", accessDenied(path, "Path matches a blocked pattern")
+		}
+		return files.JoinArchivePath(absArchive, innerPath), nil
+	}
+
 	absPath, err := filepath.Abs(path)
 	if err != nil {
-		return "", fmt.Errorf("invalid path: %w", err)
+		return "", &files.FileError{Code: files.ErrInvalidPath, Message: err.Error(), Path: path}
+	}
+
+	// Resolve symlinks, including those in the parents of a file that does
+	// not exist yet, so the checks below see the file actually accessed
+	realPath, err := files.RealPath(absPath)
+	if err != nil {
+		return "", &files.FileError{Code: files.ErrInvalidPath, Message: err.Error(), Path: absPath}
 	}
 
 	// Check blocked patterns first (deny takes precedence)
-	if isBlockedPath(absPath) {
-		return "", fmt.Errorf("access denied: path %q matches blocked pattern", path)
+	if isBlockedPath(absPath) || isBlockedPath(realPath) {
+		return "", accessDenied(absPath, "Path matches a blocked pattern")
 	}
 
 	// If no root directory restrictions, allow all paths
 	if len(allowedRootDirs) == 0 {
+		if symlinkPolicy == SymlinkDeny && realPath != absPath {
+			return "", accessDenied(absPath, "Path goes through a symlink, which the symlink policy denies")
+		}
 		return absPath, nil
 	}
 
 	// Check if path is within ANY allowed root directory
-	for _, rootDir := range allowedRootDirs {
-		if isSubPath(rootDir, absPath) {
-			return absPath, nil
+	for i, rootDir := range allowedRootDirs {
+		if !isSubPath(rootDir, absPath) {
+			continue
+		}
+		if err := checkSymlinks(absPath, realPath, i); err != nil {
+			return "", err
+		}
+		return absPath, nil
+	}
+
+	return "", accessDenied(absPath, "Path is outside the allowed directories")
+}
+
+// checkSymlinks applies the symlink policy to a path inside the root
+// directory allowedRootDirs[root]. Links in the root's own path are always
+// followed.
+func checkSymlinks(absPath, realPath string, root int) error {
+	rel, err := filepath.Rel(allowedRootDirs[root], absPath)
+	if err != nil {
+		return &files.FileError{Code: files.ErrInvalidPath, Message: err.Error(), Path: absPath}
+	}
+	if realPath == filepath.Join(realRootDirs[root], rel) {
+		return nil
+	}
+
+	switch symlinkPolicy {
+	case SymlinkDeny:
+		return accessDenied(absPath, "Path goes through a symlink, which the symlink policy denies")
+	case SymlinkWithinRoots:
+		for _, realRoot := range realRootDirs {
+			if isSubPath(realRoot, realPath) {
+				return nil
+			}
+		}
+		return accessDenied(absPath, "Path resolves to %s, outside the allowed directories", realPath)
+	}
+	return nil
+}
+
+// validateWritePath validates a path that will be modified. Archives are
+// read-only, so paths inside them are rejected.
+func validateWritePath(path string) (string, error) {
+	absPath, err := validatePath(path)
+	if err != nil {
+		return "", err
+	}
+	if _, _, ok := files.SplitArchivePath(absPath); ok {
+		return "", accessDenied(path, "Path is inside an archive, which is read-only")
+	}
+	return absPath, nil
+}
+
+// filterBlockedEntries drops archive entries whose inner paths match a
+// blocked pattern
+func filterBlockedEntries(entries []files.FileEntry) []files.FileEntry {
+	filtered := entries[:0]
+	for _, entry := range entries {
+		if !isBlockedPath(entry.Path) {
+			filtered = append(filtered, entry)
+		}
+	}
+	return filtered
+}
+
+// filterBlockedResults removes matches, files and counts with blocked paths
+// from search results and recomputes the total
+func filterBlockedResults(results *files.SearchResult) {
+	matches := results.Matches[:0]
+	for _, match := range results.Matches {
+		if !isBlockedPath(match.Path) {
+			matches = append(matches, match)
 		}
 	}
+	results.Matches = matches
 
-	return "", fmt.Errorf("access denied: path %q is outside allowed directories", path)
+	paths := results.Files[:0]
+	for _, path := range results.Files {
+		if !isBlockedPath(path) {
+			paths = append(paths, path)
+		}
+	}
+	results.Files = paths
+
+	counts := results.Counts[:0]
+	total := 0
+	for _, count := range results.Counts {
+		if !isBlockedPath(count.Path) {
+			counts = append(counts, count)
+			total += count.Count
+		}
+	}
+	results.Counts = counts
+
+	switch {
+	case len(results.Files) > 0:
+		results.Total = len(results.Files)
+	case len(results.Counts) > 0:
+		results.Total = total
+	default:
+		results.Total = len(results.Matches)
+	}
 }
 
 // isSubPath checks if child is a subpath of parent
@@ -1301,13 +3283,159 @@ func textResult(text string) (*mcp.CallToolResult, error) {
 	}, nil
 }
 
-func errorResult(message string) (*mcp.CallToolResult, error) {
+// imageResult returns a caption followed by native MCP image content
+func imageResult(caption string, data []byte, mimeType string) (*mcp.CallToolResult, error) {
+	return &mcp.CallToolResult{
+		Content: []mcp.ContentItem{
+			{Type: "text", Text: caption},
+			{Type: "image", Data: base64.StdEncoding.EncodeToString(data), MimeType: mimeType},
+		},
+	}, nil
+}
+
+// resourceResult returns a caption followed by an embedded resource blob
+func resourceResult(caption string, uri string, data []byte, mimeType string) (*mcp.CallToolResult, error) {
+	return &mcp.CallToolResult{
+		Content: []mcp.ContentItem{
+			{Type: "text", Text: caption},
+			{Type: "resource", Resource: &mcp.ResourceContents{
+				URI:      uri,
+				MimeType: mimeType,
+				Blob:     base64.StdEncoding.EncodeToString(data),
+			}},
+		},
+	}, nil
+}
+
+// fileURI converts an absolute path to a file:// URI
+func fileURI(absPath string) string {
+	path := filepath.ToSlash(absPath)
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path // Windows drive letters
+	}
+	return (&url.URL{Scheme: "file", Path: path}).String()
+}
+
+// toolError is the machine-readable error returned by every failed tool
+// call, as structuredContent.error and as JSON in a second text item
+type toolError struct {
+	Code      files.ErrorCode `json:"code"`
+	Path      string          `json:"path,omitempty"`
+	Message   string          `json:"message"`
+	Retryable bool            `json:"retryable"`
+	Hint      string          `json:"hint,omitempty"`
+}
+
+// errorCodeInfo describes what a caller can do about an error code
+type errorCodeInfo struct {
+	retryable bool
+	hint      string
+}
+
+// errorCodes are the codes tool errors use. Retryable codes may succeed if
+// the call is repeated, after following the hint.
+var errorCodes = map[files.ErrorCode]errorCodeInfo{
+	files.ErrInvalidPath:     {false, "Check the path; relative paths resolve against the server's working directory"},
+	files.ErrFileNotFound:    {false, "Use list_context_files or find_files to locate the file"},
+	files.ErrFileTooLarge:    {false, "Read the file in chunks with chunkNumber or maxTokens"},
+	files.ErrPermission:      {false, "The server has no file system permission for this path"},
+	files.ErrAlreadyExists:   {false, "Choose another destination, or remove the existing path first"},
+	files.ErrNotEmpty:        {false, "Set recursive: true to delete a directory and its contents"},
+	files.ErrInvalidEncoding: {false, "Pass another encoding, or use one the content can be written in"},
+	files.ErrBinaryFile:      {false, "Use get_files, which returns binary files as base64"},
+	files.ErrArchiveLimit:    {false, "Read single entries of the archive instead of all of it"},
+	files.ErrNoMatch:         {true, "Read the file again and match find or anchor against its current content"},
+	files.ErrAmbiguousMatch:  {false, "Make find or anchor unique with more surrounding text, or edit by line numbers"},
+	files.ErrInvalidPatch:    {false, "Send a unified diff as produced by diff -u or git diff"},
+	files.ErrConflict:        {true, "Read the file again, reapply the change and retry with the new hash"},
+	files.ErrSecretDetected:  {false, "Remove the secret; the server does not return or write content containing secrets"},
+	files.ErrReadOnlyPath:    {false, "The write policy makes this path read-only; change another file"},
+	files.ErrExtension:       {false, "The write policy only allows the extensions named in the message"},
+	files.ErrContentTooLarge: {false, "Write less content per call, e.g. by splitting the file"},
+	files.ErrAccessDenied:    {false, "Use a path inside the allowed directories that is not blocked"},
+	files.ErrInvalidArgument: {false, "Correct the arguments as the message describes"},
+	files.ErrNotApplied:      {false, "Nothing was changed; fix the failures listed in the message and retry"},
+	files.ErrDisabled:        {false, "The feature is turned off in the server's configuration"},
+	files.ErrUnknown:         {false, ""},
+}
+
+// commonErrorCodes can be returned by any tool that takes arguments
+var commonErrorCodes = []files.ErrorCode{files.ErrInvalidArgument, files.ErrInvalidPath, files.ErrAccessDenied, files.ErrPermission, files.ErrUnknown}
+
+// errorsDoc returns the sentence that ends a tool description, naming the
+// codes its errors may have: codes first, then the common ones
+func errorsDoc(codes ...files.ErrorCode) string {
+	names := make([]string, 0, len(codes)+len(commonErrorCodes))
+	for _, code := range append(codes, commonErrorCodes...) {
+		names = append(names, string(code))
+	}
+	return " Errors include a JSON object {code, path, message, retryable, hint} (also as structuredContent.error) with code " + strings.Join(names, ", ") + "."
+}
+
+// errorResult returns a failed tool result for err. Errors that are not a
+// FileError are given a code: file system errors by their cause, others
+// UNKNOWN_ERROR.
+func errorResult(err error) (*mcp.CallToolResult, error) {
+	fileErr := asFileError(err)
+	info := errorCodes[fileErr.Code]
+	message, _, _ := strings.Cut(fileErr.Message, "\n")
+	te := toolError{
+		Code:      fileErr.Code,
+		Path:      fileErr.Path,
+		Message:   strings.TrimSuffix(message, ":"),
+		Retryable: info.retryable,
+		Hint:      info.hint,
+	}
+	data, _ := json.Marshal(map[string]interface{}{"error": te})
 	return &mcp.CallToolResult{
-		Content: []mcp.ContentItem{{Type: "text", Text: message}},
-		IsError: true,
+		Content: []mcp.ContentItem{
+			{Type: "text", Text: fileErr.Error()},
+			{Type: "text", Text: string(data)},
+		},
+		IsError:           true,
+		StructuredContent: map[string]interface{}{"error": te},
 	}, nil
 }
 
+// asFileError returns err as a FileError, mapping file system errors to
+// their codes
+func asFileError(err error) *files.FileError {
+	var fileErr *files.FileError
+	if errors.As(err, &fileErr) {
+		return fileErr
+	}
+	fileErr = &files.FileError{Code: files.ErrUnknown, Message: err.Error()}
+	var pathErr *fs.PathError
+	if errors.As(err, &pathErr) {
+		fileErr.Path = pathErr.Path
+	}
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		fileErr.Code = files.ErrFileNotFound
+	case errors.Is(err, fs.ErrPermission):
+		fileErr.Code = files.ErrPermission
+	case errors.Is(err, fs.ErrExist):
+		fileErr.Code = files.ErrAlreadyExists
+	}
+	return fileErr
+}
+
+// invalidArgument returns an INVALID_ARGUMENT error
+func invalidArgument(format string, args ...interface{}) error {
+	return &files.FileError{Code: files.ErrInvalidArgument, Message: fmt.Sprintf(format, args...)}
+}
+
+// accessDenied returns an ACCESS_DENIED error for path
+func accessDenied(path string, format string, args ...interface{}) error {
+	return &files.FileError{Code: files.ErrAccessDenied, Message: fmt.Sprintf(format, args...), Path: path}
+}
+
+// notApplied returns a NOT_APPLIED error for an all-or-nothing change that
+// made no changes, or could not undo them, with the outcome of each part
+func notApplied(message string, data []byte) error {
+	return &files.FileError{Code: files.ErrNotApplied, Message: fmt.Sprintf("%s:\n%s", message, data)}
+}
+
 func getBool(args map[string]interface{}, key string, defaultVal bool) bool {
 	if val, ok := args[key].(bool); ok {
 		return val
@@ -1326,22 +3454,191 @@ func getInt64(args map[string]interface{}, key string, defaultVal int64) int64 {
 	if val, ok := args[key].(float64); ok {
 		return int64(val)
 	}
-	return defaultVal
+	return defaultVal
+}
+
+func getStringArray(args map[string]interface{}, key string) []string {
+	if val, ok := args[key].([]interface{}); ok {
+		result := make([]string, 0, len(val))
+		for _, v := range val {
+			if s, ok := v.(string); ok {
+				result = append(result, s)
+			}
+		}
+		return result
+	}
+	return nil
+}
+
+// screenText scans text that tool is about to return from path, starting
+// at line (0 if unknown), for secrets. Each one found is audit-logged. Under
+// the redact policy the secrets are replaced with markers; under refuse an
+// error names the rules that matched.
+func screenText(tool string, path string, line int, text string) (string, error) {
+	findings, err := screenFindings(tool, path, line, text)
+	if err != nil {
+		return "", err
+	}
+	return secrets.Redact(text, findings), nil
+}
+
+// screenFindings scans text as screenText does, logging and refusing, and
+// returns the secrets to redact
+func screenFindings(tool string, path string, line int, text string) ([]secrets.Finding, error) {
+	findings := secretScanner.Scan(path, text)
+	if len(findings) == 0 {
+		return nil, nil
+	}
+
+	action := "redacted"
+	if secretPolicy == SecretRefuse {
+		action = "refused"
+	}
+	for _, finding := range findings {
+		findingLine := finding.Line
+		if line > 0 {
+			findingLine += line - 1
+		}
+		logger.SecretFound(tool, path, finding.RuleID, findingLine, action)
+	}
+
+	if secretPolicy == SecretRefuse {
+		return nil, &files.FileError{
+			Code:    files.ErrSecretDetected,
+			Message: fmt.Sprintf("Content matches secret rules (%s) and the secret policy refuses to return it", strings.Join(secrets.RuleIDs(findings), ", ")),
+			Path:    path,
+		}
+	}
+	return findings, nil
+}
+
+// screenLines applies screenText to consecutive pieces of a file starting
+// at line, scanning them as one text so that a secret spanning several of
+// them, such as a private key, is found. Each piece keeps its place, with
+// its part of a secret redacted.
+func screenLines(tool string, path string, line int, pieces []string) ([]string, error) {
+	text := strings.Join(pieces, "\n")
+	findings, err := screenFindings(tool, path, line, text)
+	if err != nil || len(findings) == 0 {
+		return pieces, err
+	}
+
+	screened := make([]string, len(pieces))
+	offset := 0
+	for i, piece := range pieces {
+		var own []secrets.Finding
+		for _, f := range findings {
+			start, end := f.Start-offset, f.End-offset
+			if start < 0 {
+				start = 0
+			}
+			if end > len(piece) {
+				end = len(piece)
+			}
+			if start < end {
+				own = append(own, secrets.Finding{RuleID: f.RuleID, Start: start, End: end})
+			}
+		}
+		screened[i] = secrets.Redact(piece, own)
+		offset += len(piece) + 1
+	}
+	return screened, nil
+}
+
+// screenDiff applies screenText to a diff, or other text quoting the file,
+// that tool returns for path. Once the change has been made a refusal must
+// not hide that it succeeded, so with applied the text is replaced by the
+// refusal instead.
+func screenDiff(tool string, path string, text *string, applied bool) error {
+	if *text == "" {
+		return nil
+	}
+	screened, err := screenText(tool, path, 0, *text)
+	if err != nil {
+		if !applied {
+			return err
+		}
+		screened = err.Error()
+	}
+	*text = screened
+	return nil
+}
+
+// screenContent applies screenText to a file read by tool
+func screenContent(tool string, content *files.FileContent) error {
+	text, err := screenText(tool, content.Path, 1, content.Content)
+	if err != nil {
+		return err
+	}
+	content.Content = text
+	return nil
+}
+
+// screenContents applies screenText to the files of a directory read, in
+// path order so a refusal names the same file every time
+func screenContents(tool string, contents map[string]*files.FileContent) error {
+	paths := make([]string, 0, len(contents))
+	for path := range contents {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		if err := screenContent(tool, contents[path]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// screenMatches applies screenLines to each search match together with its
+// context lines. The offsets of a redacted match no longer fit, so they are
+// dropped.
+func screenMatches(tool string, matches []files.SearchMatch) error {
+	for i := range matches {
+		match := &matches[i]
+		before := len(match.Context.Before)
+		pieces := append(append(append([]string{}, match.Context.Before...), match.Content), match.Context.After...)
+		screened, err := screenLines(tool, match.Path, match.Line-before, pieces)
+		if err != nil {
+			return err
+		}
+		copy(match.Context.Before, screened[:before])
+		copy(match.Context.After, screened[before+1:])
+		if text := screened[before]; text != match.Content {
+			match.Content = text
+			match.Submatches = nil
+		}
+	}
+	return nil
 }
 
-func getStringArray(args map[string]interface{}, key string) []string {
-	if val, ok := args[key].([]interface{}); ok {
-		result := make([]string, 0, len(val))
-		for _, v := range val {
-			if s, ok := v.(string); ok {
-				result = append(result, s)
-			}
+// screenBatch applies screenDiff to the diffs in the results of a batch
+func screenBatch(result *batch.Result) error {
+	for _, op := range result.Operations {
+		var err error
+		switch res := op.Result.(type) {
+		case *files.WriteResult:
+			err = screenDiff("batch", res.Path, &res.Diff, result.Committed)
+		case *files.ModifyResult:
+			err = screenDiff("batch", res.Path, &res.Diff, result.Committed)
+		}
+		if err != nil {
+			return err
 		}
-		return result
 	}
 	return nil
 }
 
+// walkOptions returns the server's directory walk options with any per-call
+// respectGitignore override and include/exclude globs applied
+func walkOptions(args map[string]interface{}) files.WalkOptions {
+	opts := files.DefaultWalkOptions
+	opts.RespectGitignore = getBool(args, "respectGitignore", opts.RespectGitignore)
+	opts.Include = getStringArray(args, "include")
+	opts.Exclude = getStringArray(args, "exclude")
+	return opts
+}
+
 func getString(args map[string]interface{}, key string, defaultVal string) string {
 	if val, ok := args[key].(string); ok {
 		return val
@@ -1382,43 +3679,101 @@ func handleWriteFile(args map[string]interface{}) (*mcp.CallToolResult, error) {
 
 	path, _ := args["path"].(string)
 	content, _ := args["content"].(string)
+	opts, err := writeOptions(args)
+	if err != nil {
+		logger.Error("write_file: %v", err)
+		return errorResult(err)
+	}
+	opts.Backup = backupHook("write_file")
+	opts.DryRun = getBool(args, "dryRun", false)
+	opts.ContextLines = files.DefaultDiffContext
 
-	absPath, err := validatePath(path)
+	absPath, err := validateWritePath(path)
+	if err == nil {
+		err = writePolicy.CheckPath(absPath, false)
+	}
 	if err != nil {
 		logger.Error("write_file: %v", err)
-		return errorResult(err.Error())
+		return refusedResult(opts.DryRun, pathCheck{path, err})
 	}
 
-	result, err := files.WriteFile(absPath, content)
+	result, err := files.WriteFileWithOptions(absPath, content, opts)
 	if err != nil {
 		logger.Error("write_file: failed to write file %q: %v", absPath, err)
-		return errorResult(err.Error())
+		return errorResult(err)
+	}
+
+	if result.DryRun {
+		logger.Info("write_file: dry run for %q (%d bytes, created %t)", absPath, result.BytesWritten, result.Created)
+		if err := screenDiff("write_file", absPath, &result.Diff, false); err != nil {
+			return errorResult(err)
+		}
+		data, _ := json.MarshalIndent(result, "", "  ")
+		return textResult(string(data))
 	}
 
 	action := "overwrote"
 	if result.Created {
 		action = "created"
 	}
-	logger.Info("write_file: %s file %q (%d bytes)", action, absPath, result.BytesWritten)
+	logger.Info("write_file: %s file %q (%d bytes, %s)", action, absPath, result.BytesWritten, result.Encoding)
 
 	data, _ := json.MarshalIndent(result, "", "  ")
 	return textResult(string(data))
 }
 
+// writeOptions reads the write_file arguments other than path and content
+func writeOptions(args map[string]interface{}) (files.WriteOptions, error) {
+	opts := files.WriteOptions{
+		Encoding:     getString(args, "encoding", files.EncodingAuto),
+		LineEnding:   getString(args, "lineEnding", files.LineEndingAuto),
+		ExpectedHash: getString(args, "expectedHash", ""),
+		Check:        writePolicy.CheckContent,
+	}
+	if mode := getString(args, "mode", ""); mode != "" {
+		perm, err := strconv.ParseUint(mode, 8, 32)
+		if err != nil || perm > 0777 {
+			return opts, invalidArgument("Invalid mode %q: expected octal permissions such as 0644", mode)
+		}
+		opts.Mode = fs.FileMode(perm)
+	}
+	return opts, nil
+}
+
 func handleCreateDirectory(args map[string]interface{}) (*mcp.CallToolResult, error) {
 	logger.ToolCall("create_directory", args)
 
 	path, _ := args["path"].(string)
+	dryRun := getBool(args, "dryRun", false)
 
-	absPath, err := validatePath(path)
+	absPath, err := validateWritePath(path)
+	if err == nil {
+		err = writePolicy.CheckPath(absPath, true)
+	}
 	if err != nil {
 		logger.Error("create_directory: %v", err)
-		return errorResult(err.Error())
+		return refusedResult(dryRun, pathCheck{path, err})
+	}
+
+	if dryRun {
+		planned, err := files.PlanCreateDirectory(absPath)
+		if err != nil {
+			logger.Error("create_directory: %v", err)
+			return errorResult(err)
+		}
+		logger.Info("create_directory: dry run for %q (%d to create)", absPath, len(planned))
+		data, _ := json.MarshalIndent(map[string]interface{}{
+			"path":    absPath,
+			"created": len(planned) > 0,
+			"dryRun":  true,
+			"files":   planned,
+		}, "", "  ")
+		return textResult(string(data))
 	}
 
 	if err := files.CreateDirectory(absPath); err != nil {
 		logger.Error("create_directory: failed to create directory %q: %v", absPath, err)
-		return errorResult(err.Error())
+		return errorResult(err)
 	}
 
 	logger.Info("create_directory: created directory %q", absPath)
@@ -1436,26 +3791,43 @@ func handleCopyFile(args map[string]interface{}) (*mcp.CallToolResult, error) {
 
 	source, _ := args["source"].(string)
 	destination, _ := args["destination"].(string)
+	dryRun := getBool(args, "dryRun", false)
 
-	absSrc, err := validatePath(source)
-	if err != nil {
-		logger.Error("copy_file: source %v", err)
-		return errorResult(err.Error())
+	absSrc, srcErr := validatePath(source)
+	absDst, dstErr := validateWritePath(destination)
+	if srcErr != nil || dstErr != nil {
+		logger.Error("copy_file: source %v, destination %v", srcErr, dstErr)
+		return refusedResult(dryRun, pathCheck{source, srcErr}, pathCheck{destination, dstErr})
+	}
+	if err := checkTransfer(absSrc, absDst, false); err != nil {
+		logger.Error("copy_file: %v", err)
+		return refusedResult(dryRun, pathCheck{destination, err})
+	}
+
+	if dryRun {
+		result, err := files.PlanCopy(absSrc, absDst)
+		if err != nil {
+			logger.Error("copy_file: %v", err)
+			return errorResult(err)
+		}
+		logger.Info("copy_file: dry run for %q to %q (%d entries, %d bytes)", absSrc, absDst, len(result.Files), result.BytesCopied)
+		data, _ := json.MarshalIndent(result, "", "  ")
+		return textResult(string(data))
 	}
 
-	absDst, err := validatePath(destination)
+	backupID, err := snapshotTarget(absDst, "copy_file")
 	if err != nil {
-		logger.Error("copy_file: destination %v", err)
-		return errorResult(err.Error())
+		return errorResult(err)
 	}
 
 	result, err := files.CopyFile(absSrc, absDst)
 	if err != nil {
 		logger.Error("copy_file: failed to copy %q to %q: %v", absSrc, absDst, err)
-		return errorResult(err.Error())
+		return errorResult(err)
 	}
 
 	logger.Info("copy_file: copied %q to %q (%d bytes)", absSrc, absDst, result.BytesCopied)
+	result.BackupID = backupID
 
 	data, _ := json.MarshalIndent(result, "", "  ")
 	return textResult(string(data))
@@ -1466,26 +3838,43 @@ func handleMoveFile(args map[string]interface{}) (*mcp.CallToolResult, error) {
 
 	source, _ := args["source"].(string)
 	destination, _ := args["destination"].(string)
+	dryRun := getBool(args, "dryRun", false)
 
-	absSrc, err := validatePath(source)
-	if err != nil {
-		logger.Error("move_file: source %v", err)
-		return errorResult(err.Error())
+	absSrc, srcErr := validateWritePath(source)
+	absDst, dstErr := validateWritePath(destination)
+	if srcErr != nil || dstErr != nil {
+		logger.Error("move_file: source %v, destination %v", srcErr, dstErr)
+		return refusedResult(dryRun, pathCheck{source, srcErr}, pathCheck{destination, dstErr})
+	}
+	if err := checkTransfer(absSrc, absDst, true); err != nil {
+		logger.Error("move_file: %v", err)
+		return refusedResult(dryRun, pathCheck{destination, err})
+	}
+
+	if dryRun {
+		result, err := files.PlanMove(absSrc, absDst)
+		if err != nil {
+			logger.Error("move_file: %v", err)
+			return errorResult(err)
+		}
+		logger.Info("move_file: dry run for %q to %q (%d entries, %d bytes)", absSrc, absDst, len(result.Files), result.BytesMoved)
+		data, _ := json.MarshalIndent(result, "", "  ")
+		return textResult(string(data))
 	}
 
-	absDst, err := validatePath(destination)
+	backupID, err := snapshot(absDst, "move_file")
 	if err != nil {
-		logger.Error("move_file: destination %v", err)
-		return errorResult(err.Error())
+		return errorResult(err)
 	}
 
 	result, err := files.MoveFile(absSrc, absDst)
 	if err != nil {
 		logger.Error("move_file: failed to move %q to %q: %v", absSrc, absDst, err)
-		return errorResult(err.Error())
+		return errorResult(err)
 	}
 
 	logger.Info("move_file: moved %q to %q", absSrc, absDst)
+	result.BackupID = backupID
 
 	data, _ := json.MarshalIndent(result, "", "  ")
 	return textResult(string(data))
@@ -1496,17 +3885,41 @@ func handleDeleteFile(args map[string]interface{}) (*mcp.CallToolResult, error)
 
 	path, _ := args["path"].(string)
 	recursive := getBool(args, "recursive", false)
+	dryRun := getBool(args, "dryRun", false)
 
-	absPath, err := validatePath(path)
+	absPath, err := validateWritePath(path)
+	if err == nil {
+		err = checkDelete(absPath, recursive)
+	}
 	if err != nil {
 		logger.Error("delete_file: %v", err)
-		return errorResult(err.Error())
+		return refusedResult(dryRun, pathCheck{path, err})
+	}
+
+	if dryRun {
+		result, err := files.PlanDelete(absPath, recursive)
+		if err != nil {
+			logger.Error("delete_file: %v", err)
+			return errorResult(err)
+		}
+		logger.Info("delete_file: dry run for %q (%d entries, %d bytes)", absPath, len(result.Files), result.BytesDeleted)
+		data, _ := json.MarshalIndent(result, "", "  ")
+		return textResult(string(data))
+	}
+
+	// Only a recursive delete can remove a non-empty directory, so other
+	// directory deletes have nothing to back up
+	var backupID string
+	if info, err := os.Lstat(absPath); err == nil && (!info.IsDir() || recursive) {
+		if backupID, err = snapshot(absPath, "delete_file"); err != nil {
+			return errorResult(err)
+		}
 	}
 
 	result, err := files.DeleteFile(absPath, recursive)
 	if err != nil {
 		logger.Error("delete_file: failed to delete %q: %v", absPath, err)
-		return errorResult(err.Error())
+		return errorResult(err)
 	}
 
 	itemType := "file"
@@ -1514,6 +3927,7 @@ func handleDeleteFile(args map[string]interface{}) (*mcp.CallToolResult, error)
 		itemType = "directory"
 	}
 	logger.Info("delete_file: deleted %s %q", itemType, absPath)
+	result.BackupID = backupID
 
 	data, _ := json.MarshalIndent(result, "", "  ")
 	return textResult(string(data))
@@ -1523,29 +3937,565 @@ func handleModifyFile(args map[string]interface{}) (*mcp.CallToolResult, error)
 	logger.ToolCall("modify_file", args)
 
 	path, _ := args["path"].(string)
-	find, _ := args["find"].(string)
-	replace := getString(args, "replace", "")
-	allOccurrences := getBool(args, "all_occurrences", true)
-	useRegex := getBool(args, "regex", false)
+	opts := modifyOptions(args)
+	opts.Backup = backupHook("modify_file")
+	opts.DryRun = getBool(args, "dryRun", false)
+	if (opts.Mode == "" || opts.Mode == files.ModifyReplace) && opts.Find == "" {
+		logger.Error("modify_file: missing find")
+		return errorResult(invalidArgument("find is required in replace mode"))
+	}
 
-	absPath, err := validatePath(path)
+	absPath, err := validateWritePath(path)
+	if err == nil {
+		err = writePolicy.CheckPath(absPath, false)
+	}
 	if err != nil {
 		logger.Error("modify_file: %v", err)
-		return errorResult(err.Error())
+		return refusedResult(opts.DryRun, pathCheck{path, err})
 	}
 
-	result, err := files.ModifyFile(absPath, find, replace, allOccurrences, useRegex)
+	result, err := files.ModifyFileWithOptions(absPath, opts)
 	if err != nil {
 		logger.Error("modify_file: failed to modify %q: %v", absPath, err)
-		return errorResult(err.Error())
+		return errorResult(err)
 	}
 
-	if result.Modified {
-		logger.Info("modify_file: modified %q (%d replacements)", absPath, result.Replacements)
+	if result.DryRun {
+		logger.Info("modify_file: dry run for %q (%s, %d replacements)", absPath, opts.Mode, result.Replacements)
+	} else if result.Modified {
+		logger.Info("modify_file: modified %q (%s, %d replacements)", absPath, opts.Mode, result.Replacements)
 	} else {
 		logger.Info("modify_file: no changes made to %q", absPath)
 	}
+	if err := screenDiff("modify_file", absPath, &result.Diff, result.Modified && !result.DryRun); err != nil {
+		return errorResult(err)
+	}
+
+	data, _ := json.MarshalIndent(result, "", "  ")
+	return textResult(string(data))
+}
+
+// modifyOptions reads the modify_file arguments other than path
+func modifyOptions(args map[string]interface{}) files.ModifyOptions {
+	return files.ModifyOptions{
+		Mode:           getString(args, "mode", files.ModifyReplace),
+		Find:           getString(args, "find", ""),
+		Replace:        getString(args, "replace", ""),
+		AllOccurrences: getBool(args, "all_occurrences", true),
+		Regex:          getBool(args, "regex", false),
+		Unique:         getBool(args, "unique", false),
+		StartLine:      getInt(args, "startLine", 0),
+		EndLine:        getInt(args, "endLine", 0),
+		Anchor:         getString(args, "anchor", ""),
+		Before:         getString(args, "position", "after") == "before",
+		Content:        getString(args, "content", ""),
+		ContextLines:   getInt(args, "contextLines", files.DefaultDiffContext),
+		ExpectedHash:   getString(args, "expectedHash", ""),
+		Check:          writePolicy.CheckContent,
+	}
+}
+
+func handleReplaceInFiles(args map[string]interface{}) (*mcp.CallToolResult, error) {
+	logger.ToolCall("replace_in_files", args)
+
+	pattern, _ := args["pattern"].(string)
+	replacement, ok := args["replacement"].(string)
+	path, _ := args["path"].(string)
+	recursive := getBool(args, "recursive", true)
+	fileTypes := getStringArray(args, "fileTypes")
+	opts := files.ReplaceOptions{
+		Search: files.SearchOptions{
+			Walk:        walkOptions(args),
+			MaxFileSize: getInt64(args, "maxFileSize", files.DefaultSearchMaxFileSize),
+			FixedString: getBool(args, "fixedString", false),
+			IgnoreCase:  getBool(args, "ignoreCase", false),
+			WholeWord:   getBool(args, "wholeWord", false),
+			Multiline:   getBool(args, "multiline", false),
+		},
+		DryRun:       getBool(args, "dryRun", true),
+		ContextLines: getInt(args, "contextLines", files.DefaultDiffContext),
+		Keep: func(path string) bool {
+			return !isBlockedPath(path) && writePolicy.CheckPath(path, false) == nil
+		},
+		Check:  writePolicy.CheckContent,
+		Backup: backupHook("replace_in_files"),
+	}
+	if !ok {
+		logger.Error("replace_in_files: missing replacement")
+		return errorResult(invalidArgument("replacement is required (use an empty string to delete matches)"))
+	}
+
+	absPath, err := validateWritePath(path)
+	if err != nil {
+		logger.Error("replace_in_files: %v", err)
+		return errorResult(err)
+	}
+
+	if getBool(args, "useIndex", true) {
+		if idx := searchIndex.Lookup(absPath); idx != nil {
+			if re, err := opts.Search.Compile(pattern, absPath); err == nil {
+				if candidate, ok := idx.Candidates(re); ok {
+					opts.Search.Candidate = candidate
+					logger.Debug("replace_in_files: narrowing candidates with index of %q", idx.Root)
+				}
+			}
+		}
+	}
+
+	result, err := files.ReplaceInFiles(absPath, pattern, replacement, recursive, fileTypes, opts)
+	if err != nil {
+		logger.Error("replace_in_files: failed to replace in %q: %v", absPath, err)
+		return errorResult(err)
+	}
+
+	if result.DryRun {
+		logger.Info("replace_in_files: dry run found %d replacements in %d files under %q", result.Replacements, len(result.Files), absPath)
+	} else {
+		for _, file := range result.Files {
+			logger.Debug("replace_in_files: %d replacements in %q", file.Replacements, file.Path)
+		}
+		logger.Info("replace_in_files: made %d replacements in %d files under %q", result.Replacements, len(result.Files), absPath)
+	}
+	for i := range result.Files {
+		file := &result.Files[i]
+		if err := screenDiff("replace_in_files", file.Path, &file.Diff, !result.DryRun); err != nil {
+			return errorResult(err)
+		}
+	}
+
+	data, _ := json.MarshalIndent(result, "", "  ")
+	return textResult(string(data))
+}
+
+func handleApplyPatch(args map[string]interface{}) (*mcp.CallToolResult, error) {
+	logger.ToolCall("apply_patch", args)
+
+	patch, _ := args["patch"].(string)
+	path, _ := args["path"].(string)
+	opts := files.PatchOptions{
+		Strip:            getInt(args, "strip", -1),
+		Fuzz:             getInt(args, "fuzz", files.DefaultPatchFuzz),
+		IgnoreWhitespace: getBool(args, "ignoreWhitespace", true),
+		DryRun:           getBool(args, "dryRun", false),
+		Validate:         validatePatchPath,
+		Check:            writePolicy.CheckContent,
+		Backup:           backupHook("apply_patch"),
+	}
+
+	absPath, err := validateWritePath(path)
+	if err != nil {
+		logger.Error("apply_patch: %v", err)
+		return errorResult(err)
+	}
+	if info, err := os.Stat(absPath); err != nil || !info.IsDir() {
+		logger.Error("apply_patch: %q is not a directory", absPath)
+		return errorResult(&files.FileError{Code: files.ErrInvalidPath, Message: "Path is not a directory", Path: absPath})
+	}
+	if hashes, ok := args["expectedHashes"].(map[string]interface{}); ok {
+		opts.ExpectedHashes = make(map[string]string)
+		for name, hash := range hashes {
+			file := name
+			if !filepath.IsAbs(file) {
+				file = filepath.Join(absPath, filepath.FromSlash(file))
+			}
+			file, err := validateWritePath(file)
+			if err != nil {
+				logger.Error("apply_patch: %v", err)
+				return errorResult(err)
+			}
+			opts.ExpectedHashes[file], _ = hash.(string)
+		}
+	}
+
+	result, err := files.ApplyPatch(absPath, patch, opts)
+	if err != nil {
+		logger.Error("apply_patch: failed to apply patch in %q: %v", absPath, err)
+		return errorResult(err)
+	}
+	// Hunk errors quote the lines of the file that did not match
+	for i := range result.Files {
+		file := &result.Files[i]
+		if err := screenDiff("apply_patch", file.Path, &file.Error, false); err != nil {
+			return errorResult(err)
+		}
+		for j := range file.Hunks {
+			if err := screenDiff("apply_patch", file.Path, &file.Hunks[j].Error, false); err != nil {
+				return errorResult(err)
+			}
+		}
+	}
+
+	data, _ := json.MarshalIndent(result, "", "  ")
+	if !result.Applied {
+		logger.Error("apply_patch: patch does not apply in %q", absPath)
+		return errorResult(notApplied("Patch not applied, no files were changed. Failed files and hunks have an error", data))
+	}
+
+	for _, file := range result.Files {
+		logger.Debug("apply_patch: %s %q (+%d -%d)", file.Operation, file.Path, file.Added, file.Removed)
+	}
+	if result.DryRun {
+		logger.Info("apply_patch: dry run applies cleanly to %d files in %q", len(result.Files), absPath)
+	} else {
+		logger.Info("apply_patch: patched %d files in %q", len(result.Files), absPath)
+	}
+	return textResult(string(data))
+}
+
+func handleBatch(args map[string]interface{}) (*mcp.CallToolResult, error) {
+	logger.ToolCall("batch", args)
+
+	list, _ := args["operations"].([]interface{})
+	if len(list) == 0 {
+		logger.Error("batch: no operations")
+		return errorResult(invalidArgument("operations is required and must list at least one operation"))
+	}
+	ops := make([]batch.Operation, len(list))
+	for i, item := range list {
+		opArgs, ok := item.(map[string]interface{})
+		if !ok {
+			logger.Error("batch: operation %d is not an object", i)
+			return errorResult(invalidArgument("Operation %d must be an object", i))
+		}
+		op := batch.Operation{
+			Op:        getString(opArgs, "op", ""),
+			Path:      getString(opArgs, "path", ""),
+			Content:   getString(opArgs, "content", ""),
+			Recursive: getBool(opArgs, "recursive", false),
+		}
+		switch op.Op {
+		case batch.OpWrite:
+			opts, err := writeOptions(opArgs)
+			if err != nil {
+				logger.Error("batch: operation %d: %v", i, err)
+				return errorResult(invalidArgument("Operation %d: %v", i, asFileError(err).Message))
+			}
+			op.Write = opts
+		case batch.OpModify:
+			op.Modify = modifyOptions(opArgs)
+		case batch.OpMove, batch.OpCopy:
+			op.Source = getString(opArgs, "source", "")
+			op.Path = getString(opArgs, "destination", "")
+		}
+		ops[i] = op
+	}
+
+	result, err := batch.Run(ops, batch.Options{
+		Validate:       validateWritePath,
+		ValidateSource: validatePath,
+		Check:          checkOperation,
+		Store:          backups,
+		DryRun:         getBool(args, "dryRun", false),
+	})
+	if err != nil {
+		logger.Error("batch: %v", err)
+		return errorResult(err)
+	}
+	if err := screenBatch(result); err != nil {
+		return errorResult(err)
+	}
+
+	data, _ := json.MarshalIndent(result, "", "  ")
+	switch {
+	case result.Planned:
+		logger.Info("batch: dry run of %d operations", len(result.Operations))
+		return textResult(string(data))
+	case result.DryRun:
+		logger.Error("batch: dry run found failing operations")
+		return errorResult(notApplied("Batch would fail, nothing was changed. Operations that would fail have an error", data))
+	case result.Committed:
+		for _, op := range result.Operations {
+			logger.Debug("batch: %s %q", op.Op, op.Path)
+		}
+		logger.Info("batch: applied %d operations", len(result.Operations))
+		return textResult(string(data))
+	case len(result.RollbackErrors) > 0:
+		logger.Error("batch: rollback incomplete: %s", strings.Join(result.RollbackErrors, "; "))
+		return errorResult(notApplied("Batch failed and some changes could not be undone; see rollbackErrors and restore them with restore_backup", data))
+	case result.RolledBack:
+		logger.Error("batch: operation failed, rolled back")
+		return errorResult(notApplied("Batch failed and was rolled back, no files were changed. The failed operation has an error", data))
+	default:
+		logger.Error("batch: invalid operations")
+		return errorResult(notApplied("Batch not applied, no files were changed. Invalid operations have an error", data))
+	}
+}
+
+// validatePatchPath validates a file apply_patch changes and applies the
+// write policy to its path
+func validatePatchPath(path string) (string, error) {
+	absPath, err := validateWritePath(path)
+	if err != nil {
+		return "", err
+	}
+	return absPath, writePolicy.CheckPath(absPath, false)
+}
+
+// checkRestore applies the write policy to restoring backup b at dst, as to
+// a copy of the saved data: every file it would write is checked with its
+// content. A saved symlink, or data that cannot be planned, has only its
+// path checked.
+func checkRestore(b *backup.Backup, dst string) error {
+	if b.IsSymlink {
+		return writePolicy.CheckPath(dst, false)
+	}
+	result, err := files.PlanCopy(backups.DataPath(b), dst)
+	if err != nil {
+		return writePolicy.CheckPath(dst, b.IsDirectory)
+	}
+	return writePolicy.CheckPlan(result.Files, false)
+}
+
+// checkTransfer applies the write policy to a copy or move: every file it
+// would write is checked with the content of its source, and a move also
+// checks the files it removes. When the transfer cannot be planned, only
+// the paths are checked and the copy or move reports the error itself.
+func checkTransfer(src, dst string, move bool) error {
+	var planned []files.PlannedFile
+	var err error
+	if move {
+		var result *files.MoveResult
+		if result, err = files.PlanMove(src, dst); err == nil {
+			planned = result.Files
+		}
+	} else {
+		var result *files.CopyResult
+		if result, err = files.PlanCopy(src, dst); err == nil {
+			planned = result.Files
+		}
+	}
+	if err != nil {
+		if move {
+			if err := writePolicy.CheckPath(src, true); err != nil {
+				return err
+			}
+		}
+		return writePolicy.CheckPath(dst, true)
+	}
+	return writePolicy.CheckPlan(planned, move)
+}
+
+// checkDelete applies the write policy to the files a delete would remove
+func checkDelete(path string, recursive bool) error {
+	result, err := files.PlanDelete(path, recursive)
+	if err != nil {
+		return writePolicy.CheckPath(path, true)
+	}
+	return writePolicy.CheckPlan(result.Files, false)
+}
+
+// checkOperation applies the write policy to the paths of a batch
+// operation; the content of writes and modifications is checked when the
+// batch plans them
+func checkOperation(op batch.Operation) error {
+	switch op.Op {
+	case batch.OpWrite, batch.OpModify:
+		return writePolicy.CheckPath(op.Path, false)
+	case batch.OpMkdir:
+		return writePolicy.CheckPath(op.Path, true)
+	case batch.OpCopy:
+		return checkTransfer(op.Source, op.Path, false)
+	case batch.OpMove:
+		return checkTransfer(op.Source, op.Path, true)
+	case batch.OpDelete:
+		return checkDelete(op.Path, op.Recursive)
+	}
+	return nil
+}
+
+// pathCheck is a path argument and the error validating it, if any
+type pathCheck struct {
+	path string
+	err  error
+}
+
+// refusedResult reports path arguments that failed validation. A dry run
+// lists every refused path, so one call shows all that would be refused;
+// otherwise the first error is returned as usual. The dry run's error has
+// the code and path of the first refusal.
+func refusedResult(dryRun bool, checks ...pathCheck) (*mcp.CallToolResult, error) {
+	type refusal struct {
+		Path  string          `json:"path"`
+		Code  files.ErrorCode `json:"code"`
+		Error string          `json:"error"`
+	}
+	var refused []refusal
+	var first *files.FileError
+	for _, check := range checks {
+		if check.err == nil {
+			continue
+		}
+		if !dryRun {
+			return errorResult(check.err)
+		}
+		fileErr := asFileError(check.err)
+		if first == nil {
+			first = fileErr
+		}
+		refused = append(refused, refusal{Path: check.path, Code: fileErr.Code, Error: check.err.Error()})
+	}
+	data, _ := json.MarshalIndent(map[string]interface{}{"dryRun": true, "refused": refused}, "", "  ")
+	return errorResult(&files.FileError{
+		Code:    first.Code,
+		Message: fmt.Sprintf("Dry run: these paths would be refused, nothing was changed:\n%s", data),
+	})
+}
+
+func handleListBackups(args map[string]interface{}) (*mcp.CallToolResult, error) {
+	logger.ToolCall("list_backups", args)
+
+	if backups == nil {
+		return errorResult(&files.FileError{Code: files.ErrDisabled, Message: "Backups are disabled (backup-dir is off)"})
+	}
+	filter, err := backupFilter(args)
+	if err != nil {
+		logger.Error("list_backups: %v", err)
+		return errorResult(err)
+	}
+	limit := getInt(args, "limit", 50)
+
+	list, err := backups.List(filter)
+	if err != nil {
+		logger.Error("list_backups: %v", err)
+		return errorResult(err)
+	}
+	var totalBytes int64
+	for _, b := range list {
+		totalBytes += b.Size
+	}
+	total := len(list)
+	if limit > 0 && len(list) > limit {
+		list = list[:limit]
+	}
+
+	logger.Debug("list_backups: %d backups (%d bytes)", total, totalBytes)
+	opts := backups.Options()
+	result := map[string]interface{}{
+		"backupDir":    backups.Dir(),
+		"maxAge":       opts.MaxAge.String(),
+		"maxSizeBytes": opts.MaxBytes,
+		"total":        total,
+		"totalBytes":   totalBytes,
+		"backups":      list,
+	}
+	data, _ := json.MarshalIndent(result, "", "  ")
+	return textResult(string(data))
+}
+
+func handleRestoreBackup(args map[string]interface{}) (*mcp.CallToolResult, error) {
+	logger.ToolCall("restore_backup", args)
+
+	if backups == nil {
+		return errorResult(&files.FileError{Code: files.ErrDisabled, Message: "Backups are disabled (backup-dir is off)"})
+	}
+	id := getString(args, "id", "")
+	destination := getString(args, "destination", "")
+	overwrite := getBool(args, "overwrite", false)
+	dryRun := getBool(args, "dryRun", false)
+
+	// Backups are only visible for paths the server may still read, and
+	// are restored only where it may write
+	b, err := backups.Get(id)
+	if err == nil {
+		_, err = validatePath(b.Path)
+	}
+	if err != nil {
+		logger.Error("restore_backup: %v", err)
+		return errorResult(err)
+	}
+	if destination == "" {
+		destination = b.Path
+	}
+	absDst, err := validateWritePath(destination)
+	if err == nil {
+		err = checkRestore(b, absDst)
+	}
+	if err != nil {
+		logger.Error("restore_backup: %v", err)
+		return refusedResult(dryRun, pathCheck{destination, err})
+	}
+
+	if dryRun {
+		result, err := backups.PlanRestore(id, absDst, overwrite)
+		if err != nil {
+			logger.Error("restore_backup: %v", err)
+			return errorResult(err)
+		}
+		logger.Info("restore_backup: dry run for %s to %q", id, absDst)
+		data, _ := json.MarshalIndent(result, "", "  ")
+		return textResult(string(data))
+	}
+
+	result, err := backups.Restore(id, absDst, overwrite)
+	if err != nil {
+		logger.Error("restore_backup: failed to restore %s to %q: %v", id, absDst, err)
+		return errorResult(err)
+	}
+
+	logger.Info("restore_backup: restored %s to %q", id, absDst)
+	data, _ := json.MarshalIndent(result, "", "  ")
+	return textResult(string(data))
+}
+
+func handlePurgeBackups(args map[string]interface{}) (*mcp.CallToolResult, error) {
+	logger.ToolCall("purge_backups", args)
+
+	if backups == nil {
+		return errorResult(&files.FileError{Code: files.ErrDisabled, Message: "Backups are disabled (backup-dir is off)"})
+	}
+	filter, err := backupFilter(args)
+	if err != nil {
+		logger.Error("purge_backups: %v", err)
+		return errorResult(err)
+	}
+	filter.IDs = getStringArray(args, "ids")
+	if olderThan := getString(args, "olderThan", ""); olderThan != "" {
+		age, err := parseAge(olderThan)
+		if err != nil || age < 0 {
+			logger.Error("purge_backups: invalid olderThan %q", olderThan)
+			return errorResult(invalidArgument("Invalid olderThan %q: expected a duration such as 24h or 7d", olderThan))
+		}
+		filter.Before = time.Now().Add(-age)
+	}
+	if len(filter.IDs) == 0 && filter.Path == "" && filter.Before.IsZero() && !getBool(args, "all", false) {
+		logger.Error("purge_backups: no backups selected")
+		return errorResult(invalidArgument("Select backups to purge with ids, path or olderThan, or set all: true"))
+	}
+
+	purge := backups.Purge
+	if getBool(args, "dryRun", false) {
+		purge = backups.PlanPurge
+	}
+	result, err := purge(filter)
+	if err != nil {
+		logger.Error("purge_backups: %v", err)
+		return errorResult(err)
+	}
 
+	if result.DryRun {
+		logger.Info("purge_backups: dry run would remove %d backups (%d bytes)", result.Removed, result.FreedBytes)
+	} else {
+		logger.Info("purge_backups: removed %d backups (%d bytes)", result.Removed, result.FreedBytes)
+	}
 	data, _ := json.MarshalIndent(result, "", "  ")
 	return textResult(string(data))
 }
+
+// backupFilter selects the backups of the path argument, if any, hiding
+// backups of paths the server may no longer access
+func backupFilter(args map[string]interface{}) (backup.Filter, error) {
+	filter := backup.Filter{
+		Allow: func(path string) bool {
+			_, err := validatePath(path)
+			return err == nil
+		},
+	}
+	if path := getString(args, "path", ""); path != "" {
+		absPath, err := validatePath(path)
+		if err != nil {
+			return filter, err
+		}
+		filter.Path = absPath
+	}
+	return filter, nil
+}
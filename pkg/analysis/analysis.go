@@ -8,6 +8,7 @@ import (
 	"strings"
 
 	"github.com/JeremyProffitt/go-mcp-file-context-server/pkg/files"
+	"github.com/JeremyProffitt/go-mcp-file-context-server/pkg/tokens"
 )
 
 // CodeAnalysis represents code analysis results
@@ -196,9 +197,14 @@ func GenerateOutline(path string) (*Outline, error) {
 	if err != nil {
 		return nil, err
 	}
+	return OutlineContent(path, content.Content), nil
+}
 
+// OutlineContent generates a code outline for content already read from
+// path; the language is chosen by path's extension
+func OutlineContent(path string, content string) *Outline {
 	lang := GetLanguage(path)
-	lines := strings.Split(content.Content, "\n")
+	lines := strings.Split(content, "\n")
 
 	outline := &Outline{
 		Path:     path,
@@ -207,7 +213,7 @@ func GenerateOutline(path string) (*Outline, error) {
 
 	// Extract imports
 	if pattern, ok := importPatterns[lang]; ok {
-		matches := pattern.FindAllStringSubmatch(content.Content, -1)
+		matches := pattern.FindAllStringSubmatch(content, -1)
 		for _, match := range matches {
 			for i := 1; i < len(match); i++ {
 				if match[i] != "" {
@@ -248,7 +254,7 @@ func GenerateOutline(path string) (*Outline, error) {
 		}
 	}
 
-	return outline, nil
+	return outline
 }
 
 func calculateComplexity(content string) int {
@@ -317,7 +323,13 @@ func calculateQuality(content string) QualityMetrics {
 
 // AnalyzeDirectory analyzes all files in a directory
 func AnalyzeDirectory(dirPath string, recursive bool, fileTypes []string) ([]FileAnalysis, *QualityMetrics, error) {
-	entries, err := files.ListFiles(dirPath, recursive, fileTypes, false)
+	return AnalyzeDirectoryWithOptions(dirPath, recursive, fileTypes, files.DefaultWalkOptions)
+}
+
+// AnalyzeDirectoryWithOptions analyzes all files in a directory, skipping
+// ignored paths according to opts
+func AnalyzeDirectoryWithOptions(dirPath string, recursive bool, fileTypes []string, opts files.WalkOptions) ([]FileAnalysis, *QualityMetrics, error) {
+	entries, err := files.ListFilesWithOptions(dirPath, recursive, fileTypes, false, opts)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -365,6 +377,12 @@ func AnalyzeDirectory(dirPath string, recursive bool, fileTypes []string) ([]Fil
 
 // GetChunkCount calculates the number of chunks for a file
 func GetChunkCount(path string, chunkSize int64) (int, error) {
+	return GetChunkCountWithOptions(path, chunkSize, files.DefaultWalkOptions)
+}
+
+// GetChunkCountWithOptions calculates the number of chunks for a file, or for
+// the files of a directory selected by opts
+func GetChunkCountWithOptions(path string, chunkSize int64, opts files.WalkOptions) (int, error) {
 	info, err := os.Stat(path)
 	if err != nil {
 		return 0, err
@@ -372,7 +390,7 @@ func GetChunkCount(path string, chunkSize int64) (int, error) {
 
 	if info.IsDir() {
 		// For directories, we need to calculate total content size
-		entries, err := files.ListFiles(path, true, nil, false)
+		entries, err := files.ListFilesWithOptions(path, true, nil, false, opts)
 		if err != nil {
 			return 0, err
 		}
@@ -390,6 +408,31 @@ func GetChunkCount(path string, chunkSize int64) (int, error) {
 	return int((info.Size() + chunkSize - 1) / chunkSize), nil
 }
 
+// GetTokenChunkCount calculates the number of token-sized chunks for a file
+// and its total estimated token count. Directories are not read in token
+// chunks, so they are an error.
+func GetTokenChunkCount(path string, maxTokens int, est tokens.Estimator) (int, int, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if info.IsDir() {
+		return 0, 0, &files.FileError{Code: files.ErrInvalidPath, Message: "Token chunks are only counted for files; directories are paged by chunkSize", Path: path}
+	}
+
+	content, err := files.ReadFile(path, 0)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	totalTokens := est.Count(content.Content)
+	if totalTokens <= maxTokens {
+		return 1, totalTokens, nil
+	}
+	return len(tokens.Chunk(content.Content, maxTokens, est)), totalTokens, nil
+}
+
 // ReadChunk reads a specific chunk of content
 func ReadChunk(path string, chunkNumber int, chunkSize int64) (string, int, error) {
 	file, err := os.Open(path)
@@ -425,65 +468,158 @@ func ReadChunk(path string, chunkNumber int, chunkSize int64) (string, int, erro
 
 // GetFolderStructure returns a tree representation of the folder structure
 func GetFolderStructure(dirPath string, maxDepth int) (string, error) {
+	return GetFolderStructureWithOptions(dirPath, maxDepth, files.DefaultWalkOptions)
+}
+
+// GetFolderStructureWithOptions returns a tree representation of the folder
+// structure, skipping ignored and filtered paths according to opts. When
+// include globs or file types restrict the files shown, directories without
+// any matching files are left out.
+func GetFolderStructureWithOptions(dirPath string, maxDepth int, opts files.WalkOptions) (string, error) {
 	var builder strings.Builder
 
-	err := walkDir(dirPath, "", 0, maxDepth, &builder)
+	if files.IsArchivePath(dirPath) {
+		err := walkArchive(dirPath, maxDepth, opts, &builder)
+		if err != nil {
+			return "", err
+		}
+		return builder.String(), nil
+	}
+
+	filter, err := files.NewPathFilter(nil, opts)
+	if err != nil {
+		return "", err
+	}
+
+	nodes, err := walkDir(dirPath, "", 0, maxDepth, files.NewIgnorer(dirPath, opts), filter)
 	if err != nil {
 		return "", err
 	}
+	renderTree(nodes, "", &builder)
 
 	return builder.String(), nil
 }
 
-func walkDir(path string, prefix string, depth int, maxDepth int, builder *strings.Builder) error {
+// treeNode is one entry of a folder structure tree
+type treeNode struct {
+	name     string
+	isDir    bool
+	children []*treeNode
+}
+
+func walkDir(path string, rel string, depth int, maxDepth int, ignorer *files.Ignorer, filter *files.PathFilter) ([]*treeNode, error) {
 	if maxDepth > 0 && depth >= maxDepth {
-		return nil
+		return nil, nil
 	}
 
 	entries, err := os.ReadDir(path)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	// Filter out ignored patterns
-	var filtered []os.DirEntry
+	var nodes []*treeNode
 	for _, entry := range entries {
-		skip := false
-		for _, pattern := range files.DefaultIgnorePatterns {
-			if matched, _ := filepath.Match(pattern, entry.Name()); matched {
-				skip = true
-				break
+		// Skip hidden and ignored paths
+		if strings.HasPrefix(entry.Name(), ".") || ignorer.Ignored(filepath.Join(path, entry.Name()), entry.IsDir()) {
+			continue
+		}
+
+		entryRel := entry.Name()
+		if rel != "" {
+			entryRel = rel + "/" + entry.Name()
+		}
+
+		if !entry.IsDir() {
+			if filter.MatchFile(entryRel) {
+				nodes = append(nodes, &treeNode{name: entry.Name()})
 			}
+			continue
 		}
-		if strings.HasPrefix(entry.Name(), ".") {
-			skip = true
+
+		if filter.SkipDir(entryRel) {
+			continue
 		}
-		if !skip {
-			filtered = append(filtered, entry)
+		children, _ := walkDir(filepath.Join(path, entry.Name()), entryRel, depth+1, maxDepth, ignorer, filter)
+
+		// Drop directories with no matching files, unless the depth limit
+		// stopped the walk before their contents were seen
+		atLimit := maxDepth > 0 && depth+1 >= maxDepth
+		if filter.Restricted() && len(children) == 0 && !atLimit {
+			continue
 		}
+		nodes = append(nodes, &treeNode{name: entry.Name(), isDir: true, children: children})
 	}
 
-	for i, entry := range filtered {
-		isLast := i == len(filtered)-1
+	return nodes, nil
+}
+
+// renderTree writes nodes with box-drawing connectors
+func renderTree(nodes []*treeNode, prefix string, builder *strings.Builder) {
+	for i, node := range nodes {
+		isLast := i == len(nodes)-1
 		connector := "├── "
 		if isLast {
 			connector = "└── "
 		}
 
-		builder.WriteString(prefix + connector + entry.Name() + "\n")
+		builder.WriteString(prefix + connector + node.name + "\n")
 
-		if entry.IsDir() {
+		if node.isDir {
 			newPrefix := prefix + "│   "
 			if isLast {
 				newPrefix = prefix + "    "
 			}
-			walkDir(filepath.Join(path, entry.Name()), newPrefix, depth+1, maxDepth, builder)
+			renderTree(node.children, newPrefix, builder)
+		}
+	}
+}
+
+// walkArchive renders the tree of a directory inside an archive
+func walkArchive(archivePath string, maxDepth int, opts files.WalkOptions, builder *strings.Builder) error {
+	entries, err := files.ListArchive(archivePath, true, nil, false, opts)
+	if err != nil {
+		return err
+	}
+
+	// Build the tree relative to the listed directory. Parents of matching
+	// files are created as needed, since include globs may leave them out
+	// of the listing.
+	_, root, ok := files.SplitArchivePath(archivePath)
+	if !ok {
+		root = ""
+	}
+	top := &treeNode{isDir: true}
+	for _, entry := range entries {
+		_, inner, _ := files.SplitArchivePath(entry.Path)
+		rel := strings.TrimPrefix(strings.TrimPrefix(inner, root), "/")
+		parts := strings.Split(rel, "/")
+		if maxDepth > 0 && len(parts) > maxDepth {
+			continue
+		}
+
+		node := top
+		for i, part := range parts {
+			isDir := i < len(parts)-1 || entry.Metadata.IsDirectory
+			node = node.child(part, isDir)
 		}
 	}
+	renderTree(top.children, "", builder)
 
 	return nil
 }
 
+// child returns the named child of n, adding it if missing
+func (n *treeNode) child(name string, isDir bool) *treeNode {
+	for _, c := range n.children {
+		if c.name == name {
+			return c
+		}
+	}
+	c := &treeNode{name: name, isDir: isDir}
+	n.children = append(n.children, c)
+	return c
+}
+
 // CountLines counts lines in a file efficiently
 func CountLines(path string) (int, error) {
 	file, err := os.Open(path)
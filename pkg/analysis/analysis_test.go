@@ -5,6 +5,8 @@ import (
 	"path/filepath"
 	"strings"
 	"testing"
+
+	"github.com/JeremyProffitt/go-mcp-file-context-server/pkg/files"
 )
 
 func TestGetLanguage(t *testing.T) {
@@ -210,6 +212,31 @@ func TestGetFolderStructure(t *testing.T) {
 	}
 }
 
+func TestGetFolderStructureIncludeExclude(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	for _, f := range []string{"README.md", "src/app.ts", "src/components/button.tsx", "pkg/utils.go", "docs/guide.md"} {
+		path := filepath.Join(tmpDir, f)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte("test"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	opts := files.WalkOptions{Include: []string{"**/*.ts*"}, Exclude: []string{"src/components"}}
+	structure, err := GetFolderStructureWithOptions(tmpDir, 5, opts)
+	if err != nil {
+		t.Fatalf("GetFolderStructureWithOptions failed: %v", err)
+	}
+
+	want := "└── src\n    └── app.ts\n"
+	if structure != want {
+		t.Errorf("GetFolderStructureWithOptions() =\n%s\nwant\n%s", structure, want)
+	}
+}
+
 func TestGetChunkCount(t *testing.T) {
 	tmpDir := t.TempDir()
 
@@ -0,0 +1,557 @@
+// Package backup keeps copies of files and directories before the server
+// deletes or overwrites them, so that mistakes can be undone.
+//
+// Each backup is a directory in the store holding a backup.json record and a
+// data entry: a copy of the file, directory tree or symlink as it was. Old
+// backups are pruned by age and by the total size of the store.
+package backup
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/JeremyProffitt/go-mcp-file-context-server/pkg/files"
+)
+
+// Default retention
+const (
+	DefaultMaxAge   = 7 * 24 * time.Hour
+	DefaultMaxBytes = 1024 * 1024 * 1024 // 1GB
+)
+
+const (
+	recordFile = "backup.json"
+	dataEntry  = "data"
+)
+
+// DefaultDir returns the default directory for backups
+func DefaultDir(appName string) string {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		// Fallback to current directory
+		return filepath.Join(".", appName, "backups")
+	}
+	return filepath.Join(homeDir, appName, "backups")
+}
+
+// Backup describes one snapshot of a path
+type Backup struct {
+	ID          string    `json:"id"`
+	Path        string    `json:"path"`
+	Operation   string    `json:"operation"`
+	IsDirectory bool      `json:"isDirectory"`
+	IsSymlink   bool      `json:"isSymlink,omitempty"`
+	Files       int       `json:"files"`
+	Size        int64     `json:"size"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+// Options controls retention. A zero MaxAge keeps backups regardless of age
+// and a zero MaxBytes leaves the store unbounded.
+type Options struct {
+	MaxAge   time.Duration
+	MaxBytes int64
+}
+
+// Filter selects backups. Unset fields match everything.
+type Filter struct {
+	IDs []string
+
+	// Path matches backups of Path itself or of anything under it
+	Path string
+
+	// Before matches backups created before this time
+	Before time.Time
+
+	// Allow, if set, hides backups of paths it rejects
+	Allow func(path string) bool
+}
+
+// RestoreResult is the result of Restore and PlanRestore
+type RestoreResult struct {
+	Backup      Backup  `json:"backup"`
+	Destination string  `json:"destination"`
+	Replaced    *Backup `json:"replaced,omitempty"`
+	Overwrites  bool    `json:"overwrites,omitempty"` // the destination existed
+	DryRun      bool    `json:"dryRun,omitempty"`
+}
+
+// PurgeResult is the result of Purge, PlanPurge and Prune
+type PurgeResult struct {
+	Removed    int   `json:"removed"`
+	FreedBytes int64 `json:"freedBytes"`
+	DryRun     bool  `json:"dryRun,omitempty"`
+	// Backups lists, for a dry run, the backups that would be removed
+	Backups []Backup `json:"backups,omitempty"`
+}
+
+// Store is a directory of backups
+type Store struct {
+	dir  string
+	opts Options
+	mu   sync.Mutex
+
+	// held are backups that pruning must leave alone
+	held map[string]int
+}
+
+// NewStore creates a Store keeping backups in dir
+func NewStore(dir string, opts Options) *Store {
+	return &Store{dir: filepath.Clean(dir), opts: opts}
+}
+
+// Dir returns the directory holding the backups
+func (s *Store) Dir() string {
+	return s.dir
+}
+
+// Options returns the retention settings
+func (s *Store) Options() Options {
+	return s.opts
+}
+
+// Snapshot copies path into the store, recording operation as the reason,
+// and prunes old backups. A missing path is not an error and returns nil.
+// Symlinks are saved as links, not followed.
+func (s *Store) Snapshot(path string, operation string) (*Backup, error) {
+	path = filepath.Clean(path)
+	info, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, backupError(path, "Backup failed", err)
+	}
+	if within(s.dir, path) {
+		return nil, &files.FileError{Code: files.ErrInvalidPath, Message: "Cannot back up a directory containing the backup store", Path: path}
+	}
+
+	b := &Backup{
+		Path:        path,
+		Operation:   operation,
+		IsDirectory: info.IsDir(),
+		IsSymlink:   info.Mode()&fs.ModeSymlink != 0,
+		CreatedAt:   time.Now().UTC(),
+	}
+	b.Files, b.Size, err = measure(path, info)
+	if err != nil {
+		return nil, backupError(path, "Backup failed", err)
+	}
+	if s.opts.MaxBytes > 0 && b.Size > s.opts.MaxBytes {
+		return nil, &files.FileError{
+			Code:    files.ErrFileTooLarge,
+			Message: fmt.Sprintf("Too large to back up (%d bytes, backup store limit %d bytes); nothing was changed", b.Size, s.opts.MaxBytes),
+			Path:    path,
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b.ID, err = newID(b.CreatedAt)
+	if err != nil {
+		return nil, backupError(path, "Backup failed", err)
+	}
+	dir := filepath.Join(s.dir, b.ID)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, backupError(path, "Backup failed", err)
+	}
+	if err := copyTree(path, filepath.Join(dir, dataEntry)); err != nil {
+		os.RemoveAll(dir)
+		return nil, backupError(path, "Backup failed", err)
+	}
+	record, _ := json.MarshalIndent(b, "", "  ")
+	if err := os.WriteFile(filepath.Join(dir, recordFile), record, 0600); err != nil {
+		os.RemoveAll(dir)
+		return nil, backupError(path, "Backup failed", err)
+	}
+
+	s.prune(b.ID)
+	return b, nil
+}
+
+// SnapshotTarget is Snapshot for a change that writes through symlinks, such
+// as an overwrite: it backs up the file path resolves to and records that as
+// the path to restore. Deletes and moves replace the link itself, so they use
+// Snapshot.
+func (s *Store) SnapshotTarget(path string, operation string) (*Backup, error) {
+	target, err := files.RealPath(path)
+	if err != nil {
+		return nil, backupError(path, "Backup failed", err)
+	}
+	return s.Snapshot(target, operation)
+}
+
+// Hold keeps the backups ids from being pruned until they are released, for
+// a caller that may still need them to undo its changes
+func (s *Store) Hold(ids ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.held == nil {
+		s.held = make(map[string]int)
+	}
+	for _, id := range ids {
+		s.held[id]++
+	}
+}
+
+// Release undoes Hold, letting the backups ids be pruned again
+func (s *Store) Release(ids ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, id := range ids {
+		if s.held[id]--; s.held[id] <= 0 {
+			delete(s.held, id)
+		}
+	}
+}
+
+// DataPath returns where the copy saved by backup b is kept in the store
+func (s *Store) DataPath(b *Backup) string {
+	return filepath.Join(s.dir, b.ID, dataEntry)
+}
+
+// Get returns the backup with the given ID
+func (s *Store) Get(id string) (*Backup, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.get(id)
+}
+
+func (s *Store) get(id string) (*Backup, error) {
+	if id == "" || id != filepath.Base(id) || strings.HasPrefix(id, ".") {
+		return nil, &files.FileError{Code: files.ErrInvalidPath, Message: "Invalid backup ID", Path: id}
+	}
+	data, err := os.ReadFile(filepath.Join(s.dir, id, recordFile))
+	if err != nil {
+		return nil, &files.FileError{Code: files.ErrFileNotFound, Message: "Backup not found", Path: id}
+	}
+	var b Backup
+	if err := json.Unmarshal(data, &b); err != nil || b.ID != id {
+		return nil, &files.FileError{Code: files.ErrUnknown, Message: "Backup record is corrupt", Path: id}
+	}
+	return &b, nil
+}
+
+// List returns the backups matching f, newest first
+func (s *Store) List(f Filter) ([]Backup, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.list(f)
+}
+
+func (s *Store) list(f Filter) ([]Backup, error) {
+	entries, err := os.ReadDir(s.dir)
+	if os.IsNotExist(err) {
+		return []Backup{}, nil
+	}
+	if err != nil {
+		return nil, backupError(s.dir, "Cannot read backups", err)
+	}
+
+	ids := make(map[string]bool)
+	for _, id := range f.IDs {
+		ids[id] = true
+	}
+	path := ""
+	if f.Path != "" {
+		path = filepath.Clean(f.Path)
+	}
+
+	backups := []Backup{}
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		b, err := s.get(e.Name())
+		if err != nil {
+			continue // Half-written or foreign entries are left alone
+		}
+		switch {
+		case len(ids) > 0 && !ids[b.ID]:
+		case path != "" && !within(path, b.Path):
+		case !f.Before.IsZero() && !b.CreatedAt.Before(f.Before):
+		case f.Allow != nil && !f.Allow(b.Path):
+		default:
+			backups = append(backups, *b)
+		}
+	}
+	sort.Slice(backups, func(i, j int) bool { return backups[i].ID > backups[j].ID })
+	return backups, nil
+}
+
+// Restore copies a backup back to dest, or to where it was taken from if
+// dest is empty. An existing dest is only replaced with overwrite, and is
+// itself backed up first. The backup is kept.
+func (s *Store) Restore(id string, dest string, overwrite bool) (*RestoreResult, error) {
+	result, err := s.PlanRestore(id, dest, overwrite)
+	if err != nil {
+		return nil, err
+	}
+	result.DryRun = false
+	if result.Overwrites {
+		if result.Replaced, err = s.Snapshot(result.Destination, "restore_backup"); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := s.restore(&result.Backup, result.Destination); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// PlanRestore checks that Restore could restore backup id to dest and
+// returns what it would do, without changing anything
+func (s *Store) PlanRestore(id string, dest string, overwrite bool) (*RestoreResult, error) {
+	b, err := s.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	if dest == "" {
+		dest = b.Path
+	}
+	dest = filepath.Clean(dest)
+	result := &RestoreResult{Backup: *b, Destination: dest, DryRun: true}
+
+	if _, err := os.Lstat(dest); err == nil {
+		if !overwrite {
+			return nil, &files.FileError{Code: files.ErrAlreadyExists, Message: "Destination exists; set overwrite to replace it (it is backed up first)", Path: dest}
+		}
+		result.Overwrites = true
+	}
+	return result, nil
+}
+
+// Rollback puts back the content saved in a backup at dest, replacing
+// whatever is there without backing it up. It is for undoing a change the
+// caller has just made.
+func (s *Store) Rollback(id string, dest string) error {
+	b, err := s.Get(id)
+	if err != nil {
+		return err
+	}
+	return s.restore(b, filepath.Clean(dest))
+}
+
+// restore copies backup b to dest, replacing it
+func (s *Store) restore(b *Backup, dest string) error {
+	// The copy is made next to dest and renamed into place, so dest is
+	// never left half restored
+	parent := filepath.Dir(dest)
+	if err := os.MkdirAll(parent, 0755); err != nil {
+		return backupError(dest, "Restore failed", err)
+	}
+	tmp, err := os.MkdirTemp(parent, "."+filepath.Base(dest)+".restore-*")
+	if err != nil {
+		return backupError(dest, "Restore failed", err)
+	}
+	defer os.RemoveAll(tmp)
+	staged := filepath.Join(tmp, dataEntry)
+	if err := copyTree(filepath.Join(s.dir, b.ID, dataEntry), staged); err != nil {
+		return backupError(dest, "Restore failed", err)
+	}
+	// A file is renamed over the old one; a directory has to go first
+	if info, err := os.Lstat(dest); err == nil && (info.IsDir() || b.IsDirectory) {
+		if err := os.RemoveAll(dest); err != nil {
+			return backupError(dest, "Restore failed", err)
+		}
+	}
+	if err := os.Rename(staged, dest); err != nil {
+		return backupError(dest, "Restore failed", err)
+	}
+	return nil
+}
+
+// Purge deletes the backups matching f
+func (s *Store) Purge(f Filter) (*PurgeResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	backups, err := s.list(f)
+	if err != nil {
+		return nil, err
+	}
+	result := &PurgeResult{}
+	for _, b := range backups {
+		if err := os.RemoveAll(filepath.Join(s.dir, b.ID)); err != nil {
+			return result, backupError(b.Path, "Purge failed", err)
+		}
+		result.Removed++
+		result.FreedBytes += b.Size
+	}
+	return result, nil
+}
+
+// PlanPurge returns the backups Purge would delete, without deleting them
+func (s *Store) PlanPurge(f Filter) (*PurgeResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	backups, err := s.list(f)
+	if err != nil {
+		return nil, err
+	}
+	result := &PurgeResult{DryRun: true, Backups: backups}
+	for _, b := range backups {
+		result.Removed++
+		result.FreedBytes += b.Size
+	}
+	return result, nil
+}
+
+// Prune applies the retention settings, deleting backups older than MaxAge
+// and then the oldest backups until the store fits in MaxBytes
+func (s *Store) Prune() *PurgeResult {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.prune("")
+}
+
+// prune applies retention, never removing the backup keep or held ones
+func (s *Store) prune(keep string) *PurgeResult {
+	result := &PurgeResult{}
+	backups, err := s.list(Filter{})
+	if err != nil {
+		return result
+	}
+
+	var total int64
+	for _, b := range backups {
+		total += b.Size
+	}
+	cutoff := time.Now().Add(-s.opts.MaxAge)
+	for i := len(backups) - 1; i >= 0; i-- { // oldest first
+		b := backups[i]
+		expired := s.opts.MaxAge > 0 && b.CreatedAt.Before(cutoff)
+		full := s.opts.MaxBytes > 0 && total > s.opts.MaxBytes
+		if b.ID == keep || s.held[b.ID] > 0 || (!expired && !full) {
+			continue
+		}
+		if os.RemoveAll(filepath.Join(s.dir, b.ID)) == nil {
+			result.Removed++
+			result.FreedBytes += b.Size
+			total -= b.Size
+		}
+	}
+	return result
+}
+
+// newID returns a backup ID that sorts by creation time
+func newID(t time.Time) (string, error) {
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return "", err
+	}
+	return t.Format("20060102T150405.000000000Z") + "-" + hex.EncodeToString(suffix), nil
+}
+
+// measure returns the number of files and bytes under path
+func measure(path string, info fs.FileInfo) (int, int64, error) {
+	if !info.IsDir() {
+		return 1, info.Size(), nil
+	}
+	count, size := 0, int64(0)
+	err := filepath.WalkDir(path, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		count++
+		size += info.Size()
+		return nil
+	})
+	return count, size, err
+}
+
+// copyTree copies a file, symlink or directory tree from src to dst, keeping
+// permissions. Symlinks are copied as links.
+func copyTree(src string, dst string) error {
+	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case info.Mode()&fs.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		case info.IsDir():
+			return os.MkdirAll(target, info.Mode().Perm()|0700)
+		case info.Mode().IsRegular():
+			return copyFile(path, target, info.Mode().Perm())
+		}
+		return nil // Sockets, devices and pipes are not copied
+	})
+}
+
+// copyFile copies one regular file and syncs it to disk
+func copyFile(src string, dst string, mode fs.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, mode)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if err == nil {
+		err = out.Sync()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(dst, mode)
+	}
+	return err
+}
+
+// within reports whether path is dir or inside it
+func within(dir string, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// backupError converts an error from the store into a FileError
+func backupError(path string, action string, err error) error {
+	if fe, ok := err.(*files.FileError); ok {
+		return fe
+	}
+	code := files.ErrUnknown
+	if os.IsPermission(err) {
+		code = files.ErrPermission
+	}
+	return &files.FileError{Code: code, Message: fmt.Sprintf("%s: %v", action, err), Path: path}
+}
@@ -0,0 +1,205 @@
+package backup
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/JeremyProffitt/go-mcp-file-context-server/pkg/files"
+)
+
+func TestSnapshotAndRestore(t *testing.T) {
+	root := t.TempDir()
+	store := NewStore(filepath.Join(t.TempDir(), "backups"), Options{})
+
+	file := filepath.Join(root, "notes.txt")
+	if err := os.WriteFile(file, []byte("v1\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	dir := filepath.Join(root, "src")
+	os.MkdirAll(filepath.Join(dir, "sub"), 0755)
+	os.WriteFile(filepath.Join(dir, "a.go"), []byte("package a\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "sub", "b.go"), []byte("package b\n"), 0644)
+
+	fileBackup, err := store.Snapshot(file, "write_file")
+	if err != nil {
+		t.Fatalf("Snapshot(file) error = %v", err)
+	}
+	if fileBackup.Files != 1 || fileBackup.Size != 3 || fileBackup.IsDirectory {
+		t.Errorf("Snapshot(file) = %+v", fileBackup)
+	}
+	dirBackup, err := store.Snapshot(dir, "delete_file")
+	if err != nil {
+		t.Fatalf("Snapshot(dir) error = %v", err)
+	}
+	if dirBackup.Files != 2 || !dirBackup.IsDirectory {
+		t.Errorf("Snapshot(dir) = %+v", dirBackup)
+	}
+	if b, err := store.Snapshot(filepath.Join(root, "missing"), "write_file"); b != nil || err != nil {
+		t.Errorf("Snapshot(missing) = %v, %v, want nil, nil", b, err)
+	}
+
+	// Overwrite and delete, then undo both
+	os.WriteFile(file, []byte("v2\n"), 0600)
+	os.RemoveAll(dir)
+
+	if plan, err := store.PlanRestore(fileBackup.ID, "", true); err != nil || !plan.Overwrites || !plan.DryRun {
+		t.Errorf("PlanRestore() = %+v, %v", plan, err)
+	}
+	if data, _ := os.ReadFile(file); string(data) != "v2\n" {
+		t.Errorf("PlanRestore() changed the file to %q", data)
+	}
+	if _, err := store.Restore(fileBackup.ID, "", false); err == nil {
+		t.Error("Restore() over an existing file without overwrite succeeded")
+	}
+	result, err := store.Restore(fileBackup.ID, "", true)
+	if err != nil {
+		t.Fatalf("Restore(file) error = %v", err)
+	}
+	if data, _ := os.ReadFile(file); string(data) != "v1\n" {
+		t.Errorf("restored file = %q, want v1", data)
+	}
+	if info, _ := os.Stat(file); info.Mode().Perm() != 0600 {
+		t.Errorf("restored file mode = %v, want 0600", info.Mode().Perm())
+	}
+	if result.Replaced == nil || result.Replaced.Operation != "restore_backup" {
+		t.Errorf("Restore() did not back up the file it replaced: %+v", result)
+	}
+
+	if _, err := store.Restore(dirBackup.ID, "", false); err != nil {
+		t.Fatalf("Restore(dir) error = %v", err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(dir, "sub", "b.go")); string(data) != "package b\n" {
+		t.Errorf("restored tree file = %q", data)
+	}
+
+	if _, err := store.Get("../escape"); err == nil {
+		t.Error("Get() accepted an ID with a path")
+	}
+}
+
+func TestListAndPurge(t *testing.T) {
+	root := t.TempDir()
+	store := NewStore(filepath.Join(t.TempDir(), "backups"), Options{})
+	for _, name := range []string{"a.txt", "b.txt", "sub/c.txt"} {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(path), 0755)
+		os.WriteFile(path, []byte(name), 0644)
+		if _, err := store.Snapshot(path, "write_file"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	all, _ := store.List(Filter{})
+	if len(all) != 3 || all[0].Path != filepath.Join(root, "sub", "c.txt") {
+		t.Fatalf("List() = %+v, want 3 backups newest first", all)
+	}
+	sub, _ := store.List(Filter{Path: filepath.Join(root, "sub")})
+	if len(sub) != 1 {
+		t.Errorf("List(Path) = %d backups, want 1", len(sub))
+	}
+	allowed, _ := store.List(Filter{Allow: func(path string) bool { return filepath.Base(path) != "a.txt" }})
+	if len(allowed) != 2 {
+		t.Errorf("List(Allow) = %d backups, want 2", len(allowed))
+	}
+
+	plan, err := store.PlanPurge(Filter{Path: filepath.Join(root, "sub")})
+	if err != nil || plan.Removed != 1 || len(plan.Backups) != 1 {
+		t.Errorf("PlanPurge() = %+v, %v", plan, err)
+	}
+	if left, _ := store.List(Filter{}); len(left) != 3 {
+		t.Errorf("PlanPurge() removed backups, %d left", len(left))
+	}
+
+	result, err := store.Purge(Filter{IDs: []string{all[2].ID}})
+	if err != nil || result.Removed != 1 {
+		t.Fatalf("Purge(IDs) = %+v, %v", result, err)
+	}
+	result, _ = store.Purge(Filter{Before: time.Now().Add(time.Hour)})
+	if result.Removed != 2 {
+		t.Errorf("Purge(Before) removed %d, want 2", result.Removed)
+	}
+}
+
+func TestRetention(t *testing.T) {
+	root := t.TempDir()
+	store := NewStore(filepath.Join(t.TempDir(), "backups"), Options{MaxBytes: 25})
+
+	path := filepath.Join(root, "data.txt")
+	var last *Backup
+	for i := 0; i < 4; i++ {
+		os.WriteFile(path, []byte("0123456789"), 0644)
+		b, err := store.Snapshot(path, "write_file")
+		if err != nil {
+			t.Fatal(err)
+		}
+		last = b
+	}
+	backups, _ := store.List(Filter{})
+	if len(backups) != 2 || backups[0].ID != last.ID {
+		t.Errorf("after pruning to 25 bytes, %d backups remain, want the newest 2", len(backups))
+	}
+
+	os.WriteFile(path, make([]byte, 100), 0644)
+	_, err := store.Snapshot(path, "write_file")
+	if fe, ok := err.(*files.FileError); !ok || fe.Code != files.ErrFileTooLarge {
+		t.Errorf("Snapshot() over the size limit error = %v, want FILE_TOO_LARGE", err)
+	}
+
+	aged := NewStore(store.Dir(), Options{MaxAge: time.Nanosecond})
+	time.Sleep(time.Millisecond)
+	if result := aged.Prune(); result.Removed != 2 {
+		t.Errorf("Prune() by age removed %d, want 2", result.Removed)
+	}
+}
+
+func TestSnapshotThroughSymlink(t *testing.T) {
+	root := t.TempDir()
+	store := NewStore(filepath.Join(t.TempDir(), "backups"), Options{})
+
+	real := filepath.Join(root, "real.txt")
+	link := filepath.Join(root, "link.txt")
+	os.WriteFile(real, []byte("ORIGINAL\n"), 0644)
+	if err := os.Symlink("real.txt", link); err != nil {
+		t.Skipf("symlinks not supported: %v", err)
+	}
+
+	// A write through the link changes real.txt, so that is what is backed up
+	hook := func(path string) (string, error) {
+		b, err := store.Snapshot(path, "write_file")
+		if err != nil || b == nil {
+			return "", err
+		}
+		return b.ID, nil
+	}
+	result, err := files.WriteFileWithOptions(link, "CHANGED\n", files.WriteOptions{Backup: hook})
+	if err != nil {
+		t.Fatalf("WriteFileWithOptions() error = %v", err)
+	}
+	b, err := store.Get(result.BackupID)
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	if b.Path != real || b.IsSymlink {
+		t.Errorf("backup of a write through a link = %+v, want a copy of %s", b, real)
+	}
+	if err := store.Rollback(b.ID, b.Path); err != nil {
+		t.Fatalf("Rollback() error = %v", err)
+	}
+	if data, _ := os.ReadFile(real); string(data) != "ORIGINAL\n" {
+		t.Errorf("restored target = %q, want ORIGINAL", data)
+	}
+	if info, err := os.Lstat(link); err != nil || info.Mode()&os.ModeSymlink == 0 {
+		t.Errorf("link was replaced by the restore")
+	}
+
+	want, _ := filepath.EvalSymlinks(real)
+	target, err := store.SnapshotTarget(link, "copy_file")
+	if err != nil || target.Path != want || target.IsSymlink {
+		t.Errorf("SnapshotTarget() = %+v, %v, want a copy of %s", target, err, want)
+	}
+	if own, err := store.Snapshot(link, "delete_file"); err != nil || !own.IsSymlink {
+		t.Errorf("Snapshot(link) = %+v, %v, want the link itself", own, err)
+	}
+}
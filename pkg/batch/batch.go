@@ -0,0 +1,505 @@
+// Package batch applies a list of file operations as one transaction: every
+// operation is checked before any runs, and if one fails the ones already
+// applied are undone, newest first, from backups taken as they ran.
+package batch
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/JeremyProffitt/go-mcp-file-context-server/pkg/backup"
+	"github.com/JeremyProffitt/go-mcp-file-context-server/pkg/files"
+)
+
+// Operation kinds
+const (
+	OpWrite  = "write"
+	OpModify = "modify"
+	OpMove   = "move"
+	OpCopy   = "copy"
+	OpDelete = "delete"
+	OpMkdir  = "mkdir"
+)
+
+// Operation outcomes
+const (
+	StatusApplied    = "applied"     // done and kept
+	StatusRolledBack = "rolled_back" // done, then undone after a later failure
+	StatusFailed     = "failed"      // the operation that failed
+	StatusInvalid    = "invalid"     // rejected before anything ran
+	StatusNotRun     = "not_run"     // never started
+	StatusPlanned    = "planned"     // checked by a dry run
+)
+
+// backupOperation is the operation recorded on backups taken by a batch
+const backupOperation = "batch"
+
+// Operation is one step of a batch
+type Operation struct {
+	// Op is one of the Op kinds
+	Op string
+
+	// Path is the file written, modified, deleted or created, or the
+	// destination of a move or copy
+	Path string
+
+	// Source is the path moved or copied
+	Source string
+
+	// Content and Write are the content and options of a write
+	Content string
+	Write   files.WriteOptions
+
+	// Modify describes the edit made by a modify
+	Modify files.ModifyOptions
+
+	// Recursive allows deleting a non-empty directory
+	Recursive bool
+}
+
+// Options controls Run
+type Options struct {
+	// Validate checks every path that is written, moved or deleted and
+	// returns the absolute path to use
+	Validate func(path string) (string, error)
+
+	// ValidateSource checks the source of a copy, which is only read; nil
+	// uses Validate
+	ValidateSource func(path string) (string, error)
+
+	// Check, if set, is called with each operation once its paths are
+	// resolved; an error makes the operation invalid
+	Check func(op Operation) error
+
+	// Store keeps the backups used for rollback. They are kept after the
+	// batch, and named in the results, so changes can be undone later too.
+	// With a nil Store a temporary one is used and removed afterwards.
+	Store *backup.Store
+
+	// DryRun validates the operations and plans each one against the files
+	// as they are now, without changing anything. An operation on a path an
+	// earlier operation changes is not planned in detail.
+	DryRun bool
+}
+
+// OperationResult is the outcome of one operation
+type OperationResult struct {
+	Index    int         `json:"index"`
+	Op       string      `json:"op"`
+	Path     string      `json:"path,omitempty"`
+	Source   string      `json:"source,omitempty"`
+	Status   string      `json:"status"`
+	Error    string      `json:"error,omitempty"`
+	BackupID string      `json:"backupId,omitempty"`
+	Result   interface{} `json:"result,omitempty"`
+	Note     string      `json:"note,omitempty"`
+}
+
+// Result is the outcome of a batch
+type Result struct {
+	// Committed is true when every operation was applied
+	Committed  bool              `json:"committed"`
+	RolledBack bool              `json:"rolledBack"`
+	DryRun     bool              `json:"dryRun,omitempty"`
+	Operations []OperationResult `json:"operations"`
+
+	// Planned is true when a dry run found nothing that would fail
+	Planned bool `json:"planned,omitempty"`
+
+	// RollbackErrors lists changes that could not be undone
+	RollbackErrors []string `json:"rollbackErrors,omitempty"`
+}
+
+// step is an applied operation and how to undo it
+type step struct {
+	index int
+	undo  func() error
+}
+
+// Run validates ops and applies them in order. If any operation is invalid
+// nothing runs; if one fails while running, every operation before it is
+// undone. The result gives the outcome of each operation.
+func Run(ops []Operation, opts Options) (*Result, error) {
+	if opts.ValidateSource == nil {
+		opts.ValidateSource = opts.Validate
+	}
+	result := &Result{Operations: make([]OperationResult, len(ops))}
+	for i, op := range ops {
+		result.Operations[i] = OperationResult{Index: i, Op: op.Op, Path: op.Path, Source: op.Source, Status: StatusNotRun}
+	}
+
+	// Stage: check every operation before changing anything
+	valid := true
+	for i := range ops {
+		if err := validate(&ops[i], opts); err != nil {
+			result.Operations[i].Status = StatusInvalid
+			result.Operations[i].Error = err.Error()
+			valid = false
+			continue
+		}
+		result.Operations[i].Path, result.Operations[i].Source = ops[i].Path, ops[i].Source
+	}
+	if !valid {
+		return result, nil
+	}
+	if opts.DryRun {
+		result.DryRun = true
+		result.Planned = plan(ops, result)
+		return result, nil
+	}
+
+	store := opts.Store
+	if store == nil {
+		dir, err := os.MkdirTemp("", "batch-backups-*")
+		if err != nil {
+			return nil, &files.FileError{Code: files.ErrUnknown, Message: fmt.Sprintf("Cannot create backup directory: %v", err)}
+		}
+		defer os.RemoveAll(dir)
+		store = backup.NewStore(dir, backup.Options{})
+
+		// Temporary backups are gone once the batch ends, so don't name them
+		defer func() {
+			for i := range result.Operations {
+				result.Operations[i].BackupID = ""
+			}
+		}()
+	}
+
+	// Commit: apply in order, remembering how to undo each step
+	r := &runner{store: store}
+	defer func() { store.Release(r.held...) }()
+	var steps []step
+	for i, op := range ops {
+		out := &result.Operations[i]
+		undo, err := r.apply(op, out)
+		if err != nil {
+			out.Status = StatusFailed
+			out.Error = err.Error()
+			if undo != nil {
+				steps = append(steps, step{index: -1, undo: undo})
+			}
+			result.RolledBack = true
+			result.RollbackErrors = rollback(steps, result)
+			return result, nil
+		}
+		out.Status = StatusApplied
+		steps = append(steps, step{index: i, undo: undo})
+	}
+	result.Committed = true
+	return result, nil
+}
+
+// validate checks an operation's arguments and resolves its paths
+func validate(op *Operation, opts Options) error {
+	var err error
+	switch op.Op {
+	case OpWrite, OpModify, OpDelete, OpMkdir:
+		if op.Path == "" {
+			return fmt.Errorf("path is required for %s", op.Op)
+		}
+	case OpMove, OpCopy:
+		if op.Source == "" || op.Path == "" {
+			return fmt.Errorf("source and destination are required for %s", op.Op)
+		}
+		validateSource := opts.Validate
+		if op.Op == OpCopy {
+			validateSource = opts.ValidateSource
+		}
+		if validateSource != nil {
+			if op.Source, err = validateSource(op.Source); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("unknown operation %q: expected write, modify, move, copy, delete or mkdir", op.Op)
+	}
+	if opts.Validate != nil {
+		if op.Path, err = opts.Validate(op.Path); err != nil {
+			return err
+		}
+	}
+	if opts.Check != nil {
+		if err := opts.Check(*op); err != nil {
+			return err
+		}
+	}
+
+	if op.Op == OpModify {
+		switch op.Modify.Mode {
+		case "", files.ModifyReplace:
+			if op.Modify.Find == "" {
+				return fmt.Errorf("find is required in replace mode")
+			}
+		case files.ModifyReplaceLines, files.ModifyInsert, files.ModifyDeleteLines:
+		default:
+			return fmt.Errorf("unknown modify mode %q", op.Modify.Mode)
+		}
+	}
+	if (op.Op == OpMove || op.Op == OpCopy) && op.Source == op.Path {
+		return fmt.Errorf("source and destination are the same")
+	}
+	return nil
+}
+
+// plan runs a dry run of each operation and reports whether all of them
+// would succeed
+func plan(ops []Operation, result *Result) bool {
+	ok := true
+	for i, op := range ops {
+		out := &result.Operations[i]
+		out.Status = StatusPlanned
+		if j := dependency(op, ops[:i]); j >= 0 {
+			out.Note = fmt.Sprintf("depends on operation %d, so it is only checked when the batch runs", j)
+		} else if res, err := planOperation(op); err != nil {
+			out.Status = StatusFailed
+			out.Error = err.Error()
+			ok = false
+		} else {
+			out.Result = res
+		}
+	}
+	return ok
+}
+
+// dependency returns the index of the last of earlier that changes a path op
+// reads or writes, or -1
+func dependency(op Operation, earlier []Operation) int {
+	for j := len(earlier) - 1; j >= 0; j-- {
+		changed := []string{earlier[j].Path}
+		if earlier[j].Op == OpMove {
+			changed = append(changed, earlier[j].Source)
+		}
+		for _, a := range changed {
+			for _, b := range []string{op.Path, op.Source} {
+				if b != "" && (within(a, b) || within(b, a)) {
+					return j
+				}
+			}
+		}
+	}
+	return -1
+}
+
+// within reports whether path is dir or inside it
+func within(dir string, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// planOperation returns what op would do
+func planOperation(op Operation) (interface{}, error) {
+	switch op.Op {
+	case OpWrite:
+		op.Write.DryRun = true
+		return files.WriteFileWithOptions(op.Path, op.Content, op.Write)
+	case OpModify:
+		op.Modify.DryRun = true
+		return files.ModifyFileWithOptions(op.Path, op.Modify)
+	case OpMove:
+		return files.PlanMove(op.Source, op.Path)
+	case OpCopy:
+		return files.PlanCopy(op.Source, op.Path)
+	case OpDelete:
+		return files.PlanDelete(op.Path, op.Recursive)
+	case OpMkdir:
+		return files.PlanCreateDirectory(op.Path)
+	}
+	return nil, fmt.Errorf("unknown operation %q", op.Op)
+}
+
+// runner applies operations, backing up what they replace
+type runner struct {
+	store *backup.Store
+
+	// held are the backups taken so far, kept from pruning until the batch
+	// ends in case it has to roll back
+	held []string
+}
+
+// snapshot backs up path if it exists and returns the backup ID, or ""
+func (r *runner) snapshot(path string) (string, error) {
+	b, err := r.store.Snapshot(path, backupOperation)
+	if err != nil || b == nil {
+		return "", err
+	}
+	r.store.Hold(b.ID)
+	r.held = append(r.held, b.ID)
+	return b.ID, nil
+}
+
+// restore undoes a change to path: back to backup id, or removed if it did
+// not exist before
+func (r *runner) restore(path string, id string) error {
+	if id == "" {
+		return os.RemoveAll(path)
+	}
+	return r.store.Rollback(id, path)
+}
+
+// apply runs one operation and returns how to undo it. When the operation
+// fails part way, the returned undo, if any, reverts what it did.
+func (r *runner) apply(op Operation, out *OperationResult) (func() error, error) {
+	switch op.Op {
+	case OpWrite:
+		// Writes go through a symlink, so back up and restore its target
+		target, err := files.RealPath(op.Path)
+		if err != nil {
+			return nil, err
+		}
+		id, err := r.snapshot(target)
+		if err != nil {
+			return nil, err
+		}
+		out.BackupID = id
+		dirs := files.MissingDirs(filepath.Dir(op.Path))
+		undo := func() error {
+			err := r.restore(target, id)
+			removeDirs(dirs)
+			return err
+		}
+		op.Write.Backup = nil
+		res, err := files.WriteFileWithOptions(op.Path, op.Content, op.Write)
+		if err != nil {
+			return undo, err
+		}
+		out.Result = res
+		return undo, nil
+
+	case OpModify:
+		target, err := files.RealPath(op.Path)
+		if err != nil {
+			return nil, err
+		}
+		id, err := r.snapshot(target)
+		if err != nil {
+			return nil, err
+		}
+		out.BackupID = id
+		op.Modify.Backup = nil
+		res, err := files.ModifyFileWithOptions(op.Path, op.Modify)
+		if err != nil {
+			return nil, err // the file is only written once the edit succeeds
+		}
+		out.Result = res
+		return func() error { return r.restore(target, id) }, nil
+
+	case OpDelete:
+		// Without Recursive only an empty directory can be deleted, so there
+		// is nothing to back up
+		if info, err := os.Lstat(op.Path); err == nil && info.IsDir() && !op.Recursive {
+			res, err := files.DeleteFile(op.Path, false)
+			if err != nil {
+				return nil, err
+			}
+			out.Result = res
+			return func() error { return os.Mkdir(op.Path, info.Mode().Perm()) }, nil
+		}
+		id, err := r.snapshot(op.Path)
+		if err != nil {
+			return nil, err
+		}
+		out.BackupID = id
+		undo := func() error { return r.restore(op.Path, id) }
+		res, err := files.DeleteFile(op.Path, op.Recursive)
+		if err != nil {
+			return undo, err
+		}
+		out.Result = res
+		return undo, nil
+
+	case OpMove:
+		id, err := r.snapshot(op.Path)
+		if err != nil {
+			return nil, err
+		}
+		out.BackupID = id
+		dirs := files.MissingDirs(filepath.Dir(op.Path))
+		res, err := files.MoveFile(op.Source, op.Path)
+		if err != nil {
+			return nil, err
+		}
+		out.Result = res
+		return func() error {
+			if _, err := files.MoveFile(op.Path, op.Source); err != nil {
+				return err
+			}
+			if id != "" {
+				if err := r.store.Rollback(id, op.Path); err != nil {
+					return err
+				}
+			}
+			removeDirs(dirs)
+			return nil
+		}, nil
+
+	case OpCopy:
+		target, err := files.RealPath(op.Path)
+		if err != nil {
+			return nil, err
+		}
+		id, err := r.snapshot(target)
+		if err != nil {
+			return nil, err
+		}
+		out.BackupID = id
+		dirs := files.MissingDirs(filepath.Dir(op.Path))
+		undo := func() error {
+			err := r.restore(target, id)
+			removeDirs(dirs)
+			return err
+		}
+		res, err := files.CopyFile(op.Source, op.Path)
+		if err != nil {
+			return undo, err
+		}
+		out.Result = res
+		return undo, nil
+
+	case OpMkdir:
+		dirs := files.MissingDirs(op.Path)
+		undo := func() error {
+			removeDirs(dirs)
+			return nil
+		}
+		if err := files.CreateDirectory(op.Path); err != nil {
+			return undo, err
+		}
+		out.Result = map[string]interface{}{"path": op.Path, "created": len(dirs) > 0}
+		return undo, nil
+	}
+	return nil, fmt.Errorf("unknown operation %q", op.Op)
+}
+
+// rollback undoes steps newest first, marking the operations rolled back,
+// and returns what could not be undone
+func rollback(steps []step, result *Result) []string {
+	var failed []string
+	for i := len(steps) - 1; i >= 0; i-- {
+		s := steps[i]
+		err := s.undo()
+		if s.index < 0 {
+			if err != nil {
+				failed = append(failed, fmt.Sprintf("failed operation: %v", err))
+			}
+			continue
+		}
+		out := &result.Operations[s.index]
+		if err != nil {
+			out.Error = fmt.Sprintf("rollback failed: %v", err)
+			failed = append(failed, fmt.Sprintf("operation %d (%s %s): %v", s.index, out.Op, out.Path, err))
+			continue
+		}
+		out.Status = StatusRolledBack
+	}
+	return failed
+}
+
+// removeDirs removes directories created by an operation, innermost first.
+// Directories that are no longer empty are left alone.
+func removeDirs(dirs []string) {
+	for i := len(dirs) - 1; i >= 0; i-- {
+		os.Remove(dirs[i])
+	}
+}
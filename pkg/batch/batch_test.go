@@ -0,0 +1,275 @@
+package batch
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/JeremyProffitt/go-mcp-file-context-server/pkg/backup"
+	"github.com/JeremyProffitt/go-mcp-file-context-server/pkg/files"
+)
+
+// writeTree creates files under root from a map of slash paths to content
+func writeTree(t *testing.T, root string, tree map[string]string) {
+	t.Helper()
+	for name, content := range tree {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// readTree returns every file and directory under root, directories with a
+// trailing slash
+func readTree(t *testing.T, root string) map[string]string {
+	t.Helper()
+	tree := make(map[string]string)
+	filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || path == root {
+			return err
+		}
+		rel, _ := filepath.Rel(root, path)
+		rel = filepath.ToSlash(rel)
+		if d.IsDir() {
+			tree[rel+"/"] = ""
+			return nil
+		}
+		data, _ := os.ReadFile(path)
+		tree[rel] = string(data)
+		return nil
+	})
+	return tree
+}
+
+// inRoot resolves paths relative to root
+func inRoot(root string) func(string) (string, error) {
+	return func(path string) (string, error) {
+		if strings.Contains(path, "..") {
+			return "", fmt.Errorf("access denied: %s", path)
+		}
+		return filepath.Join(root, filepath.FromSlash(path)), nil
+	}
+}
+
+func sampleOps() []Operation {
+	return []Operation{
+		{Op: OpWrite, Path: "main.go", Content: "package main\n\nfunc main() {}\n"},
+		{Op: OpModify, Path: "util.go", Modify: files.ModifyOptions{Find: "old", Replace: "new", AllOccurrences: true}},
+		{Op: OpMkdir, Path: "internal/helpers"},
+		{Op: OpMove, Source: "util.go", Path: "internal/helpers/util.go"},
+		{Op: OpCopy, Source: "README.md", Path: "docs/README.md"},
+		{Op: OpDelete, Path: "legacy", Recursive: true},
+		{Op: OpWrite, Path: "gen/new.go", Content: "package gen\n"},
+	}
+}
+
+func TestRunCommits(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{
+		"main.go":        "package main\n",
+		"util.go":        "func old() {}\n",
+		"README.md":      "# Readme\n",
+		"legacy/a.go":    "package legacy\n",
+		"legacy/x/b.go":  "package x\n",
+		"unrelated.txt":  "keep\n",
+		"docs/other.txt": "other\n",
+	})
+	store := backup.NewStore(t.TempDir(), backup.Options{})
+
+	result, err := Run(sampleOps(), Options{Validate: inRoot(root), Store: store})
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if !result.Committed || result.RolledBack {
+		t.Fatalf("Run() = %+v, want committed", result)
+	}
+	for _, op := range result.Operations {
+		if op.Status != StatusApplied {
+			t.Errorf("operation %d status = %s (%s)", op.Index, op.Status, op.Error)
+		}
+	}
+
+	want := map[string]string{
+		"main.go":                  "package main\n\nfunc main() {}\n",
+		"internal/":                "",
+		"internal/helpers/":        "",
+		"internal/helpers/util.go": "func new() {}\n",
+		"README.md":                "# Readme\n",
+		"docs/":                    "",
+		"docs/README.md":           "# Readme\n",
+		"docs/other.txt":           "other\n",
+		"unrelated.txt":            "keep\n",
+		"gen/":                     "",
+		"gen/new.go":               "package gen\n",
+	}
+	if got := readTree(t, root); !reflect.DeepEqual(got, want) {
+		t.Errorf("tree after commit = %v, want %v", got, want)
+	}
+
+	// What was overwritten or deleted stays in the store
+	if result.Operations[0].BackupID == "" || result.Operations[5].BackupID == "" {
+		t.Errorf("overwrite and delete backups not reported: %+v", result.Operations)
+	}
+	if backups, _ := store.List(backup.Filter{}); len(backups) != 3 {
+		t.Errorf("store has %d backups, want 3 (write, modify, delete)", len(backups))
+	}
+}
+
+func TestRunRollsBack(t *testing.T) {
+	root := t.TempDir()
+	original := map[string]string{
+		"main.go":       "package main\n",
+		"util.go":       "func old() {}\n",
+		"README.md":     "# Readme\n",
+		"legacy/a.go":   "package legacy\n",
+		"legacy/x/b.go": "package x\n",
+	}
+	writeTree(t, root, original)
+	before := readTree(t, root)
+
+	// The last step fails after every other kind of operation has run
+	ops := append(sampleOps(), Operation{Op: OpModify, Path: "main.go", Modify: files.ModifyOptions{Find: "missing", Unique: true}})
+	result, err := Run(ops, Options{Validate: inRoot(root)})
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if result.Committed || !result.RolledBack || len(result.RollbackErrors) > 0 {
+		t.Fatalf("Run() = %+v, want rolled back cleanly", result)
+	}
+	last := len(ops) - 1
+	for i, op := range result.Operations {
+		want := StatusRolledBack
+		if i == last {
+			want = StatusFailed
+		}
+		if op.Status != want {
+			t.Errorf("operation %d status = %s, want %s (%s)", i, op.Status, want, op.Error)
+		}
+		if op.BackupID != "" {
+			t.Errorf("operation %d names temporary backup %s", i, op.BackupID)
+		}
+	}
+	if !strings.Contains(result.Operations[last].Error, string(files.ErrNoMatch)) {
+		t.Errorf("failed operation error = %q", result.Operations[last].Error)
+	}
+	if got := readTree(t, root); !reflect.DeepEqual(got, before) {
+		t.Errorf("tree after rollback = %v, want %v", got, before)
+	}
+}
+
+func TestRunRollsBackThroughSymlinks(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{"real.txt": "ORIGINAL\n", "a.txt": "0123456789"})
+	if err := os.Symlink("real.txt", filepath.Join(root, "link.txt")); err != nil {
+		t.Skipf("symlinks not supported: %v", err)
+	}
+	before := readTree(t, root)
+
+	// The store only fits one backup, so the second would prune the first
+	// if the batch did not hold on to it
+	store := backup.NewStore(filepath.Join(t.TempDir(), "backups"), backup.Options{MaxBytes: 15})
+	ops := []Operation{
+		{Op: OpModify, Path: "link.txt", Modify: files.ModifyOptions{Find: "ORIGINAL", Replace: "CHANGED"}},
+		{Op: OpWrite, Path: "a.txt", Content: "new"},
+		{Op: OpModify, Path: "a.txt", Modify: files.ModifyOptions{Find: "missing", Unique: true}},
+	}
+	result, err := Run(ops, Options{Validate: inRoot(root), Store: store})
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if !result.RolledBack || len(result.RollbackErrors) > 0 {
+		t.Fatalf("Run() = %+v, want rolled back cleanly", result)
+	}
+	if got := readTree(t, root); !reflect.DeepEqual(got, before) {
+		t.Errorf("tree after rollback = %v, want %v", got, before)
+	}
+	if info, err := os.Lstat(filepath.Join(root, "link.txt")); err != nil || info.Mode()&fs.ModeSymlink == 0 {
+		t.Errorf("link.txt is no longer a symlink after rollback")
+	}
+}
+
+func TestRunValidatesFirst(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{"a.txt": "a\n"})
+
+	result, err := Run([]Operation{
+		{Op: OpWrite, Path: "a.txt", Content: "changed\n"},
+		{Op: OpWrite, Path: "../outside.txt", Content: "x\n"},
+		{Op: "rename", Path: "a.txt"},
+		{Op: OpModify, Path: "a.txt"},
+		{Op: OpMkdir, Path: "locked"},
+	}, Options{Validate: inRoot(root), Check: func(op Operation) error {
+		if filepath.Base(op.Path) == "locked" {
+			return fmt.Errorf("read-only path: %s", op.Path)
+		}
+		return nil
+	}})
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	statuses := []string{}
+	for _, op := range result.Operations {
+		statuses = append(statuses, op.Status)
+	}
+	want := []string{StatusNotRun, StatusInvalid, StatusInvalid, StatusInvalid, StatusInvalid}
+	if result.Committed || !reflect.DeepEqual(statuses, want) {
+		t.Errorf("Run() statuses = %v, want %v", statuses, want)
+	}
+	if data, _ := os.ReadFile(filepath.Join(root, "a.txt")); string(data) != "a\n" {
+		t.Errorf("a.txt = %q, invalid batch changed files", data)
+	}
+}
+
+func TestRunDryRun(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{
+		"main.go":       "package main\n",
+		"util.go":       "func old() {}\n",
+		"README.md":     "# Readme\n",
+		"legacy/a.go":   "package legacy\n",
+		"legacy/x/b.go": "package x\n",
+	})
+	before := readTree(t, root)
+
+	result, err := Run(sampleOps(), Options{Validate: inRoot(root), DryRun: true})
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if !result.DryRun || !result.Planned || result.Committed {
+		t.Fatalf("Run() = %+v, want a successful plan", result)
+	}
+	for i, op := range result.Operations {
+		if op.Status != StatusPlanned {
+			t.Errorf("operation %d status = %s (%s)", i, op.Status, op.Error)
+		}
+	}
+	// The move reads the file the modify before it changes
+	if result.Operations[3].Note == "" || result.Operations[3].Result != nil {
+		t.Errorf("dependent move = %+v, want a note and no plan", result.Operations[3])
+	}
+	if deleted, ok := result.Operations[5].Result.(*files.DeleteResult); !ok || len(deleted.Files) != 4 {
+		t.Errorf("delete plan = %+v", result.Operations[5].Result)
+	}
+
+	failing := append(sampleOps(), Operation{Op: OpModify, Path: "README.md", Modify: files.ModifyOptions{Find: "missing", Unique: true}})
+	result, err = Run(failing, Options{Validate: inRoot(root), DryRun: true})
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if result.Planned || result.Operations[len(failing)-1].Status != StatusFailed {
+		t.Errorf("Run() = %+v, want the last operation to fail", result)
+	}
+
+	if got := readTree(t, root); !reflect.DeepEqual(got, before) {
+		t.Errorf("dry run changed the tree: %v, want %v", got, before)
+	}
+}
@@ -0,0 +1,85 @@
+package extract
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// MIME types handled by the built-in extractors
+const (
+	MimePDF      = "application/pdf"
+	MimeDOCX     = "application/vnd.openxmlformats-officedocument.wordprocessingml.document"
+	MimeXLSX     = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	MimePPTX     = "application/vnd.openxmlformats-officedocument.presentationml.presentation"
+	MimeNotebook = "application/x-ipynb+json"
+)
+
+// MaxExtractedSize caps the amount of text produced by an extractor, and the
+// amount of data decompressed from any single archive member or stream
+const MaxExtractedSize = 50 * 1024 * 1024 // 50MB
+
+// Extractor converts the raw bytes of a document to plain text or markdown
+type Extractor func(data []byte) (string, error)
+
+var (
+	registry   = make(map[string]Extractor)
+	registryMu sync.RWMutex
+)
+
+func init() {
+	Register(MimePDF, ExtractPDF)
+	Register(MimeDOCX, ExtractDOCX)
+	Register(MimeXLSX, ExtractXLSX)
+	Register(MimePPTX, ExtractPPTX)
+	Register(MimeNotebook, ExtractNotebook)
+}
+
+// Register adds or replaces the extractor for a MIME type
+func Register(mimeType string, fn Extractor) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	registry[normalizeMime(mimeType)] = fn
+}
+
+// Lookup returns the extractor registered for a MIME type
+func Lookup(mimeType string) (Extractor, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	fn, ok := registry[normalizeMime(mimeType)]
+	return fn, ok
+}
+
+// Supported reports whether an extractor is registered for a MIME type
+func Supported(mimeType string) bool {
+	_, ok := Lookup(mimeType)
+	return ok
+}
+
+// Extract converts data of the given MIME type to text
+func Extract(mimeType string, data []byte) (string, error) {
+	fn, ok := Lookup(mimeType)
+	if !ok {
+		return "", fmt.Errorf("no text extractor for %s", mimeType)
+	}
+
+	text, err := fn(data)
+	if err != nil {
+		return "", fmt.Errorf("failed to extract text from %s: %w", mimeType, err)
+	}
+	if len(text) > MaxExtractedSize {
+		// Cut at a rune boundary so the result stays valid UTF-8
+		end := MaxExtractedSize
+		for end > 0 && !utf8.RuneStart(text[end]) {
+			end--
+		}
+		text = text[:end]
+	}
+	return text, nil
+}
+
+// normalizeMime strips parameters such as charset and lowercases the type
+func normalizeMime(mimeType string) string {
+	return strings.ToLower(strings.TrimSpace(strings.SplitN(mimeType, ";", 2)[0]))
+}
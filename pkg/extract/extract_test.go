@@ -0,0 +1,238 @@
+package extract
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+// buildZip creates an in-memory zip archive from name/content pairs
+func buildZip(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for name, content := range files {
+		f, err := w.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := f.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestRegistry(t *testing.T) {
+	for _, mimeType := range []string{MimePDF, MimeDOCX, MimeXLSX, MimePPTX, MimeNotebook} {
+		if !Supported(mimeType) {
+			t.Errorf("expected extractor for %s", mimeType)
+		}
+	}
+	if Supported("image/png") {
+		t.Error("unexpected extractor for image/png")
+	}
+	if !Supported("Application/PDF; charset=binary") {
+		t.Error("expected MIME parameters and case to be ignored")
+	}
+
+	Register("text/x-test", func(data []byte) (string, error) {
+		return strings.ToUpper(string(data)), nil
+	})
+	text, err := Extract("text/x-test", []byte("hello"))
+	if err != nil || text != "HELLO" {
+		t.Errorf("Extract() = %q, %v", text, err)
+	}
+
+	// Oversized text is cut at a rune boundary, never mid-rune
+	Register("text/x-long", func(data []byte) (string, error) {
+		return "a" + strings.Repeat("é", MaxExtractedSize/2), nil
+	})
+	text, err = Extract("text/x-long", nil)
+	if err != nil || len(text) != MaxExtractedSize-1 || !utf8.ValidString(text) {
+		t.Errorf("Extract() returned %d bytes (valid UTF-8: %t), %v", len(text), utf8.ValidString(text), err)
+	}
+
+	if _, err := Extract("image/png", nil); err == nil {
+		t.Error("expected error for unsupported MIME type")
+	}
+}
+
+func TestExtractDOCX(t *testing.T) {
+	doc := `<?xml version="1.0" encoding="UTF-8"?>
+<w:document xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main">
+<w:body>
+<w:p><w:pPr><w:pStyle w:val="Heading1"/></w:pPr><w:r><w:t>Overview</w:t></w:r></w:p>
+<w:p><w:r><w:t xml:space="preserve">Hello </w:t></w:r><w:r><w:t>world</w:t></w:r></w:p>
+<w:tbl>
+<w:tr><w:tc><w:p><w:r><w:t>a</w:t></w:r></w:p></w:tc><w:tc><w:p><w:r><w:t>b</w:t></w:r></w:p></w:tc></w:tr>
+</w:tbl>
+</w:body>
+</w:document>`
+
+	text, err := ExtractDOCX(buildZip(t, map[string]string{"word/document.xml": doc}))
+	if err != nil {
+		t.Fatalf("ExtractDOCX() error: %v", err)
+	}
+
+	for _, want := range []string{"# Overview\n", "Hello world\n", "| a | b |\n"} {
+		if !strings.Contains(text, want) {
+			t.Errorf("expected %q in:\n%s", want, text)
+		}
+	}
+
+	if _, err := ExtractDOCX(buildZip(t, map[string]string{"other.xml": "<x/>"})); err == nil {
+		t.Error("expected error for missing document part")
+	}
+	if _, err := ExtractDOCX([]byte("not a zip")); err == nil {
+		t.Error("expected error for invalid archive")
+	}
+}
+
+func TestExtractXLSX(t *testing.T) {
+	workbook := `<workbook><sheets><sheet name="Budget" sheetId="1"/><sheet name="Notes" sheetId="2"/></sheets></workbook>`
+	shared := `<sst><si><t>Item</t></si><si><t>Cost</t></si></sst>`
+	sheet1 := `<worksheet><sheetData>
+<row r="1"><c r="A1" t="s"><v>0</v></c><c r="B1" t="s"><v>1</v></c></row>
+<row r="2"><c r="A2" t="inlineStr"><is><t>Coffee</t></is></c><c r="B2"><v>3.5</v></c></row>
+</sheetData></worksheet>`
+	sheet2 := `<worksheet><sheetData><row r="1"><c r="A1" t="inlineStr"><is><t>Done</t></is></c></row></sheetData></worksheet>`
+
+	text, err := ExtractXLSX(buildZip(t, map[string]string{
+		"xl/workbook.xml":          workbook,
+		"xl/sharedStrings.xml":     shared,
+		"xl/worksheets/sheet1.xml": sheet1,
+		"xl/worksheets/sheet2.xml": sheet2,
+	}))
+	if err != nil {
+		t.Fatalf("ExtractXLSX() error: %v", err)
+	}
+
+	for _, want := range []string{"## Budget\n", "| Item | Cost |\n", "| Coffee | 3.5 |\n", "## Notes\n", "| Done |\n"} {
+		if !strings.Contains(text, want) {
+			t.Errorf("expected %q in:\n%s", want, text)
+		}
+	}
+	if strings.Index(text, "Budget") > strings.Index(text, "Notes") {
+		t.Error("expected sheets in workbook order")
+	}
+}
+
+func TestExtractPPTX(t *testing.T) {
+	slide := func(text string) string {
+		return fmt.Sprintf(`<p:sld xmlns:p="p" xmlns:a="a"><p:cSld><p:spTree><p:sp><p:txBody><a:p><a:r><a:t>%s</a:t></a:r></a:p></p:txBody></p:sp></p:spTree></p:cSld></p:sld>`, text)
+	}
+
+	files := map[string]string{}
+	for i := 1; i <= 11; i++ {
+		files[fmt.Sprintf("ppt/slides/slide%d.xml", i)] = slide(fmt.Sprintf("Content %d", i))
+	}
+	files["ppt/slides/_rels/slide1.xml.rels"] = "<Relationships/>"
+
+	text, err := ExtractPPTX(buildZip(t, files))
+	if err != nil {
+		t.Fatalf("ExtractPPTX() error: %v", err)
+	}
+
+	if !strings.Contains(text, "## Slide 1\n\nContent 1\n") {
+		t.Errorf("expected first slide in:\n%s", text)
+	}
+	// Slides are ordered numerically, not lexically
+	if strings.Index(text, "Content 2\n") > strings.Index(text, "Content 10\n") {
+		t.Errorf("expected slide 2 before slide 10 in:\n%s", text)
+	}
+}
+
+func TestExtractNotebook(t *testing.T) {
+	nb := `{
+  "metadata": {"language_info": {"name": "python"}},
+  "cells": [
+    {"cell_type": "markdown", "source": ["# Analysis\n", "Intro text"]},
+    {"cell_type": "code", "source": "print(1 + 1)", "outputs": [
+      {"output_type": "stream", "name": "stdout", "text": ["2\n"]},
+      {"output_type": "display_data", "data": {"image/png": "iVBOR"}}
+    ]},
+    {"cell_type": "code", "source": "1/0", "outputs": [
+      {"output_type": "error", "ename": "ZeroDivisionError", "evalue": "division by zero"}
+    ]}
+  ]
+}`
+
+	text, err := ExtractNotebook([]byte(nb))
+	if err != nil {
+		t.Fatalf("ExtractNotebook() error: %v", err)
+	}
+
+	for _, want := range []string{
+		"# Analysis\nIntro text\n",
+		"```python\nprint(1 + 1)\n```\n",
+		"```output\n2\n```\n",
+		"[image/png output]",
+		"ZeroDivisionError: division by zero",
+	} {
+		if !strings.Contains(text, want) {
+			t.Errorf("expected %q in:\n%s", want, text)
+		}
+	}
+
+	if _, err := ExtractNotebook([]byte("{not json")); err == nil {
+		t.Error("expected error for invalid notebook")
+	}
+}
+
+// buildPDF assembles a minimal PDF with the given content streams
+func buildPDF(streams ...[]byte) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("%PDF-1.4\n")
+	for i, s := range streams {
+		fmt.Fprintf(&buf, "%d 0 obj\n", i+1)
+		buf.Write(s)
+		buf.WriteString("\nendobj\n")
+	}
+	buf.WriteString("%%EOF\n")
+	return buf.Bytes()
+}
+
+func pdfStreamObject(dict string, content []byte) []byte {
+	return []byte(fmt.Sprintf("<< %s /Length %d >>\nstream\n%s\nendstream", dict, len(content), content))
+}
+
+func TestExtractPDF(t *testing.T) {
+	page1 := []byte("BT /F1 12 Tf 72 720 Td (Hello \\(PDF\\) world) Tj 0 -14 Td [(Kern)-50(ed) -300 (text)] TJ ET")
+
+	var compressed bytes.Buffer
+	zw := zlib.NewWriter(&compressed)
+	zw.Write([]byte("BT /F1 12 Tf 72 720 Td <0048006900210021> Tj T* (Second page) Tj ET"))
+	zw.Close()
+
+	data := buildPDF(
+		pdfStreamObject("", page1),
+		pdfStreamObject("/Filter /FlateDecode", compressed.Bytes()),
+		pdfStreamObject("/Type /XObject /Subtype /Image /Width 1 /Height 1", []byte("\x00\x01\x02")),
+	)
+
+	text, err := ExtractPDF(data)
+	if err != nil {
+		t.Fatalf("ExtractPDF() error: %v", err)
+	}
+
+	for _, want := range []string{"Hello (PDF) world\n", "Kerned text\n", "Hi!!\n", "Second page"} {
+		if !strings.Contains(text, want) {
+			t.Errorf("expected %q in:\n%s", want, text)
+		}
+	}
+
+	if _, err := ExtractPDF([]byte("plain text")); err == nil {
+		t.Error("expected error for non-PDF data")
+	}
+	if _, err := ExtractPDF(buildPDF(pdfStreamObject("/Subtype /Image", []byte("xx")))); err == nil {
+		t.Error("expected error for PDF without text")
+	}
+}
@@ -0,0 +1,119 @@
+package extract
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// notebook is the subset of the Jupyter notebook format used for extraction
+type notebook struct {
+	Cells    []notebookCell `json:"cells"`
+	Metadata struct {
+		KernelSpec struct {
+			Language string `json:"language"`
+		} `json:"kernelspec"`
+		LanguageInfo struct {
+			Name string `json:"name"`
+		} `json:"language_info"`
+	} `json:"metadata"`
+}
+
+type notebookCell struct {
+	CellType string           `json:"cell_type"`
+	Source   multilineString  `json:"source"`
+	Outputs  []notebookOutput `json:"outputs"`
+}
+
+type notebookOutput struct {
+	OutputType string                     `json:"output_type"`
+	Name       string                     `json:"name"`
+	Text       multilineString            `json:"text"`
+	Data       map[string]json.RawMessage `json:"data"`
+	EName      string                     `json:"ename"`
+	EValue     string                     `json:"evalue"`
+}
+
+// multilineString accepts notebook text fields stored either as a string or
+// as a list of lines
+type multilineString string
+
+func (m *multilineString) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*m = multilineString(s)
+		return nil
+	}
+	var lines []string
+	if err := json.Unmarshal(data, &lines); err != nil {
+		return err
+	}
+	*m = multilineString(strings.Join(lines, ""))
+	return nil
+}
+
+// ExtractNotebook converts a Jupyter notebook to markdown: markdown cells as
+// is, code cells as fenced blocks, and text outputs as fenced output blocks.
+// Rich outputs (images, HTML) are noted but not included.
+func ExtractNotebook(data []byte) (string, error) {
+	var nb notebook
+	if err := json.Unmarshal(data, &nb); err != nil {
+		return "", fmt.Errorf("invalid notebook: %w", err)
+	}
+
+	language := nb.Metadata.LanguageInfo.Name
+	if language == "" {
+		language = nb.Metadata.KernelSpec.Language
+	}
+
+	var b strings.Builder
+	for i, cell := range nb.Cells {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		source := strings.TrimRight(string(cell.Source), "\n")
+
+		switch cell.CellType {
+		case "markdown":
+			b.WriteString(source + "\n")
+		case "code":
+			fmt.Fprintf(&b, "```%s\n%s\n```\n", language, source)
+			for _, output := range cell.Outputs {
+				if text := notebookOutputText(output); text != "" {
+					fmt.Fprintf(&b, "\n```output\n%s\n```\n", strings.TrimRight(text, "\n"))
+				}
+			}
+		default:
+			b.WriteString(source + "\n")
+		}
+	}
+
+	return b.String(), nil
+}
+
+// notebookOutputText returns the plain-text form of a cell output
+func notebookOutputText(output notebookOutput) string {
+	switch output.OutputType {
+	case "stream":
+		return string(output.Text)
+	case "error":
+		return fmt.Sprintf("%s: %s", output.EName, output.EValue)
+	case "execute_result", "display_data":
+		if raw, ok := output.Data["text/plain"]; ok {
+			var text multilineString
+			if err := json.Unmarshal(raw, &text); err == nil {
+				return string(text)
+			}
+		}
+		mimeTypes := make([]string, 0, len(output.Data))
+		for mimeType := range output.Data {
+			mimeTypes = append(mimeTypes, mimeType)
+		}
+		if len(mimeTypes) > 0 {
+			sort.Strings(mimeTypes)
+			return fmt.Sprintf("[%s output]", strings.Join(mimeTypes, ", "))
+		}
+	}
+	return ""
+}
@@ -0,0 +1,345 @@
+package extract
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// partNumber extracts the trailing number from parts like "slide12.xml"
+var partNumber = regexp.MustCompile(`(\d+)\.xml$`)
+
+// ExtractDOCX converts a Word document to markdown, keeping paragraphs,
+// headings, line breaks and table rows
+func ExtractDOCX(data []byte) (string, error) {
+	parts, err := openOOXML(data)
+	if err != nil {
+		return "", err
+	}
+
+	doc, ok := parts["word/document.xml"]
+	if !ok {
+		return "", fmt.Errorf("missing word/document.xml")
+	}
+
+	var b strings.Builder
+	var para strings.Builder
+	var cells []string
+	heading := 0
+	inTable := 0
+
+	err = walkXML(doc, func(tok xml.Token, d *xml.Decoder) error {
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "p":
+				para.Reset()
+				heading = 0
+			case "pStyle":
+				heading = headingLevel(attr(t, "val"))
+			case "tab":
+				para.WriteString("\t")
+			case "br", "cr":
+				para.WriteString("\n")
+			case "t":
+				var text string
+				if err := d.DecodeElement(&text, &t); err != nil {
+					return err
+				}
+				para.WriteString(text)
+			case "tbl":
+				inTable++
+			case "tr":
+				cells = cells[:0]
+			}
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "p":
+				text := para.String()
+				if inTable > 0 {
+					cells = append(cells, text)
+					return nil
+				}
+				if heading > 0 && text != "" {
+					b.WriteString(strings.Repeat("#", heading) + " ")
+				}
+				b.WriteString(text + "\n")
+			case "tr":
+				b.WriteString("| " + strings.Join(cells, " | ") + " |\n")
+			case "tbl":
+				inTable--
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return b.String(), nil
+}
+
+// headingLevel maps Word paragraph styles such as "Heading2" or "Title" to a
+// markdown heading level (0 for body text)
+func headingLevel(style string) int {
+	lower := strings.ToLower(style)
+	if lower == "title" {
+		return 1
+	}
+	if strings.HasPrefix(lower, "heading") {
+		if n, err := strconv.Atoi(strings.TrimPrefix(lower, "heading")); err == nil && n > 0 && n <= 6 {
+			return n
+		}
+	}
+	return 0
+}
+
+// ExtractXLSX converts an Excel workbook to markdown, one table per sheet
+func ExtractXLSX(data []byte) (string, error) {
+	parts, err := openOOXML(data)
+	if err != nil {
+		return "", err
+	}
+
+	sharedStrings, err := readSharedStrings(parts["xl/sharedStrings.xml"])
+	if err != nil {
+		return "", err
+	}
+
+	sheetNames, err := readSheetNames(parts["xl/workbook.xml"])
+	if err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	for i, name := range numberedParts(parts, "xl/worksheets/sheet") {
+		title := fmt.Sprintf("Sheet%d", i+1)
+		if i < len(sheetNames) {
+			title = sheetNames[i]
+		}
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		b.WriteString("## " + title + "\n\n")
+
+		rows, err := readSheetRows(parts[name], sharedStrings)
+		if err != nil {
+			return "", err
+		}
+		for _, row := range rows {
+			b.WriteString("| " + strings.Join(row, " | ") + " |\n")
+		}
+	}
+
+	return b.String(), nil
+}
+
+func readSharedStrings(data []byte) ([]string, error) {
+	if data == nil {
+		return nil, nil
+	}
+
+	var shared []string
+	var current strings.Builder
+	err := walkXML(data, func(tok xml.Token, d *xml.Decoder) error {
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "si":
+				current.Reset()
+			case "t":
+				var text string
+				if err := d.DecodeElement(&text, &t); err != nil {
+					return err
+				}
+				current.WriteString(text)
+			}
+		case xml.EndElement:
+			if t.Name.Local == "si" {
+				shared = append(shared, current.String())
+			}
+		}
+		return nil
+	})
+	return shared, err
+}
+
+func readSheetNames(data []byte) ([]string, error) {
+	if data == nil {
+		return nil, nil
+	}
+
+	var names []string
+	err := walkXML(data, func(tok xml.Token, d *xml.Decoder) error {
+		if t, ok := tok.(xml.StartElement); ok && t.Name.Local == "sheet" {
+			names = append(names, attr(t, "name"))
+		}
+		return nil
+	})
+	return names, err
+}
+
+func readSheetRows(data []byte, sharedStrings []string) ([][]string, error) {
+	var rows [][]string
+	var row []string
+	cellType := ""
+
+	err := walkXML(data, func(tok xml.Token, d *xml.Decoder) error {
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "row":
+				row = nil
+			case "c":
+				cellType = attr(t, "t")
+				row = append(row, "")
+			case "v", "t":
+				var text string
+				if err := d.DecodeElement(&text, &t); err != nil {
+					return err
+				}
+				if len(row) == 0 {
+					return nil
+				}
+				if cellType == "s" && t.Name.Local == "v" {
+					if idx, err := strconv.Atoi(strings.TrimSpace(text)); err == nil && idx >= 0 && idx < len(sharedStrings) {
+						text = sharedStrings[idx]
+					}
+				}
+				row[len(row)-1] += text
+			}
+		case xml.EndElement:
+			if t.Name.Local == "row" {
+				rows = append(rows, row)
+			}
+		}
+		return nil
+	})
+	return rows, err
+}
+
+// ExtractPPTX converts a PowerPoint presentation to markdown, one section per slide
+func ExtractPPTX(data []byte) (string, error) {
+	parts, err := openOOXML(data)
+	if err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	for i, name := range numberedParts(parts, "ppt/slides/slide") {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		fmt.Fprintf(&b, "## Slide %d\n\n", i+1)
+
+		var para strings.Builder
+		err := walkXML(parts[name], func(tok xml.Token, d *xml.Decoder) error {
+			switch t := tok.(type) {
+			case xml.StartElement:
+				switch t.Name.Local {
+				case "p":
+					para.Reset()
+				case "br":
+					para.WriteString("\n")
+				case "t":
+					var text string
+					if err := d.DecodeElement(&text, &t); err != nil {
+						return err
+					}
+					para.WriteString(text)
+				}
+			case xml.EndElement:
+				if t.Name.Local == "p" && strings.TrimSpace(para.String()) != "" {
+					b.WriteString(para.String() + "\n")
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return b.String(), nil
+}
+
+// openOOXML reads the XML parts of an Office Open XML package, limiting the
+// decompressed size of each part
+func openOOXML(data []byte) (map[string][]byte, error) {
+	reader, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("not a valid Office document: %w", err)
+	}
+
+	parts := make(map[string][]byte)
+	for _, f := range reader.File {
+		if !strings.HasSuffix(f.Name, ".xml") {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		content, err := io.ReadAll(io.LimitReader(rc, MaxExtractedSize+1))
+		rc.Close()
+		if err != nil {
+			return nil, err
+		}
+		if len(content) > MaxExtractedSize {
+			return nil, fmt.Errorf("part %s exceeds %d bytes", f.Name, MaxExtractedSize)
+		}
+		parts[f.Name] = content
+	}
+
+	return parts, nil
+}
+
+// numberedParts returns the parts named prefix<N>.xml sorted by N
+func numberedParts(parts map[string][]byte, prefix string) []string {
+	var names []string
+	for name := range parts {
+		if strings.HasPrefix(name, prefix) && path.Dir(name) == path.Dir(prefix+"x") && partNumber.MatchString(name) {
+			names = append(names, name)
+		}
+	}
+	sort.Slice(names, func(i, j int) bool {
+		a, _ := strconv.Atoi(partNumber.FindStringSubmatch(names[i])[1])
+		b, _ := strconv.Atoi(partNumber.FindStringSubmatch(names[j])[1])
+		return a < b
+	})
+	return names
+}
+
+// walkXML calls fn for every token in an XML document
+func walkXML(data []byte, fn func(xml.Token, *xml.Decoder) error) error {
+	d := xml.NewDecoder(bytes.NewReader(data))
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("invalid XML: %w", err)
+		}
+		if err := fn(tok, d); err != nil {
+			return err
+		}
+	}
+}
+
+// attr returns the value of the attribute with the given local name
+func attr(el xml.StartElement, name string) string {
+	for _, a := range el.Attr {
+		if a.Name.Local == name {
+			return a.Value
+		}
+	}
+	return ""
+}
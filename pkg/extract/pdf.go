@@ -0,0 +1,410 @@
+package extract
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	// pdfStream matches a stream dictionary and the start of its data
+	pdfStream = regexp.MustCompile(`(?s)<<((?:[^<>]|<<(?:[^<>]|<<[^<>]*>>)*>>|<[^<>]*>)*)>>\s*stream\r?\n`)
+	// pdfLength matches a direct /Length value in a stream dictionary
+	pdfLength = regexp.MustCompile(`/Length\s+(\d+)(\s+\d+\s+R)?`)
+)
+
+// ExtractPDF extracts text from a PDF by decoding its content streams and
+// interpreting the text-showing operators. It handles uncompressed and
+// FlateDecode streams with simple font encodings; text drawn with CID fonts
+// or stored in compressed object streams may be missed. Pages are separated
+// by blank lines in document order.
+func ExtractPDF(data []byte) (string, error) {
+	if !bytes.HasPrefix(bytes.TrimLeft(data, "\x00\t\r\n "), []byte("%PDF")) {
+		return "", fmt.Errorf("not a PDF file")
+	}
+
+	var b strings.Builder
+	for _, loc := range pdfStream.FindAllSubmatchIndex(data, -1) {
+		dict := data[loc[2]:loc[3]]
+		start := loc[1]
+
+		if bytes.Contains(dict, []byte("/Image")) || bytes.Contains(dict, []byte("/XObject")) ||
+			bytes.Contains(dict, []byte("/FontFile")) || bytes.Contains(dict, []byte("/Length1")) {
+			continue
+		}
+
+		end := streamEnd(data, dict, start)
+		if end < 0 {
+			continue
+		}
+		content := data[start:end]
+
+		if bytes.Contains(dict, []byte("/FlateDecode")) {
+			decoded, err := inflate(content)
+			if err != nil {
+				continue
+			}
+			content = decoded
+		} else if bytes.Contains(dict, []byte("/Filter")) {
+			continue // Unsupported filter
+		}
+
+		if text := pdfContentText(content); strings.TrimSpace(text) != "" {
+			if b.Len() > 0 {
+				b.WriteString("\n")
+			}
+			b.WriteString(text)
+		}
+		if b.Len() > MaxExtractedSize {
+			break
+		}
+	}
+
+	if b.Len() == 0 {
+		return "", fmt.Errorf("no extractable text found")
+	}
+	return b.String(), nil
+}
+
+// streamEnd finds the end of a stream's data using /Length when it is a
+// direct value, falling back to the endstream keyword
+func streamEnd(data []byte, dict []byte, start int) int {
+	if m := pdfLength.FindSubmatch(dict); m != nil && len(m[2]) == 0 {
+		if n, err := strconv.Atoi(string(m[1])); err == nil && start+n <= len(data) {
+			return start + n
+		}
+	}
+	idx := bytes.Index(data[start:], []byte("endstream"))
+	if idx < 0 {
+		return -1
+	}
+	return start + idx
+}
+
+func inflate(data []byte) ([]byte, error) {
+	r, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	// Truncated streams are common; keep whatever decompressed cleanly
+	out, err := io.ReadAll(io.LimitReader(r, MaxExtractedSize))
+	if err != nil && len(out) == 0 {
+		return nil, err
+	}
+	return out, nil
+}
+
+// pdfContentText interprets a page content stream, returning the text shown
+// by Tj, TJ, ' and " operators. Text positioning operators start new lines.
+func pdfContentText(content []byte) string {
+	var b strings.Builder
+	var operands []string
+	inText := false
+
+	newline := func() {
+		s := b.String()
+		if len(s) > 0 && !strings.HasSuffix(s, "\n") {
+			b.WriteString("\n")
+		}
+	}
+
+	lex := &pdfLexer{data: content}
+	for {
+		tok, ok := lex.next()
+		if !ok {
+			break
+		}
+
+		switch {
+		case tok.kind != pdfOperator:
+			operands = append(operands, tok.value)
+			continue
+		case tok.value == "BT":
+			inText = true
+		case tok.value == "ET":
+			inText = false
+			newline()
+		case !inText:
+		case tok.value == "Tj":
+			if len(operands) > 0 {
+				b.WriteString(operands[len(operands)-1])
+			}
+		case tok.value == "'" || tok.value == "\"":
+			newline()
+			if len(operands) > 0 {
+				b.WriteString(operands[len(operands)-1])
+			}
+		case tok.value == "TJ":
+			b.WriteString(strings.Join(operands, ""))
+		case tok.value == "T*":
+			newline()
+		case tok.value == "Td" || tok.value == "TD":
+			// Only vertical moves start a new line
+			if len(operands) >= 2 && operands[len(operands)-1] != "0" {
+				newline()
+			}
+		case tok.value == "Tm":
+			newline()
+		}
+		operands = operands[:0]
+	}
+
+	return b.String()
+}
+
+type pdfTokenKind int
+
+const (
+	pdfOperator pdfTokenKind = iota
+	pdfOperand
+)
+
+type pdfToken struct {
+	kind  pdfTokenKind
+	value string
+}
+
+// pdfLexer tokenizes a content stream. String operands are decoded to text;
+// array operands collapse to the concatenation of their strings, with large
+// negative kerning rendered as a space.
+type pdfLexer struct {
+	data []byte
+	pos  int
+}
+
+func (l *pdfLexer) next() (pdfToken, bool) {
+	for l.pos < len(l.data) {
+		c := l.data[l.pos]
+		switch {
+		case isPDFSpace(c):
+			l.pos++
+		case c == '%':
+			for l.pos < len(l.data) && l.data[l.pos] != '\n' && l.data[l.pos] != '\r' {
+				l.pos++
+			}
+		case c == '(':
+			return pdfToken{kind: pdfOperand, value: l.literalString()}, true
+		case c == '<' && l.pos+1 < len(l.data) && l.data[l.pos+1] == '<':
+			l.skipDict()
+			return pdfToken{kind: pdfOperand}, true
+		case c == '<':
+			return pdfToken{kind: pdfOperand, value: l.hexString()}, true
+		case c == '[':
+			return pdfToken{kind: pdfOperand, value: l.array()}, true
+		case c == ']' || c == '>' || c == ')' || c == '{' || c == '}':
+			l.pos++
+		case c == '/':
+			l.pos++
+			l.word()
+			return pdfToken{kind: pdfOperand}, true
+		default:
+			w := l.word()
+			if w == "" {
+				l.pos++
+				continue
+			}
+			if isPDFNumber(w) {
+				return pdfToken{kind: pdfOperand, value: w}, true
+			}
+			if w == "true" || w == "false" || w == "null" {
+				return pdfToken{kind: pdfOperand, value: w}, true
+			}
+			if w == "BI" {
+				l.skipInlineImage()
+				continue
+			}
+			return pdfToken{kind: pdfOperator, value: w}, true
+		}
+	}
+	return pdfToken{}, false
+}
+
+func (l *pdfLexer) word() string {
+	start := l.pos
+	for l.pos < len(l.data) {
+		c := l.data[l.pos]
+		if isPDFSpace(c) || strings.IndexByte("()<>[]{}/%", c) >= 0 {
+			break
+		}
+		l.pos++
+	}
+	return string(l.data[start:l.pos])
+}
+
+func (l *pdfLexer) literalString() string {
+	var b strings.Builder
+	l.pos++ // (
+	depth := 1
+	for l.pos < len(l.data) {
+		c := l.data[l.pos]
+		l.pos++
+		switch c {
+		case '\\':
+			if l.pos >= len(l.data) {
+				break
+			}
+			e := l.data[l.pos]
+			l.pos++
+			switch e {
+			case 'n':
+				b.WriteByte('\n')
+			case 'r':
+				b.WriteByte('\r')
+			case 't':
+				b.WriteByte('\t')
+			case 'b', 'f':
+			case '\r', '\n':
+				// Line continuation
+			default:
+				if e >= '0' && e <= '7' {
+					n := int(e - '0')
+					for i := 0; i < 2 && l.pos < len(l.data) && l.data[l.pos] >= '0' && l.data[l.pos] <= '7'; i++ {
+						n = n*8 + int(l.data[l.pos]-'0')
+						l.pos++
+					}
+					b.WriteString(pdfDocChar(byte(n)))
+				} else {
+					b.WriteByte(e)
+				}
+			}
+		case '(':
+			depth++
+			b.WriteByte(c)
+		case ')':
+			depth--
+			if depth == 0 {
+				return b.String()
+			}
+			b.WriteByte(c)
+		default:
+			b.WriteString(pdfDocChar(c))
+		}
+	}
+	return b.String()
+}
+
+func (l *pdfLexer) hexString() string {
+	l.pos++ // <
+	var digits []byte
+	for l.pos < len(l.data) && l.data[l.pos] != '>' {
+		if c := l.data[l.pos]; isHexDigit(c) {
+			digits = append(digits, c)
+		}
+		l.pos++
+	}
+	l.pos++ // >
+	if len(digits)%2 != 0 {
+		digits = append(digits, '0')
+	}
+
+	raw := make([]byte, len(digits)/2)
+	for i := range raw {
+		v, _ := strconv.ParseUint(string(digits[2*i:2*i+2]), 16, 8)
+		raw[i] = byte(v)
+	}
+
+	// Two-byte strings starting with a UTF-16 BOM, or that look like two-byte
+	// character codes, are decoded as UTF-16BE
+	if len(raw) >= 2 && len(raw)%2 == 0 && (bytes.HasPrefix(raw, []byte{0xFE, 0xFF}) || raw[0] == 0) {
+		raw = bytes.TrimPrefix(raw, []byte{0xFE, 0xFF})
+		var b strings.Builder
+		for i := 0; i+1 < len(raw); i += 2 {
+			b.WriteRune(rune(raw[i])<<8 | rune(raw[i+1]))
+		}
+		return b.String()
+	}
+
+	var b strings.Builder
+	for _, c := range raw {
+		b.WriteString(pdfDocChar(c))
+	}
+	return b.String()
+}
+
+func (l *pdfLexer) array() string {
+	l.pos++ // [
+	var b strings.Builder
+	for l.pos < len(l.data) {
+		c := l.data[l.pos]
+		switch {
+		case c == ']':
+			l.pos++
+			return b.String()
+		case isPDFSpace(c):
+			l.pos++
+		case c == '(':
+			b.WriteString(l.literalString())
+		case c == '<':
+			b.WriteString(l.hexString())
+		default:
+			w := l.word()
+			if w == "" {
+				l.pos++
+				continue
+			}
+			// Large negative adjustments are word gaps
+			if n, err := strconv.ParseFloat(w, 64); err == nil && n < -200 {
+				b.WriteString(" ")
+			}
+		}
+	}
+	return b.String()
+}
+
+func (l *pdfLexer) skipDict() {
+	depth := 0
+	for l.pos+1 < len(l.data) {
+		if l.data[l.pos] == '<' && l.data[l.pos+1] == '<' {
+			depth++
+			l.pos += 2
+			continue
+		}
+		if l.data[l.pos] == '>' && l.data[l.pos+1] == '>' {
+			depth--
+			l.pos += 2
+			if depth == 0 {
+				return
+			}
+			continue
+		}
+		l.pos++
+	}
+	l.pos = len(l.data)
+}
+
+func (l *pdfLexer) skipInlineImage() {
+	idx := bytes.Index(l.data[l.pos:], []byte("EI"))
+	if idx < 0 {
+		l.pos = len(l.data)
+		return
+	}
+	l.pos += idx + 2
+}
+
+// pdfDocChar maps a byte in a simple font encoding to text, treating it as
+// Latin-1 (which matches WinAnsi and PDFDocEncoding for printable ASCII)
+func pdfDocChar(c byte) string {
+	if c < 0x20 && c != '\n' && c != '\r' && c != '\t' {
+		return ""
+	}
+	return string(rune(c))
+}
+
+func isPDFSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\r' || c == '\n' || c == '\f' || c == 0
+}
+
+func isPDFNumber(w string) bool {
+	_, err := strconv.ParseFloat(w, 64)
+	return err == nil
+}
+
+func isHexDigit(c byte) bool {
+	return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
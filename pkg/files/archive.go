@@ -0,0 +1,697 @@
+package files
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	pathpkg "path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/JeremyProffitt/go-mcp-file-context-server/pkg/extract"
+	"github.com/bmatcuk/doublestar/v4"
+)
+
+// ArchiveSeparator separates an archive file from a path inside it, as in
+// "release.zip!/docs/README.md"
+const ArchiveSeparator = "!/"
+
+// archiveExtensions are the suffixes of files that can be browsed as directories
+var archiveExtensions = []string{".zip", ".jar", ".tar", ".tar.gz", ".tgz"}
+
+// ArchiveLimits guards against decompression bombs when reading archives
+type ArchiveLimits struct {
+	MaxEntries          int   // Maximum number of entries in an archive
+	MaxEntrySize        int64 // Maximum uncompressed size of a single entry
+	MaxTotalSize        int64 // Maximum uncompressed bytes read from one archive
+	MaxCompressionRatio int64 // Maximum uncompressed/compressed ratio of a zip entry
+}
+
+// DefaultArchiveLimits are the limits applied to every archive operation
+var DefaultArchiveLimits = ArchiveLimits{
+	MaxEntries:          100000,
+	MaxEntrySize:        100 * 1024 * 1024,  // 100MB
+	MaxTotalSize:        1024 * 1024 * 1024, // 1GB
+	MaxCompressionRatio: 200,
+}
+
+// ratioCheckThreshold is the entry size above which the compression ratio is
+// checked; small, highly repetitive files legitimately compress very well
+const ratioCheckThreshold = 1024 * 1024
+
+// IsArchiveFile reports whether path has an archive extension
+func IsArchiveFile(path string) bool {
+	lower := strings.ToLower(path)
+	for _, ext := range archiveExtensions {
+		if strings.HasSuffix(lower, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// SplitArchivePath splits "archive.zip!/inner/path" into the archive file and
+// the cleaned inner path ("" for the archive root). ok is false when path does
+// not address the inside of an archive.
+func SplitArchivePath(path string) (archivePath string, innerPath string, ok bool) {
+	slashed := filepath.ToSlash(path)
+	offset := 0
+	for {
+		idx := strings.Index(slashed[offset:], "!")
+		if idx < 0 {
+			return "", "", false
+		}
+		idx += offset
+		rest := slashed[idx+1:]
+		if IsArchiveFile(slashed[:idx]) && (rest == "" || strings.HasPrefix(rest, "/")) {
+			inner := strings.TrimPrefix(pathpkg.Clean("/"+rest), "/")
+			return path[:idx], inner, true
+		}
+		offset = idx + 1
+	}
+}
+
+// JoinArchivePath builds the path of an entry inside an archive
+func JoinArchivePath(archivePath, innerPath string) string {
+	return filepath.ToSlash(archivePath) + ArchiveSeparator + innerPath
+}
+
+// IsArchivePath reports whether path addresses an archive to be browsed as a
+// directory: either an archive file itself or a path inside one
+func IsArchivePath(path string) bool {
+	if _, _, ok := SplitArchivePath(path); ok {
+		return true
+	}
+	if !IsArchiveFile(path) {
+		return false
+	}
+	info, err := os.Stat(path)
+	return err == nil && info.Mode().IsRegular()
+}
+
+// archiveEntry is a file or directory inside an archive
+type archiveEntry struct {
+	name       string
+	size       int64
+	compressed int64
+	modTime    time.Time
+	isDir      bool
+	zipFile    *zip.File
+}
+
+// Archive is an opened zip or tar archive
+type Archive struct {
+	path    string
+	entries map[string]*archiveEntry
+	names   []string
+	zip     *zip.ReadCloser
+	limits  ArchiveLimits
+	read    int64 // Uncompressed bytes read so far
+}
+
+// OpenArchive reads the index of a zip or tar archive. Entries with absolute
+// paths or ".." components are ignored.
+func OpenArchive(path string, limits ArchiveLimits) (*Archive, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, &FileError{Code: ErrFileNotFound, Message: "Archive not found", Path: path}
+		}
+		return nil, &FileError{Code: ErrUnknown, Message: err.Error(), Path: path}
+	}
+	if !info.Mode().IsRegular() {
+		return nil, &FileError{Code: ErrInvalidPath, Message: "Path is not an archive file", Path: path}
+	}
+
+	a := &Archive{path: path, entries: make(map[string]*archiveEntry), limits: limits}
+	if isZipArchive(path) {
+		err = a.indexZip()
+	} else {
+		err = a.indexTar()
+	}
+	if err != nil {
+		a.Close()
+		return nil, err
+	}
+
+	sort.Strings(a.names)
+	return a, nil
+}
+
+// Close releases the archive
+func (a *Archive) Close() error {
+	if a.zip != nil {
+		return a.zip.Close()
+	}
+	return nil
+}
+
+func isZipArchive(path string) bool {
+	lower := strings.ToLower(path)
+	return strings.HasSuffix(lower, ".zip") || strings.HasSuffix(lower, ".jar")
+}
+
+func (a *Archive) indexZip() error {
+	zr, err := zip.OpenReader(a.path)
+	if err != nil {
+		return &FileError{Code: ErrInvalidPath, Message: fmt.Sprintf("Invalid zip archive: %s", err.Error()), Path: a.path}
+	}
+	a.zip = zr
+
+	if len(zr.File) > a.limits.MaxEntries {
+		return a.limitError(fmt.Sprintf("Archive has more than %d entries", a.limits.MaxEntries))
+	}
+
+	var total int64
+	for _, f := range zr.File {
+		total += int64(f.UncompressedSize64)
+		if total > a.limits.MaxTotalSize {
+			return a.limitError(fmt.Sprintf("Archive expands to more than %d bytes", a.limits.MaxTotalSize))
+		}
+		a.add(f.Name, &archiveEntry{
+			size:       int64(f.UncompressedSize64),
+			compressed: int64(f.CompressedSize64),
+			modTime:    f.Modified,
+			isDir:      f.FileInfo().IsDir(),
+			zipFile:    f,
+		})
+	}
+	return nil
+}
+
+func (a *Archive) indexTar() error {
+	r, closer, err := a.openTar()
+	if err != nil {
+		return err
+	}
+	defer closer.Close()
+
+	for {
+		hdr, err := r.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			var fe *FileError
+			if errors.As(err, &fe) {
+				return fe
+			}
+			return &FileError{Code: ErrInvalidPath, Message: fmt.Sprintf("Invalid tar archive: %s", err.Error()), Path: a.path}
+		}
+		if len(a.entries) >= a.limits.MaxEntries {
+			return a.limitError(fmt.Sprintf("Archive has more than %d entries", a.limits.MaxEntries))
+		}
+
+		switch hdr.Typeflag {
+		case tar.TypeReg, tar.TypeDir:
+			a.add(hdr.Name, &archiveEntry{
+				size:       hdr.Size,
+				compressed: hdr.Size,
+				modTime:    hdr.ModTime,
+				isDir:      hdr.Typeflag == tar.TypeDir,
+			})
+		}
+	}
+}
+
+// openTar opens the tar stream, decompressing gzip archives. The returned
+// reader fails once more than MaxTotalSize bytes have been decompressed.
+func (a *Archive) openTar() (*tar.Reader, io.Closer, error) {
+	file, err := os.Open(a.path)
+	if err != nil {
+		return nil, nil, &FileError{Code: ErrUnknown, Message: err.Error(), Path: a.path}
+	}
+
+	var stream io.Reader = file
+	lower := strings.ToLower(a.path)
+	if strings.HasSuffix(lower, ".gz") || strings.HasSuffix(lower, ".tgz") {
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			file.Close()
+			return nil, nil, &FileError{Code: ErrInvalidPath, Message: fmt.Sprintf("Invalid gzip stream: %s", err.Error()), Path: a.path}
+		}
+		stream = gz
+	}
+
+	limited := &limitedReader{r: stream, remaining: a.limits.MaxTotalSize, err: a.limitError(fmt.Sprintf("Archive expands to more than %d bytes", a.limits.MaxTotalSize))}
+	return tar.NewReader(limited), file, nil
+}
+
+// add records an entry and any parent directories missing from the archive
+func (a *Archive) add(name string, entry *archiveEntry) {
+	name = strings.TrimSuffix(name, "/")
+	if name == "" || strings.HasPrefix(name, "/") {
+		return
+	}
+	for _, part := range strings.Split(name, "/") {
+		if part == ".." {
+			return
+		}
+	}
+	name = pathpkg.Clean(name)
+	if name == "." {
+		return
+	}
+
+	entry.name = name
+	if _, exists := a.entries[name]; !exists {
+		a.names = append(a.names, name)
+	}
+	a.entries[name] = entry
+
+	for dir := pathpkg.Dir(name); dir != "."; dir = pathpkg.Dir(dir) {
+		if _, exists := a.entries[dir]; exists {
+			break
+		}
+		a.entries[dir] = &archiveEntry{name: dir, isDir: true, modTime: entry.modTime}
+		a.names = append(a.names, dir)
+	}
+}
+
+func (a *Archive) limitError(message string) *FileError {
+	return &FileError{Code: ErrArchiveLimit, Message: message, Path: a.path}
+}
+
+// metadata converts an entry to FileMetadata
+func (a *Archive) metadata(entry *archiveEntry) FileMetadata {
+	return FileMetadata{
+		Size:         entry.size,
+		MimeType:     GetMimeType(entry.name),
+		ModifiedTime: entry.modTime,
+		CreatedTime:  entry.modTime,
+		IsDirectory:  entry.isDir,
+	}
+}
+
+// Stat returns the metadata of an inner path ("" for the archive root)
+func (a *Archive) Stat(innerPath string) (*FileMetadata, error) {
+	if innerPath == "" {
+		return &FileMetadata{MimeType: GetMimeType(a.path), IsDirectory: true}, nil
+	}
+	entry, ok := a.entries[innerPath]
+	if !ok {
+		return nil, &FileError{Code: ErrFileNotFound, Message: "File not found in archive", Path: JoinArchivePath(a.path, innerPath)}
+	}
+	metadata := a.metadata(entry)
+	return &metadata, nil
+}
+
+// List lists the entries under an inner directory, applying the same hidden,
+// ignore, file type and include/exclude filters as ListFiles. Globs are
+// matched against paths relative to innerPath.
+func (a *Archive) List(innerPath string, recursive bool, fileTypes []string, includeHidden bool, opts WalkOptions) ([]FileEntry, error) {
+	filter, err := NewPathFilter(fileTypes, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	metadata, err := a.Stat(innerPath)
+	if err != nil {
+		return nil, err
+	}
+	if !metadata.IsDirectory {
+		return nil, &FileError{Code: ErrInvalidPath, Message: "Path is not a directory", Path: JoinArchivePath(a.path, innerPath)}
+	}
+
+	prefix := ""
+	if innerPath != "" {
+		prefix = innerPath + "/"
+	}
+
+	var entries []FileEntry
+	for _, name := range a.names {
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		rel := strings.TrimPrefix(name, prefix)
+		if !recursive && strings.Contains(rel, "/") {
+			continue
+		}
+
+		entry := a.entries[name]
+		if skipArchiveEntry(rel, entry.isDir, filter, includeHidden) {
+			continue
+		}
+
+		entries = append(entries, FileEntry{
+			Path:     JoinArchivePath(a.path, name),
+			Name:     pathpkg.Base(name),
+			Metadata: a.metadata(entry),
+		})
+	}
+
+	return entries, nil
+}
+
+// skipArchiveEntry applies the hidden, ignore and exclude filters to every
+// component of rel, and the remaining path filters to the entry itself
+func skipArchiveEntry(rel string, isDir bool, filter *PathFilter, includeHidden bool) bool {
+	parts := strings.Split(rel, "/")
+	for i, part := range parts {
+		if !includeHidden && strings.HasPrefix(part, ".") {
+			return true
+		}
+		for _, pattern := range DefaultIgnorePatterns {
+			if matched, _ := doublestar.Match(pattern, part); matched {
+				return true
+			}
+		}
+		if (i < len(parts)-1 || isDir) && filter.SkipDir(strings.Join(parts[:i+1], "/")) {
+			return true
+		}
+	}
+
+	if isDir {
+		return !filter.ListDir(rel)
+	}
+	return !filter.MatchFile(rel)
+}
+
+// ReadRaw returns the uncompressed bytes of a file inside the archive
+func (a *Archive) ReadRaw(innerPath string, maxSize int64) ([]byte, error) {
+	var data []byte
+	err := a.eachFile([]string{innerPath}, maxSize, func(_ string, raw []byte, err error) error {
+		data = raw
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// eachFile calls fn with the uncompressed bytes of each inner path, or with
+// the error that prevented reading it. Tar archives are streamed once, so fn
+// is called in archive order; zip entries are visited in the order given.
+// Archive-wide limit violations stop the iteration, as does an error from fn.
+func (a *Archive) eachFile(innerPaths []string, maxSize int64, fn func(innerPath string, raw []byte, err error) error) error {
+	wanted := make(map[string]bool)
+	for _, innerPath := range innerPaths {
+		if err := a.checkEntry(innerPath, maxSize); err != nil {
+			if err.Code == ErrArchiveLimit {
+				return err
+			}
+			if err := fn(innerPath, nil, err); err != nil {
+				return err
+			}
+			continue
+		}
+		wanted[innerPath] = true
+	}
+	if len(wanted) == 0 {
+		return nil
+	}
+
+	if a.zip != nil {
+		for _, innerPath := range innerPaths {
+			if !wanted[innerPath] {
+				continue
+			}
+			raw, err := a.readZipEntry(a.entries[innerPath])
+			if err := fn(innerPath, raw, err); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	r, closer, err := a.openTar()
+	if err != nil {
+		return err
+	}
+	defer closer.Close()
+
+	for len(wanted) > 0 {
+		hdr, err := r.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			var fe *FileError
+			if errors.As(err, &fe) {
+				return fe
+			}
+			return &FileError{Code: ErrInvalidPath, Message: fmt.Sprintf("Invalid tar archive: %s", err.Error()), Path: a.path}
+		}
+
+		name := pathpkg.Clean(strings.TrimSuffix(hdr.Name, "/"))
+		if hdr.Typeflag != tar.TypeReg || !wanted[name] {
+			continue
+		}
+		delete(wanted, name)
+
+		raw, err := a.readEntry(a.entries[name], r)
+		if err := fn(name, raw, err); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkEntry validates an inner path against maxSize and the archive limits
+// before it is decompressed
+func (a *Archive) checkEntry(innerPath string, maxSize int64) *FileError {
+	fullPath := JoinArchivePath(a.path, innerPath)
+	entry, ok := a.entries[innerPath]
+	if !ok {
+		return &FileError{Code: ErrFileNotFound, Message: "File not found in archive", Path: fullPath}
+	}
+	if entry.isDir {
+		return &FileError{Code: ErrInvalidPath, Message: "Path is a directory", Path: fullPath}
+	}
+	if maxSize > 0 && entry.size > maxSize {
+		return &FileError{Code: ErrFileTooLarge, Message: fmt.Sprintf("File exceeds max size of %d bytes", maxSize), Path: fullPath}
+	}
+	if entry.size > a.limits.MaxEntrySize {
+		return &FileError{Code: ErrFileTooLarge, Message: fmt.Sprintf("Entry expands to more than %d bytes", a.limits.MaxEntrySize), Path: fullPath}
+	}
+	if entry.size > ratioCheckThreshold && entry.compressed > 0 && entry.size/entry.compressed > a.limits.MaxCompressionRatio {
+		return &FileError{Code: ErrArchiveLimit, Message: fmt.Sprintf("Entry compression ratio exceeds %d:1", a.limits.MaxCompressionRatio), Path: fullPath}
+	}
+	if a.read+entry.size > a.limits.MaxTotalSize {
+		return &FileError{Code: ErrArchiveLimit, Message: fmt.Sprintf("Reading more than %d bytes from archive", a.limits.MaxTotalSize), Path: fullPath}
+	}
+	return nil
+}
+
+func (a *Archive) readZipEntry(entry *archiveEntry) ([]byte, error) {
+	rc, err := entry.zipFile.Open()
+	if err != nil {
+		return nil, &FileError{Code: ErrUnknown, Message: err.Error(), Path: JoinArchivePath(a.path, entry.name)}
+	}
+	defer rc.Close()
+	return a.readEntry(entry, rc)
+}
+
+// readEntry reads an entry's data, never trusting more than its declared size
+func (a *Archive) readEntry(entry *archiveEntry, r io.Reader) ([]byte, error) {
+	fullPath := JoinArchivePath(a.path, entry.name)
+	data, err := io.ReadAll(io.LimitReader(r, entry.size+1))
+	if err != nil {
+		var fe *FileError
+		if errors.As(err, &fe) {
+			return nil, fe
+		}
+		return nil, &FileError{Code: ErrUnknown, Message: err.Error(), Path: fullPath}
+	}
+	if int64(len(data)) > entry.size {
+		return nil, &FileError{Code: ErrArchiveLimit, Message: "Entry is larger than its declared size", Path: fullPath}
+	}
+
+	a.read += int64(len(data))
+	return data, nil
+}
+
+// ReadFile reads a text file inside the archive, extracting documents and
+// rejecting binary files like ReadFile
+func (a *Archive) ReadFile(innerPath string, maxSize int64) (*FileContent, error) {
+	raw, err := a.ReadRaw(innerPath, maxSize)
+	if err != nil {
+		return nil, err
+	}
+	return a.decode(innerPath, raw)
+}
+
+// decode converts the raw bytes of an entry to a FileContent
+func (a *Archive) decode(innerPath string, raw []byte) (*FileContent, error) {
+	metadata := a.metadata(a.entries[innerPath])
+	return decodeFileContent(JoinArchivePath(a.path, innerPath), raw, &metadata, EncodingAuto)
+}
+
+// limitedReader fails with err once more than remaining bytes are read
+type limitedReader struct {
+	r         io.Reader
+	remaining int64
+	err       error
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if l.remaining < 0 {
+		return 0, l.err
+	}
+	if int64(len(p)) > l.remaining+1 {
+		p = p[:l.remaining+1]
+	}
+	n, err := l.r.Read(p)
+	l.remaining -= int64(n)
+	if l.remaining < 0 {
+		return n, l.err
+	}
+	return n, err
+}
+
+// openArchivePath opens the archive addressed by path, which is either an
+// archive file or a path inside one, and returns the inner path
+func openArchivePath(path string) (*Archive, string, error) {
+	archivePath, innerPath, ok := SplitArchivePath(path)
+	if !ok {
+		archivePath, innerPath = path, ""
+	}
+	archive, err := OpenArchive(archivePath, DefaultArchiveLimits)
+	if err != nil {
+		return nil, "", err
+	}
+	return archive, innerPath, nil
+}
+
+// ListArchive lists files inside an archive, where path is an archive file
+// or a directory inside one ("archive.zip!/src")
+func ListArchive(path string, recursive bool, fileTypes []string, includeHidden bool, opts WalkOptions) ([]FileEntry, error) {
+	archive, innerPath, err := openArchivePath(path)
+	if err != nil {
+		return nil, err
+	}
+	defer archive.Close()
+
+	return archive.List(innerPath, recursive, fileTypes, includeHidden, opts)
+}
+
+// StatArchive returns the metadata of a path inside an archive
+func StatArchive(path string) (*FileMetadata, error) {
+	archive, innerPath, err := openArchivePath(path)
+	if err != nil {
+		return nil, err
+	}
+	defer archive.Close()
+
+	return archive.Stat(innerPath)
+}
+
+// ReadArchiveFile reads a text file inside an archive ("archive.zip!/README.md")
+func ReadArchiveFile(path string, maxSize int64) (*FileContent, error) {
+	archive, innerPath, err := openArchivePath(path)
+	if err != nil {
+		return nil, err
+	}
+	defer archive.Close()
+
+	return archive.ReadFile(innerPath, maxSize)
+}
+
+// ReadArchiveDirectory reads all text files under a directory inside an archive
+func ReadArchiveDirectory(path string, recursive bool, fileTypes []string, maxSize int64, opts WalkOptions) (map[string]*FileContent, error) {
+	archive, innerPath, err := openArchivePath(path)
+	if err != nil {
+		return nil, err
+	}
+	defer archive.Close()
+
+	entries, err := archive.List(innerPath, recursive, fileTypes, false, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	contents := make(map[string]*FileContent)
+	err = archive.eachFile(archiveFiles(entries, false), maxSize, func(name string, raw []byte, err error) error {
+		if err != nil {
+			return archiveLimitError(err) // Skip files that can't be read
+		}
+		if content, err := archive.decode(name, raw); err == nil {
+			contents[content.Path] = content
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return contents, nil
+}
+
+// SearchArchive searches for a pattern in the text files inside an archive,
+// with the same matching and output modes as SearchFilesWithOptions
+func SearchArchive(path string, pattern string, recursive bool, fileTypes []string, contextLines int, maxResults int, opts SearchOptions) (*SearchResult, error) {
+	re, err := opts.Compile(pattern, path)
+	if err != nil {
+		return nil, err
+	}
+
+	archive, innerPath, err := openArchivePath(path)
+	if err != nil {
+		return nil, err
+	}
+	defer archive.Close()
+
+	entries, err := archive.List(innerPath, recursive, fileTypes, false, opts.Walk)
+	if err != nil {
+		return nil, err
+	}
+
+	s := newSearcher(re, contextLines, maxResults, opts)
+	c := &searchCollector{s: s}
+	err = archive.eachFile(archiveFiles(entries, true), 0, func(name string, raw []byte, err error) error {
+		if err != nil {
+			return archiveLimitError(err) // Skip files that can't be read
+		}
+		content, err := archive.decode(name, raw)
+		if err != nil {
+			return nil // Skip binary files
+		}
+
+		if c.add(s.searchText(content.Path, content.Content)) {
+			return errStopIteration
+		}
+		return nil
+	})
+	if err != nil && err != errStopIteration {
+		return nil, err
+	}
+
+	return c.result(), nil
+}
+
+// errStopIteration ends an eachFile iteration early without error
+var errStopIteration = errors.New("stop iteration")
+
+// archiveFiles returns the inner paths of the files among entries, skipping
+// binary types without a text extractor when searching
+func archiveFiles(entries []FileEntry, textOnly bool) []string {
+	var names []string
+	for _, entry := range entries {
+		if entry.Metadata.IsDirectory {
+			continue
+		}
+		if textOnly && IsBinaryMimeType(entry.Metadata.MimeType) && !extract.Supported(entry.Metadata.MimeType) {
+			continue
+		}
+		_, innerPath, _ := SplitArchivePath(entry.Path)
+		names = append(names, innerPath)
+	}
+	return names
+}
+
+// archiveLimitError passes through archive limit violations, which abort a
+// multi-file operation; other per-file errors are dropped
+func archiveLimitError(err error) error {
+	var fe *FileError
+	if errors.As(err, &fe) && fe.Code == ErrArchiveLimit {
+		return fe
+	}
+	return nil
+}
@@ -0,0 +1,216 @@
+package files
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+var archiveFixture = map[string]string{
+	"README.md":        "# Release\n",
+	"src/main.go":      "package main\n\nfunc main() {}\n",
+	"src/util/util.go": "package util\n\n// TODO: tidy\n",
+	".hidden/secret":   "hidden\n",
+	"../escape.txt":    "outside\n",
+}
+
+func writeZipFixture(t *testing.T, path string, entries map[string]string) {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	w := zip.NewWriter(f)
+	for name, content := range entries {
+		fw, err := w.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fw.Write([]byte(content))
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func writeTarGzFixture(t *testing.T, path string, entries map[string]string) {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for name, content := range entries {
+		tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg})
+		tw.Write([]byte(content))
+	}
+	tw.Close()
+	gz.Close()
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSplitArchivePath(t *testing.T) {
+	tests := []struct {
+		path    string
+		archive string
+		inner   string
+		ok      bool
+	}{
+		{"/data/release.zip!/src/main.go", "/data/release.zip", "src/main.go", true},
+		{"/data/release.zip!/", "/data/release.zip", "", true},
+		{"/data/release.zip!", "/data/release.zip", "", true},
+		{"/data/lib.JAR!/META-INF/../a", "/data/lib.JAR", "a", true},
+		{"/data/release.tar.gz!/x", "/data/release.tar.gz", "x", true},
+		{"/data/release.zip", "", "", false},
+		{"/data/wow!/file.txt", "", "", false},
+		{"/data/a!b.zip!/c", "/data/a!b.zip", "c", true},
+		{"/data/release.zip!/../../etc/passwd", "/data/release.zip", "etc/passwd", true},
+	}
+
+	for _, tt := range tests {
+		archive, inner, ok := SplitArchivePath(tt.path)
+		if ok != tt.ok || archive != tt.archive || inner != tt.inner {
+			t.Errorf("SplitArchivePath(%q) = %q, %q, %v; want %q, %q, %v", tt.path, archive, inner, ok, tt.archive, tt.inner, tt.ok)
+		}
+	}
+}
+
+func TestListArchive(t *testing.T) {
+	tmpDir := t.TempDir()
+	zipPath := filepath.Join(tmpDir, "release.zip")
+	tgzPath := filepath.Join(tmpDir, "release.tgz")
+	writeZipFixture(t, zipPath, archiveFixture)
+	writeTarGzFixture(t, tgzPath, archiveFixture)
+
+	for _, archivePath := range []string{zipPath, tgzPath} {
+		if !IsArchivePath(archivePath) {
+			t.Errorf("IsArchivePath(%q) = false", archivePath)
+		}
+
+		entries, err := ListArchive(archivePath, true, nil, false, WalkOptions{})
+		if err != nil {
+			t.Fatalf("ListArchive(%q) error: %v", archivePath, err)
+		}
+
+		var names []string
+		for _, e := range entries {
+			_, inner, _ := SplitArchivePath(e.Path)
+			names = append(names, inner)
+		}
+		sort.Strings(names)
+		want := "README.md,src,src/main.go,src/util,src/util/util.go"
+		if got := strings.Join(names, ","); got != want {
+			t.Errorf("ListArchive(%q) = %s, want %s", archivePath, got, want)
+		}
+
+		// Non-recursive listing of an inner directory
+		entries, err = ListArchive(archivePath+"!/src", false, nil, false, WalkOptions{})
+		if err != nil {
+			t.Fatalf("ListArchive inner error: %v", err)
+		}
+		if len(entries) != 2 {
+			t.Errorf("expected 2 entries in src, got %d", len(entries))
+		}
+
+		// File type filter applies to files; directories are still listed
+		entries, _ = ListArchive(archivePath, true, []string{"md"}, false, WalkOptions{})
+		var matched []string
+		for _, e := range entries {
+			if !e.Metadata.IsDirectory {
+				matched = append(matched, e.Name)
+			}
+		}
+		if len(matched) != 1 || matched[0] != "README.md" {
+			t.Errorf("expected only README.md, got %v", matched)
+		}
+
+		if _, err := ListArchive(archivePath+"!/missing", true, nil, false, WalkOptions{}); err == nil {
+			t.Error("expected error for missing inner directory")
+		}
+	}
+}
+
+func TestReadArchiveFile(t *testing.T) {
+	tmpDir := t.TempDir()
+	zipPath := filepath.Join(tmpDir, "release.zip")
+	tgzPath := filepath.Join(tmpDir, "release.tar.gz")
+	writeZipFixture(t, zipPath, archiveFixture)
+	writeTarGzFixture(t, tgzPath, archiveFixture)
+
+	for _, archivePath := range []string{zipPath, tgzPath} {
+		content, err := ReadArchiveFile(archivePath+"!/src/main.go", 0)
+		if err != nil {
+			t.Fatalf("ReadArchiveFile error: %v", err)
+		}
+		if content.Content != archiveFixture["src/main.go"] {
+			t.Errorf("unexpected content %q", content.Content)
+		}
+		if content.Path != filepath.ToSlash(archivePath)+"!/src/main.go" {
+			t.Errorf("unexpected path %q", content.Path)
+		}
+
+		_, err = ReadArchiveFile(archivePath+"!/src/main.go", 5)
+		if fe, ok := err.(*FileError); !ok || fe.Code != ErrFileTooLarge {
+			t.Errorf("expected FILE_TOO_LARGE, got %v", err)
+		}
+
+		contents, err := ReadArchiveDirectory(archivePath+"!/src", true, nil, 0, WalkOptions{})
+		if err != nil {
+			t.Fatalf("ReadArchiveDirectory error: %v", err)
+		}
+		if len(contents) != 2 {
+			t.Errorf("expected 2 files, got %d", len(contents))
+		}
+
+		result, err := SearchArchive(archivePath, "TODO", true, nil, 1, 0, SearchOptions{})
+		if err != nil {
+			t.Fatalf("SearchArchive error: %v", err)
+		}
+		if result.Total != 1 || !strings.HasSuffix(result.Matches[0].Path, "!/src/util/util.go") || result.Matches[0].Line != 3 {
+			t.Errorf("unexpected search result %+v", result)
+		}
+	}
+}
+
+func TestArchiveLimits(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	// A highly compressible entry trips the compression ratio check
+	bomb := filepath.Join(tmpDir, "bomb.zip")
+	writeZipFixture(t, bomb, map[string]string{"zeros.txt": strings.Repeat("0", 4*1024*1024)})
+
+	_, err := ReadArchiveFile(bomb+"!/zeros.txt", 0)
+	if fe, ok := err.(*FileError); !ok || fe.Code != ErrArchiveLimit {
+		t.Errorf("expected ARCHIVE_LIMIT_EXCEEDED, got %v", err)
+	}
+
+	// Total expanded size is enforced for streamed tar archives
+	tgz := filepath.Join(tmpDir, "big.tgz")
+	writeTarGzFixture(t, tgz, map[string]string{"a.txt": strings.Repeat("a", 64*1024)})
+
+	archive := &Archive{path: tgz, entries: make(map[string]*archiveEntry), limits: ArchiveLimits{MaxEntries: 10, MaxEntrySize: 1 << 20, MaxTotalSize: 1024, MaxCompressionRatio: 200}}
+	err = archive.indexTar()
+	if fe, ok := err.(*FileError); !ok || fe.Code != ErrArchiveLimit {
+		t.Errorf("expected ARCHIVE_LIMIT_EXCEEDED, got %v", err)
+	}
+
+	// Entry count
+	many := map[string]string{}
+	for i := 0; i < 5; i++ {
+		many[string(rune('a'+i))+".txt"] = "x"
+	}
+	manyPath := filepath.Join(tmpDir, "many.zip")
+	writeZipFixture(t, manyPath, many)
+	_, err = OpenArchive(manyPath, ArchiveLimits{MaxEntries: 3, MaxEntrySize: 1 << 20, MaxTotalSize: 1 << 20, MaxCompressionRatio: 200})
+	if fe, ok := err.(*FileError); !ok || fe.Code != ErrArchiveLimit {
+		t.Errorf("expected ARCHIVE_LIMIT_EXCEEDED, got %v", err)
+	}
+}
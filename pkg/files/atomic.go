@@ -0,0 +1,103 @@
+package files
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// DefaultFileMode is the permission of files created without an explicit mode
+const DefaultFileMode fs.FileMode = 0644
+
+// maxSymlinkHops bounds the symlink chain followed to find a write target
+const maxSymlinkHops = 40
+
+// writeFileAtomic replaces the content of path with data so that a crash
+// leaves either the old or the new file, never a truncated one. The data is
+// written to a temporary file in the same directory, synced and renamed over
+// the target. An existing file keeps its permissions and, where allowed, its
+// owner; new files get mode. If path is a symlink, the file it points to is
+// replaced and the link is left alone.
+func writeFileAtomic(path string, data []byte, mode fs.FileMode) error {
+	target, err := resolveSymlinks(path)
+	if err != nil {
+		return err
+	}
+
+	info, err := os.Stat(target)
+	switch {
+	case err == nil:
+		if !info.Mode().IsRegular() {
+			return fmt.Errorf("%s is not a regular file", target)
+		}
+		// Renaming over a file needs no permission on the file itself, so
+		// check it to keep read-only files read-only
+		f, err := os.OpenFile(target, os.O_WRONLY, 0)
+		if err != nil {
+			return err
+		}
+		f.Close()
+		mode = info.Mode() & (fs.ModePerm | fs.ModeSetuid | fs.ModeSetgid | fs.ModeSticky)
+	case !os.IsNotExist(err):
+		return err
+	}
+
+	dir := filepath.Dir(target)
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(target)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if err == nil {
+		err = tmp.Chmod(mode)
+	}
+	if err == nil && info != nil {
+		chown(tmp, info)
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), target); err != nil {
+		return err
+	}
+	syncDir(dir)
+	return nil
+}
+
+// resolveSymlinks follows path through any chain of symlinks to the file it
+// names, which need not exist yet
+func resolveSymlinks(path string) (string, error) {
+	for i := 0; i < maxSymlinkHops; i++ {
+		info, err := os.Lstat(path)
+		if err != nil || info.Mode()&fs.ModeSymlink == 0 {
+			return path, nil
+		}
+		link, err := os.Readlink(path)
+		if err != nil {
+			return "", err
+		}
+		if !filepath.IsAbs(link) {
+			link = filepath.Join(filepath.Dir(path), link)
+		}
+		path = link
+	}
+	return "", fmt.Errorf("too many levels of symbolic links: %s", path)
+}
+
+// syncDir flushes a directory entry change such as a rename to disk. Not all
+// platforms can sync a directory, so failures are ignored.
+func syncDir(dir string) {
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+}
@@ -0,0 +1,78 @@
+package files
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestWriteFileAtomic(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Unix permissions and symlinks")
+	}
+	dir := t.TempDir()
+
+	script := filepath.Join(dir, "run.sh")
+	if err := os.WriteFile(script, []byte("#!/bin/sh\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	secret := filepath.Join(dir, "secret.txt")
+	if err := os.WriteFile(secret, []byte("a\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := WriteFile(script, "#!/bin/sh\necho hi\n"); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	if _, err := ModifyFile(secret, "a", "b", false, false); err != nil {
+		t.Fatalf("ModifyFile() error = %v", err)
+	}
+	for path, want := range map[string]fs.FileMode{script: 0755, secret: 0600} {
+		if info, _ := os.Stat(path); info.Mode().Perm() != want {
+			t.Errorf("%s mode = %v, want %v", filepath.Base(path), info.Mode().Perm(), want)
+		}
+	}
+
+	// Writing through a symlink replaces its target and keeps the link
+	link := filepath.Join(dir, "link.sh")
+	if err := os.Symlink("run.sh", link); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := WriteFile(link, "#!/bin/sh\necho bye\n"); err != nil {
+		t.Fatalf("WriteFile() through symlink error = %v", err)
+	}
+	if info, _ := os.Lstat(link); info.Mode()&fs.ModeSymlink == 0 {
+		t.Errorf("WriteFile() replaced the symlink with a file")
+	}
+	if data, _ := os.ReadFile(script); string(data) != "#!/bin/sh\necho bye\n" {
+		t.Errorf("symlink target = %q", data)
+	}
+
+	created := filepath.Join(dir, "new", "tool.sh")
+	if _, err := WriteFileWithOptions(created, "x\n", WriteOptions{Mode: 0750}); err != nil {
+		t.Fatalf("WriteFileWithOptions() error = %v", err)
+	}
+	if info, _ := os.Stat(created); info.Mode().Perm() != 0750 {
+		t.Errorf("new file mode = %v, want 0750", info.Mode().Perm())
+	}
+
+	entries, _ := os.ReadDir(dir)
+	for _, e := range entries {
+		if e.Name()[0] == '.' {
+			t.Errorf("temporary file %s left behind", e.Name())
+		}
+	}
+
+	if os.Geteuid() == 0 {
+		return // root can write read-only files
+	}
+	readOnly := filepath.Join(dir, "readonly.txt")
+	if err := os.WriteFile(readOnly, []byte("keep\n"), 0444); err != nil {
+		t.Fatal(err)
+	}
+	_, err := WriteFile(readOnly, "changed\n")
+	if fe, ok := err.(*FileError); !ok || fe.Code != ErrPermission {
+		t.Errorf("WriteFile() on read-only file error = %v, want permission error", err)
+	}
+}
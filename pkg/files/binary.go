@@ -0,0 +1,149 @@
+package files
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// BinaryContent represents the raw content of a binary file
+type BinaryContent struct {
+	Data     []byte       `json:"-"`
+	Base64   string       `json:"data,omitempty"`
+	Metadata FileMetadata `json:"metadata"`
+	Path     string       `json:"path"`
+	IsBinary bool         `json:"isBinary"`
+}
+
+// binaryMimeTypes are non-prefix MIME types that are always treated as binary
+var binaryMimeTypes = map[string]bool{
+	"application/zip":              true,
+	"application/gzip":             true,
+	"application/x-gzip":           true,
+	"application/x-tar":            true,
+	"application/x-bzip2":          true,
+	"application/x-xz":             true,
+	"application/x-7z-compressed":  true,
+	"application/x-rar-compressed": true,
+	"application/java-archive":     true,
+	"application/pdf":              true,
+	"application/wasm":             true,
+	"application/x-executable":     true,
+	"application/x-sharedlib":      true,
+	"application/vnd.ms-excel":     true,
+	"application/msword":           true,
+	"application/x-sqlite3":        true,
+}
+
+// IsBinaryMimeType reports whether a MIME type always denotes binary content.
+// application/octet-stream is not included because it is also the fallback
+// for unknown extensions, many of which are text.
+func IsBinaryMimeType(mimeType string) bool {
+	mimeType = strings.ToLower(strings.TrimSpace(strings.SplitN(mimeType, ";", 2)[0]))
+	switch {
+	case mimeType == "image/svg+xml":
+		return false
+	case strings.HasPrefix(mimeType, "image/"),
+		strings.HasPrefix(mimeType, "audio/"),
+		strings.HasPrefix(mimeType, "video/"),
+		strings.HasPrefix(mimeType, "font/"),
+		strings.HasPrefix(mimeType, "application/vnd.openxmlformats-"),
+		strings.HasPrefix(mimeType, "application/vnd.oasis.opendocument."):
+		return true
+	}
+	return binaryMimeTypes[mimeType]
+}
+
+// IsBinaryContent sniffs the start of data for binary content: NUL bytes
+// (outside UTF-16 text) or a high proportion of control characters
+func IsBinaryContent(data []byte) bool {
+	sample := data
+	if len(sample) > sniffLen {
+		sample = sample[:sniffLen]
+	}
+	if len(sample) == 0 {
+		return false
+	}
+
+	switch DetectEncoding(sample).Encoding {
+	case EncodingUTF16LE, EncodingUTF16BE:
+		return false
+	}
+
+	if bytes.IndexByte(sample, 0) >= 0 {
+		return true
+	}
+
+	control := 0
+	for _, b := range sample {
+		if b < 0x20 && b != '\t' && b != '\n' && b != '\r' && b != '\f' && b != '\b' && b != 0x1B {
+			control++
+		}
+	}
+	return control*10 > len(sample)
+}
+
+// IsBinaryFile reports whether the file at path is binary, judged by its
+// MIME type and by sniffing its first bytes
+func IsBinaryFile(path string) (bool, error) {
+	if IsBinaryMimeType(GetMimeType(path)) {
+		return true, nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	buf := make([]byte, sniffLen)
+	n, err := io.ReadFull(file, buf)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return false, err
+	}
+
+	return IsBinaryContent(buf[:n]), nil
+}
+
+// ReadBinaryFile reads the raw bytes of a file with optional size limit
+func ReadBinaryFile(path string, maxSize int64) (*BinaryContent, error) {
+	metadata, err := GetFileMetadata(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if metadata.IsDirectory {
+		return nil, &FileError{Code: ErrInvalidPath, Message: "Path is a directory", Path: path}
+	}
+
+	if maxSize > 0 && metadata.Size > maxSize {
+		return nil, &FileError{Code: ErrFileTooLarge, Message: fmt.Sprintf("File exceeds max size of %d bytes", maxSize), Path: path}
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, &FileError{Code: ErrUnknown, Message: err.Error(), Path: path}
+	}
+
+	return &BinaryContent{
+		Data:     data,
+		Metadata: *metadata,
+		Path:     path,
+		IsBinary: true,
+	}, nil
+}
+
+// EncodeBase64 fills the Base64 field so the content can be marshaled as JSON
+func (b *BinaryContent) EncodeBase64() *BinaryContent {
+	b.Base64 = base64.StdEncoding.EncodeToString(b.Data)
+	return b
+}
+
+// HexDump returns a hexdump -C style rendering of the content
+func (b *BinaryContent) HexDump() string {
+	return hex.Dump(b.Data)
+}
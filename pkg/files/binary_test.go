@@ -0,0 +1,135 @@
+package files
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestIsBinaryContent(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     []byte
+		expected bool
+	}{
+		{"empty", []byte{}, false},
+		{"text", []byte("package main\n\nfunc main() {}\n"), false},
+		{"nul byte", []byte("abc\x00def"), true},
+		{"png header", []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR"), true},
+		{"utf16 text", []byte{0xFF, 0xFE, 'h', 0, 'i', 0}, false},
+		{"control characters", []byte("\x01\x02\x03\x04\x05abc"), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsBinaryContent(tt.data); got != tt.expected {
+				t.Errorf("IsBinaryContent = %v, want %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestIsBinaryMimeType(t *testing.T) {
+	tests := []struct {
+		mimeType string
+		expected bool
+	}{
+		{"image/png", true},
+		{"image/svg+xml", false},
+		{"application/pdf", true},
+		{"application/zip", true},
+		{"application/octet-stream", false},
+		{"text/plain; charset=utf-8", false},
+		{"application/vnd.openxmlformats-officedocument.wordprocessingml.document", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.mimeType, func(t *testing.T) {
+			if got := IsBinaryMimeType(tt.mimeType); got != tt.expected {
+				t.Errorf("IsBinaryMimeType(%s) = %v, want %v", tt.mimeType, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestBinaryFilesSkipped(t *testing.T) {
+	tmpDir := t.TempDir()
+	os.WriteFile(filepath.Join(tmpDir, "text.txt"), []byte("needle in text"), 0644)
+	os.WriteFile(filepath.Join(tmpDir, "blob.dat"), []byte("needle\x00\x01\x02"), 0644)
+
+	// ReadFile refuses binary content
+	_, err := ReadFile(filepath.Join(tmpDir, "blob.dat"), 0)
+	fileErr, ok := err.(*FileError)
+	if !ok || fileErr.Code != ErrBinaryFile {
+		t.Errorf("Expected ErrBinaryFile, got %v", err)
+	}
+
+	// ReadBinaryFile returns the raw bytes
+	binary, err := ReadBinaryFile(filepath.Join(tmpDir, "blob.dat"), 0)
+	if err != nil {
+		t.Fatalf("ReadBinaryFile failed: %v", err)
+	}
+	if binary.EncodeBase64().Base64 != "bmVlZGxlAAEC" {
+		t.Errorf("Base64 = %q", binary.Base64)
+	}
+	if !strings.Contains(binary.HexDump(), "6e 65 65 64") {
+		t.Errorf("HexDump = %q", binary.HexDump())
+	}
+
+	// Directory reads skip binaries
+	contents, err := ReadDirectory(tmpDir, true, nil, 0)
+	if err != nil {
+		t.Fatalf("ReadDirectory failed: %v", err)
+	}
+	if len(contents) != 1 {
+		t.Errorf("Expected 1 text file, got %d", len(contents))
+	}
+
+	// Searches skip binaries
+	results, err := SearchFiles(tmpDir, "needle", true, nil, 0, 100)
+	if err != nil {
+		t.Fatalf("SearchFiles failed: %v", err)
+	}
+	if results.Total != 1 || !strings.HasSuffix(results.Matches[0].Path, "text.txt") {
+		t.Errorf("Expected a single match in text.txt, got %+v", results.Matches)
+	}
+}
+
+func TestExtractedDocuments(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	notebook := `{"cells": [{"cell_type": "markdown", "source": "Quarterly findings"}, {"cell_type": "code", "source": "total = 42", "outputs": []}]}`
+	nbPath := filepath.Join(tmpDir, "report.ipynb")
+	if err := os.WriteFile(nbPath, []byte(notebook), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := ReadFile(nbPath, 0)
+	if err != nil {
+		t.Fatalf("ReadFile() error: %v", err)
+	}
+	if content.ExtractedFrom != "application/x-ipynb+json" {
+		t.Errorf("ExtractedFrom = %q", content.ExtractedFrom)
+	}
+	if !strings.Contains(content.Content, "Quarterly findings") || strings.Contains(content.Content, "cell_type") {
+		t.Errorf("expected extracted text, got %q", content.Content)
+	}
+
+	// Documents that fail extraction are reported as binary
+	if err := os.WriteFile(filepath.Join(tmpDir, "broken.docx"), []byte("PK\x03\x04garbage"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, err = ReadFile(filepath.Join(tmpDir, "broken.docx"), 0)
+	if fe, ok := err.(*FileError); !ok || fe.Code != ErrBinaryFile {
+		t.Errorf("expected BINARY_FILE error, got %v", err)
+	}
+
+	result, err := SearchFiles(tmpDir, "total = \\d+", true, nil, 0, 0)
+	if err != nil {
+		t.Fatalf("SearchFiles() error: %v", err)
+	}
+	if result.Total != 1 || !strings.HasSuffix(result.Matches[0].Path, "report.ipynb") {
+		t.Errorf("expected match in notebook, got %+v", result.Matches)
+	}
+}
@@ -0,0 +1,181 @@
+package files
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/JeremyProffitt/go-mcp-file-context-server/pkg/tokens"
+)
+
+// summaryLines is the number of leading non-empty lines kept when a file is summarized
+const summaryLines = 5
+
+// minTruncateTokens is the smallest budget worth spending on a truncated file;
+// below this a file is summarized instead
+const minTruncateTokens = 64
+
+// TokenBudgetReport describes how a token budget was spent across files
+type TokenBudgetReport struct {
+	MaxTokens  int      `json:"maxTokens"`
+	UsedTokens int      `json:"usedTokens"`
+	Tokenizer  string   `json:"tokenizer"`
+	Included   []string `json:"included"`
+	Truncated  []string `json:"truncated,omitempty"`
+	Summarized []string `json:"summarized,omitempty"`
+	Omitted    []string `json:"omitted,omitempty"`
+}
+
+// CountTokens sets TokenCount on each file content and returns the total
+func CountTokens(contents map[string]*FileContent, est tokens.Estimator) int {
+	total := 0
+	for _, content := range contents {
+		content.TokenCount = est.Count(content.Content)
+		total += content.TokenCount
+	}
+	return total
+}
+
+// PrioritizeFiles orders file paths by how useful they are as context:
+// READMEs and docs first, then manifests and entry points, then source
+// files, shallower paths before deeper ones and smaller files before larger.
+func PrioritizeFiles(contents map[string]*FileContent) []string {
+	paths := make([]string, 0, len(contents))
+	for path := range contents {
+		paths = append(paths, path)
+	}
+
+	sort.Slice(paths, func(i, j int) bool {
+		a, b := paths[i], paths[j]
+		if ra, rb := filePriority(a), filePriority(b); ra != rb {
+			return ra < rb
+		}
+		if da, db := strings.Count(a, "/"), strings.Count(b, "/"); da != db {
+			return da < db
+		}
+		if sa, sb := contents[a].Metadata.Size, contents[b].Metadata.Size; sa != sb {
+			return sa < sb
+		}
+		return a < b
+	})
+
+	return paths
+}
+
+// filePriority ranks a file for budgeting (lower is more important)
+func filePriority(path string) int {
+	name := strings.ToLower(filepath.Base(path))
+	stem := strings.TrimSuffix(name, filepath.Ext(name))
+
+	switch {
+	case stem == "readme" || stem == "overview" || stem == "architecture":
+		return 0
+	case name == "go.mod" || name == "package.json" || name == "cargo.toml" ||
+		name == "pyproject.toml" || name == "pom.xml" || name == "build.gradle":
+		return 1
+	case stem == "main" || stem == "index" || stem == "app" || stem == "lib" || stem == "mod":
+		return 1
+	case strings.HasPrefix(GetMimeType(path), "text/") || strings.HasSuffix(name, ".json"):
+		return 2
+	default:
+		return 3
+	}
+}
+
+// ApplyTokenBudget fits file contents into maxTokens. Files are taken in the
+// given order (or PrioritizeFiles order when order is nil); files that do not
+// fit are truncated, then summarized, and dropped from contents only when not
+// even a summary fits.
+func ApplyTokenBudget(contents map[string]*FileContent, order []string, maxTokens int, est tokens.Estimator) *TokenBudgetReport {
+	CountTokens(contents, est)
+	if order == nil {
+		order = PrioritizeFiles(contents)
+	}
+
+	report := &TokenBudgetReport{
+		MaxTokens: maxTokens,
+		Tokenizer: est.Name(),
+		Included:  []string{},
+	}
+
+	// Pre-compute summaries so truncation can leave room for the files after it
+	summaries := make(map[string]string, len(order))
+	summaryCost := make(map[string]int, len(order))
+	for _, path := range order {
+		if content, ok := contents[path]; ok {
+			summaries[path] = summarize(content, est)
+			summaryCost[path] = est.Count(summaries[path])
+		}
+	}
+
+	remaining := maxTokens
+	for i, path := range order {
+		content, ok := contents[path]
+		if !ok {
+			continue
+		}
+
+		if content.TokenCount <= remaining {
+			remaining -= content.TokenCount
+			report.Included = append(report.Included, path)
+			continue
+		}
+
+		reserve := 0
+		for _, later := range order[i+1:] {
+			reserve += summaryCost[later]
+		}
+		if reserve > remaining/2 {
+			reserve = remaining / 2
+		}
+
+		if available := remaining - reserve; available >= minTruncateTokens {
+			truncated, _ := tokens.Truncate(content.Content, available, est)
+			content.Content = truncated
+			content.Truncated = true
+			content.TokenCount = est.Count(truncated)
+			remaining -= content.TokenCount
+			report.Truncated = append(report.Truncated, path)
+			continue
+		}
+
+		if summaryCost[path] <= remaining {
+			content.Content = summaries[path]
+			content.Summarized = true
+			content.Truncated = true
+			content.TokenCount = summaryCost[path]
+			remaining -= content.TokenCount
+			report.Summarized = append(report.Summarized, path)
+			continue
+		}
+
+		delete(contents, path)
+		report.Omitted = append(report.Omitted, path)
+	}
+
+	report.UsedTokens = maxTokens - remaining
+	return report
+}
+
+// summarize returns the first few non-empty lines of a file followed by a
+// note describing how much of the rest was left out
+func summarize(content *FileContent, est tokens.Estimator) string {
+	var kept []string
+	lines := strings.Split(strings.TrimSuffix(content.Content, "\n"), "\n")
+	end := 0 // lines up to the last one kept
+	for i, line := range lines {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		kept = append(kept, line)
+		end = i + 1
+		if len(kept) == summaryLines {
+			break
+		}
+	}
+
+	omitted := strings.Join(lines[end:], "\n")
+	return fmt.Sprintf("%s\n... [summarized: %d lines, ~%d tokens omitted]",
+		strings.Join(kept, "\n"), len(lines)-end, est.Count(omitted))
+}
@@ -0,0 +1,94 @@
+package files
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/JeremyProffitt/go-mcp-file-context-server/pkg/tokens"
+)
+
+func newTestContent(path, content string) *FileContent {
+	return &FileContent{
+		Content:    content,
+		Path:       path,
+		TotalLines: strings.Count(content, "\n") + 1,
+		Metadata:   FileMetadata{Size: int64(len(content)), MimeType: GetMimeType(path)},
+	}
+}
+
+func TestPrioritizeFiles(t *testing.T) {
+	contents := map[string]*FileContent{
+		"/p/pkg/deep/util.go": newTestContent("/p/pkg/deep/util.go", "x"),
+		"/p/image.png":        newTestContent("/p/image.png", "x"),
+		"/p/README.md":        newTestContent("/p/README.md", "x"),
+		"/p/main.go":          newTestContent("/p/main.go", "x"),
+		"/p/helper.go":        newTestContent("/p/helper.go", "x"),
+	}
+
+	order := PrioritizeFiles(contents)
+	expected := []string{"/p/README.md", "/p/main.go", "/p/helper.go", "/p/pkg/deep/util.go", "/p/image.png"}
+	if strings.Join(order, ",") != strings.Join(expected, ",") {
+		t.Errorf("PrioritizeFiles = %v, want %v", order, expected)
+	}
+}
+
+func TestApplyTokenBudget(t *testing.T) {
+	est := &tokens.ByteEstimator{BytesPerToken: 1}
+	big := strings.Repeat("x\n", 300) // 600 tokens, short summary
+
+	contents := map[string]*FileContent{
+		"/p/README.md": newTestContent("/p/README.md", "readme\n"),
+		"/p/a.go":      newTestContent("/p/a.go", big),
+		"/p/b.go":      newTestContent("/p/b.go", big+"more\n"),
+	}
+
+	report := ApplyTokenBudget(contents, nil, 160, est)
+
+	if report.UsedTokens > 160 {
+		t.Errorf("UsedTokens = %d, exceeds budget of 160", report.UsedTokens)
+	}
+	if len(report.Included) != 1 || report.Included[0] != "/p/README.md" {
+		t.Errorf("Included = %v, want [/p/README.md]", report.Included)
+	}
+	if len(report.Truncated) != 1 || report.Truncated[0] != "/p/a.go" {
+		t.Errorf("Truncated = %v, want [/p/a.go]", report.Truncated)
+	}
+	if len(report.Summarized) != 1 || report.Summarized[0] != "/p/b.go" {
+		t.Errorf("Summarized = %v, want [/p/b.go]", report.Summarized)
+	}
+	if !contents["/p/b.go"].Summarized || !strings.Contains(contents["/p/b.go"].Content, "summarized") {
+		t.Error("Expected b.go to be replaced with a summary")
+	}
+	// The note counts only what the summary leaves out
+	if !strings.HasSuffix(contents["/p/b.go"].Content, "[summarized: 296 lines, ~594 tokens omitted]") {
+		t.Errorf("unexpected summary note in %q", contents["/p/b.go"].Content)
+	}
+	if !contents["/p/a.go"].Truncated {
+		t.Error("Expected a.go to be marked truncated")
+	}
+
+	total := 0
+	for _, c := range contents {
+		total += c.TokenCount
+	}
+	if total != report.UsedTokens {
+		t.Errorf("Sum of TokenCount = %d, want %d", total, report.UsedTokens)
+	}
+}
+
+func TestApplyTokenBudgetOmits(t *testing.T) {
+	est := &tokens.ByteEstimator{BytesPerToken: 1}
+	contents := map[string]*FileContent{
+		"/p/a.txt": newTestContent("/p/a.txt", strings.Repeat("a", 20)),
+		"/p/b.txt": newTestContent("/p/b.txt", strings.Repeat("b", 200)),
+	}
+
+	report := ApplyTokenBudget(contents, []string{"/p/a.txt", "/p/b.txt"}, 25, est)
+
+	if len(report.Omitted) != 1 || report.Omitted[0] != "/p/b.txt" {
+		t.Errorf("Omitted = %v, want [/p/b.txt]", report.Omitted)
+	}
+	if _, ok := contents["/p/b.txt"]; ok {
+		t.Error("Expected omitted file to be removed from contents")
+	}
+}
@@ -0,0 +1,183 @@
+package files
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// fileChange is a pending change to one file: new content to write, or its
+// removal
+type fileChange struct {
+	path   string
+	data   []byte
+	remove bool
+
+	// existed and original describe the file before the change, so that
+	// it can be put back
+	existed  bool
+	original []byte
+	mode     fs.FileMode
+}
+
+// BackupFunc saves a copy of path before it is overwritten or deleted and
+// returns an ID for the copy. A write through a symlink passes the file the
+// link resolves to, since that is what changes; a delete passes the link.
+type BackupFunc func(path string) (id string, err error)
+
+// backupTarget calls backup with the file a write to path replaces: path
+// itself, or the file it resolves to if it is a symlink
+func backupTarget(backup BackupFunc, path string) (string, error) {
+	target, err := resolveSymlinks(path)
+	if err != nil {
+		return "", err
+	}
+	return backup(target)
+}
+
+// ContentCheck inspects the content a write is about to give path and
+// returns an error to refuse it
+type ContentCheck func(path string, data []byte) error
+
+// changeSet is a list of file changes applied all or nothing
+type changeSet struct {
+	changes []fileChange
+
+	// backup, if set, is called with each existing file before anything is
+	// changed; an error cancels the commit. backups maps paths to the IDs
+	// it returned.
+	backup  BackupFunc
+	backups map[string]string
+}
+
+// write adds a change replacing or creating path with data. original is the
+// current content of an existing file.
+func (c *changeSet) write(path string, data []byte, existed bool, original []byte) {
+	c.changes = append(c.changes, fileChange{path: path, data: data, existed: existed, original: original, mode: fileMode(path, DefaultFileMode)})
+}
+
+// remove adds a change deleting path, whose current content is original
+func (c *changeSet) remove(path string, original []byte) {
+	c.changes = append(c.changes, fileChange{path: path, remove: true, existed: true, original: original, mode: fileMode(path, DefaultFileMode)})
+}
+
+// fileMode returns the permissions of path, or def if it can't be read
+func fileMode(path string, def fs.FileMode) fs.FileMode {
+	if info, err := os.Stat(path); err == nil {
+		return info.Mode().Perm()
+	}
+	return def
+}
+
+// commit applies the changes in order, writing each file atomically. Existing
+// files are checked for write access before anything is written, and if a
+// change still fails, the changes already made are undone: overwritten and
+// removed files get their original content back, and created files and
+// directories are removed.
+func (c *changeSet) commit() error {
+	for _, ch := range c.changes {
+		if !ch.existed {
+			continue
+		}
+		f, err := os.OpenFile(ch.path, os.O_WRONLY, 0)
+		if err != nil {
+			return writeError(ch.path, err, "no files were changed")
+		}
+		f.Close()
+	}
+	if c.backup != nil {
+		for _, ch := range c.changes {
+			if !ch.existed {
+				continue
+			}
+			var id string
+			var err error
+			if ch.remove {
+				id, err = c.backup(ch.path)
+			} else {
+				id, err = backupTarget(c.backup, ch.path)
+			}
+			if err != nil {
+				return err
+			}
+			if c.backups == nil {
+				c.backups = make(map[string]string)
+			}
+			c.backups[ch.path] = id
+		}
+	}
+
+	var createdDirs []string
+	for i, ch := range c.changes {
+		var err error
+		if ch.remove {
+			err = os.Remove(ch.path)
+		} else {
+			var dirs []string
+			dirs, err = mkdirParents(ch.path)
+			createdDirs = append(createdDirs, dirs...)
+			if err == nil {
+				err = writeFileAtomic(ch.path, ch.data, ch.mode)
+			}
+		}
+		if err != nil {
+			failed := c.rollback(i, createdDirs)
+			if len(failed) > 0 {
+				return writeError(ch.path, err, fmt.Sprintf("could not restore %s", strings.Join(failed, ", ")))
+			}
+			return writeError(ch.path, err, "no files were changed")
+		}
+	}
+	return nil
+}
+
+// rollback undoes the first n changes, last first, and removes the
+// directories created for them. It returns the paths it could not restore.
+func (c *changeSet) rollback(n int, createdDirs []string) []string {
+	var failed []string
+	for i := n - 1; i >= 0; i-- {
+		ch := c.changes[i]
+		var err error
+		if ch.existed {
+			err = writeFileAtomic(ch.path, ch.original, ch.mode)
+		} else {
+			err = os.Remove(ch.path)
+		}
+		if err != nil && !os.IsNotExist(err) {
+			failed = append(failed, ch.path)
+		}
+	}
+	for i := len(createdDirs) - 1; i >= 0; i-- {
+		os.Remove(createdDirs[i])
+	}
+	return failed
+}
+
+// mkdirParents creates the missing parent directories of path and returns
+// them, outermost first
+func mkdirParents(path string) ([]string, error) {
+	var missing []string
+	for dir := filepath.Dir(path); ; dir = filepath.Dir(dir) {
+		if _, err := os.Stat(dir); err == nil || dir == filepath.Dir(dir) {
+			break
+		}
+		missing = append([]string{dir}, missing...)
+	}
+	for _, dir := range missing {
+		if err := os.Mkdir(dir, 0755); err != nil && !os.IsExist(err) {
+			return missing, err
+		}
+	}
+	return missing, nil
+}
+
+// writeError describes a failed write during an all-or-nothing update
+func writeError(path string, err error, outcome string) error {
+	code := ErrUnknown
+	if os.IsPermission(err) {
+		code = ErrPermission
+	}
+	return &FileError{Code: code, Message: fmt.Sprintf("Failed to write file (%s): %v", outcome, err), Path: path}
+}
@@ -0,0 +1,11 @@
+//go:build !unix
+
+package files
+
+import (
+	"io/fs"
+	"os"
+)
+
+// chown is a no-op on platforms without Unix file ownership
+func chown(f *os.File, info fs.FileInfo) {}
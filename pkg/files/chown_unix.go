@@ -0,0 +1,18 @@
+//go:build unix
+
+package files
+
+import (
+	"io/fs"
+	"os"
+	"syscall"
+)
+
+// chown gives f the owner and group of the file described by info. Only
+// privileged processes can give away files, so failures are ignored and the
+// file keeps the writer's ownership.
+func chown(f *os.File, info fs.FileInfo) {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		f.Chown(int(stat.Uid), int(stat.Gid))
+	}
+}
@@ -0,0 +1,267 @@
+package files
+
+import (
+	"fmt"
+	"strings"
+)
+
+// DefaultDiffContext is the number of unchanged lines shown around each
+// change in a unified diff
+const DefaultDiffContext = 3
+
+// maxDiffEdits bounds the work spent looking for a minimal diff. Inputs that
+// differ by more lines than this are diffed as one replaced block, which is
+// still correct but longer.
+const maxDiffEdits = 2000
+
+// diffOp is one line of an edit script: ' ' keeps, '-' deletes and '+'
+// inserts a line
+type diffOp struct {
+	kind byte
+	line string // including its line terminator, if any
+}
+
+// LineChange is a run of changed lines: OldLines lines starting at OldStart
+// were replaced by NewLines lines starting at NewStart. For a pure insertion
+// or deletion, the start on the empty side is the line that follows it.
+type LineChange struct {
+	OldStart int `json:"oldStart"`
+	OldLines int `json:"oldLines"`
+	NewStart int `json:"newStart"`
+	NewLines int `json:"newLines"`
+}
+
+// UnifiedDiff returns a unified diff turning oldText into newText, with
+// oldName and newName in the file headers and context unchanged lines around
+// each change. It returns "" when the texts are equal. A missing newline at
+// the end of either text is marked as diff(1) does.
+func UnifiedDiff(oldName string, newName string, oldText string, newText string, context int) string {
+	if oldText == newText {
+		return ""
+	}
+	return formatUnified(oldName, newName, diffLines(splitLines(oldText), splitLines(newText)), context)
+}
+
+// formatUnified formats an edit script as a unified diff
+func formatUnified(oldName string, newName string, ops []diffOp, context int) string {
+	if context < 0 {
+		context = 0
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "--- %s\n+++ %s\n", oldName, newName)
+
+	// oldLine and newLine count the lines before ops[i]
+	oldLine, newLine := 0, 0
+	for i := 0; i < len(ops); {
+		if ops[i].kind == ' ' {
+			oldLine++
+			newLine++
+			i++
+			continue
+		}
+
+		// A hunk starts context lines before this change and ends once more
+		// than 2*context unchanged lines separate it from the next change
+		start := i
+		for start > 0 && i-start < context && ops[start-1].kind == ' ' {
+			start--
+		}
+		end := i
+		for end < len(ops) {
+			if ops[end].kind != ' ' {
+				end++
+				continue
+			}
+			run := end
+			for run < len(ops) && ops[run].kind == ' ' {
+				run++
+			}
+			if run == len(ops) || run-end > 2*context {
+				end = min(end+context, run)
+				break
+			}
+			end = run
+		}
+
+		oldStart, newStart := oldLine-(i-start)+1, newLine-(i-start)+1
+		oldCount, newCount := 0, 0
+		for _, op := range ops[start:end] {
+			if op.kind != '+' {
+				oldCount++
+			}
+			if op.kind != '-' {
+				newCount++
+			}
+		}
+		fmt.Fprintf(&b, "@@ -%s +%s @@\n", hunkRange(oldStart, oldCount), hunkRange(newStart, newCount))
+		for _, op := range ops[start:end] {
+			b.WriteByte(op.kind)
+			b.WriteString(op.line)
+			if !strings.HasSuffix(op.line, "\n") {
+				b.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+
+		oldLine += oldCount - (i - start)
+		newLine += newCount - (i - start)
+		i = end
+	}
+	return b.String()
+}
+
+// lineChanges returns the runs of changed lines in an edit script
+func lineChanges(ops []diffOp) []LineChange {
+	var changes []LineChange
+	oldLine, newLine := 1, 1
+	for i := 0; i < len(ops); {
+		if ops[i].kind == ' ' {
+			oldLine++
+			newLine++
+			i++
+			continue
+		}
+		change := LineChange{OldStart: oldLine, NewStart: newLine}
+		for ; i < len(ops) && ops[i].kind != ' '; i++ {
+			if ops[i].kind == '-' {
+				change.OldLines++
+			} else {
+				change.NewLines++
+			}
+		}
+		oldLine += change.OldLines
+		newLine += change.NewLines
+		changes = append(changes, change)
+	}
+	return changes
+}
+
+// hunkRange formats the start,count pair of a hunk header. An empty range
+// names the line before it, and a count of 1 is left out.
+func hunkRange(start int, count int) string {
+	switch count {
+	case 0:
+		return fmt.Sprintf("%d,0", start-1)
+	case 1:
+		return fmt.Sprintf("%d", start)
+	}
+	return fmt.Sprintf("%d,%d", start, count)
+}
+
+// splitLines splits text after each newline, keeping the terminators so
+// that a missing final newline is a difference like any other
+func splitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	lines := strings.SplitAfter(text, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// diffLines returns an edit script turning a into b. Common leading and
+// trailing lines are matched directly and the rest is diffed with Myers'
+// algorithm.
+func diffLines(a []string, b []string) []diffOp {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	ops := make([]diffOp, 0, len(a)+len(b))
+	for _, line := range a[:prefix] {
+		ops = append(ops, diffOp{' ', line})
+	}
+	ops = append(ops, myers(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix])...)
+	for _, line := range a[len(a)-suffix:] {
+		ops = append(ops, diffOp{' ', line})
+	}
+	return ops
+}
+
+// myers returns a shortest edit script turning a into b, or deletes all of a
+// and inserts all of b if that needs more than maxDiffEdits edits
+func myers(a []string, b []string) []diffOp {
+	n, m := len(a), len(b)
+	limit := min(n+m, maxDiffEdits)
+
+	// v[offset+k] is the furthest x reached on diagonal k = x-y; trace[d]
+	// holds v[-d..d] as it was before step d, for backtracking
+	offset := limit + 1
+	v := make([]int, 2*limit+3)
+	var trace [][]int
+	for d := 0; d <= limit; d++ {
+		trace = append(trace, append([]int(nil), v[offset-d:offset+d+1]...))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				return backtrack(a, b, trace, d)
+			}
+		}
+	}
+
+	ops := make([]diffOp, 0, n+m)
+	for _, line := range a {
+		ops = append(ops, diffOp{'-', line})
+	}
+	for _, line := range b {
+		ops = append(ops, diffOp{'+', line})
+	}
+	return ops
+}
+
+// backtrack walks the trace of a Myers search that finished at step d back
+// to the start, building the edit script in reverse
+func backtrack(a []string, b []string, trace [][]int, d int) []diffOp {
+	var ops []diffOp
+	x, y := len(a), len(b)
+	for ; d > 0; d-- {
+		v := trace[d] // v[-d..d] before step d, stored from index 0
+		k := x - y
+		prevK := k - 1
+		if k == -d || (k != d && v[d+k-1] < v[d+k+1]) {
+			prevK = k + 1
+		}
+		prevX := v[d+prevK]
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			ops = append(ops, diffOp{' ', a[x-1]})
+			x--
+			y--
+		}
+		if x == prevX {
+			ops = append(ops, diffOp{'+', b[y-1]})
+			y--
+		} else {
+			ops = append(ops, diffOp{'-', a[x-1]})
+			x--
+		}
+	}
+	for x > 0 && y > 0 {
+		ops = append(ops, diffOp{' ', a[x-1]})
+		x--
+		y--
+	}
+
+	for i, j := 0, len(ops)-1; i < j; i, j = i+1, j-1 {
+		ops[i], ops[j] = ops[j], ops[i]
+	}
+	return ops
+}
@@ -0,0 +1,74 @@
+package files
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	if diff := UnifiedDiff("a", "b", "same\n", "same\n", 3); diff != "" {
+		t.Errorf("expected no diff for equal texts, got %q", diff)
+	}
+
+	var lines []string
+	for i := 1; i <= 20; i++ {
+		lines = append(lines, fmt.Sprintf("line %d", i))
+	}
+	oldText := strings.Join(lines, "\n") + "\n"
+	lines[1] = "changed 2"
+	lines[17] = "changed 18"
+	lines = append(lines[:10], lines[11:]...)
+	newText := strings.Join(lines, "\n") + "\n"
+
+	want := `--- a/f.txt
++++ b/f.txt
+@@ -1,5 +1,5 @@
+ line 1
+-line 2
++changed 2
+ line 3
+ line 4
+ line 5
+@@ -8,13 +8,12 @@
+ line 8
+ line 9
+ line 10
+-line 11
+ line 12
+ line 13
+ line 14
+ line 15
+ line 16
+ line 17
+-line 18
++changed 18
+ line 19
+ line 20
+`
+	if diff := UnifiedDiff("a/f.txt", "b/f.txt", oldText, newText, 3); diff != want {
+		t.Errorf("unexpected diff:\n%s", diff)
+	}
+
+	// Changes further apart than twice the context get separate hunks
+	if diff := UnifiedDiff("a", "b", oldText, newText, 1); strings.Count(diff, "@@ -") != 3 {
+		t.Errorf("expected three hunks with one context line:\n%s", diff)
+	}
+
+	want = `--- a
++++ b
+@@ -1,2 +1,2 @@
+ x
+-y
+\ No newline at end of file
++y
+`
+	if diff := UnifiedDiff("a", "b", "x\ny", "x\ny\n", 3); diff != want {
+		t.Errorf("unexpected diff for a missing final newline:\n%s", diff)
+	}
+
+	want = "--- a\n+++ b\n@@ -0,0 +1,2 @@\n+new\n+file\n"
+	if diff := UnifiedDiff("a", "b", "", "new\nfile\n", 3); diff != want {
+		t.Errorf("unexpected diff for a new file:\n%s", diff)
+	}
+}
@@ -0,0 +1,329 @@
+package files
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// Modify modes
+const (
+	ModifyReplace      = "replace"      // find and replace text
+	ModifyReplaceLines = "replaceLines" // replace a range of lines
+	ModifyInsert       = "insert"       // insert lines before or after a line or anchor
+	ModifyDeleteLines  = "deleteLines"  // delete a range of lines
+)
+
+// maxReportedLocations caps the match locations listed in an ambiguity error
+const maxReportedLocations = 10
+
+// ModifyOptions describes an edit made by ModifyFileWithOptions
+type ModifyOptions struct {
+	// Mode is ModifyReplace (the default), ModifyReplaceLines, ModifyInsert
+	// or ModifyDeleteLines
+	Mode string
+
+	// Find is the text, or with Regex the pattern, replaced by Replace in
+	// ModifyReplace mode. AllOccurrences replaces every match rather than
+	// the first.
+	Find           string
+	Replace        string
+	AllOccurrences bool
+	Regex          bool
+
+	// Unique requires Find to match exactly once. Otherwise the file is left
+	// alone and the error lists the lines it matched on.
+	Unique bool
+
+	// StartLine and EndLine are the 1-based, inclusive range replaced in
+	// ModifyReplaceLines mode or deleted in ModifyDeleteLines mode; EndLine 0
+	// means StartLine. In ModifyInsert mode StartLine is the line to insert
+	// next to, and 0 inserts at the start of the file.
+	StartLine int
+	EndLine   int
+
+	// Anchor, in ModifyInsert mode, selects the line to insert next to by
+	// text that must appear exactly once in the file, instead of StartLine
+	Anchor string
+
+	// Before inserts before the line rather than after it
+	Before bool
+
+	// Content is the text inserted or replacing the line range. A final line
+	// ending is added if missing, and line endings are converted to the
+	// file's.
+	Content string
+
+	// ContextLines is the number of unchanged lines around each change in
+	// the diff; a negative value uses DefaultDiffContext
+	ContextLines int
+
+	// ExpectedHash, if set, is the ContentHash the file must still have;
+	// otherwise nothing is changed and the edit fails with ErrConflict
+	ExpectedHash string
+
+	// Backup, if set, is called with the path before the file is changed;
+	// an error cancels the edit
+	Backup BackupFunc
+
+	// DryRun returns the diff of the edit without changing the file. The
+	// result's Hash is then the hash the file would have.
+	DryRun bool
+
+	// Check, if set, is called with the edited content before it is
+	// written, in dry runs too; an error refuses the edit
+	Check ContentCheck
+}
+
+// ModifyFileWithOptions edits a file in one of the Modify modes and returns
+// the diff of the change and the changed line ranges. The file keeps its
+// encoding, line endings and missing final newline, if any.
+func ModifyFileWithOptions(path string, opts ModifyOptions) (*ModifyResult, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, &FileError{Code: ErrFileNotFound, Message: "File not found", Path: path}
+		}
+		if os.IsPermission(err) {
+			return nil, &FileError{Code: ErrPermission, Message: "Permission denied", Path: path}
+		}
+		return nil, &FileError{Code: ErrUnknown, Message: err.Error(), Path: path}
+	}
+	if err := checkHash(path, opts.ExpectedHash, content, true); err != nil {
+		return nil, err
+	}
+
+	encInfo := DetectEncoding(content)
+	originalContent, encInfo, err := Decode(content, encInfo.Encoding)
+	if err != nil {
+		return nil, &FileError{Code: ErrInvalidEncoding, Message: err.Error(), Path: path}
+	}
+
+	var newContent string
+	var replacements int
+	switch opts.Mode {
+	case "", ModifyReplace:
+		newContent, replacements, err = findReplace(originalContent, opts)
+	case ModifyReplaceLines, ModifyDeleteLines:
+		if opts.Mode == ModifyDeleteLines {
+			opts.Content = ""
+		}
+		newContent, err = replaceLines(originalContent, opts)
+	case ModifyInsert:
+		newContent, err = insertLines(originalContent, opts)
+	default:
+		err = fmt.Errorf("Unknown modify mode: %s", opts.Mode)
+	}
+	if err != nil {
+		if fe, ok := err.(*FileError); ok {
+			fe.Path = path
+			return nil, fe
+		}
+		return nil, &FileError{Code: ErrInvalidArgument, Message: err.Error(), Path: path}
+	}
+
+	result := &ModifyResult{
+		Path:         path,
+		Replacements: replacements,
+		Modified:     newContent != originalContent,
+		Hash:         ContentHash(content),
+		DryRun:       opts.DryRun,
+	}
+	if !result.Modified {
+		return result, nil
+	}
+
+	data, err := Encode(newContent, encInfo)
+	if err != nil {
+		return nil, &FileError{Code: ErrInvalidEncoding, Message: err.Error(), Path: path}
+	}
+	if opts.Check != nil {
+		if err := opts.Check(path, data); err != nil {
+			return nil, err
+		}
+	}
+	if !opts.DryRun {
+		if err := modifyWrite(path, data, opts, result); err != nil {
+			return nil, err
+		}
+	}
+	result.Hash = ContentHash(data)
+
+	if opts.ContextLines < 0 {
+		opts.ContextLines = DefaultDiffContext
+	}
+	ops := diffLines(splitLines(originalContent), splitLines(newContent))
+	result.Diff = formatUnified(path, path, ops, opts.ContextLines)
+	result.Changes = lineChanges(ops)
+	return result, nil
+}
+
+// modifyWrite backs up and replaces the file being modified
+func modifyWrite(path string, data []byte, opts ModifyOptions, result *ModifyResult) error {
+	var err error
+	if opts.Backup != nil {
+		if result.BackupID, err = backupTarget(opts.Backup, path); err != nil {
+			return err
+		}
+	}
+	if err := writeFileAtomic(path, data, DefaultFileMode); err != nil {
+		if os.IsPermission(err) {
+			return &FileError{Code: ErrPermission, Message: "Permission denied", Path: path}
+		}
+		return &FileError{Code: ErrUnknown, Message: err.Error(), Path: path}
+	}
+	return nil
+}
+
+// findReplace replaces opts.Find in text and returns the new text and the
+// number of replacements
+func findReplace(text string, opts ModifyOptions) (string, int, error) {
+	var re *regexp.Regexp
+	if opts.Regex {
+		var err error
+		re, err = regexp.Compile(opts.Find)
+		if err != nil {
+			return "", 0, fmt.Errorf("Invalid regex pattern: %s", err.Error())
+		}
+	} else {
+		re = regexp.MustCompile(regexp.QuoteMeta(opts.Find))
+	}
+
+	locs := re.FindAllStringSubmatchIndex(text, -1)
+	if opts.Unique && len(locs) != 1 {
+		return "", 0, matchCountError("find", text, locs)
+	}
+	if len(locs) == 0 {
+		return text, 0, nil
+	}
+	if !opts.AllOccurrences {
+		locs = locs[:1]
+	}
+
+	var b strings.Builder
+	last := 0
+	for _, loc := range locs {
+		b.WriteString(text[last:loc[0]])
+		if opts.Regex {
+			b.Write(re.ExpandString(nil, opts.Replace, text, loc))
+		} else {
+			b.WriteString(opts.Replace)
+		}
+		last = loc[1]
+	}
+	b.WriteString(text[last:])
+	return b.String(), len(locs), nil
+}
+
+// matchCountError reports that what matched at locs was not unique
+func matchCountError(what string, text string, locs [][]int) error {
+	if len(locs) == 0 {
+		return &FileError{Code: ErrNoMatch, Message: fmt.Sprintf("%s text not found", what)}
+	}
+
+	var lines []string
+	for _, loc := range locs[:min(len(locs), maxReportedLocations)] {
+		lines = append(lines, fmt.Sprintf("%d", lineAt(text, loc[0])))
+	}
+	if len(locs) > maxReportedLocations {
+		lines = append(lines, "...")
+	}
+	return &FileError{
+		Code:    ErrAmbiguousMatch,
+		Message: fmt.Sprintf("%s text matches %d times, on lines %s; it must match exactly once. Include more surrounding text to make it unique.", what, len(locs), strings.Join(lines, ", ")),
+	}
+}
+
+// lineAt returns the 1-based line holding byte offset pos of text
+func lineAt(text string, pos int) int {
+	return strings.Count(text[:pos], "\n") + 1
+}
+
+// replaceLines replaces lines StartLine to EndLine of text with opts.Content
+func replaceLines(text string, opts ModifyOptions) (string, error) {
+	lines := splitLines(text)
+	start, end := opts.StartLine, opts.EndLine
+	if end == 0 {
+		end = start
+	}
+	if start < 1 || end < start || end > len(lines) {
+		return "", fmt.Errorf("Line range %d-%d is outside the file, which has %d lines", start, end, len(lines))
+	}
+	return spliceLines(text, lines, start-1, end, opts.Content), nil
+}
+
+// insertLines inserts opts.Content before or after a line chosen by
+// StartLine or Anchor
+func insertLines(text string, opts ModifyOptions) (string, error) {
+	if opts.Content == "" {
+		return "", fmt.Errorf("content is required for insert")
+	}
+	lines := splitLines(text)
+
+	// at is the index in lines that the content is inserted before
+	var at int
+	if opts.Anchor != "" {
+		var locs [][]int
+		for offset := 0; ; {
+			i := strings.Index(text[offset:], opts.Anchor)
+			if i < 0 {
+				break
+			}
+			locs = append(locs, []int{offset + i, offset + i + len(opts.Anchor)})
+			offset += i + len(opts.Anchor)
+		}
+		if len(locs) != 1 {
+			return "", matchCountError("anchor", text, locs)
+		}
+		at = lineAt(text, locs[0][1]-1)
+		if opts.Before {
+			at = lineAt(text, locs[0][0]) - 1
+		}
+	} else {
+		line := opts.StartLine
+		if line < 0 || line > len(lines) || (line == 0 && opts.Before) {
+			return "", fmt.Errorf("Line %d is outside the file, which has %d lines", line, len(lines))
+		}
+		at = line
+		if opts.Before {
+			at = line - 1
+		}
+	}
+	return spliceLines(text, lines, at, at, opts.Content), nil
+}
+
+// spliceLines replaces lines[start:end] of text with content, adapted to the
+// file's line endings
+func spliceLines(text string, lines []string, start int, end int, content string) string {
+	style, ending := LineEndingLF, "\n"
+	if DetectLineEnding(text) == LineEndingCRLF {
+		style, ending = LineEndingCRLF, "\r\n"
+	}
+	if content != "" {
+		content = ConvertLineEndings(content, style)
+		if !strings.HasSuffix(content, "\n") {
+			content += ending
+		}
+	}
+
+	var b strings.Builder
+	for _, line := range lines[:start] {
+		b.WriteString(line)
+	}
+	// Content placed after a last line without a newline starts a new line
+	if start > 0 && !strings.HasSuffix(lines[start-1], "\n") && content != "" {
+		b.WriteString(ending)
+	}
+	b.WriteString(content)
+	for _, line := range lines[end:] {
+		b.WriteString(line)
+	}
+
+	// Keep a missing final newline missing
+	result := b.String()
+	if text != "" && !strings.HasSuffix(text, "\n") {
+		result = strings.TrimSuffix(result, ending)
+	}
+	return result
+}
@@ -0,0 +1,123 @@
+package files
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestModifyFileWithOptions(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "edit.txt")
+	tests := []struct {
+		name    string
+		content string
+		opts    ModifyOptions
+		want    string
+		changes []LineChange
+	}{
+		{
+			name:    "replace lines",
+			content: "a\nb\nc\nd\n",
+			opts:    ModifyOptions{Mode: ModifyReplaceLines, StartLine: 2, EndLine: 3, Content: "x\ny\nz"},
+			want:    "a\nx\ny\nz\nd\n",
+			changes: []LineChange{{OldStart: 2, OldLines: 2, NewStart: 2, NewLines: 3}},
+		},
+		{
+			name:    "delete lines",
+			content: "a\nb\nc\n",
+			opts:    ModifyOptions{Mode: ModifyDeleteLines, StartLine: 2},
+			want:    "a\nc\n",
+			changes: []LineChange{{OldStart: 2, OldLines: 1, NewStart: 2, NewLines: 0}},
+		},
+		{
+			name:    "insert after line",
+			content: "a\r\nb\r\n",
+			opts:    ModifyOptions{Mode: ModifyInsert, StartLine: 1, Content: "x\ny\n"},
+			want:    "a\r\nx\r\ny\r\nb\r\n",
+			changes: []LineChange{{OldStart: 2, OldLines: 0, NewStart: 2, NewLines: 2}},
+		},
+		{
+			name:    "insert before first line",
+			content: "a\n",
+			opts:    ModifyOptions{Mode: ModifyInsert, StartLine: 1, Before: true, Content: "x"},
+			want:    "x\na\n",
+		},
+		{
+			name:    "insert after last line without newline",
+			content: "a\nb",
+			opts:    ModifyOptions{Mode: ModifyInsert, StartLine: 2, Content: "c"},
+			want:    "a\nb\nc",
+		},
+		{
+			name:    "insert after anchor",
+			content: "import (\n\t\"fmt\"\n)\n",
+			opts:    ModifyOptions{Mode: ModifyInsert, Anchor: "import (", Content: "\t\"os\""},
+			want:    "import (\n\t\"os\"\n\t\"fmt\"\n)\n",
+		},
+		{
+			name:    "unique find",
+			content: "x := 1\ny := 1\n",
+			opts:    ModifyOptions{Find: "y := 1", Replace: "y := 2", Unique: true},
+			want:    "x := 1\ny := 2\n",
+		},
+	}
+	for _, tt := range tests {
+		if err := os.WriteFile(path, []byte(tt.content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		result, err := ModifyFileWithOptions(path, tt.opts)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		data, _ := os.ReadFile(path)
+		if string(data) != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, data, tt.want)
+		}
+		if !result.Modified || result.Diff == "" {
+			t.Errorf("%s: expected a modification with a diff, got %+v", tt.name, result)
+		}
+		if tt.changes != nil && !reflect.DeepEqual(result.Changes, tt.changes) {
+			t.Errorf("%s: got changes %+v, want %+v", tt.name, result.Changes, tt.changes)
+		}
+	}
+}
+
+func TestModifyFileWithOptionsErrors(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "edit.txt")
+	content := "x := 1\ny := 1\nz := 1\n"
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tooLarge := func(path string, data []byte) error {
+		return &FileError{Code: ErrContentTooLarge, Message: fmt.Sprintf("%d bytes is too large", len(data)), Path: path}
+	}
+
+	tests := []struct {
+		name string
+		opts ModifyOptions
+		code ErrorCode
+		text string
+	}{
+		{"ambiguous find", ModifyOptions{Find: ":= 1", Replace: ":= 2", Unique: true}, ErrAmbiguousMatch, "matches 3 times, on lines 1, 2, 3"},
+		{"missing find", ModifyOptions{Find: "w :=", Unique: true}, ErrNoMatch, "not found"},
+		{"ambiguous anchor", ModifyOptions{Mode: ModifyInsert, Anchor: "1", Content: "w"}, ErrAmbiguousMatch, "lines 1, 2, 3"},
+		{"range past the end", ModifyOptions{Mode: ModifyReplaceLines, StartLine: 3, EndLine: 4, Content: "w"}, ErrInvalidArgument, "has 3 lines"},
+		{"unknown mode", ModifyOptions{Mode: "append"}, ErrInvalidArgument, "Unknown modify mode"},
+		{"refused by check", ModifyOptions{Find: "x", Replace: "w", Check: tooLarge}, ErrContentTooLarge, "21 bytes"},
+	}
+	for _, tt := range tests {
+		_, err := ModifyFileWithOptions(path, tt.opts)
+		fe, ok := err.(*FileError)
+		if !ok || fe.Code != tt.code || !strings.Contains(fe.Message, tt.text) || fe.Path != path {
+			t.Errorf("%s: unexpected error %v", tt.name, err)
+		}
+	}
+
+	if data, _ := os.ReadFile(path); string(data) != content {
+		t.Errorf("failed edits modified the file: %q", data)
+	}
+}
@@ -0,0 +1,304 @@
+package files
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// Supported character encodings
+const (
+	EncodingAuto        = "auto"
+	EncodingUTF8        = "utf-8"
+	EncodingUTF16LE     = "utf-16le"
+	EncodingUTF16BE     = "utf-16be"
+	EncodingASCII       = "ascii"
+	EncodingLatin1      = "latin1"
+	EncodingWindows1252 = "windows-1252"
+)
+
+// Line ending styles
+const (
+	LineEndingAuto  = "auto"
+	LineEndingLF    = "lf"
+	LineEndingCRLF  = "crlf"
+	LineEndingMixed = "mixed"
+	LineEndingNone  = "none"
+)
+
+var (
+	bomUTF8    = []byte{0xEF, 0xBB, 0xBF}
+	bomUTF16LE = []byte{0xFF, 0xFE}
+	bomUTF16BE = []byte{0xFE, 0xFF}
+)
+
+// sniffLen is the number of bytes inspected by the UTF-16 heuristic
+const sniffLen = 4096
+
+// windows1252High maps bytes 0x80-0x9F to Unicode. Unassigned bytes map to
+// the corresponding C1 control character, as browsers do.
+var windows1252High = [32]rune{
+	0x20AC, 0x0081, 0x201A, 0x0192, 0x201E, 0x2026, 0x2020, 0x2021,
+	0x02C6, 0x2030, 0x0160, 0x2039, 0x0152, 0x008D, 0x017D, 0x008F,
+	0x0090, 0x2018, 0x2019, 0x201C, 0x201D, 0x2022, 0x2013, 0x2014,
+	0x02DC, 0x2122, 0x0161, 0x203A, 0x0153, 0x009D, 0x017E, 0x0178,
+}
+
+// EncodingInfo describes the detected or requested encoding of a file
+type EncodingInfo struct {
+	Encoding string
+	BOM      bool
+}
+
+// NormalizeEncoding maps user-supplied encoding names to the canonical
+// names used by this package. An empty name means auto-detection.
+func NormalizeEncoding(name string) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "", "auto", "detect":
+		return EncodingAuto, nil
+	case "utf8", "utf-8":
+		return EncodingUTF8, nil
+	case "utf16le", "utf-16le", "utf16", "utf-16", "ucs-2", "unicode":
+		return EncodingUTF16LE, nil
+	case "utf16be", "utf-16be":
+		return EncodingUTF16BE, nil
+	case "ascii", "us-ascii":
+		return EncodingASCII, nil
+	case "latin1", "latin-1", "iso-8859-1", "iso8859-1", "l1":
+		return EncodingLatin1, nil
+	case "windows-1252", "cp1252", "win1252":
+		return EncodingWindows1252, nil
+	default:
+		return "", fmt.Errorf("unsupported encoding %q (supported: auto, utf8, utf16le, utf16be, ascii, latin1, windows-1252)", name)
+	}
+}
+
+// DetectEncoding determines the encoding of raw file content. A byte order
+// mark wins; otherwise valid UTF-8 is UTF-8, NUL-byte patterns indicate
+// UTF-16, and anything else is treated as a single-byte Western encoding.
+func DetectEncoding(data []byte) EncodingInfo {
+	switch {
+	case bytes.HasPrefix(data, bomUTF8):
+		return EncodingInfo{Encoding: EncodingUTF8, BOM: true}
+	case bytes.HasPrefix(data, bomUTF16LE):
+		return EncodingInfo{Encoding: EncodingUTF16LE, BOM: true}
+	case bytes.HasPrefix(data, bomUTF16BE):
+		return EncodingInfo{Encoding: EncodingUTF16BE, BOM: true}
+	}
+
+	if enc := sniffUTF16(data); enc != "" {
+		return EncodingInfo{Encoding: enc}
+	}
+
+	if utf8.Valid(data) {
+		return EncodingInfo{Encoding: EncodingUTF8}
+	}
+
+	for _, b := range data {
+		if b >= 0x80 && b <= 0x9F {
+			return EncodingInfo{Encoding: EncodingWindows1252}
+		}
+	}
+	return EncodingInfo{Encoding: EncodingLatin1}
+}
+
+// sniffUTF16 detects BOM-less UTF-16 text, where mostly-ASCII content leaves
+// a NUL in every other byte
+func sniffUTF16(data []byte) string {
+	sample := data
+	if len(sample) > sniffLen {
+		sample = sample[:sniffLen]
+	}
+	if len(sample) < 4 || len(sample)%2 != 0 {
+		return ""
+	}
+
+	var evenZeros, oddZeros int
+	for i := 0; i < len(sample); i += 2 {
+		if sample[i] == 0 {
+			evenZeros++
+		}
+		if sample[i+1] == 0 {
+			oddZeros++
+		}
+	}
+
+	pairs := len(sample) / 2
+	switch {
+	case oddZeros*10 >= pairs*9 && evenZeros*10 < pairs:
+		return EncodingUTF16LE
+	case evenZeros*10 >= pairs*9 && oddZeros*10 < pairs:
+		return EncodingUTF16BE
+	default:
+		return ""
+	}
+}
+
+// Decode converts raw bytes in the given encoding to a UTF-8 string.
+// Pass EncodingAuto to detect the encoding. Any byte order mark is stripped.
+func Decode(data []byte, encoding string) (string, EncodingInfo, error) {
+	enc, err := NormalizeEncoding(encoding)
+	if err != nil {
+		return "", EncodingInfo{}, err
+	}
+
+	info := DetectEncoding(data)
+	if enc != EncodingAuto {
+		bom := info.BOM && (info.Encoding == enc || (enc == EncodingASCII && info.Encoding == EncodingUTF8))
+		info = EncodingInfo{Encoding: enc, BOM: bom}
+	}
+
+	if info.BOM {
+		switch info.Encoding {
+		case EncodingUTF8, EncodingASCII:
+			data = data[len(bomUTF8):]
+		case EncodingUTF16LE, EncodingUTF16BE:
+			data = data[2:]
+		}
+	}
+
+	switch info.Encoding {
+	case EncodingUTF8:
+		return string(data), info, nil
+	case EncodingASCII:
+		for i, b := range data {
+			if b >= 0x80 {
+				return "", info, fmt.Errorf("byte 0x%02X at offset %d is not valid ASCII", b, i)
+			}
+		}
+		return string(data), info, nil
+	case EncodingUTF16LE, EncodingUTF16BE:
+		return decodeUTF16(data, info.Encoding == EncodingUTF16BE), info, nil
+	case EncodingLatin1, EncodingWindows1252:
+		return decodeSingleByte(data, info.Encoding == EncodingWindows1252), info, nil
+	}
+
+	return "", info, fmt.Errorf("unsupported encoding %q", info.Encoding)
+}
+
+func decodeUTF16(data []byte, bigEndian bool) string {
+	units := make([]uint16, len(data)/2)
+	for i := range units {
+		if bigEndian {
+			units[i] = binary.BigEndian.Uint16(data[2*i:])
+		} else {
+			units[i] = binary.LittleEndian.Uint16(data[2*i:])
+		}
+	}
+	s := string(utf16.Decode(units))
+	if len(data)%2 != 0 {
+		s += string(utf8.RuneError)
+	}
+	return s
+}
+
+func decodeSingleByte(data []byte, windows1252 bool) string {
+	var b strings.Builder
+	b.Grow(len(data))
+	for _, c := range data {
+		if windows1252 && c >= 0x80 && c <= 0x9F {
+			b.WriteRune(windows1252High[c-0x80])
+		} else {
+			b.WriteRune(rune(c))
+		}
+	}
+	return b.String()
+}
+
+// Encode converts a UTF-8 string to the given encoding, optionally writing a
+// byte order mark. It fails if the text contains characters the encoding
+// cannot represent.
+func Encode(text string, info EncodingInfo) ([]byte, error) {
+	enc, err := NormalizeEncoding(info.Encoding)
+	if err != nil {
+		return nil, err
+	}
+
+	var out []byte
+	switch enc {
+	case EncodingAuto, EncodingUTF8:
+		if info.BOM {
+			out = append(out, bomUTF8...)
+		}
+		return append(out, text...), nil
+	case EncodingUTF16LE, EncodingUTF16BE:
+		bigEndian := enc == EncodingUTF16BE
+		if info.BOM {
+			if bigEndian {
+				out = append(out, bomUTF16BE...)
+			} else {
+				out = append(out, bomUTF16LE...)
+			}
+		}
+		for _, u := range utf16.Encode([]rune(text)) {
+			if bigEndian {
+				out = binary.BigEndian.AppendUint16(out, u)
+			} else {
+				out = binary.LittleEndian.AppendUint16(out, u)
+			}
+		}
+		return out, nil
+	}
+
+	out = make([]byte, 0, len(text))
+	for i, r := range text {
+		b, ok := encodeSingleByte(r, enc)
+		if !ok {
+			return nil, fmt.Errorf("character %q at offset %d cannot be represented in %s", r, i, enc)
+		}
+		out = append(out, b)
+	}
+	return out, nil
+}
+
+func encodeSingleByte(r rune, enc string) (byte, bool) {
+	switch enc {
+	case EncodingASCII:
+		return byte(r), r < 0x80
+	case EncodingLatin1:
+		return byte(r), r < 0x100
+	}
+
+	// windows-1252
+	if r < 0x80 || (r >= 0xA0 && r < 0x100) {
+		return byte(r), true
+	}
+	for i, mapped := range windows1252High {
+		if mapped == r {
+			return byte(0x80 + i), true
+		}
+	}
+	return 0, false
+}
+
+// DetectLineEnding reports whether text uses LF, CRLF, a mix of both, or has no line breaks
+func DetectLineEnding(text string) string {
+	crlf := strings.Count(text, "\r\n")
+	lf := strings.Count(text, "\n") - crlf
+	switch {
+	case crlf == 0 && lf == 0:
+		return LineEndingNone
+	case crlf == 0:
+		return LineEndingLF
+	case lf == 0:
+		return LineEndingCRLF
+	default:
+		return LineEndingMixed
+	}
+}
+
+// ConvertLineEndings rewrites all line breaks in text to the given style.
+// Styles other than LF and CRLF leave the text unchanged.
+func ConvertLineEndings(text string, lineEnding string) string {
+	switch lineEnding {
+	case LineEndingLF:
+		return strings.ReplaceAll(text, "\r\n", "\n")
+	case LineEndingCRLF:
+		return strings.ReplaceAll(strings.ReplaceAll(text, "\r\n", "\n"), "\n", "\r\n")
+	default:
+		return text
+	}
+}
@@ -0,0 +1,148 @@
+package files
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDetectEncoding(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     []byte
+		expected EncodingInfo
+	}{
+		{"utf8", []byte("héllo"), EncodingInfo{Encoding: EncodingUTF8}},
+		{"utf8 bom", append([]byte{0xEF, 0xBB, 0xBF}, "hi"...), EncodingInfo{Encoding: EncodingUTF8, BOM: true}},
+		{"utf16le bom", []byte{0xFF, 0xFE, 'h', 0, 'i', 0}, EncodingInfo{Encoding: EncodingUTF16LE, BOM: true}},
+		{"utf16be bom", []byte{0xFE, 0xFF, 0, 'h', 0, 'i'}, EncodingInfo{Encoding: EncodingUTF16BE, BOM: true}},
+		{"utf16le no bom", []byte{'h', 0, 'e', 0, 'l', 0, 'l', 0, 'o', 0}, EncodingInfo{Encoding: EncodingUTF16LE}},
+		{"utf16be no bom", []byte{0, 'h', 0, 'e', 0, 'l', 0, 'l', 0, 'o'}, EncodingInfo{Encoding: EncodingUTF16BE}},
+		{"latin1", []byte{'c', 'a', 'f', 0xE9}, EncodingInfo{Encoding: EncodingLatin1}},
+		{"windows-1252", []byte{0x93, 'q', 0x94}, EncodingInfo{Encoding: EncodingWindows1252}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := DetectEncoding(tt.data); got != tt.expected {
+				t.Errorf("DetectEncoding = %+v, want %+v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestDecodeEncodeRoundTrip(t *testing.T) {
+	text := "café “quoted” €"
+	for _, enc := range []string{EncodingUTF8, EncodingUTF16LE, EncodingUTF16BE, EncodingWindows1252} {
+		t.Run(enc, func(t *testing.T) {
+			info := EncodingInfo{Encoding: enc, BOM: enc != EncodingWindows1252}
+			data, err := Encode(text, info)
+			if err != nil {
+				t.Fatalf("Encode failed: %v", err)
+			}
+			decoded, detected, err := Decode(data, EncodingAuto)
+			if err != nil {
+				t.Fatalf("Decode failed: %v", err)
+			}
+			if decoded != text {
+				t.Errorf("Round trip = %q, want %q", decoded, text)
+			}
+			if detected != info {
+				t.Errorf("Detected %+v, want %+v", detected, info)
+			}
+		})
+	}
+
+	if _, err := Encode("€", EncodingInfo{Encoding: EncodingLatin1}); err == nil {
+		t.Error("Expected error encoding € as latin1")
+	}
+	if _, _, err := Decode([]byte{0xE9}, "ascii"); err == nil {
+		t.Error("Expected error decoding non-ASCII byte as ascii")
+	}
+	if _, err := NormalizeEncoding("ebcdic"); err == nil {
+		t.Error("Expected error for unsupported encoding")
+	}
+}
+
+func TestReadFileTranscodes(t *testing.T) {
+	tmpDir := t.TempDir()
+	testFile := filepath.Join(tmpDir, "utf16.txt")
+	data, _ := Encode("hello\r\nworld\r\n", EncodingInfo{Encoding: EncodingUTF16LE, BOM: true})
+	if err := os.WriteFile(testFile, data, 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	result, err := ReadFile(testFile, 0)
+	if err != nil {
+		t.Fatalf("ReadFile failed: %v", err)
+	}
+	if result.Content != "hello\r\nworld\r\n" {
+		t.Errorf("Content = %q", result.Content)
+	}
+	if result.Encoding != EncodingUTF16LE || !result.BOM {
+		t.Errorf("Encoding = %s (bom=%v), want utf-16le with BOM", result.Encoding, result.BOM)
+	}
+	if result.LineEnding != LineEndingCRLF {
+		t.Errorf("LineEnding = %s, want crlf", result.LineEnding)
+	}
+
+	// An explicit encoding overrides detection
+	latin := filepath.Join(tmpDir, "latin.txt")
+	os.WriteFile(latin, []byte{0x93, 'q', 0x94}, 0644)
+	result, err = ReadFileWithEncoding(latin, 0, "latin1")
+	if err != nil {
+		t.Fatalf("ReadFileWithEncoding failed: %v", err)
+	}
+	if result.Content != "\u0093q\u0094" {
+		t.Errorf("Content = %q, want latin1 C1 controls", result.Content)
+	}
+}
+
+func TestWriteFilePreservesEncoding(t *testing.T) {
+	tmpDir := t.TempDir()
+	testFile := filepath.Join(tmpDir, "file.txt")
+	original, _ := Encode("one\r\ntwo\r\n", EncodingInfo{Encoding: EncodingUTF16LE, BOM: true})
+	if err := os.WriteFile(testFile, original, 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	result, err := WriteFile(testFile, "three\nfour\n")
+	if err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	if result.Encoding != EncodingUTF16LE || result.LineEnding != LineEndingCRLF {
+		t.Errorf("WriteResult = %+v, want utf-16le/crlf", result)
+	}
+
+	written, _ := os.ReadFile(testFile)
+	expected, _ := Encode("three\r\nfour\r\n", EncodingInfo{Encoding: EncodingUTF16LE, BOM: true})
+	if !bytes.Equal(written, expected) {
+		t.Errorf("Written bytes = %v, want %v", written, expected)
+	}
+
+	// Explicit options override the existing file
+	_, err = WriteFileWithOptions(testFile, "five\r\n", WriteOptions{Encoding: "utf8", LineEnding: "lf"})
+	if err != nil {
+		t.Fatalf("WriteFileWithOptions failed: %v", err)
+	}
+	written, _ = os.ReadFile(testFile)
+	if string(written) != "five\n" {
+		t.Errorf("Written = %q, want %q", written, "five\n")
+	}
+}
+
+func TestModifyFilePreservesEncoding(t *testing.T) {
+	tmpDir := t.TempDir()
+	testFile := filepath.Join(tmpDir, "file.txt")
+	os.WriteFile(testFile, []byte("caf\xe9 au lait"), 0644) // latin1
+
+	if _, err := ModifyFile(testFile, "lait", "chocolat", true, false); err != nil {
+		t.Fatalf("ModifyFile failed: %v", err)
+	}
+
+	written, _ := os.ReadFile(testFile)
+	if string(written) != "caf\xe9 au chocolat" {
+		t.Errorf("Written = %q, want latin1 bytes preserved", written)
+	}
+}
@@ -1,17 +1,16 @@
 package files
 
 import (
-	"bufio"
 	"fmt"
 	"io"
+	"io/fs"
 	"mime"
 	"os"
 	"path/filepath"
-	"regexp"
 	"strings"
 	"time"
 
-	"github.com/bmatcuk/doublestar/v4"
+	"github.com/JeremyProffitt/go-mcp-file-context-server/pkg/extract"
 )
 
 // FileMetadata represents metadata about a file
@@ -31,6 +30,16 @@ type FileContent struct {
 	Truncated  bool         `json:"truncated"`
 	TotalLines int          `json:"totalLines"`
 	Path       string       `json:"path"`
+	TokenCount int          `json:"tokenCount,omitempty"`
+	Summarized bool         `json:"summarized,omitempty"`
+	BOM        bool         `json:"bom,omitempty"`
+	LineEnding string       `json:"lineEnding,omitempty"`
+	// ExtractedFrom is the source MIME type when Content is text extracted
+	// from a document (PDF, Office, notebook) rather than the raw file
+	ExtractedFrom string `json:"extractedFrom,omitempty"`
+	// Hash is the ContentHash of the file, to pass back as the expected
+	// hash of a later write
+	Hash string `json:"hash,omitempty"`
 }
 
 // FileEntry represents a file entry in a directory listing
@@ -38,14 +47,24 @@ type FileEntry struct {
 	Path     string       `json:"path"`
 	Name     string       `json:"name"`
 	Metadata FileMetadata `json:"metadata"`
+	Symlink  *SymlinkInfo `json:"symlink,omitempty"`
 }
 
 // SearchMatch represents a search match
 type SearchMatch struct {
-	Path    string        `json:"path"`
-	Line    int           `json:"line"`
-	Content string        `json:"content"`
-	Context SearchContext `json:"context"`
+	Path       string        `json:"path"`
+	Line       int           `json:"line"`
+	EndLine    int           `json:"endLine,omitempty"` // last line of a multiline match
+	Content    string        `json:"content"`
+	Submatches []Submatch    `json:"submatches,omitempty"`
+	Context    SearchContext `json:"context"`
+}
+
+// Submatch locates the pattern within a match's Content, as byte offsets
+// (end exclusive)
+type Submatch struct {
+	Start int `json:"start"`
+	End   int `json:"end"`
 }
 
 // SearchContext represents lines around a match
@@ -54,10 +73,20 @@ type SearchContext struct {
 	After  []string `json:"after"`
 }
 
-// SearchResult represents search results
+// SearchResult represents search results. Matches is filled in the content
+// output mode, Files and Counts in the filesWithMatches and count modes.
 type SearchResult struct {
-	Matches []SearchMatch `json:"matches"`
-	Total   int           `json:"total"`
+	Matches []SearchMatch    `json:"matches,omitempty"`
+	Files   []string         `json:"files,omitempty"`
+	Counts  []FileMatchCount `json:"counts,omitempty"`
+	Total   int              `json:"total"`
+}
+
+// FileMatchCount is the number of matching lines in one file, or of
+// matches in multiline mode
+type FileMatchCount struct {
+	Path  string `json:"path"`
+	Count int    `json:"count"`
 }
 
 // WriteResult represents the result of a write operation
@@ -65,6 +94,41 @@ type WriteResult struct {
 	Path         string `json:"path"`
 	BytesWritten int64  `json:"bytesWritten"`
 	Created      bool   `json:"created"` // true if file was created, false if overwritten
+	Encoding     string `json:"encoding"`
+	LineEnding   string `json:"lineEnding,omitempty"`
+	Hash         string `json:"hash"`
+	BackupID     string `json:"backupId,omitempty"` // backup of the overwritten file
+	DryRun       bool   `json:"dryRun,omitempty"`
+	Diff         string `json:"diff,omitempty"` // with DryRun, the change to the file's text
+}
+
+// WriteOptions controls how content is encoded when written
+type WriteOptions struct {
+	// Encoding is the target character encoding. Empty or "auto" keeps the
+	// existing file's encoding (including its byte order mark), or UTF-8 for new files.
+	Encoding string
+	// LineEnding is "lf" or "crlf". Empty or "auto" converts to the existing
+	// file's line ending style, and leaves new files unchanged.
+	LineEnding string
+	// ExpectedHash, if set, is the ContentHash the file must still have;
+	// otherwise the write fails with ErrConflict
+	ExpectedHash string
+	// Mode is the permission of a newly created file; 0 means
+	// DefaultFileMode. Existing files keep their mode.
+	Mode fs.FileMode
+	// Backup, if set, is called with the path of an existing file, or the
+	// file a symlink at path resolves to, before it is overwritten; an error
+	// cancels the write
+	Backup BackupFunc
+	// DryRun checks and encodes the content and returns the result, with a
+	// diff against the existing file, without writing anything
+	DryRun bool
+	// ContextLines is the number of unchanged lines around each change in
+	// a dry run's diff; a negative value uses DefaultDiffContext
+	ContextLines int
+	// Check, if set, is called with the encoded content before anything is
+	// written, in dry runs too; an error refuses the write
+	Check ContentCheck
 }
 
 // CopyResult represents the result of a copy operation
@@ -73,38 +137,73 @@ type CopyResult struct {
 	Destination string `json:"destination"`
 	BytesCopied int64  `json:"bytesCopied"`
 	IsDirectory bool   `json:"isDirectory"`
+	BackupID    string `json:"backupId,omitempty"` // backup of an overwritten destination
+	DryRun      bool   `json:"dryRun,omitempty"`
+	// Files lists, for a dry run, each file and directory that would be written
+	Files []PlannedFile `json:"files,omitempty"`
 }
 
 // MoveResult represents the result of a move operation
 type MoveResult struct {
 	Source      string `json:"source"`
 	Destination string `json:"destination"`
+	BackupID    string `json:"backupId,omitempty"` // backup of an overwritten destination
+	DryRun      bool   `json:"dryRun,omitempty"`
+	// Files and BytesMoved describe, for a dry run, what would arrive at
+	// the destination
+	Files      []PlannedFile `json:"files,omitempty"`
+	BytesMoved int64         `json:"bytesMoved,omitempty"`
 }
 
 // DeleteResult represents the result of a delete operation
 type DeleteResult struct {
 	Path        string `json:"path"`
 	IsDirectory bool   `json:"isDirectory"`
+	BackupID    string `json:"backupId,omitempty"` // backup of what was deleted
+	DryRun      bool   `json:"dryRun,omitempty"`
+	// Files and BytesDeleted describe, for a dry run, what would be removed
+	Files        []PlannedFile `json:"files,omitempty"`
+	BytesDeleted int64         `json:"bytesDeleted,omitempty"`
 }
 
 // ModifyResult represents the result of a modify operation
 type ModifyResult struct {
-	Path         string `json:"path"`
-	Replacements int    `json:"replacements"`
-	Modified     bool   `json:"modified"`
+	Path         string       `json:"path"`
+	Replacements int          `json:"replacements"`
+	Modified     bool         `json:"modified"`
+	Diff         string       `json:"diff,omitempty"`
+	Changes      []LineChange `json:"changes,omitempty"`
+	Hash         string       `json:"hash,omitempty"`
+	BackupID     string       `json:"backupId,omitempty"` // backup of the original file
+	DryRun       bool         `json:"dryRun,omitempty"`
 }
 
 // ErrorCode represents file operation error codes
 type ErrorCode string
 
 const (
-	ErrInvalidPath   ErrorCode = "INVALID_PATH"
-	ErrFileNotFound  ErrorCode = "FILE_NOT_FOUND"
-	ErrFileTooLarge  ErrorCode = "FILE_TOO_LARGE"
-	ErrPermission    ErrorCode = "PERMISSION_DENIED"
-	ErrAlreadyExists ErrorCode = "ALREADY_EXISTS"
-	ErrNotEmpty      ErrorCode = "NOT_EMPTY"
-	ErrUnknown       ErrorCode = "UNKNOWN_ERROR"
+	ErrInvalidPath     ErrorCode = "INVALID_PATH"
+	ErrFileNotFound    ErrorCode = "FILE_NOT_FOUND"
+	ErrFileTooLarge    ErrorCode = "FILE_TOO_LARGE"
+	ErrPermission      ErrorCode = "PERMISSION_DENIED"
+	ErrAlreadyExists   ErrorCode = "ALREADY_EXISTS"
+	ErrNotEmpty        ErrorCode = "NOT_EMPTY"
+	ErrInvalidEncoding ErrorCode = "INVALID_ENCODING"
+	ErrBinaryFile      ErrorCode = "BINARY_FILE"
+	ErrArchiveLimit    ErrorCode = "ARCHIVE_LIMIT_EXCEEDED"
+	ErrNoMatch         ErrorCode = "NO_MATCH"
+	ErrAmbiguousMatch  ErrorCode = "AMBIGUOUS_MATCH"
+	ErrInvalidPatch    ErrorCode = "INVALID_PATCH"
+	ErrConflict        ErrorCode = "CONFLICT"
+	ErrSecretDetected  ErrorCode = "SECRET_DETECTED"
+	ErrReadOnlyPath    ErrorCode = "READ_ONLY_PATH"
+	ErrExtension       ErrorCode = "EXTENSION_NOT_ALLOWED"
+	ErrContentTooLarge ErrorCode = "CONTENT_TOO_LARGE"
+	ErrAccessDenied    ErrorCode = "ACCESS_DENIED"
+	ErrInvalidArgument ErrorCode = "INVALID_ARGUMENT"
+	ErrNotApplied      ErrorCode = "NOT_APPLIED"
+	ErrDisabled        ErrorCode = "DISABLED"
+	ErrUnknown         ErrorCode = "UNKNOWN_ERROR"
 )
 
 // FileError represents a file operation error
@@ -115,10 +214,14 @@ type FileError struct {
 }
 
 func (e *FileError) Error() string {
+	if e.Path == "" {
+		return fmt.Sprintf("%s: %s", e.Code, e.Message)
+	}
 	return fmt.Sprintf("%s: %s (path: %s)", e.Code, e.Message, e.Path)
 }
 
-// DefaultIgnorePatterns contains patterns to ignore by default
+// DefaultIgnorePatterns contains base names to ignore when walking outside a
+// git repository or when .gitignore files are not respected
 var DefaultIgnorePatterns = []string{
 	".git",
 	"node_modules",
@@ -168,6 +271,16 @@ func GetMimeType(path string) string {
 		return "text/toml"
 	case ".json":
 		return "application/json"
+	case ".pdf":
+		return extract.MimePDF
+	case ".docx":
+		return extract.MimeDOCX
+	case ".xlsx":
+		return extract.MimeXLSX
+	case ".pptx":
+		return extract.MimePPTX
+	case ".ipynb":
+		return extract.MimeNotebook
 	}
 
 	// Fall back to system MIME type detection
@@ -200,8 +313,14 @@ func GetFileMetadata(path string) (*FileMetadata, error) {
 	}, nil
 }
 
-// ReadFile reads a file with optional size limit
+// ReadFile reads a file with optional size limit, detecting its encoding
 func ReadFile(path string, maxSize int64) (*FileContent, error) {
+	return ReadFileWithEncoding(path, maxSize, EncodingAuto)
+}
+
+// ReadFileWithEncoding reads a file with optional size limit and transcodes
+// it from the given encoding ("auto" to detect) to UTF-8
+func ReadFileWithEncoding(path string, maxSize int64, encoding string) (*FileContent, error) {
 	metadata, err := GetFileMetadata(path)
 	if err != nil {
 		return nil, err
@@ -215,28 +334,83 @@ func ReadFile(path string, maxSize int64) (*FileContent, error) {
 		return nil, &FileError{Code: ErrFileTooLarge, Message: fmt.Sprintf("File exceeds max size of %d bytes", maxSize), Path: path}
 	}
 
-	content, err := os.ReadFile(path)
+	raw, err := os.ReadFile(path)
 	if err != nil {
 		return nil, &FileError{Code: ErrUnknown, Message: err.Error(), Path: path}
 	}
 
-	lines := strings.Count(string(content), "\n")
+	return decodeFileContent(path, raw, metadata, encoding)
+}
+
+// decodeFileContent converts the raw bytes of a file to a FileContent,
+// extracting documents and rejecting binary content when encoding is "auto"
+func decodeFileContent(path string, raw []byte, metadata *FileMetadata, encoding string) (*FileContent, error) {
+	// Documents with a registered extractor are returned as extracted text;
+	// other binary files are only decoded as text when an encoding is forced
+	if enc, _ := NormalizeEncoding(encoding); enc == EncodingAuto {
+		if extract.Supported(metadata.MimeType) {
+			return extractFile(path, raw, metadata)
+		}
+		if IsBinaryMimeType(metadata.MimeType) || IsBinaryContent(raw) {
+			return nil, &FileError{Code: ErrBinaryFile, Message: "File appears to be binary", Path: path}
+		}
+	}
+
+	content, encInfo, err := Decode(raw, encoding)
+	if err != nil {
+		return nil, &FileError{Code: ErrInvalidEncoding, Message: err.Error(), Path: path}
+	}
+
+	lines := strings.Count(content, "\n")
 	if len(content) > 0 && content[len(content)-1] != '\n' {
 		lines++
 	}
 
 	return &FileContent{
-		Content:    string(content),
+		Content:    content,
 		Metadata:   *metadata,
-		Encoding:   "utf-8",
+		Encoding:   encInfo.Encoding,
 		Truncated:  false,
 		TotalLines: lines,
 		Path:       path,
+		BOM:        encInfo.BOM,
+		LineEnding: DetectLineEnding(content),
+		Hash:       ContentHash(raw),
+	}, nil
+}
+
+// extractFile builds a FileContent from the text extracted from a document
+func extractFile(path string, raw []byte, metadata *FileMetadata) (*FileContent, error) {
+	content, err := extract.Extract(metadata.MimeType, raw)
+	if err != nil {
+		return nil, &FileError{Code: ErrBinaryFile, Message: err.Error(), Path: path}
+	}
+
+	lines := strings.Count(content, "\n")
+	if len(content) > 0 && content[len(content)-1] != '\n' {
+		lines++
+	}
+
+	return &FileContent{
+		Content:       content,
+		Metadata:      *metadata,
+		Encoding:      EncodingUTF8,
+		TotalLines:    lines,
+		Path:          path,
+		LineEnding:    DetectLineEnding(content),
+		ExtractedFrom: metadata.MimeType,
+		Hash:          ContentHash(raw),
 	}, nil
 }
 
 // ListFiles lists files in a directory
 func ListFiles(dirPath string, recursive bool, fileTypes []string, includeHidden bool) ([]FileEntry, error) {
+	return ListFilesWithOptions(dirPath, recursive, fileTypes, includeHidden, DefaultWalkOptions)
+}
+
+// ListFilesWithOptions lists files in a directory, skipping ignored paths
+// according to opts
+func ListFilesWithOptions(dirPath string, recursive bool, fileTypes []string, includeHidden bool, opts WalkOptions) ([]FileEntry, error) {
 	metadata, err := GetFileMetadata(dirPath)
 	if err != nil {
 		return nil, err
@@ -246,6 +420,11 @@ func ListFiles(dirPath string, recursive bool, fileTypes []string, includeHidden
 		return nil, &FileError{Code: ErrInvalidPath, Message: "Path is not a directory", Path: dirPath}
 	}
 
+	filter, err := newEntryFilter(dirPath, fileTypes, includeHidden, opts)
+	if err != nil {
+		return nil, err
+	}
+
 	var entries []FileEntry
 	walkFn := func(path string, d os.DirEntry, err error) error {
 		if err != nil {
@@ -260,37 +439,13 @@ func ListFiles(dirPath string, recursive bool, fileTypes []string, includeHidden
 
 		name := d.Name()
 
-		// Skip hidden files if not included
-		if !includeHidden && strings.HasPrefix(name, ".") {
-			if d.IsDir() {
-				return filepath.SkipDir
-			}
-			return nil
-		}
-
-		// Skip ignored patterns
-		for _, pattern := range DefaultIgnorePatterns {
-			if matched, _ := doublestar.Match(pattern, name); matched {
-				if d.IsDir() {
-					return filepath.SkipDir
-				}
-				return nil
-			}
+		// Skip hidden, ignored and filtered entries
+		list, descend := filter.visit(path, name, d.IsDir())
+		if d.IsDir() && !descend {
+			return filepath.SkipDir
 		}
-
-		// Filter by file types
-		if !d.IsDir() && len(fileTypes) > 0 {
-			ext := strings.TrimPrefix(filepath.Ext(name), ".")
-			found := false
-			for _, ft := range fileTypes {
-				if strings.EqualFold(ext, ft) {
-					found = true
-					break
-				}
-			}
-			if !found {
-				return nil
-			}
+		if !list || !filter.allowLink(path, d) {
+			return nil
 		}
 
 		info, err := d.Info()
@@ -308,6 +463,7 @@ func ListFiles(dirPath string, recursive bool, fileTypes []string, includeHidden
 				CreatedTime:  info.ModTime(),
 				IsDirectory:  d.IsDir(),
 			},
+			Symlink: entrySymlink(path, d),
 		})
 
 		if !recursive && d.IsDir() {
@@ -320,7 +476,7 @@ func ListFiles(dirPath string, recursive bool, fileTypes []string, includeHidden
 	if recursive {
 		err = filepath.WalkDir(dirPath, walkFn)
 	} else {
-		entries, err = readDirNonRecursive(dirPath, fileTypes, includeHidden)
+		entries, err = readDirNonRecursive(dirPath, filter)
 	}
 
 	if err != nil {
@@ -330,7 +486,7 @@ func ListFiles(dirPath string, recursive bool, fileTypes []string, includeHidden
 	return entries, nil
 }
 
-func readDirNonRecursive(dirPath string, fileTypes []string, includeHidden bool) ([]FileEntry, error) {
+func readDirNonRecursive(dirPath string, filter *entryFilter) ([]FileEntry, error) {
 	dirEntries, err := os.ReadDir(dirPath)
 	if err != nil {
 		return nil, err
@@ -340,43 +496,18 @@ func readDirNonRecursive(dirPath string, fileTypes []string, includeHidden bool)
 	for _, d := range dirEntries {
 		name := d.Name()
 
-		if !includeHidden && strings.HasPrefix(name, ".") {
-			continue
-		}
+		fullPath := filepath.Join(dirPath, name)
 
-		// Skip ignored patterns
-		skip := false
-		for _, pattern := range DefaultIgnorePatterns {
-			if matched, _ := doublestar.Match(pattern, name); matched {
-				skip = true
-				break
-			}
-		}
-		if skip {
+		// Skip hidden, ignored and filtered entries
+		if list, _ := filter.visit(fullPath, name, d.IsDir()); !list || !filter.allowLink(fullPath, d) {
 			continue
 		}
 
-		// Filter by file types
-		if !d.IsDir() && len(fileTypes) > 0 {
-			ext := strings.TrimPrefix(filepath.Ext(name), ".")
-			found := false
-			for _, ft := range fileTypes {
-				if strings.EqualFold(ext, ft) {
-					found = true
-					break
-				}
-			}
-			if !found {
-				continue
-			}
-		}
-
 		info, err := d.Info()
 		if err != nil {
 			continue
 		}
 
-		fullPath := filepath.Join(dirPath, name)
 		entries = append(entries, FileEntry{
 			Path: filepath.ToSlash(fullPath),
 			Name: name,
@@ -387,6 +518,7 @@ func readDirNonRecursive(dirPath string, fileTypes []string, includeHidden bool)
 				CreatedTime:  info.ModTime(),
 				IsDirectory:  d.IsDir(),
 			},
+			Symlink: entrySymlink(fullPath, d),
 		})
 	}
 
@@ -395,141 +527,169 @@ func readDirNonRecursive(dirPath string, fileTypes []string, includeHidden bool)
 
 // SearchFiles searches for a pattern in files
 func SearchFiles(basePath string, pattern string, recursive bool, fileTypes []string, contextLines int, maxResults int) (*SearchResult, error) {
-	re, err := regexp.Compile(pattern)
-	if err != nil {
-		return nil, &FileError{Code: ErrInvalidPath, Message: fmt.Sprintf("Invalid regex pattern: %s", err.Error()), Path: basePath}
-	}
+	return SearchFilesWithOptions(basePath, pattern, recursive, fileTypes, contextLines, maxResults, SearchOptions{Walk: DefaultWalkOptions})
+}
 
-	entries, err := ListFiles(basePath, recursive, fileTypes, false)
+// ReadDirectory reads all files in a directory and returns their contents
+func ReadDirectory(dirPath string, recursive bool, fileTypes []string, maxSize int64) (map[string]*FileContent, error) {
+	return ReadDirectoryWithOptions(dirPath, recursive, fileTypes, maxSize, DefaultWalkOptions)
+}
+
+// ReadDirectoryWithOptions reads all files in a directory, skipping ignored
+// paths according to opts
+func ReadDirectoryWithOptions(dirPath string, recursive bool, fileTypes []string, maxSize int64, opts WalkOptions) (map[string]*FileContent, error) {
+	entries, err := ListFilesWithOptions(dirPath, recursive, fileTypes, false, opts)
 	if err != nil {
 		return nil, err
 	}
 
-	var matches []SearchMatch
+	contents := make(map[string]*FileContent)
 	for _, entry := range entries {
 		if entry.Metadata.IsDirectory {
 			continue
 		}
 
-		fileMatches, err := searchInFile(entry.Path, re, contextLines)
+		content, err := ReadFile(entry.Path, maxSize)
 		if err != nil {
 			continue // Skip files that can't be read
 		}
 
-		matches = append(matches, fileMatches...)
-
-		if maxResults > 0 && len(matches) >= maxResults {
-			matches = matches[:maxResults]
-			break
-		}
+		contents[entry.Path] = content
 	}
 
-	return &SearchResult{
-		Matches: matches,
-		Total:   len(matches),
-	}, nil
+	return contents, nil
 }
 
-func searchInFile(path string, re *regexp.Regexp, contextLines int) ([]SearchMatch, error) {
-	file, err := os.Open(path)
-	if err != nil {
+// WriteFile creates a new file or overwrites an existing file with content,
+// keeping the existing file's encoding and line endings
+func WriteFile(path string, content string) (*WriteResult, error) {
+	return WriteFileWithOptions(path, content, WriteOptions{})
+}
+
+// WriteFileWithOptions creates a new file or overwrites an existing file with
+// content, encoded according to opts. The file is replaced atomically, keeping
+// its permissions and owner; a symlink is written through to its target.
+func WriteFileWithOptions(path string, content string, opts WriteOptions) (*WriteResult, error) {
+	// Check if file exists to determine if we're creating or overwriting
+	existing, err := os.ReadFile(path)
+	created := os.IsNotExist(err)
+	if err := checkHash(path, opts.ExpectedHash, existing, !created); err != nil {
 		return nil, err
 	}
-	defer file.Close()
-
-	var matches []SearchMatch
-	var lines []string
 
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		lines = append(lines, scanner.Text())
+	encInfo, lineEnding, err := resolveWriteEncoding(existing, !created, opts)
+	if err != nil {
+		return nil, &FileError{Code: ErrInvalidEncoding, Message: err.Error(), Path: path}
 	}
 
-	for i, line := range lines {
-		if re.MatchString(line) {
-			var before, after []string
+	content = ConvertLineEndings(content, lineEnding)
+	data, err := Encode(content, encInfo)
+	if err != nil {
+		return nil, &FileError{Code: ErrInvalidEncoding, Message: err.Error(), Path: path}
+	}
+	result := &WriteResult{
+		Path:         path,
+		BytesWritten: int64(len(data)),
+		Created:      created,
+		Encoding:     encInfo.Encoding,
+		LineEnding:   DetectLineEnding(content),
+		Hash:         ContentHash(data),
+	}
+	if opts.Check != nil {
+		if err := opts.Check(path, data); err != nil {
+			return nil, err
+		}
+	}
 
-			// Get context before
-			start := i - contextLines
-			if start < 0 {
-				start = 0
-			}
-			before = lines[start:i]
+	if opts.DryRun {
+		result.DryRun = true
+		result.Diff = writeDiff(path, existing, created, content, opts.ContextLines)
+		return result, nil
+	}
 
-			// Get context after
-			end := i + contextLines + 1
-			if end > len(lines) {
-				end = len(lines)
-			}
-			if i+1 < len(lines) {
-				after = lines[i+1 : end]
-			}
+	// Ensure parent directory exists
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, &FileError{Code: ErrPermission, Message: fmt.Sprintf("Failed to create parent directory: %s", err.Error()), Path: path}
+	}
 
-			matches = append(matches, SearchMatch{
-				Path:    filepath.ToSlash(path),
-				Line:    i + 1,
-				Content: line,
-				Context: SearchContext{
-					Before: before,
-					After:  after,
-				},
-			})
+	if opts.Backup != nil && !created {
+		if result.BackupID, err = backupTarget(opts.Backup, path); err != nil {
+			return nil, err
 		}
 	}
-
-	return matches, nil
+	mode := opts.Mode
+	if mode == 0 {
+		mode = DefaultFileMode
+	}
+	if err := writeFileAtomic(path, data, mode); err != nil {
+		if os.IsPermission(err) {
+			return nil, &FileError{Code: ErrPermission, Message: "Permission denied", Path: path}
+		}
+		return nil, &FileError{Code: ErrUnknown, Message: err.Error(), Path: path}
+	}
+	return result, nil
 }
 
-// ReadDirectory reads all files in a directory and returns their contents
-func ReadDirectory(dirPath string, recursive bool, fileTypes []string, maxSize int64) (map[string]*FileContent, error) {
-	entries, err := ListFiles(dirPath, recursive, fileTypes, false)
+// writeDiff returns the diff of writing content over the existing file at
+// path, or of creating it. An existing binary file gets no diff.
+func writeDiff(path string, existing []byte, created bool, content string, context int) string {
+	if context < 0 {
+		context = DefaultDiffContext
+	}
+	if created {
+		return UnifiedDiff("/dev/null", path, "", content, context)
+	}
+	if IsBinaryContent(existing) {
+		return ""
+	}
+	text, _, err := Decode(existing, DetectEncoding(existing).Encoding)
 	if err != nil {
-		return nil, err
+		return ""
 	}
+	return UnifiedDiff(path, path, text, content, context)
+}
 
-	contents := make(map[string]*FileContent)
-	for _, entry := range entries {
-		if entry.Metadata.IsDirectory {
-			continue
-		}
+// resolveWriteEncoding decides the encoding and line ending style for a write,
+// falling back to those of the existing file when opts leaves them on auto
+func resolveWriteEncoding(existing []byte, exists bool, opts WriteOptions) (EncodingInfo, string, error) {
+	enc, err := NormalizeEncoding(opts.Encoding)
+	if err != nil {
+		return EncodingInfo{}, "", err
+	}
 
-		content, err := ReadFile(entry.Path, maxSize)
-		if err != nil {
-			continue // Skip files that can't be read
+	var original EncodingInfo
+	originalLineEnding := LineEndingNone
+	if exists {
+		original = DetectEncoding(existing)
+		if text, _, err := Decode(existing, original.Encoding); err == nil {
+			originalLineEnding = DetectLineEnding(text)
 		}
-
-		contents[entry.Path] = content
 	}
 
-	return contents, nil
-}
-
-// WriteFile creates a new file or overwrites an existing file with content
-func WriteFile(path string, content string) (*WriteResult, error) {
-	// Check if file exists to determine if we're creating or overwriting
-	_, err := os.Stat(path)
-	created := os.IsNotExist(err)
-
-	// Ensure parent directory exists
-	dir := filepath.Dir(path)
-	if err := os.MkdirAll(dir, 0755); err != nil {
-		return nil, &FileError{Code: ErrPermission, Message: fmt.Sprintf("Failed to create parent directory: %s", err.Error()), Path: path}
+	info := EncodingInfo{Encoding: EncodingUTF8}
+	switch {
+	case enc == EncodingAuto && exists:
+		info = original
+	case enc == EncodingUTF16LE || enc == EncodingUTF16BE:
+		info = EncodingInfo{Encoding: enc, BOM: true}
+	case enc != EncodingAuto:
+		info = EncodingInfo{Encoding: enc, BOM: enc == EncodingUTF8 && original.Encoding == EncodingUTF8 && original.BOM}
 	}
 
-	// Write the file
-	data := []byte(content)
-	if err := os.WriteFile(path, data, 0644); err != nil {
-		if os.IsPermission(err) {
-			return nil, &FileError{Code: ErrPermission, Message: "Permission denied", Path: path}
+	lineEnding := strings.ToLower(strings.TrimSpace(opts.LineEnding))
+	switch lineEnding {
+	case LineEndingLF, LineEndingCRLF:
+	case "", LineEndingAuto:
+		lineEnding = ""
+		if originalLineEnding == LineEndingLF || originalLineEnding == LineEndingCRLF {
+			lineEnding = originalLineEnding
 		}
-		return nil, &FileError{Code: ErrUnknown, Message: err.Error(), Path: path}
+	default:
+		return EncodingInfo{}, "", fmt.Errorf("unsupported line ending %q (supported: auto, lf, crlf)", opts.LineEnding)
 	}
 
-	return &WriteResult{
-		Path:         path,
-		BytesWritten: int64(len(data)),
-		Created:      created,
-	}, nil
+	return info, lineEnding, nil
 }
 
 // CreateDirectory creates a new directory (including parent directories if needed)
@@ -755,77 +915,11 @@ func DeleteFile(path string, recursive bool) (*DeleteResult, error) {
 
 // ModifyFile performs find and replace operations on a file
 func ModifyFile(path string, find string, replace string, allOccurrences bool, useRegex bool) (*ModifyResult, error) {
-	// Read the file
-	content, err := os.ReadFile(path)
-	if err != nil {
-		if os.IsNotExist(err) {
-			return nil, &FileError{Code: ErrFileNotFound, Message: "File not found", Path: path}
-		}
-		if os.IsPermission(err) {
-			return nil, &FileError{Code: ErrPermission, Message: "Permission denied", Path: path}
-		}
-		return nil, &FileError{Code: ErrUnknown, Message: err.Error(), Path: path}
-	}
-
-	originalContent := string(content)
-	var newContent string
-	var replacements int
-
-	if useRegex {
-		re, err := regexp.Compile(find)
-		if err != nil {
-			return nil, &FileError{Code: ErrInvalidPath, Message: fmt.Sprintf("Invalid regex pattern: %s", err.Error()), Path: path}
-		}
-
-		if allOccurrences {
-			matches := re.FindAllStringIndex(originalContent, -1)
-			replacements = len(matches)
-			newContent = re.ReplaceAllString(originalContent, replace)
-		} else {
-			if re.MatchString(originalContent) {
-				replacements = 1
-				newContent = re.ReplaceAllStringFunc(originalContent, func(match string) string {
-					if replacements == 1 {
-						replacements = 0 // Mark as replaced
-						return re.ReplaceAllString(match, replace)
-					}
-					return match
-				})
-				replacements = 1 // Reset to correct count
-			} else {
-				newContent = originalContent
-				replacements = 0
-			}
-		}
-	} else {
-		if allOccurrences {
-			replacements = strings.Count(originalContent, find)
-			newContent = strings.ReplaceAll(originalContent, find, replace)
-		} else {
-			if strings.Contains(originalContent, find) {
-				replacements = 1
-				newContent = strings.Replace(originalContent, find, replace, 1)
-			} else {
-				newContent = originalContent
-				replacements = 0
-			}
-		}
-	}
-
-	modified := newContent != originalContent
-
-	if modified {
-		if err := os.WriteFile(path, []byte(newContent), 0644); err != nil {
-			if os.IsPermission(err) {
-				return nil, &FileError{Code: ErrPermission, Message: "Permission denied", Path: path}
-			}
-			return nil, &FileError{Code: ErrUnknown, Message: err.Error(), Path: path}
-		}
-	}
-
-	return &ModifyResult{
-		Path:         path,
-		Replacements: replacements,
-		Modified:     modified,
-	}, nil
+	return ModifyFileWithOptions(path, ModifyOptions{
+		Find:           find,
+		Replace:        replace,
+		AllOccurrences: allOccurrences,
+		Regex:          useRegex,
+		ContextLines:   DefaultDiffContext,
+	})
 }
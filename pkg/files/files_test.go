@@ -53,6 +53,17 @@ func TestReadFileNotFound(t *testing.T) {
 	}
 }
 
+func TestFileErrorString(t *testing.T) {
+	err := &FileError{Code: ErrNotEmpty, Message: "Directory is not empty", Path: "/tmp/dir"}
+	if got := err.Error(); got != "NOT_EMPTY: Directory is not empty (path: /tmp/dir)" {
+		t.Errorf("Error() = %q", got)
+	}
+	err = &FileError{Code: ErrInvalidArgument, Message: "find is required"}
+	if got := err.Error(); got != "INVALID_ARGUMENT: find is required" {
+		t.Errorf("Error() without a path = %q", got)
+	}
+}
+
 func TestListFiles(t *testing.T) {
 	tmpDir := t.TempDir()
 
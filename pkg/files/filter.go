@@ -0,0 +1,152 @@
+package files
+
+import (
+	"fmt"
+	"io/fs"
+	pathpkg "path"
+	"path/filepath"
+	"strings"
+
+	"github.com/bmatcuk/doublestar/v4"
+)
+
+// PathFilter selects walk entries by extension and by include/exclude
+// doublestar globs matched against slash-separated paths relative to the
+// walk root
+type PathFilter struct {
+	fileTypes []string
+	include   []string
+	exclude   []string
+}
+
+// NewPathFilter builds a filter from a list of extensions (without leading
+// dots) and the include/exclude globs in opts
+func NewPathFilter(fileTypes []string, opts WalkOptions) (*PathFilter, error) {
+	for _, pattern := range append(append([]string{}, opts.Include...), opts.Exclude...) {
+		if !doublestar.ValidatePattern(pattern) {
+			return nil, &FileError{Code: ErrInvalidArgument, Message: fmt.Sprintf("Invalid glob pattern: %s", pattern)}
+		}
+	}
+
+	return &PathFilter{
+		fileTypes: fileTypes,
+		include:   opts.Include,
+		exclude:   opts.Exclude,
+	}, nil
+}
+
+// Restricted reports whether include globs or extensions limit which files match
+func (f *PathFilter) Restricted() bool {
+	return len(f.fileTypes) > 0 || len(f.include) > 0
+}
+
+// SkipDir reports whether a directory and everything below it is excluded.
+// Include globs never prune directories, since matching files may lie below.
+func (f *PathFilter) SkipDir(rel string) bool {
+	return matchAny(f.exclude, rel)
+}
+
+// ListDir reports whether a directory should appear in listings: always,
+// unless include globs are given and none of them matches the directory
+func (f *PathFilter) ListDir(rel string) bool {
+	return len(f.include) == 0 || matchAny(f.include, rel)
+}
+
+// MatchFile reports whether a file passes the extension, include and
+// exclude filters
+func (f *PathFilter) MatchFile(rel string) bool {
+	if len(f.fileTypes) > 0 {
+		ext := strings.TrimPrefix(pathpkg.Ext(rel), ".")
+		found := false
+		for _, ft := range f.fileTypes {
+			if strings.EqualFold(ext, strings.TrimPrefix(ft, ".")) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if len(f.include) > 0 && !matchAny(f.include, rel) {
+		return false
+	}
+
+	return !matchAny(f.exclude, rel)
+}
+
+// MatchTree reports whether a file passes MatchFile and no directory above
+// it is excluded. Use it for paths that did not come from a filtered walk.
+func (f *PathFilter) MatchTree(rel string) bool {
+	for dir := pathpkg.Dir(rel); dir != "." && dir != "/"; dir = pathpkg.Dir(dir) {
+		if f.SkipDir(dir) {
+			return false
+		}
+	}
+	return f.MatchFile(rel)
+}
+
+func matchAny(patterns []string, rel string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := doublestar.Match(pattern, rel); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// entryFilter combines the hidden-file rule, ignore files and a PathFilter
+// for one directory walk
+type entryFilter struct {
+	root          string
+	includeHidden bool
+	ignorer       *Ignorer
+	paths         *PathFilter
+	symlink       func(string) bool
+}
+
+func newEntryFilter(root string, fileTypes []string, includeHidden bool, opts WalkOptions) (*entryFilter, error) {
+	paths, err := NewPathFilter(fileTypes, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &entryFilter{
+		root:          root,
+		includeHidden: includeHidden,
+		ignorer:       NewIgnorer(root, opts),
+		paths:         paths,
+		symlink:       opts.Symlink,
+	}, nil
+}
+
+// allowLink reports whether the entry d at path may be used, which is
+// always true unless it is a symlink the walk options reject
+func (f *entryFilter) allowLink(path string, d fs.DirEntry) bool {
+	return d.Type()&fs.ModeSymlink == 0 || f.symlink == nil || f.symlink(path)
+}
+
+// visit reports whether an entry belongs in the results and, for
+// directories, whether the walk should descend into it
+func (f *entryFilter) visit(path string, name string, isDir bool) (list bool, descend bool) {
+	if !f.includeHidden && strings.HasPrefix(name, ".") {
+		return false, false
+	}
+	if f.ignorer.Ignored(path, isDir) {
+		return false, false
+	}
+
+	rel, err := filepath.Rel(f.root, path)
+	if err != nil {
+		return false, false
+	}
+	rel = filepath.ToSlash(rel)
+
+	if isDir {
+		if f.paths.SkipDir(rel) {
+			return false, false
+		}
+		return f.paths.ListDir(rel), true
+	}
+	return f.paths.MatchFile(rel), false
+}
@@ -0,0 +1,118 @@
+package files
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPathFilter(t *testing.T) {
+	filter, err := NewPathFilter([]string{".go"}, WalkOptions{
+		Include: []string{"pkg/**"},
+		Exclude: []string{"**/*_test.go", "pkg/gen"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := []struct {
+		rel  string
+		want bool
+	}{
+		{"pkg/files/files.go", true},
+		{"pkg/files/FILES.GO", true},
+		{"pkg/files/files_test.go", false},
+		{"pkg/files/README.md", false},
+		{"main.go", false},
+	}
+	for _, tt := range files {
+		if got := filter.MatchFile(tt.rel); got != tt.want {
+			t.Errorf("MatchFile(%q) = %v, want %v", tt.rel, got, tt.want)
+		}
+	}
+
+	if !filter.SkipDir("pkg/gen") || filter.SkipDir("pkg") {
+		t.Error("expected only pkg/gen to be pruned")
+	}
+	if !filter.ListDir("pkg/files") || filter.ListDir("cmd") {
+		t.Error("expected only directories matching include to be listed")
+	}
+	if filter.MatchTree("pkg/gen/types.go") || !filter.MatchTree("pkg/files/files.go") {
+		t.Error("expected MatchTree to honour excluded parent directories")
+	}
+
+	if _, err := NewPathFilter(nil, WalkOptions{Include: []string{"[abc"}}); err == nil {
+		t.Error("expected error for invalid glob")
+	} else if fe, ok := err.(*FileError); !ok || fe.Code != ErrInvalidArgument {
+		t.Errorf("expected INVALID_ARGUMENT, got %v", err)
+	}
+}
+
+func TestIncludeExclude(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{
+		"main.go":                 "package main\n// TODO: main\n",
+		"README.md":               "TODO: docs\n",
+		"pkg/files/files.go":      "package files\n// TODO: files\n",
+		"pkg/files/files_test.go": "package files\n// TODO: test\n",
+		"pkg/gen/gen.go":          "package gen\n// TODO: gen\n",
+	})
+
+	opts := WalkOptions{Include: []string{"pkg/**/*.go"}, Exclude: []string{"pkg/gen/**"}}
+	got := strings.Join(listedFiles(t, root, opts), ",")
+	if want := "pkg/files/files.go,pkg/files/files_test.go"; got != want {
+		t.Errorf("ListFilesWithOptions = %s, want %s", got, want)
+	}
+
+	opts.Exclude = append(opts.Exclude, "**/*_test.go")
+	result, err := SearchFilesWithOptions(root, "TODO", true, nil, 0, 0, SearchOptions{Walk: opts})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Total != 1 || !strings.HasSuffix(filepath.ToSlash(result.Matches[0].Path), "pkg/files/files.go") {
+		t.Errorf("unexpected search result %+v", result)
+	}
+
+	contents, err := ReadDirectoryWithOptions(root, true, nil, 0, WalkOptions{Exclude: []string{"pkg"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(contents) != 2 {
+		t.Errorf("expected main.go and README.md, got %d files", len(contents))
+	}
+
+	// Non-recursive listings hide directories that include globs don't match
+	entries, err := ListFilesWithOptions(root, false, nil, false, WalkOptions{Include: []string{"*.md"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name != "README.md" {
+		t.Errorf("expected only README.md, got %+v", entries)
+	}
+
+	if _, err := ListFilesWithOptions(root, true, nil, false, WalkOptions{Exclude: []string{"{a"}}); err == nil {
+		t.Error("expected error for invalid exclude glob")
+	}
+}
+
+func TestArchiveIncludeExclude(t *testing.T) {
+	zipPath := filepath.Join(t.TempDir(), "release.zip")
+	writeZipFixture(t, zipPath, archiveFixture)
+
+	entries, err := ListArchive(zipPath, true, nil, false, WalkOptions{Include: []string{"**/*.go"}, Exclude: []string{"src/util"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name != "main.go" {
+		t.Errorf("expected only src/main.go, got %+v", entries)
+	}
+
+	// Globs are relative to the listed inner directory
+	entries, err = ListArchive(zipPath+"!/src", true, nil, false, WalkOptions{Include: []string{"util/*.go"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name != "util.go" {
+		t.Errorf("expected only util/util.go, got %+v", entries)
+	}
+}
@@ -0,0 +1,277 @@
+package files
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Fuzzy match scoring, modelled on fzf: every matched character scores,
+// characters starting a word or path segment earn a bonus, runs of
+// consecutive characters are rewarded and gaps between matches are
+// penalized. Matches inside the file name score extra.
+const (
+	scoreMatch        = 16
+	scoreGapStart     = -3
+	scoreGapExtension = -1
+
+	bonusSegment     = 9 // after a path separator, or at the start
+	bonusBoundary    = 8 // after a space, '_', '-' or '.'
+	bonusCamel       = 7 // lower-to-upper case change, or letter to digit
+	bonusConsecutive = 4 // minimum bonus for extending a run of matches
+	bonusBasename    = 2 // per character matched in the file name
+
+	bonusFirstCharMultiplier = 2
+)
+
+// DefaultFindLimit is the number of matches FindFiles returns by default
+const DefaultFindLimit = 20
+
+// FindOptions controls a fuzzy file-name search
+type FindOptions struct {
+	Walk          WalkOptions
+	IncludeHidden bool
+
+	// Keep, if set, is called with each file's path before it is scored;
+	// files it rejects are left out of the results
+	Keep func(path string) bool
+}
+
+// FindMatch is a file whose relative path fuzzy-matches the query
+type FindMatch struct {
+	Path  string `json:"path"`
+	Score int    `json:"score"`
+}
+
+// FindResult holds the best matches of a fuzzy file-name search
+type FindResult struct {
+	Matches []FindMatch `json:"matches"`
+	Total   int         `json:"total"`   // files that matched, before the limit
+	Scanned int         `json:"scanned"` // files compared with the query
+}
+
+// FindFiles fuzzy-matches query against the paths of files under root,
+// relative to root, and returns the limit best matches, best first.
+// Whitespace separates terms that must all match. Matching ignores case
+// unless the query contains an upper-case letter.
+func FindFiles(root string, query string, fileTypes []string, limit int, opts FindOptions) (*FindResult, error) {
+	terms := strings.Fields(query)
+	if len(terms) == 0 {
+		return nil, &FileError{Code: ErrInvalidArgument, Message: "Query must not be empty", Path: root}
+	}
+	if limit <= 0 {
+		limit = DefaultFindLimit
+	}
+
+	metadata, err := GetFileMetadata(root)
+	if err != nil {
+		return nil, err
+	}
+	if !metadata.IsDirectory {
+		return nil, &FileError{Code: ErrInvalidPath, Message: "Path is not a directory", Path: root}
+	}
+
+	filter, err := newEntryFilter(root, fileTypes, opts.IncludeHidden, opts.Walk)
+	if err != nil {
+		return nil, err
+	}
+
+	caseSensitive := strings.IndexFunc(query, unicode.IsUpper) >= 0
+	patterns := make([][]rune, len(terms))
+	for i, term := range terms {
+		patterns[i] = []rune(term)
+	}
+
+	result := &FindResult{}
+	type scored struct {
+		FindMatch
+		rel, base string
+	}
+	var matches []scored
+	err = filepath.WalkDir(root, func(path string, d os.DirEntry, err error) error {
+		if err != nil || path == root {
+			return nil // Skip errors
+		}
+
+		list, descend := filter.visit(path, d.Name(), d.IsDir())
+		if d.IsDir() {
+			if !descend {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !list || !filter.allowLink(path, d) || (opts.Keep != nil && !opts.Keep(path)) {
+			return nil
+		}
+
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return nil
+		}
+		rel = filepath.ToSlash(rel)
+		result.Scanned++
+
+		score, ok := fuzzyMatch(patterns, rel, caseSensitive)
+		if ok {
+			matches = append(matches, scored{FindMatch{Path: filepath.ToSlash(path), Score: score}, rel, d.Name()})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, &FileError{Code: ErrUnknown, Message: err.Error(), Path: root}
+	}
+
+	// Best score first; shorter file names, then shorter paths, win ties
+	sort.Slice(matches, func(i, j int) bool {
+		a, b := matches[i], matches[j]
+		if a.Score != b.Score {
+			return a.Score > b.Score
+		}
+		if len(a.base) != len(b.base) {
+			return len(a.base) < len(b.base)
+		}
+		if len(a.rel) != len(b.rel) {
+			return len(a.rel) < len(b.rel)
+		}
+		return a.rel < b.rel
+	})
+
+	result.Total = len(matches)
+	if len(matches) > limit {
+		matches = matches[:limit]
+	}
+	result.Matches = make([]FindMatch, len(matches))
+	for i, m := range matches {
+		result.Matches[i] = m.FindMatch
+	}
+	return result, nil
+}
+
+// fuzzyMatch scores a slash-separated relative path against every term.
+// ok is false unless each term is a subsequence of the path.
+func fuzzyMatch(terms [][]rune, path string, caseSensitive bool) (int, bool) {
+	text := []rune(path)
+	if !caseSensitive {
+		for i, r := range text {
+			text[i] = unicode.ToLower(r)
+		}
+	}
+	bonus := charBonuses([]rune(path))
+
+	baseStart := 0
+	for i, r := range text {
+		if r == '/' {
+			baseStart = i + 1
+		}
+	}
+
+	total := 0
+	for _, term := range terms {
+		pattern := term
+		if !caseSensitive {
+			pattern = []rune(strings.ToLower(string(term)))
+		}
+		score, ok := fuzzyScore(pattern, text, bonus, baseStart)
+		if !ok {
+			return 0, false
+		}
+		total += score
+	}
+	return total, true
+}
+
+// charBonuses returns the bonus for matching each character of text,
+// based on the character before it
+func charBonuses(text []rune) []int {
+	bonus := make([]int, len(text))
+	prev := '/'
+	for i, r := range text {
+		switch {
+		case prev == '/':
+			bonus[i] = bonusSegment
+		case prev == ' ' || prev == '_' || prev == '-' || prev == '.':
+			bonus[i] = bonusBoundary
+		case unicode.IsLower(prev) && unicode.IsUpper(r),
+			unicode.IsLetter(prev) && unicode.IsDigit(r):
+			bonus[i] = bonusCamel
+		}
+		if r == '/' || r == ' ' || r == '_' || r == '-' || r == '.' {
+			bonus[i] = 0 // Separators themselves earn nothing
+		}
+		prev = r
+	}
+	return bonus
+}
+
+// fuzzyScore finds the highest-scoring way to match pattern as a
+// subsequence of text. cur[j] is the best score of matching the pattern so
+// far with its last character at text[j], and curRun[j] the bonus carried
+// along the run of consecutive matches ending there, so a run that starts
+// at a boundary keeps that boundary's bonus throughout.
+func fuzzyScore(pattern, text []rune, bonus []int, baseStart int) (int, bool) {
+	if len(pattern) == 0 {
+		return 0, true
+	}
+	if !isSubsequence(pattern, text) {
+		return 0, false
+	}
+
+	const none = -1 << 30
+	prev, cur := make([]int, len(text)), make([]int, len(text))
+	prevRun, curRun := make([]int, len(text)), make([]int, len(text))
+	for i, pc := range pattern {
+		gap := none // best prev[k] for k < j-1, less the gap penalty up to j
+		for j, tc := range text {
+			if j >= 2 && prev[j-2] != none {
+				gap = max(gap+scoreGapExtension, prev[j-2]+scoreGapStart)
+			} else if gap != none {
+				gap += scoreGapExtension
+			}
+
+			cur[j] = none
+			if tc != pc {
+				continue
+			}
+
+			s := scoreMatch
+			if j >= baseStart {
+				s += bonusBasename
+			}
+			curRun[j] = bonus[j]
+			if i == 0 {
+				cur[j] = s + bonus[j]*bonusFirstCharMultiplier
+				continue
+			}
+			if gap != none {
+				cur[j] = gap + s + bonus[j]
+			}
+			if j >= 1 && prev[j-1] != none {
+				run := max(prevRun[j-1], bonus[j], bonusConsecutive)
+				if consecutive := prev[j-1] + s + run; consecutive > cur[j] {
+					cur[j], curRun[j] = consecutive, run
+				}
+			}
+		}
+		prev, cur = cur, prev
+		prevRun, curRun = curRun, prevRun
+	}
+
+	best := none
+	for _, s := range prev {
+		best = max(best, s)
+	}
+	return best, best != none
+}
+
+// isSubsequence reports whether every rune of pattern appears in text in order
+func isSubsequence(pattern, text []rune) bool {
+	i := 0
+	for _, r := range text {
+		if i < len(pattern) && r == pattern[i] {
+			i++
+		}
+	}
+	return i == len(pattern)
+}
@@ -0,0 +1,93 @@
+package files
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFuzzyScoreRanking(t *testing.T) {
+	score := func(query, path string) int {
+		t.Helper()
+		s, ok := fuzzyMatch([][]rune{[]rune(query)}, path, false)
+		if !ok {
+			t.Fatalf("%q should match %q", query, path)
+		}
+		return s
+	}
+
+	tests := []struct {
+		query, better, worse string
+	}{
+		// Word boundaries beat scattered characters
+		{"fb", "foo_bar.go", "fizzbuzz.go"},
+		// Camel case humps count as boundaries
+		{"fb", "pkg/FooBar.go", "pkg/fabric.go"},
+		// Consecutive characters beat a split match
+		{"auth", "pkg/auth.go", "pkg/a/u/t/h.go"},
+		// The file name beats a directory of the same name
+		{"auth", "src/middleware/auth.go", "auth/src/middleware.go"},
+	}
+	for _, tt := range tests {
+		if b, w := score(tt.query, tt.better), score(tt.query, tt.worse); b <= w {
+			t.Errorf("%q: expected %q (%d) to beat %q (%d)", tt.query, tt.better, b, tt.worse, w)
+		}
+	}
+
+	if _, ok := fuzzyMatch([][]rune{[]rune("zz")}, "main.go", false); ok {
+		t.Error("expected no match for a missing subsequence")
+	}
+	if _, ok := fuzzyMatch([][]rune{[]rune("Main")}, "main.go", true); ok {
+		t.Error("expected upper case to match case-sensitively")
+	}
+}
+
+func TestFindFiles(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{
+		".gitignore":                   "build/\n",
+		"src/middleware/auth.go":       "",
+		"src/middleware/logging.go":    "",
+		"src/handlers/authorize.go":    "",
+		"docs/authentication.md":       "",
+		"build/middleware/auth.go":     "",
+		".hidden/middleware/auth.go":   "",
+		"src/middleware/secret/key.go": "",
+	})
+
+	rel := func(result *FindResult) []string {
+		var out []string
+		for _, m := range result.Matches {
+			r, _ := filepath.Rel(root, filepath.FromSlash(m.Path))
+			out = append(out, filepath.ToSlash(r))
+		}
+		return out
+	}
+
+	result, err := FindFiles(root, "auth middleware", nil, 0, FindOptions{Walk: WalkOptions{RespectGitignore: true}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := rel(result); !reflect.DeepEqual(got, []string{"src/middleware/auth.go"}) {
+		t.Errorf("multi-term query got %v", got)
+	}
+
+	result, err = FindFiles(root, "auth", nil, 2, FindOptions{
+		Walk: WalkOptions{RespectGitignore: true},
+		Keep: func(path string) bool { return !strings.Contains(path, "docs") },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := rel(result); !reflect.DeepEqual(got, []string{"src/middleware/auth.go", "src/handlers/authorize.go"}) {
+		t.Errorf("top 2 got %v", got)
+	}
+	if result.Total != 2 || result.Matches[0].Score < result.Matches[1].Score {
+		t.Errorf("unexpected result %+v", result)
+	}
+
+	if _, err := FindFiles(root, "  ", nil, 0, FindOptions{}); err == nil {
+		t.Error("expected error for an empty query")
+	}
+}
@@ -0,0 +1,49 @@
+package files
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+)
+
+// ContentHash returns the hash used to detect that a file changed between a
+// read and a later write: the first 128 bits of the SHA-256 of its bytes, in
+// hex. It covers the raw bytes, so changes to encoding or line endings count.
+func ContentHash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:16])
+}
+
+// FileHash returns the ContentHash of the file at path without reading it
+// into memory
+func FileHash(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)[:16]), nil
+}
+
+// checkHash returns a conflict error if expected is set and does not match
+// the current content of the file at path; exists is false if there is no
+// file
+func checkHash(path string, expected string, data []byte, exists bool) error {
+	if expected == "" {
+		return nil
+	}
+	if !exists {
+		return &FileError{Code: ErrConflict, Message: fmt.Sprintf("File has changed since it was read: expected hash %s, but the file no longer exists", expected), Path: path}
+	}
+	if current := ContentHash(data); current != expected {
+		return &FileError{Code: ErrConflict, Message: fmt.Sprintf("File has changed since it was read: expected hash %s, current hash %s. Read it again and reapply the change.", expected, current), Path: path}
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package files
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExpectedHash(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "file.txt")
+	if err := os.WriteFile(path, []byte("one\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := ReadFile(path, 0)
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+	if want := ContentHash([]byte("one\n")); content.Hash != want {
+		t.Fatalf("ReadFile() hash = %q, want %q", content.Hash, want)
+	}
+	if hash, err := FileHash(path); err != nil || hash != content.Hash {
+		t.Fatalf("FileHash() = %q, %v, want %q", hash, err, content.Hash)
+	}
+
+	write, err := WriteFileWithOptions(path, "two\n", WriteOptions{ExpectedHash: content.Hash})
+	if err != nil {
+		t.Fatalf("WriteFileWithOptions() with current hash error = %v", err)
+	}
+	if write.Hash != ContentHash([]byte("two\n")) {
+		t.Errorf("WriteFileWithOptions() hash = %q", write.Hash)
+	}
+
+	// The hash read before the write is now stale
+	isConflict := func(err error) bool {
+		fe, ok := err.(*FileError)
+		return ok && fe.Code == ErrConflict
+	}
+	if _, err := WriteFileWithOptions(path, "three\n", WriteOptions{ExpectedHash: content.Hash}); !isConflict(err) {
+		t.Errorf("WriteFileWithOptions() with stale hash error = %v, want conflict", err)
+	}
+	if _, err := ModifyFileWithOptions(path, ModifyOptions{Find: "two", Replace: "three", ExpectedHash: content.Hash}); !isConflict(err) {
+		t.Errorf("ModifyFileWithOptions() with stale hash error = %v, want conflict", err)
+	}
+	patch := "--- file.txt\n+++ file.txt\n@@ -1 +1 @@\n-two\n+three\n"
+	stale, err := ApplyPatch(dir, patch, PatchOptions{ExpectedHashes: map[string]string{path: content.Hash}})
+	if err != nil {
+		t.Fatalf("ApplyPatch() error = %v", err)
+	}
+	if stale.Applied || !strings.Contains(stale.Files[0].Error, string(ErrConflict)) {
+		t.Errorf("ApplyPatch() with stale hash = %+v, want conflict", stale.Files[0])
+	}
+	if data, _ := os.ReadFile(path); string(data) != "two\n" {
+		t.Fatalf("conflicting writes changed the file to %q", data)
+	}
+
+	modify, err := ModifyFileWithOptions(path, ModifyOptions{Find: "two", Replace: "three", ExpectedHash: write.Hash})
+	if err != nil {
+		t.Fatalf("ModifyFileWithOptions() with current hash error = %v", err)
+	}
+	result, err := ApplyPatch(dir, "--- file.txt\n+++ file.txt\n@@ -1 +1 @@\n-three\n+four\n", PatchOptions{ExpectedHashes: map[string]string{path: modify.Hash}})
+	if err != nil || !result.Applied {
+		t.Fatalf("ApplyPatch() with current hash = %+v, %v", result, err)
+	}
+	if result.Files[0].Hash != ContentHash([]byte("four\n")) {
+		t.Errorf("ApplyPatch() hash = %q", result.Files[0].Hash)
+	}
+
+	// A file deleted since it was read is a conflict too
+	os.Remove(path)
+	if _, err := WriteFileWithOptions(path, "five\n", WriteOptions{ExpectedHash: result.Files[0].Hash}); !isConflict(err) {
+		t.Errorf("WriteFileWithOptions() on deleted file error = %v, want conflict", err)
+	}
+}
@@ -0,0 +1,257 @@
+package files
+
+import (
+	"bufio"
+	"os"
+	pathpkg "path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/bmatcuk/doublestar/v4"
+)
+
+// Per-directory ignore files, in increasing order of precedence
+const (
+	GitignoreFile = ".gitignore"
+	IgnoreFile    = ".ignore"
+	MCPIgnoreFile = ".mcpignore"
+)
+
+// WalkOptions controls which entries the directory walkers visit
+type WalkOptions struct {
+	// RespectGitignore applies .gitignore, .ignore and .git/info/exclude rules.
+	// .mcpignore files are always applied.
+	RespectGitignore bool
+
+	// Include and Exclude are doublestar globs matched against paths relative
+	// to the walk root. When Include is set only matching files are visited;
+	// Exclude removes matching files and directories.
+	Include []string
+	Exclude []string
+
+	// Symlink, if set, is asked about each symlink a walk meets; symlinks it
+	// rejects are skipped as if ignored. Walks never descend into symlinked
+	// directories either way.
+	Symlink func(path string) bool
+
+	// Roots, if set, are the directories walks are confined to. The search
+	// for the enclosing repository's ignore files stops at the root holding
+	// the walk, so files above it are never read.
+	Roots []string
+}
+
+// DefaultWalkOptions are used by walkers called without explicit options
+var DefaultWalkOptions = WalkOptions{RespectGitignore: true}
+
+// ignoreRule is one pattern line from an ignore file
+type ignoreRule struct {
+	pattern  string // doublestar pattern relative to base
+	base     string // slash-separated directory of the ignore file, relative to the matcher root
+	negate   bool
+	dirOnly  bool
+	anchored bool // pattern contains a slash, so it matches from base rather than any depth
+}
+
+// Ignorer decides which paths a directory walk skips, following gitignore
+// semantics: ignore files in every directory from the repository root down,
+// later and deeper rules overriding earlier ones, "!" negation, trailing "/"
+// for directories and patterns containing "/" anchored to their file's
+// directory.
+//
+// Outside a git repository, or when .gitignore is not respected,
+// DefaultIgnorePatterns apply as the lowest-precedence rules.
+type Ignorer struct {
+	root  string
+	opts  WalkOptions
+	base  []ignoreRule
+	rules map[string][]ignoreRule
+	mu    sync.Mutex
+}
+
+// NewIgnorer creates an Ignorer for a walk starting at dirPath. Ignore files
+// are read from the enclosing repository root (the nearest ancestor holding a
+// .git entry, up to the enclosing opts.Roots entry) so that parent .gitignore
+// files apply to subdirectory walks.
+func NewIgnorer(dirPath string, opts WalkOptions) *Ignorer {
+	ig := &Ignorer{root: dirPath, opts: opts, rules: make(map[string][]ignoreRule)}
+
+	repoRoot := ""
+	if opts.RespectGitignore {
+		repoRoot = findRepoRoot(dirPath, opts.Roots)
+	}
+
+	if repoRoot != "" {
+		ig.root = repoRoot
+		ig.base = append(ig.base, ignoreRule{pattern: ".git"})
+		ig.base = append(ig.base, readIgnoreFile(filepath.Join(repoRoot, ".git", "info", "exclude"), "")...)
+	} else {
+		for _, pattern := range DefaultIgnorePatterns {
+			ig.base = append(ig.base, ignoreRule{pattern: pattern})
+		}
+	}
+
+	return ig
+}
+
+// findRepoRoot returns the nearest ancestor of dirPath (inclusive) that
+// contains a .git file or directory, or "" if there is none. With roots it
+// looks no higher than the outermost root containing dirPath, or only at
+// dirPath if none does.
+func findRepoRoot(dirPath string, roots []string) string {
+	dir := filepath.Clean(dirPath)
+	limit := ""
+	if len(roots) > 0 {
+		limit = dir
+		for _, root := range roots {
+			root = filepath.Clean(root)
+			if rel, err := filepath.Rel(root, dir); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) && len(root) < len(limit) {
+				limit = root
+			}
+		}
+	}
+	for {
+		if _, err := os.Lstat(filepath.Join(dir, ".git")); err == nil {
+			return dir
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir || dir == limit {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+// Ignored reports whether path should be skipped. Only the path itself is
+// matched; walkers are expected to skip the contents of ignored directories.
+func (ig *Ignorer) Ignored(path string, isDir bool) bool {
+	rel, err := filepath.Rel(ig.root, path)
+	if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+		return false
+	}
+	rel = filepath.ToSlash(rel)
+
+	ignored := matchRules(ig.base, rel, isDir, false)
+
+	// Apply the rules of each directory from the root down to the path's parent
+	parts := strings.Split(rel, "/")
+	for i := range parts {
+		dir := strings.Join(parts[:i], "/")
+		ignored = matchRules(ig.dirRules(dir), rel, isDir, ignored)
+	}
+
+	return ignored
+}
+
+// matchRules applies rules in order; the last matching rule wins
+func matchRules(rules []ignoreRule, rel string, isDir bool, ignored bool) bool {
+	for _, rule := range rules {
+		if rule.matches(rel, isDir) {
+			ignored = !rule.negate
+		}
+	}
+	return ignored
+}
+
+func (r ignoreRule) matches(rel string, isDir bool) bool {
+	if r.dirOnly && !isDir {
+		return false
+	}
+
+	sub := rel
+	if r.base != "" {
+		if !strings.HasPrefix(rel, r.base+"/") {
+			return false
+		}
+		sub = rel[len(r.base)+1:]
+	}
+
+	if r.anchored {
+		matched, _ := doublestar.Match(r.pattern, sub)
+		return matched
+	}
+	matched, _ := doublestar.Match(r.pattern, pathpkg.Base(sub))
+	return matched
+}
+
+// dirRules returns the rules from the ignore files in dir, relative to the
+// root, loading them on first use
+func (ig *Ignorer) dirRules(dir string) []ignoreRule {
+	ig.mu.Lock()
+	defer ig.mu.Unlock()
+
+	if rules, ok := ig.rules[dir]; ok {
+		return rules
+	}
+
+	names := []string{MCPIgnoreFile}
+	if ig.opts.RespectGitignore {
+		names = []string{GitignoreFile, IgnoreFile, MCPIgnoreFile}
+	}
+
+	var rules []ignoreRule
+	absDir := filepath.Join(ig.root, filepath.FromSlash(dir))
+	for _, name := range names {
+		rules = append(rules, readIgnoreFile(filepath.Join(absDir, name), dir)...)
+	}
+
+	ig.rules[dir] = rules
+	return rules
+}
+
+// readIgnoreFile parses an ignore file, returning no rules if it is missing
+func readIgnoreFile(path string, base string) []ignoreRule {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+
+	var rules []ignoreRule
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if rule, ok := parseIgnoreLine(scanner.Text(), base); ok {
+			rules = append(rules, rule)
+		}
+	}
+	return rules
+}
+
+// parseIgnoreLine parses one line of a gitignore-format file
+func parseIgnoreLine(line string, base string) (ignoreRule, bool) {
+	line = strings.TrimSuffix(line, "\r")
+
+	// Trailing spaces are ignored unless escaped
+	for strings.HasSuffix(line, " ") && !strings.HasSuffix(line, "\\ ") {
+		line = line[:len(line)-1]
+	}
+
+	if line == "" || strings.HasPrefix(line, "#") {
+		return ignoreRule{}, false
+	}
+
+	rule := ignoreRule{base: base}
+	if strings.HasPrefix(line, "!") {
+		rule.negate = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, "\\!") || strings.HasPrefix(line, "\\#") {
+		line = line[1:]
+	}
+
+	if strings.HasSuffix(line, "/") {
+		rule.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+
+	if strings.Contains(line, "/") {
+		rule.anchored = true
+		line = strings.TrimPrefix(line, "/")
+	}
+
+	if line == "" || !doublestar.ValidatePattern(line) {
+		return ignoreRule{}, false
+	}
+
+	rule.pattern = line
+	return rule, true
+}
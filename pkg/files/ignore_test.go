@@ -0,0 +1,175 @@
+package files
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// writeTree creates files (and their parent directories) under root
+func writeTree(t *testing.T, root string, tree map[string]string) {
+	t.Helper()
+	for name, content := range tree {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// listedFiles returns the root-relative paths of the files ListFilesWithOptions finds
+func listedFiles(t *testing.T, root string, opts WalkOptions) []string {
+	t.Helper()
+	entries, err := ListFilesWithOptions(root, true, nil, false, opts)
+	if err != nil {
+		t.Fatalf("ListFilesWithOptions() error: %v", err)
+	}
+	var names []string
+	for _, e := range entries {
+		if !e.Metadata.IsDirectory {
+			rel, _ := filepath.Rel(root, filepath.FromSlash(e.Path))
+			names = append(names, filepath.ToSlash(rel))
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestParseIgnoreLine(t *testing.T) {
+	tests := []struct {
+		line string
+		ok   bool
+		want ignoreRule
+	}{
+		{"", false, ignoreRule{}},
+		{"# comment", false, ignoreRule{}},
+		{"*.log", true, ignoreRule{pattern: "*.log"}},
+		{"!keep.log", true, ignoreRule{pattern: "keep.log", negate: true}},
+		{"build/", true, ignoreRule{pattern: "build", dirOnly: true}},
+		{"/dist", true, ignoreRule{pattern: "dist", anchored: true}},
+		{"docs/*.md", true, ignoreRule{pattern: "docs/*.md", anchored: true}},
+		{"\\#file", true, ignoreRule{pattern: "#file"}},
+		{"trailing   ", true, ignoreRule{pattern: "trailing"}},
+	}
+
+	for _, tt := range tests {
+		rule, ok := parseIgnoreLine(tt.line, "")
+		if ok != tt.ok || rule != tt.want {
+			t.Errorf("parseIgnoreLine(%q) = %+v, %v; want %+v, %v", tt.line, rule, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestGitignoreSemantics(t *testing.T) {
+	root := t.TempDir()
+	if err := os.Mkdir(filepath.Join(root, ".git"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	writeTree(t, root, map[string]string{
+		".gitignore":           "*.log\n!keep.log\n/out\ntmp/\n",
+		"app.go":               "",
+		"debug.log":            "",
+		"keep.log":             "",
+		"out/bin":              "",
+		"src/out/generated.go": "",
+		"src/tmp":              "",
+		"src/.gitignore":       "*.gen.go\n",
+		"src/api.gen.go":       "",
+		"src/api.go":           "",
+		"lib/tmp/cache":        "",
+		"build/main.go":        "",
+		"vendor/dep/dep.go":    "",
+		".git/config":          "",
+	})
+
+	got := strings.Join(listedFiles(t, root, WalkOptions{RespectGitignore: true}), ",")
+	// build/ and vendor/ are real source here: only the ignore files decide
+	want := "app.go,build/main.go,keep.log,src/api.go,src/out/generated.go,src/tmp,vendor/dep/dep.go"
+	if got != want {
+		t.Errorf("with gitignore:\n got  %s\n want %s", got, want)
+	}
+
+	// Walking a subdirectory still applies the repository's root .gitignore
+	entries, err := ListFilesWithOptions(filepath.Join(root, "src"), true, nil, false, WalkOptions{RespectGitignore: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		if strings.HasSuffix(e.Path, ".gen.go") {
+			t.Errorf("expected %s to be ignored", e.Path)
+		}
+	}
+
+	// Without gitignore, the default ignore patterns apply instead
+	got = strings.Join(listedFiles(t, root, WalkOptions{RespectGitignore: false}), ",")
+	want = "app.go,debug.log,keep.log,lib/tmp/cache,out/bin,src/api.gen.go,src/api.go,src/out/generated.go,src/tmp"
+	if got != want {
+		t.Errorf("without gitignore:\n got  %s\n want %s", got, want)
+	}
+}
+
+func TestIgnoreStopsAtRoot(t *testing.T) {
+	repo := t.TempDir()
+	writeTree(t, repo, map[string]string{
+		".git/config":    "",
+		".gitignore":     "*.txt\n",
+		"sub/notes.txt":  "",
+		"sub/main.go":    "",
+		"sub/.gitignore": "",
+	})
+	sub := filepath.Join(repo, "sub")
+
+	// Unconfined walks read the repository's ignore files above the walk
+	got := strings.Join(listedFiles(t, sub, WalkOptions{RespectGitignore: true}), ",")
+	if want := "main.go"; got != want {
+		t.Errorf("unconfined: got %s, want %s", got, want)
+	}
+
+	// Confined to sub, the walk never reads the .gitignore above it
+	got = strings.Join(listedFiles(t, sub, WalkOptions{RespectGitignore: true, Roots: []string{sub}}), ",")
+	if want := "main.go,notes.txt"; got != want {
+		t.Errorf("confined: got %s, want %s", got, want)
+	}
+}
+
+func TestMCPIgnore(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{
+		".mcpignore":        "fixtures/\n!vendor\n",
+		".gitignore":        "*.tmp\n",
+		"main.go":           "",
+		"scratch.tmp":       "",
+		"fixtures/big.json": "",
+		"vendor/dep.go":     "",
+		"node_modules/x.js": "",
+	})
+
+	// Outside a repository the defaults apply, but .mcpignore can re-include them
+	got := strings.Join(listedFiles(t, root, WalkOptions{RespectGitignore: true}), ",")
+	if want := "main.go,vendor/dep.go"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+
+	// .mcpignore applies even when gitignore files are not respected
+	got = strings.Join(listedFiles(t, root, WalkOptions{RespectGitignore: false}), ",")
+	if want := "main.go,scratch.tmp,vendor/dep.go"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+
+	// Non-recursive listings use the same rules
+	entries, err := ListFilesWithOptions(root, false, nil, false, WalkOptions{RespectGitignore: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		if e.Name == "fixtures" || e.Name == "scratch.tmp" || e.Name == "node_modules" {
+			t.Errorf("expected %s to be ignored", e.Name)
+		}
+	}
+}
@@ -0,0 +1,747 @@
+package files
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// DefaultPatchFuzz is the number of leading and trailing context lines a
+// hunk may ignore to apply, as in patch(1)
+const DefaultPatchFuzz = 2
+
+// Patch operations
+const (
+	PatchModify = "modify"
+	PatchCreate = "create"
+	PatchDelete = "delete"
+	PatchRename = "rename"
+)
+
+// PatchOptions controls ApplyPatch
+type PatchOptions struct {
+	// Strip removes this many leading components from the paths in the
+	// patch, like patch -p. A negative value strips the a/ and b/ prefixes
+	// of git-style patches and nothing otherwise.
+	Strip int
+
+	// Fuzz is the number of leading and trailing context lines a hunk may
+	// ignore when its full context is not found
+	Fuzz int
+
+	// IgnoreWhitespace lets context and removed lines match lines that
+	// differ only in whitespace when no exact match is found. The file's
+	// own version of context lines is kept.
+	IgnoreWhitespace bool
+
+	// DryRun checks that every hunk applies without writing anything
+	DryRun bool
+
+	// Validate, if set, checks every path the patch reads or writes and
+	// returns the absolute path to use
+	Validate func(path string) (string, error)
+
+	// ExpectedHashes maps absolute paths to the ContentHash each file must
+	// still have. A file that has changed fails with a conflict, and paths
+	// the patch does not touch are an error.
+	ExpectedHashes map[string]string
+
+	// Backup, if set, is called with each existing file the patch changes
+	// or deletes before any is written; an error cancels the patch
+	Backup BackupFunc
+
+	// Check, if set, is called with the new content of each file the patch
+	// writes, in dry runs too; an error cancels the patch
+	Check ContentCheck
+}
+
+// PatchResult is the result of ApplyPatch
+type PatchResult struct {
+	// Applied is true when every hunk applied and, unless this is a dry
+	// run, the changes were written
+	Applied bool          `json:"applied"`
+	DryRun  bool          `json:"dryRun"`
+	Files   []PatchedFile `json:"files"`
+}
+
+// PatchedFile is the outcome of the part of a patch for one file
+type PatchedFile struct {
+	Path      string        `json:"path"`
+	OldPath   string        `json:"oldPath,omitempty"`
+	Operation string        `json:"operation"`
+	Added     int           `json:"added"`
+	Removed   int           `json:"removed"`
+	Hunks     []HunkOutcome `json:"hunks,omitempty"`
+	Error     string        `json:"error,omitempty"`
+	// Hash is the ContentHash of the file once written
+	Hash string `json:"hash,omitempty"`
+	// BackupID names the backup of the file taken before it was changed
+	BackupID string `json:"backupId,omitempty"`
+}
+
+// HunkOutcome is the outcome of one hunk. Line is where the hunk starts in
+// the new file; Offset is how far that is from where its header said, and
+// Fuzz is the number of context lines ignored at each end.
+type HunkOutcome struct {
+	Hunk               int    `json:"hunk"`
+	Header             string `json:"header"`
+	Applied            bool   `json:"applied"`
+	Line               int    `json:"line,omitempty"`
+	Offset             int    `json:"offset,omitempty"`
+	Fuzz               int    `json:"fuzz,omitempty"`
+	WhitespaceMismatch bool   `json:"whitespaceMismatch,omitempty"`
+	Error              string `json:"error,omitempty"`
+}
+
+// filePatch is the part of a patch for one file. Paths are as written in
+// the patch, before stripping; "" stands for /dev/null.
+type filePatch struct {
+	oldPath  string
+	newPath  string
+	stripped bool // paths came from git rename lines, which have no prefix
+	binary   bool
+	hunks    []*hunk
+}
+
+// hunk is one @@ section of a patch
+type hunk struct {
+	header       string
+	oldStart     int
+	oldLines     int
+	lines        []hunkLine
+	oldNoNewline bool // the last old line has no newline
+	newNoNewline bool // the last new line has no newline
+}
+
+// hunkLine is one line of a hunk: ' ' context, '-' removed or '+' added
+type hunkLine struct {
+	kind  byte
+	text  string
+	blank bool // an empty line taken as empty context
+}
+
+var hunkHeaderRe = regexp.MustCompile(`^@@ -(\d+)(?:,(\d+))? \+(\d+)(?:,(\d+))? @@`)
+
+// parsePatch splits a unified diff into per-file patches. Text outside file
+// sections, such as a commit message, is ignored. Hunk line counts are not
+// trusted, since hand-written diffs often get them wrong: a hunk ends at the
+// next header or at a line that cannot belong to a hunk.
+func parsePatch(patch string) ([]*filePatch, error) {
+	lines := strings.Split(strings.ReplaceAll(patch, "\r\n", "\n"), "\n")
+	var patches []*filePatch
+	var cur *filePatch
+	isFileHeader := func(i int) bool {
+		return strings.HasPrefix(lines[i], "--- ") && i+1 < len(lines) && strings.HasPrefix(lines[i+1], "+++ ")
+	}
+
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		switch {
+		case strings.HasPrefix(line, "diff --git "):
+			cur = &filePatch{}
+			patches = append(patches, cur)
+			if a, b, ok := splitGitPaths(strings.TrimPrefix(line, "diff --git ")); ok {
+				cur.oldPath, cur.newPath = a, b
+			}
+
+		case isFileHeader(i):
+			// A git header is followed by its own ---/+++ lines
+			if cur == nil || len(cur.hunks) > 0 || !gitExtendedHeader(lines, i) {
+				cur = &filePatch{}
+				patches = append(patches, cur)
+			}
+			if !cur.stripped {
+				cur.oldPath = headerPath(line[4:])
+				cur.newPath = headerPath(lines[i+1][4:])
+			}
+			i++
+
+		case cur != nil && strings.HasPrefix(line, "rename from "):
+			cur.oldPath, cur.stripped = strings.TrimPrefix(line, "rename from "), true
+		case cur != nil && strings.HasPrefix(line, "rename to "):
+			cur.newPath, cur.stripped = strings.TrimPrefix(line, "rename to "), true
+		case cur != nil && strings.HasPrefix(line, "new file mode"):
+			cur.oldPath = ""
+		case cur != nil && strings.HasPrefix(line, "deleted file mode"):
+			cur.newPath = ""
+		case cur != nil && (strings.HasPrefix(line, "GIT binary patch") || strings.HasPrefix(line, "Binary files ")):
+			cur.binary = true
+
+		case strings.HasPrefix(line, "@@"):
+			if cur == nil {
+				return nil, &FileError{Code: ErrInvalidPatch, Message: fmt.Sprintf("line %d: hunk before any file header", i+1)}
+			}
+			m := hunkHeaderRe.FindStringSubmatch(line)
+			if m == nil {
+				return nil, &FileError{Code: ErrInvalidPatch, Message: fmt.Sprintf("line %d: invalid hunk header %q", i+1, line)}
+			}
+			h := &hunk{header: line, oldStart: atoiDefault(m[1], 0), oldLines: atoiDefault(m[2], 1)}
+		body:
+			for i+1 < len(lines) {
+				next := lines[i+1]
+				if strings.HasPrefix(next, "@@") || strings.HasPrefix(next, "diff --git ") || isFileHeader(i+1) {
+					break
+				}
+				switch {
+				case next == "":
+					h.lines = append(h.lines, hunkLine{kind: ' ', blank: true})
+				case next[0] == ' ' || next[0] == '-' || next[0] == '+':
+					h.lines = append(h.lines, hunkLine{kind: next[0], text: next[1:]})
+				case next[0] == '\\':
+					if n := len(h.lines); n > 0 {
+						if h.lines[n-1].kind != '+' {
+							h.oldNoNewline = true
+						}
+						if h.lines[n-1].kind != '-' {
+							h.newNoNewline = true
+						}
+					}
+				default:
+					break body
+				}
+				i++
+			}
+
+			// Blank lines after the hunk, such as the end of the input, are
+			// not context unless the header counts them
+			for n := len(h.lines); n > 0 && h.lines[n-1].blank && h.countOld() > h.oldLines; n-- {
+				h.lines = h.lines[:n-1]
+			}
+			if !h.hasChanges() {
+				continue
+			}
+			cur.hunks = append(cur.hunks, h)
+		}
+	}
+
+	var valid []*filePatch
+	for _, p := range patches {
+		if p.oldPath == "" && p.newPath == "" {
+			continue
+		}
+		valid = append(valid, p)
+	}
+	if len(valid) == 0 {
+		return nil, &FileError{Code: ErrInvalidPatch, Message: "no file changes found; expected a unified diff with ---/+++ file headers and @@ hunks"}
+	}
+	return valid, nil
+}
+
+// gitExtendedHeader reports whether the lines before lines[i] are git
+// extended header lines following a diff --git line
+func gitExtendedHeader(lines []string, i int) bool {
+	for j := i - 1; j >= 0; j-- {
+		switch {
+		case strings.HasPrefix(lines[j], "diff --git "):
+			return true
+		case strings.HasPrefix(lines[j], "index "), strings.HasPrefix(lines[j], "new file mode"),
+			strings.HasPrefix(lines[j], "deleted file mode"), strings.HasPrefix(lines[j], "old mode"),
+			strings.HasPrefix(lines[j], "new mode"), strings.HasPrefix(lines[j], "similarity index"),
+			strings.HasPrefix(lines[j], "rename "), strings.HasPrefix(lines[j], "copy "):
+			continue
+		default:
+			return false
+		}
+	}
+	return false
+}
+
+// splitGitPaths splits the "a/x b/y" part of a diff --git line
+func splitGitPaths(s string) (string, string, bool) {
+	if i := strings.LastIndex(s, " b/"); i >= 0 {
+		return unquotePath(s[:i]), unquotePath(s[i+1:]), true
+	}
+	if fields := strings.Fields(s); len(fields) == 2 {
+		return unquotePath(fields[0]), unquotePath(fields[1]), true
+	}
+	return "", "", false
+}
+
+// headerPath returns the path of a ---/+++ line without its timestamp, or
+// "" for /dev/null
+func headerPath(s string) string {
+	if i := strings.IndexByte(s, '\t'); i >= 0 {
+		s = s[:i]
+	}
+	s = unquotePath(strings.TrimSpace(s))
+	if s == "/dev/null" {
+		return ""
+	}
+	return s
+}
+
+// unquotePath removes the quotes git puts around unusual paths
+func unquotePath(s string) string {
+	if len(s) >= 2 && s[0] == '"' {
+		if u, err := strconv.Unquote(s); err == nil {
+			return u
+		}
+	}
+	return s
+}
+
+func atoiDefault(s string, def int) int {
+	if n, err := strconv.Atoi(s); err == nil {
+		return n
+	}
+	return def
+}
+
+// countOld returns the number of old lines in the hunk
+func (h *hunk) countOld() int {
+	n := 0
+	for _, l := range h.lines {
+		if l.kind != '+' {
+			n++
+		}
+	}
+	return n
+}
+
+func (h *hunk) hasChanges() bool {
+	for _, l := range h.lines {
+		if l.kind != ' ' {
+			return true
+		}
+	}
+	return false
+}
+
+// stripPath removes n leading components from a patch path. A negative n
+// removes the a/ or b/ prefix of git-style paths.
+func stripPath(path string, n int, prefix string) string {
+	if n < 0 {
+		return strings.TrimPrefix(path, prefix)
+	}
+	for ; n > 0; n-- {
+		i := strings.IndexByte(path, '/')
+		if i < 0 {
+			break
+		}
+		path = path[i+1:]
+	}
+	return path
+}
+
+// patchFile is the state of a file while a patch is applied in memory
+type patchFile struct {
+	exists   bool
+	original []byte // content on disk, if it exists
+	text     string
+	info     EncodingInfo
+}
+
+// ApplyPatch applies a unified diff covering one or more files, with paths
+// relative to baseDir. It handles git-style patches with new files,
+// deletions and renames as well as plain diff -u output.
+//
+// Each hunk is looked for near the line its header names, then anywhere
+// after the previous hunk; with opts.Fuzz up to that many leading and
+// trailing context lines may be ignored, and with opts.IgnoreWhitespace
+// lines may differ in whitespace. The patch is all or nothing: if any hunk
+// fails, nothing is written and the result reports every hunk's outcome,
+// with the closest partial match for hunks that failed. Files keep their
+// encoding and line endings.
+func ApplyPatch(baseDir string, patch string, opts PatchOptions) (*PatchResult, error) {
+	patches, err := parsePatch(patch)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Fuzz < 0 {
+		opts.Fuzz = 0
+	}
+
+	result := &PatchResult{Applied: true, DryRun: opts.DryRun}
+	state := make(map[string]*patchFile)
+	var order []string // paths in the order they were first touched
+	touched := make(map[string]bool)
+	load := func(path string) (*patchFile, error) {
+		if f, ok := state[path]; ok {
+			return f, nil
+		}
+		touched[path] = true
+		f := &patchFile{}
+		data, err := os.ReadFile(path)
+		if err == nil || os.IsNotExist(err) {
+			if err := checkHash(path, opts.ExpectedHashes[path], data, err == nil); err != nil {
+				return nil, err
+			}
+		}
+		switch {
+		case os.IsNotExist(err):
+		case err != nil:
+			return nil, err
+		default:
+			if IsBinaryContent(data) {
+				return nil, fmt.Errorf("binary files cannot be patched")
+			}
+			f.exists, f.original = true, data
+			f.text, f.info, err = Decode(data, EncodingAuto)
+			if err != nil {
+				return nil, err
+			}
+		}
+		state[path] = f
+		order = append(order, path)
+		return f, nil
+	}
+	resolve := func(path string) (string, error) {
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(baseDir, filepath.FromSlash(path))
+		}
+		if opts.Validate != nil {
+			return opts.Validate(path)
+		}
+		return filepath.Clean(path), nil
+	}
+
+	for _, p := range patches {
+		oldPath, newPath := p.oldPath, p.newPath
+		if !p.stripped {
+			strip := opts.Strip
+			if strip < 0 && !(hasPrefixOrEmpty(oldPath, "a/") && hasPrefixOrEmpty(newPath, "b/")) {
+				strip = 0
+			}
+			oldPath, newPath = stripPath(oldPath, strip, "a/"), stripPath(newPath, strip, "b/")
+		}
+
+		out := PatchedFile{Operation: PatchModify}
+		switch {
+		case oldPath == "":
+			out.Operation = PatchCreate
+		case newPath == "":
+			out.Operation = PatchDelete
+		case oldPath != newPath:
+			out.Operation = PatchRename
+		}
+		for i, h := range p.hunks {
+			out.Hunks = append(out.Hunks, HunkOutcome{Hunk: i + 1, Header: h.header})
+		}
+		fail := func(format string, args ...interface{}) {
+			out.Error = fmt.Sprintf(format, args...)
+			result.Applied = false
+		}
+
+		var src, dst *patchFile
+		srcPath, dstPath := oldPath, newPath
+		var err error
+		if oldPath != "" {
+			var abs string
+			if abs, err = resolve(oldPath); err == nil {
+				srcPath = abs
+				src, err = load(srcPath)
+			}
+		}
+		if err == nil && newPath != "" {
+			var abs string
+			if abs, err = resolve(newPath); err == nil {
+				dstPath = abs
+				dst, err = load(dstPath)
+			}
+		}
+		out.Path, out.OldPath = dstPath, srcPath
+		if out.Operation == PatchDelete {
+			out.Path, out.OldPath = srcPath, ""
+		} else if out.Operation != PatchRename {
+			out.OldPath = ""
+		}
+
+		switch {
+		case err != nil:
+			fail("%v", err)
+		case p.binary:
+			fail("binary patches are not supported")
+		case src != nil && !src.exists:
+			fail("file not found")
+		case out.Operation == PatchCreate && dst.exists:
+			fail("file already exists")
+		case out.Operation == PatchRename && dst.exists:
+			fail("rename target already exists")
+		}
+		if out.Error != "" {
+			result.Files = append(result.Files, out)
+			continue
+		}
+
+		text := ""
+		if src != nil {
+			text = src.text
+		}
+		newText, ok := applyHunks(text, p.hunks, out.Hunks, opts)
+		for _, h := range p.hunks {
+			for _, l := range h.lines {
+				switch l.kind {
+				case '+':
+					out.Added++
+				case '-':
+					out.Removed++
+				}
+			}
+		}
+		switch {
+		case !ok:
+			result.Applied = false
+		case out.Operation == PatchDelete && newText != "":
+			fail("file has content the patch does not remove")
+		case out.Operation == PatchDelete:
+			src.exists, src.text = false, ""
+		case out.Operation == PatchCreate:
+			dst.exists, dst.text, dst.info = true, newText, EncodingInfo{Encoding: EncodingUTF8}
+		case out.Operation == PatchRename:
+			dst.exists, dst.text, dst.info = true, newText, src.info
+			src.exists, src.text = false, ""
+		default:
+			src.text = newText
+		}
+		result.Files = append(result.Files, out)
+	}
+
+	for path := range opts.ExpectedHashes {
+		if !touched[path] {
+			return nil, &FileError{Code: ErrInvalidPatch, Message: "expectedHashes names a file the patch does not touch", Path: path}
+		}
+	}
+	if !result.Applied {
+		return result, nil
+	}
+
+	changes := changeSet{backup: opts.Backup}
+	hashes := make(map[string]string)
+	for _, path := range order {
+		f := state[path]
+		switch {
+		case f.exists && f.original == nil:
+			data, err := Encode(f.text, f.info)
+			if err != nil {
+				return nil, &FileError{Code: ErrInvalidEncoding, Message: err.Error(), Path: path}
+			}
+			changes.write(path, data, false, nil)
+			hashes[path] = ContentHash(data)
+		case f.exists:
+			data, err := Encode(f.text, f.info)
+			if err != nil {
+				return nil, &FileError{Code: ErrInvalidEncoding, Message: err.Error(), Path: path}
+			}
+			if string(data) != string(f.original) {
+				changes.write(path, data, true, f.original)
+			}
+			hashes[path] = ContentHash(data)
+		case f.original != nil:
+			changes.remove(path, f.original)
+		}
+	}
+	if opts.Check != nil {
+		for _, ch := range changes.changes {
+			if ch.remove {
+				continue
+			}
+			if err := opts.Check(ch.path, ch.data); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if opts.DryRun {
+		return result, nil
+	}
+	if err := changes.commit(); err != nil {
+		return nil, err
+	}
+	for i := range result.Files {
+		result.Files[i].Hash = hashes[result.Files[i].Path]
+		result.Files[i].BackupID = changes.backups[result.Files[i].Path]
+		if old := result.Files[i].OldPath; old != "" {
+			result.Files[i].BackupID = changes.backups[old]
+		}
+	}
+	return result, nil
+}
+
+func hasPrefixOrEmpty(path string, prefix string) bool {
+	return path == "" || strings.HasPrefix(path, prefix)
+}
+
+// applyHunks applies hunks to text, recording each one's outcome, and
+// reports whether all of them applied
+func applyHunks(text string, hunks []*hunk, outcomes []HunkOutcome, opts PatchOptions) (string, bool) {
+	// CRLF files are patched as LF and converted back; lines of files with
+	// mixed endings keep their carriage returns
+	crlf := DetectLineEnding(text) == LineEndingCRLF
+	if crlf {
+		text = ConvertLineEndings(text, LineEndingLF)
+	}
+	var lines []string
+	if text != "" {
+		lines = strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+	}
+	finalNewline := text == "" || strings.HasSuffix(text, "\n")
+
+	var out []string
+	cursor, offset, ok := 0, 0, true
+	for i, h := range hunks {
+		outcome := &outcomes[i]
+		var old []string
+		lead, trail := 0, 0
+		for j, l := range h.lines {
+			if l.kind == '+' {
+				continue
+			}
+			old = append(old, strings.TrimSuffix(l.text, "\r"))
+			if l.kind == ' ' && lead == j {
+				lead++
+			}
+		}
+		for j := len(h.lines) - 1; j >= 0 && h.lines[j].kind != '-'; j-- {
+			if h.lines[j].kind == ' ' {
+				trail++
+			}
+		}
+
+		expected := h.oldStart - 1 + offset
+		if len(old) == 0 {
+			expected = h.oldStart + offset
+		}
+		pos, fuzz, loose := locateHunk(lines, cursor, old, lead, trail, expected, opts)
+		if pos < 0 {
+			outcome.Error = hunkMismatch(lines, cursor, old)
+			ok = false
+			continue
+		}
+
+		// Copy up to the hunk, then walk it: context keeps the file's line,
+		// removed lines are skipped and added lines inserted
+		out = append(out, lines[cursor:pos]...)
+		outcome.Applied = true
+		outcome.Line = len(out) + 1
+		outcome.Offset = pos - min(fuzz, lead) - (h.oldStart - 1)
+		if len(old) == 0 {
+			outcome.Offset = pos - h.oldStart
+		}
+		outcome.Fuzz = fuzz
+		outcome.WhitespaceMismatch = loose
+		offset = outcome.Offset
+
+		p := pos
+		body := h.lines[min(fuzz, lead) : len(h.lines)-min(fuzz, trail)]
+		for _, l := range body {
+			switch l.kind {
+			case ' ':
+				out = append(out, lines[p])
+				p++
+			case '-':
+				p++
+			case '+':
+				out = append(out, strings.TrimSuffix(l.text, "\r"))
+			}
+		}
+		cursor = p
+		if p == len(lines) && (h.oldNoNewline || h.newNoNewline) {
+			finalNewline = !h.newNoNewline
+		}
+	}
+	out = append(out, lines[cursor:]...)
+
+	if len(out) == 0 {
+		return "", ok
+	}
+	result := strings.Join(out, "\n")
+	if finalNewline {
+		result += "\n"
+	}
+	if crlf {
+		result = ConvertLineEndings(result, LineEndingCRLF)
+	}
+	return result, ok
+}
+
+// locateHunk finds where the old lines of a hunk start in lines, searching
+// outward from expected but never before cursor. It tries the full context
+// first, then drops up to opts.Fuzz context lines at each end, and at each
+// level tries an exact match before a whitespace-insensitive one. It returns
+// the position of the first line compared, the fuzz used and whether
+// whitespace was ignored, or -1 if the hunk does not apply.
+func locateHunk(lines []string, cursor int, old []string, lead int, trail int, expected int, opts PatchOptions) (int, int, bool) {
+	if len(old) == 0 {
+		return max(cursor, min(expected, len(lines))), 0, false
+	}
+	for fuzz := 0; fuzz <= opts.Fuzz && (fuzz == 0 || fuzz <= max(lead, trail)); fuzz++ {
+		block := old[min(fuzz, lead) : len(old)-min(fuzz, trail)]
+		if len(block) == 0 {
+			break
+		}
+		start := expected + min(fuzz, lead)
+		for _, loose := range []bool{false, true} {
+			if loose && !opts.IgnoreWhitespace {
+				break
+			}
+			if pos := searchBlock(lines, cursor, block, start, loose); pos >= 0 {
+				return pos, fuzz, loose
+			}
+		}
+	}
+	return -1, 0, false
+}
+
+// searchBlock returns the position of block in lines at or after cursor
+// that is closest to start, or -1
+func searchBlock(lines []string, cursor int, block []string, start int, loose bool) int {
+	last := len(lines) - len(block)
+	start = max(cursor, min(start, last))
+	for d := 0; start-d >= cursor || start+d <= last; d++ {
+		for _, pos := range []int{start - d, start + d} {
+			if pos >= cursor && pos <= last && blockMatches(lines[pos:pos+len(block)], block, loose) {
+				return pos
+			}
+			if d == 0 {
+				break
+			}
+		}
+	}
+	return -1
+}
+
+func blockMatches(lines []string, block []string, loose bool) bool {
+	for i := range block {
+		if lines[i] != block[i] && (!loose || !sameIgnoringSpace(lines[i], block[i])) {
+			return false
+		}
+	}
+	return true
+}
+
+// sameIgnoringSpace reports whether a and b differ only in whitespace
+func sameIgnoringSpace(a string, b string) bool {
+	return strings.Join(strings.Fields(a), "") == strings.Join(strings.Fields(b), "")
+}
+
+// hunkMismatch explains why a hunk's old lines were not found, pointing at
+// the position where most of them match
+func hunkMismatch(lines []string, cursor int, old []string) string {
+	best, bestCount := -1, 0
+	for pos := cursor; pos+len(old) <= len(lines); pos++ {
+		count := 0
+		for i := range old {
+			if sameIgnoringSpace(lines[pos+i], old[i]) {
+				count++
+			}
+		}
+		if count > bestCount {
+			best, bestCount = pos, count
+		}
+	}
+	if best < 0 {
+		if len(old) > len(lines)-cursor {
+			return fmt.Sprintf("hunk context not found: the hunk expects %d lines but only %d remain after the previous hunk", len(old), len(lines)-cursor)
+		}
+		return "hunk context not found: none of its lines appear in the file"
+	}
+	for i := range old {
+		if !sameIgnoringSpace(lines[best+i], old[i]) {
+			return fmt.Sprintf("hunk context not found; closest match at line %d has %d of %d lines matching, line %d differs: expected %q, found %q",
+				best+1, bestCount, len(old), best+i+1, old[i], lines[best+i])
+		}
+	}
+	return fmt.Sprintf("hunk context not found; closest match at line %d differs only in whitespace (set ignoreWhitespace)", best+1)
+}
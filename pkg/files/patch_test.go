@@ -0,0 +1,215 @@
+package files
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestApplyPatch(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{
+		"main.go":    "package main\n\n// added upstream\n\nfunc main() {\n\tprintln(\"hi\")\n}\n",
+		"old.txt":    "obsolete\n",
+		"util/a.go":  "package util\n\nfunc A() {}\n",
+		"crlf.txt":   "one\r\ntwo\r\nthree\r\n",
+		"noeol.txt":  "x\ny",
+		"keep/k.txt": "k\n",
+	})
+	read := func(name string) string {
+		data, err := os.ReadFile(filepath.Join(root, name))
+		if err != nil {
+			return "<missing>"
+		}
+		return string(data)
+	}
+
+	patch := `Rename A and tidy up
+
+diff --git a/main.go b/main.go
+index 1111111..2222222 100644
+--- a/main.go
++++ b/main.go
+@@ -3,3 +3,3 @@
+ func main() {
+-	println("hi")
++	println("hello")
+ }
+diff --git a/old.txt b/old.txt
+deleted file mode 100644
+--- a/old.txt
++++ /dev/null
+@@ -1 +0,0 @@
+-obsolete
+diff --git a/util/a.go b/util/b.go
+similarity index 80%
+rename from util/a.go
+rename to util/b.go
+--- a/util/a.go
++++ b/util/b.go
+@@ -1,3 +1,3 @@
+ package util
+ 
+-func A() {}
++func B() {}
+diff --git a/docs/new.md b/docs/new.md
+new file mode 100644
+--- /dev/null
++++ b/docs/new.md
+@@ -0,0 +1,2 @@
++# New
++text
+--- a/crlf.txt
++++ b/crlf.txt
+@@ -2 +2 @@
+-two
++TWO
+--- a/noeol.txt
++++ b/noeol.txt
+@@ -1,2 +1,2 @@
+ x
+-y
+\ No newline at end of file
++z
+\ No newline at end of file
+`
+	result, err := ApplyPatch(root, patch, PatchOptions{Strip: -1, Fuzz: DefaultPatchFuzz})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Applied || len(result.Files) != 6 {
+		t.Fatalf("unexpected result %+v", result)
+	}
+
+	// The main.go hunk is found two lines below where its header says
+	if h := result.Files[0].Hunks[0]; !h.Applied || h.Offset != 2 || h.Line != 5 || h.Fuzz != 0 {
+		t.Errorf("unexpected hunk outcome %+v", h)
+	}
+	ops := []string{}
+	for _, f := range result.Files {
+		ops = append(ops, f.Operation)
+	}
+	if strings.Join(ops, ",") != "modify,delete,rename,create,modify,modify" {
+		t.Errorf("unexpected operations %v", ops)
+	}
+
+	want := map[string]string{
+		"main.go":     "package main\n\n// added upstream\n\nfunc main() {\n\tprintln(\"hello\")\n}\n",
+		"old.txt":     "<missing>",
+		"util/a.go":   "<missing>",
+		"util/b.go":   "package util\n\nfunc B() {}\n",
+		"docs/new.md": "# New\ntext\n",
+		"crlf.txt":    "one\r\nTWO\r\nthree\r\n",
+		"noeol.txt":   "x\nz",
+	}
+	for name, content := range want {
+		if got := read(name); got != content {
+			t.Errorf("%s: got %q, want %q", name, got, content)
+		}
+	}
+}
+
+func TestApplyPatchFuzzAndWhitespace(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{"f.py": "def f():\n    a = 1\n    b = 2\n    c = 3\n    return a\n"})
+
+	// The first context line was edited since the diff was made
+	fuzzy := "--- f.py\n+++ f.py\n@@ -1,5 +1,5 @@\n def f(x):\n     a = 1\n-    b = 2\n+    b = 20\n     c = 3\n     return a\n"
+	result, err := ApplyPatch(root, fuzzy, PatchOptions{Strip: -1, DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Applied || !strings.Contains(result.Files[0].Hunks[0].Error, `line 1 differs: expected "def f(x):", found "def f():"`) {
+		t.Errorf("expected the hunk to fail without fuzz, got %+v", result.Files[0].Hunks)
+	}
+	result, err = ApplyPatch(root, fuzzy, PatchOptions{Strip: -1, Fuzz: 1, DryRun: true})
+	if err != nil || !result.Applied || result.Files[0].Hunks[0].Fuzz != 1 {
+		t.Errorf("expected the hunk to apply with fuzz 1, got %+v, %v", result, err)
+	}
+
+	// Context indented with a tab instead of spaces
+	loose := "--- f.py\n+++ f.py\n@@ -3,3 +3,3 @@\n \tb = 2\n-\tc = 3\n+    c = 30\n \treturn a\n"
+	result, err = ApplyPatch(root, loose, PatchOptions{Strip: -1, IgnoreWhitespace: true})
+	if err != nil || !result.Applied || !result.Files[0].Hunks[0].WhitespaceMismatch {
+		t.Fatalf("expected the hunk to apply ignoring whitespace, got %+v, %v", result, err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(root, "f.py")); string(data) != "def f():\n    a = 1\n    b = 2\n    c = 30\n    return a\n" {
+		t.Errorf("unexpected content %q", data)
+	}
+}
+
+func TestApplyPatchIsAllOrNothing(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{"a.txt": "a\n", "b.txt": "b\n"})
+	patch := "--- a/a.txt\n+++ b/a.txt\n@@ -1 +1 @@\n-a\n+A\n--- a/b.txt\n+++ b/b.txt\n@@ -1 +1 @@\n-missing\n+B\n--- /dev/null\n+++ b/c.txt\n@@ -0,0 +1 @@\n+c\n"
+
+	result, err := ApplyPatch(root, patch, PatchOptions{Strip: -1, Fuzz: DefaultPatchFuzz})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Applied || !result.Files[0].Hunks[0].Applied || result.Files[1].Hunks[0].Applied ||
+		!strings.Contains(result.Files[1].Hunks[0].Error, "not found") {
+		t.Errorf("unexpected result %+v", result)
+	}
+	if data, _ := os.ReadFile(filepath.Join(root, "a.txt")); string(data) != "a\n" {
+		t.Errorf("a.txt was modified: %q", data)
+	}
+	if _, err := os.Stat(filepath.Join(root, "c.txt")); !os.IsNotExist(err) {
+		t.Error("c.txt was created")
+	}
+
+	// Paths are checked before anything is read
+	validate := func(path string) (string, error) {
+		if filepath.Base(path) == "b.txt" {
+			return "", fmt.Errorf("access denied")
+		}
+		return path, nil
+	}
+	result, err = ApplyPatch(root, patch, PatchOptions{Strip: -1, Validate: validate})
+	if err != nil || result.Applied || result.Files[1].Error != "access denied" {
+		t.Errorf("expected b.txt to be refused, got %+v, %v", result, err)
+	}
+
+	if _, err := ApplyPatch(root, "just some text\n", PatchOptions{}); err == nil {
+		t.Error("expected error for input without a diff")
+	}
+}
+
+func TestApplyPatchRoundTrip(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	words := []string{"alpha", "beta", "gamma", "delta", ""}
+	text := func() string {
+		var b strings.Builder
+		for n := r.Intn(30); n > 0; n-- {
+			b.WriteString(words[r.Intn(len(words))] + "\n")
+		}
+		s := b.String()
+		if r.Intn(4) == 0 {
+			s = strings.TrimSuffix(s, "\n")
+		}
+		return s
+	}
+
+	root := t.TempDir()
+	path := filepath.Join(root, "f.txt")
+	for i := 0; i < 200; i++ {
+		oldText, newText := text(), text()
+		diff := UnifiedDiff("a/f.txt", "b/f.txt", oldText, newText, r.Intn(4))
+		if diff == "" {
+			continue
+		}
+		if err := os.WriteFile(path, []byte(oldText), 0644); err != nil {
+			t.Fatal(err)
+		}
+		result, err := ApplyPatch(root, diff, PatchOptions{Strip: -1})
+		if err != nil || !result.Applied {
+			t.Fatalf("patch failed: %+v, %v\n%s", result, err, diff)
+		}
+		if data, _ := os.ReadFile(path); string(data) != newText {
+			t.Fatalf("got %q, want %q after\n%s", data, newText, diff)
+		}
+	}
+}
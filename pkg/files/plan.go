@@ -0,0 +1,219 @@
+package files
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// Actions of a PlannedFile
+const (
+	ActionCreate    = "create"
+	ActionOverwrite = "overwrite"
+	ActionDelete    = "delete"
+)
+
+// maxPlanDiffSize is the largest file a plan shows a diff for
+const maxPlanDiffSize = 1024 * 1024
+
+// PlannedFile is a file or directory that an operation would create,
+// overwrite or delete, as reported by a dry run
+type PlannedFile struct {
+	Path        string `json:"path"`
+	Source      string `json:"source,omitempty"` // the file copied or moved to Path
+	Action      string `json:"action"`
+	Size        int64  `json:"size"` // bytes written, or removed by a delete
+	IsDirectory bool   `json:"isDirectory,omitempty"`
+	Diff        string `json:"diff,omitempty"` // for an overwritten text file
+}
+
+// PlanCreateDirectory returns the directories CreateDirectory would create,
+// outermost first, without creating them
+func PlanCreateDirectory(path string) ([]PlannedFile, error) {
+	if info, err := os.Stat(path); err == nil && !info.IsDir() {
+		return nil, &FileError{Code: ErrAlreadyExists, Message: "Path exists but is not a directory", Path: path}
+	}
+	planned := []PlannedFile{}
+	for _, dir := range MissingDirs(path) {
+		planned = append(planned, PlannedFile{Path: dir, Action: ActionCreate, IsDirectory: true})
+	}
+	return planned, nil
+}
+
+// PlanCopy returns what CopyFile would do, listing each file and directory
+// it would write, without copying anything
+func PlanCopy(source, destination string) (*CopyResult, error) {
+	srcInfo, err := os.Stat(source)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, &FileError{Code: ErrFileNotFound, Message: "Source not found", Path: source}
+		}
+		return nil, &FileError{Code: ErrUnknown, Message: err.Error(), Path: source}
+	}
+
+	result := &CopyResult{Source: source, Destination: destination, IsDirectory: srcInfo.IsDir(), DryRun: true}
+	result.Files, result.BytesCopied, err = planTransfer(source, destination, false)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// PlanMove returns what MoveFile would do, listing each file and directory
+// that would arrive at the destination, without moving anything
+func PlanMove(source, destination string) (*MoveResult, error) {
+	if _, err := os.Stat(source); err != nil {
+		if os.IsNotExist(err) {
+			return nil, &FileError{Code: ErrFileNotFound, Message: "Source not found", Path: source}
+		}
+		return nil, &FileError{Code: ErrUnknown, Message: err.Error(), Path: source}
+	}
+
+	result := &MoveResult{Source: source, Destination: destination, DryRun: true}
+	var err error
+	result.Files, result.BytesMoved, err = planTransfer(source, destination, true)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// planTransfer lists the files a copy or move of source to destination
+// would write. A move renames symlinks rather than following them.
+func planTransfer(source, destination string, move bool) ([]PlannedFile, int64, error) {
+	planned := []PlannedFile{}
+	var total int64
+	for _, dir := range MissingDirs(filepath.Dir(destination)) {
+		planned = append(planned, PlannedFile{Path: dir, Action: ActionCreate, IsDirectory: true})
+	}
+
+	err := filepath.WalkDir(source, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(source, path)
+		if err != nil {
+			return err
+		}
+		dest := filepath.Join(destination, rel)
+		existing, statErr := os.Stat(dest)
+
+		info, err := os.Stat(path)
+		if move {
+			info, err = d.Info()
+		}
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if statErr != nil {
+				planned = append(planned, PlannedFile{Path: dest, Source: path, Action: ActionCreate, IsDirectory: true})
+			}
+			return nil
+		}
+
+		file := PlannedFile{Path: dest, Source: path, Action: ActionCreate, Size: info.Size()}
+		if statErr == nil {
+			file.Action = ActionOverwrite
+			if !existing.IsDir() && info.Mode().IsRegular() {
+				file.Diff = planDiff(dest, path, DefaultDiffContext)
+			}
+		}
+		planned = append(planned, file)
+		total += info.Size()
+		return nil
+	})
+	if err != nil {
+		return nil, 0, &FileError{Code: ErrUnknown, Message: err.Error(), Path: source}
+	}
+	return planned, total, nil
+}
+
+// PlanDelete returns what DeleteFile would do, listing each file and
+// directory it would remove, without deleting anything
+func PlanDelete(path string, recursive bool) (*DeleteResult, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, &FileError{Code: ErrFileNotFound, Message: "Path not found", Path: path}
+		}
+		return nil, &FileError{Code: ErrUnknown, Message: err.Error(), Path: path}
+	}
+	result := &DeleteResult{Path: path, IsDirectory: info.IsDir(), DryRun: true, Files: []PlannedFile{}}
+
+	if info.IsDir() && !recursive {
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			return nil, &FileError{Code: ErrUnknown, Message: err.Error(), Path: path}
+		}
+		if len(entries) > 0 {
+			return nil, &FileError{Code: ErrNotEmpty, Message: "Directory is not empty. Use recursive=true to delete non-empty directories", Path: path}
+		}
+	}
+
+	// Like os.RemoveAll, the walk removes symlinks without following them
+	err = filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		file := PlannedFile{Path: p, Action: ActionDelete, IsDirectory: d.IsDir()}
+		if !d.IsDir() {
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			file.Size = info.Size()
+			result.BytesDeleted += file.Size
+		}
+		result.Files = append(result.Files, file)
+		return nil
+	})
+	if err != nil {
+		return nil, &FileError{Code: ErrUnknown, Message: err.Error(), Path: path}
+	}
+	return result, nil
+}
+
+// MissingDirs returns dir and those of its parents that do not exist,
+// outermost first
+func MissingDirs(dir string) []string {
+	var missing []string
+	for ; ; dir = filepath.Dir(dir) {
+		if _, err := os.Lstat(dir); err == nil || dir == filepath.Dir(dir) {
+			break
+		}
+		missing = append([]string{dir}, missing...)
+	}
+	return missing
+}
+
+// planDiff returns the diff of overwriting the text file at path with the
+// file at source, or "" if either is binary or too large to show
+func planDiff(path string, source string, context int) string {
+	oldText, ok := readPlanText(path)
+	if !ok {
+		return ""
+	}
+	newText, ok := readPlanText(source)
+	if !ok {
+		return ""
+	}
+	return UnifiedDiff(path, path, oldText, newText, context)
+}
+
+// readPlanText reads and decodes a text file for a plan's diff
+func readPlanText(path string) (string, bool) {
+	info, err := os.Stat(path)
+	if err != nil || !info.Mode().IsRegular() || info.Size() > maxPlanDiffSize {
+		return "", false
+	}
+	data, err := os.ReadFile(path)
+	if err != nil || IsBinaryContent(data) {
+		return "", false
+	}
+	text, _, err := Decode(data, DetectEncoding(data).Encoding)
+	if err != nil {
+		return "", false
+	}
+	return text, true
+}
@@ -0,0 +1,99 @@
+package files
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPlans(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{
+		"src/a.txt":     "one\ntwo\n",
+		"src/sub/b.txt": "bee\n",
+		"dst/a.txt":     "one\n2\n",
+		"empty/.keep":   "",
+	})
+	os.Remove(filepath.Join(root, "empty", ".keep"))
+	path := func(name string) string { return filepath.Join(root, filepath.FromSlash(name)) }
+
+	// Listing what is on disk before and after shows the plans change nothing
+	snapshot := func() map[string]string {
+		tree := make(map[string]string)
+		filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+			data, _ := os.ReadFile(p)
+			tree[p] = string(data)
+			return nil
+		})
+		return tree
+	}
+	before := snapshot()
+
+	copied, err := PlanCopy(path("src"), path("dst"))
+	if err != nil {
+		t.Fatalf("PlanCopy() error = %v", err)
+	}
+	actions := map[string]string{}
+	for _, file := range copied.Files {
+		actions[file.Path] = file.Action
+	}
+	want := map[string]string{path("dst/a.txt"): ActionOverwrite, path("dst/sub"): ActionCreate, path("dst/sub/b.txt"): ActionCreate}
+	if !reflect.DeepEqual(actions, want) || copied.BytesCopied != 12 || !copied.DryRun {
+		t.Errorf("PlanCopy() = %+v, want actions %v and 12 bytes", copied, want)
+	}
+	for _, file := range copied.Files {
+		if file.Path == path("dst/a.txt") && !strings.Contains(file.Diff, "-2\n+two\n") {
+			t.Errorf("PlanCopy() overwrite diff = %q", file.Diff)
+		}
+	}
+
+	moved, err := PlanMove(path("src/a.txt"), path("new/dir/a.txt"))
+	if err != nil {
+		t.Fatalf("PlanMove() error = %v", err)
+	}
+	if len(moved.Files) != 3 || moved.Files[2].Source != path("src/a.txt") || moved.BytesMoved != 8 {
+		t.Errorf("PlanMove() = %+v, want two new directories and the file", moved)
+	}
+
+	deleted, err := PlanDelete(path("src"), true)
+	if err != nil {
+		t.Fatalf("PlanDelete() error = %v", err)
+	}
+	if len(deleted.Files) != 4 || deleted.BytesDeleted != 12 {
+		t.Errorf("PlanDelete() = %+v, want 4 entries and 12 bytes", deleted)
+	}
+	if _, err := PlanDelete(path("src"), false); err == nil || err.(*FileError).Code != ErrNotEmpty {
+		t.Errorf("PlanDelete(non-empty, false) error = %v, want NOT_EMPTY", err)
+	}
+	if deleted, err := PlanDelete(path("empty"), false); err != nil || len(deleted.Files) != 1 {
+		t.Errorf("PlanDelete(empty) = %+v, %v", deleted, err)
+	}
+
+	dirs, err := PlanCreateDirectory(path("x/y"))
+	if err != nil || len(dirs) != 2 || dirs[0].Path != path("x") {
+		t.Errorf("PlanCreateDirectory() = %+v, %v", dirs, err)
+	}
+	if _, err := PlanCreateDirectory(path("src/a.txt")); err == nil {
+		t.Error("PlanCreateDirectory() over a file succeeded")
+	}
+
+	written, err := WriteFileWithOptions(path("src/a.txt"), "one\nthree\n", WriteOptions{DryRun: true})
+	if err != nil || written.Created || !strings.Contains(written.Diff, "-two\n+three\n") || written.Hash != ContentHash([]byte("one\nthree\n")) {
+		t.Errorf("WriteFileWithOptions(DryRun) = %+v, %v", written, err)
+	}
+	created, err := WriteFileWithOptions(path("new.txt"), "hi\n", WriteOptions{DryRun: true})
+	if err != nil || !created.Created || !strings.HasPrefix(created.Diff, "--- /dev/null\n") {
+		t.Errorf("WriteFileWithOptions(DryRun, new) = %+v, %v", created, err)
+	}
+
+	modified, err := ModifyFileWithOptions(path("src/sub/b.txt"), ModifyOptions{Find: "bee", Replace: "wasp", DryRun: true})
+	if err != nil || !modified.Modified || !modified.DryRun || !strings.Contains(modified.Diff, "+wasp") {
+		t.Errorf("ModifyFileWithOptions(DryRun) = %+v, %v", modified, err)
+	}
+
+	if after := snapshot(); !reflect.DeepEqual(after, before) {
+		t.Errorf("plans changed the tree: %v, want %v", after, before)
+	}
+}
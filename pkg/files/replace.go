@@ -0,0 +1,228 @@
+package files
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/JeremyProffitt/go-mcp-file-context-server/pkg/extract"
+)
+
+// ReplaceOptions controls ReplaceInFiles
+type ReplaceOptions struct {
+	// Search selects and matches files as in SearchFilesWithOptions. Invert
+	// and Output do not apply to replacements and must be left unset.
+	Search SearchOptions
+
+	// DryRun computes the changes and their diffs without writing anything
+	DryRun bool
+
+	// ContextLines is the number of unchanged lines around each change in
+	// the diffs; a negative value uses DefaultDiffContext
+	ContextLines int
+
+	// Keep, if set, is asked about every matching file; files it rejects
+	// are left alone and listed in the result as refused
+	Keep func(path string) bool
+
+	// Backup, if set, is called with each file before any is changed; an
+	// error cancels the replacement
+	Backup BackupFunc
+
+	// Check, if set, is called with the new content of each file, in dry
+	// runs too; an error cancels the replacement
+	Check ContentCheck
+}
+
+// FileReplacement describes the changes made, or to be made, to one file
+type FileReplacement struct {
+	Path         string `json:"path"`
+	Replacements int    `json:"replacements"`
+	Diff         string `json:"diff,omitempty"`
+	BackupID     string `json:"backupId,omitempty"`
+}
+
+// ReplaceResult is the result of ReplaceInFiles
+type ReplaceResult struct {
+	Files        []FileReplacement `json:"files"`
+	Replacements int               `json:"replacements"`
+	Scanned      int               `json:"scanned"`
+	DryRun       bool              `json:"dryRun"`
+	Refused      []string          `json:"refused,omitempty"` // files Keep rejected
+}
+
+// ReplaceInFiles replaces every match of pattern in the files under
+// basePath, which may also be a single file. Matching follows search_context:
+// unless opts.Search.Multiline is set the pattern is applied to each line on
+// its own. In regex mode the replacement may refer to capture groups as $1 or
+// ${name}; with FixedString it is inserted literally.
+//
+// Each file keeps its encoding, byte order mark and line endings. Binary
+// files, documents whose text is extracted for searching and symlinks are
+// never modified. Changes are all or nothing: every file is read and
+// rewritten in memory first, and if writing any of them fails the files
+// already written are restored. With opts.DryRun nothing is written and each
+// file's unified diff, with paths relative to basePath, is returned instead.
+func ReplaceInFiles(basePath string, pattern string, replacement string, recursive bool, fileTypes []string, opts ReplaceOptions) (*ReplaceResult, error) {
+	if opts.Search.Invert {
+		return nil, &FileError{Code: ErrInvalidArgument, Message: "invert cannot be used for replacements", Path: basePath}
+	}
+	opts.Search.Output = ""
+	re, err := opts.Search.Compile(pattern, basePath)
+	if err != nil {
+		return nil, err
+	}
+	if opts.ContextLines < 0 {
+		opts.ContextLines = DefaultDiffContext
+	}
+	maxFileSize := opts.Search.MaxFileSize
+	if maxFileSize == 0 {
+		maxFileSize = DefaultSearchMaxFileSize
+	}
+
+	metadata, err := GetFileMetadata(basePath)
+	if err != nil {
+		return nil, err
+	}
+
+	// Diff paths are relative to the directory searched
+	base := basePath
+	var paths []string
+	if metadata.IsDirectory {
+		filter, err := newEntryFilter(basePath, fileTypes, false, opts.Search.Walk)
+		if err != nil {
+			return nil, err
+		}
+		walkSearchFiles(basePath, recursive, filter, opts.Search.Candidate, func(path string) bool {
+			paths = append(paths, path)
+			return true
+		})
+	} else {
+		base = filepath.Dir(basePath)
+		paths = []string{basePath}
+	}
+
+	result := &ReplaceResult{Files: []FileReplacement{}, DryRun: opts.DryRun}
+	changes := changeSet{backup: opts.Backup}
+	for _, path := range paths {
+		if opts.Keep != nil && !opts.Keep(path) {
+			result.Refused = append(result.Refused, path)
+			continue
+		}
+		original, text, encInfo, ok, err := readReplaceable(path, maxFileSize)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+		result.Scanned++
+
+		updated, count := replaceText(re, text, replacement, opts.Search)
+		if count == 0 || updated == text {
+			continue
+		}
+		data, err := Encode(updated, encInfo)
+		if err != nil {
+			return nil, &FileError{Code: ErrInvalidEncoding, Message: err.Error(), Path: path}
+		}
+		if opts.Check != nil {
+			if err := opts.Check(path, data); err != nil {
+				return nil, err
+			}
+		}
+
+		file := FileReplacement{Path: path, Replacements: count}
+		if opts.DryRun {
+			rel, err := filepath.Rel(base, path)
+			if err != nil {
+				rel = path
+			}
+			rel = filepath.ToSlash(rel)
+			file.Diff = UnifiedDiff("a/"+rel, "b/"+rel, text, updated, opts.ContextLines)
+		}
+		result.Files = append(result.Files, file)
+		result.Replacements += count
+		changes.write(path, data, true, original)
+	}
+
+	if !opts.DryRun {
+		if err := changes.commit(); err != nil {
+			return nil, err
+		}
+		for i := range result.Files {
+			result.Files[i].BackupID = changes.backups[result.Files[i].Path]
+		}
+	}
+	return result, nil
+}
+
+// readReplaceable reads a file that ReplaceInFiles may rewrite. ok is false
+// for files that are skipped rather than failing the whole call: symlinks,
+// special files, files over maxFileSize, binary files and documents.
+func readReplaceable(path string, maxFileSize int64) (data []byte, text string, info EncodingInfo, ok bool, err error) {
+	stat, err := os.Lstat(path)
+	if err != nil {
+		return nil, "", info, false, nil
+	}
+	if !stat.Mode().IsRegular() || (maxFileSize > 0 && stat.Size() > maxFileSize) {
+		return nil, "", info, false, nil
+	}
+	if extract.Supported(GetMimeType(path)) {
+		return nil, "", info, false, nil
+	}
+
+	data, err = os.ReadFile(path)
+	if err != nil {
+		if os.IsPermission(err) {
+			return nil, "", info, false, &FileError{Code: ErrPermission, Message: "Permission denied", Path: path}
+		}
+		return nil, "", info, false, &FileError{Code: ErrUnknown, Message: err.Error(), Path: path}
+	}
+	if IsBinaryContent(data) {
+		return nil, "", info, false, nil
+	}
+	text, info, err = Decode(data, EncodingAuto)
+	if err != nil {
+		return nil, "", info, false, nil
+	}
+	return data, text, info, true, nil
+}
+
+// replaceText applies re to text the way a search would match it and
+// returns the new text and the number of matches replaced
+func replaceText(re *regexp.Regexp, text string, replacement string, opts SearchOptions) (string, int) {
+	replace := func(s string) (string, int) {
+		count := len(re.FindAllStringIndex(s, -1))
+		if count == 0 {
+			return s, 0
+		}
+		if opts.FixedString {
+			return re.ReplaceAllLiteralString(s, replacement), count
+		}
+		return re.ReplaceAllString(s, replacement), count
+	}
+
+	if opts.Multiline {
+		return replace(text)
+	}
+
+	// Lines are matched without their terminators, as search_context
+	// reports them
+	var b strings.Builder
+	total := 0
+	for _, line := range splitLines(text) {
+		body := strings.TrimSuffix(line, "\n")
+		end := line[len(body):]
+		if trimmed := strings.TrimSuffix(body, "\r"); len(trimmed) < len(body) {
+			end = "\r" + end
+			body = trimmed
+		}
+		replaced, count := replace(body)
+		total += count
+		b.WriteString(replaced)
+		b.WriteString(end)
+	}
+	return b.String(), total
+}
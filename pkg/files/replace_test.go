@@ -0,0 +1,90 @@
+package files
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReplaceInFiles(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{
+		"a.go":        "package a\n\nfunc oldName() {}\n\nvar x = oldName\n",
+		"sub/b.go":    "package sub\n\r\nfunc use() { oldName() }\r\n",
+		"sub/c.txt":   "no match here\n",
+		"blocked.go":  "oldName\n",
+		"sub/data.go": "oldName\x00\x01",
+	})
+	keep := func(path string) bool { return !strings.HasSuffix(path, "blocked.go") }
+	read := func(name string) string {
+		data, err := os.ReadFile(filepath.Join(root, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data)
+	}
+
+	opts := ReplaceOptions{Search: SearchOptions{Walk: WalkOptions{}, WholeWord: true}, DryRun: true, ContextLines: 0, Keep: keep}
+	result, err := ReplaceInFiles(root, `old(Name)`, "new$1", true, nil, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Files) != 2 || result.Replacements != 3 || result.Scanned != 3 {
+		t.Fatalf("unexpected dry run result %+v", result)
+	}
+	if len(result.Refused) != 1 || filepath.Base(result.Refused[0]) != "blocked.go" {
+		t.Errorf("refused = %v, want blocked.go", result.Refused)
+	}
+	want := "--- a/a.go\n+++ b/a.go\n@@ -3 +3 @@\n-func oldName() {}\n+func newName() {}\n@@ -5 +5 @@\n-var x = oldName\n+var x = newName\n"
+	if result.Files[0].Diff != want {
+		t.Errorf("unexpected diff:\n%s", result.Files[0].Diff)
+	}
+	if read("a.go") != "package a\n\nfunc oldName() {}\n\nvar x = oldName\n" {
+		t.Error("dry run modified a file")
+	}
+
+	opts.DryRun = false
+	result, err = ReplaceInFiles(root, `old(Name)`, "new$1", true, nil, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Replacements != 3 || result.Files[1].Replacements != 1 || result.Files[1].Diff != "" {
+		t.Fatalf("unexpected result %+v", result)
+	}
+	if got := read("sub/b.go"); got != "package sub\n\r\nfunc use() { newName() }\r\n" {
+		t.Errorf("line endings not preserved: %q", got)
+	}
+	if read("blocked.go") != "oldName\n" || read("sub/data.go") != "oldName\x00\x01" {
+		t.Error("rejected or binary file was modified")
+	}
+
+	// Fixed strings insert the replacement literally
+	result, err = ReplaceInFiles(filepath.Join(root, "a.go"), "newName", "$1", true, nil, ReplaceOptions{Search: SearchOptions{FixedString: true}})
+	if err != nil || result.Replacements != 2 || read("a.go") != "package a\n\nfunc $1() {}\n\nvar x = $1\n" {
+		t.Errorf("unexpected fixed string result %+v, %v: %q", result, err, read("a.go"))
+	}
+
+	if _, err := ReplaceInFiles(root, "x", "y", true, nil, ReplaceOptions{Search: SearchOptions{Invert: true}}); err == nil {
+		t.Error("expected error for invert")
+	}
+}
+
+func TestReplaceInFilesIsAllOrNothing(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("permissions are not enforced for root")
+	}
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{"a.txt": "foo\n", "b.txt": "foo\n"})
+	if err := os.Chmod(filepath.Join(root, "b.txt"), 0444); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := ReplaceInFiles(root, "foo", "bar", true, nil, ReplaceOptions{Search: SearchOptions{Walk: WalkOptions{}}})
+	if fe, ok := err.(*FileError); !ok || fe.Code != ErrPermission {
+		t.Fatalf("expected a permission error, got %v", err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(root, "a.txt")); string(data) != "foo\n" {
+		t.Errorf("a.txt was modified: %q", data)
+	}
+}
@@ -0,0 +1,659 @@
+package files
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/JeremyProffitt/go-mcp-file-context-server/pkg/extract"
+)
+
+// DefaultSearchMaxFileSize is the largest file searched when SearchOptions
+// does not set a limit
+const DefaultSearchMaxFileSize = 10 * 1024 * 1024 // 10MB
+
+// DefaultSearchWorkers is the number of files searched concurrently when
+// SearchOptions does not set a worker count
+var DefaultSearchWorkers = runtime.NumCPU()
+
+// cancelCheckInterval is how many lines a worker scans between checks for
+// early termination
+const cancelCheckInterval = 1024
+
+// Search output modes
+const (
+	OutputContent          = "content"          // matching lines with context
+	OutputFilesWithMatches = "filesWithMatches" // paths of files with at least one match
+	OutputCount            = "count"            // number of matching lines per file
+)
+
+// SearchOptions controls how SearchFilesWithOptions walks, matches and
+// reports
+type SearchOptions struct {
+	// Walk selects which paths are visited
+	Walk WalkOptions
+
+	// Workers is the number of files searched concurrently; 0 uses
+	// DefaultSearchWorkers
+	Workers int
+
+	// MaxFileSize skips files larger than this many bytes; 0 uses
+	// DefaultSearchMaxFileSize and a negative value disables the limit
+	MaxFileSize int64
+
+	// FixedString treats the pattern as a literal string instead of a regex
+	FixedString bool
+
+	// IgnoreCase matches letters regardless of case
+	IgnoreCase bool
+
+	// WholeWord only matches the pattern at word boundaries
+	WholeWord bool
+
+	// Multiline matches the pattern against whole files so that matches can
+	// span lines; ^ and $ match at line boundaries
+	Multiline bool
+
+	// Invert selects the lines that do not match. It cannot be combined
+	// with Multiline.
+	Invert bool
+
+	// Output is OutputContent (the default), OutputFilesWithMatches or
+	// OutputCount
+	Output string
+
+	// Candidate, if set, is asked about every file before it is opened;
+	// files it rejects are skipped. Search indexes use it to narrow the
+	// files scanned.
+	Candidate func(path string, d fs.DirEntry) bool
+}
+
+// Compile builds the regex for pattern under the options' matching modes.
+// path is only used in errors.
+func (o SearchOptions) Compile(pattern string, path string) (*regexp.Regexp, error) {
+	switch o.Output {
+	case "", OutputContent, OutputFilesWithMatches, OutputCount:
+	default:
+		return nil, &FileError{Code: ErrInvalidArgument, Message: fmt.Sprintf("Unknown output mode: %s", o.Output), Path: path}
+	}
+	if o.Multiline && o.Invert {
+		return nil, &FileError{Code: ErrInvalidArgument, Message: "invert cannot be combined with multiline", Path: path}
+	}
+
+	if o.FixedString {
+		pattern = regexp.QuoteMeta(pattern)
+	}
+	if o.WholeWord {
+		pattern = `\b(?:` + pattern + `)\b`
+	}
+
+	flags := ""
+	if o.IgnoreCase {
+		flags += "i"
+	}
+	if o.Multiline {
+		flags += "m"
+	}
+	if flags != "" {
+		pattern = "(?" + flags + ")" + pattern
+	}
+
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, &FileError{Code: ErrInvalidArgument, Message: fmt.Sprintf("Invalid regex pattern: %s", err.Error()), Path: path}
+	}
+	return re, nil
+}
+
+// SearchFilesWithOptions searches for a pattern in the files under basePath.
+//
+// Files are streamed from the directory walk to a bounded pool of workers
+// and scanned line by line, so memory use does not depend on file size.
+// Lines are checked for the pattern's literal prefix before the regex runs,
+// binary files and files over the size limit are skipped, and the search
+// stops as soon as maxResults results are known. Results are returned in
+// walk order (by path, then line) regardless of which worker found them.
+//
+// maxResults limits the matches returned, or the files listed in the
+// filesWithMatches and count output modes.
+func SearchFilesWithOptions(basePath string, pattern string, recursive bool, fileTypes []string, contextLines int, maxResults int, opts SearchOptions) (*SearchResult, error) {
+	re, err := opts.Compile(pattern, basePath)
+	if err != nil {
+		return nil, err
+	}
+
+	metadata, err := GetFileMetadata(basePath)
+	if err != nil {
+		return nil, err
+	}
+	if !metadata.IsDirectory {
+		return nil, &FileError{Code: ErrInvalidPath, Message: "Path is not a directory", Path: basePath}
+	}
+
+	filter, err := newEntryFilter(basePath, fileTypes, false, opts.Walk)
+	if err != nil {
+		return nil, err
+	}
+
+	s := newSearcher(re, contextLines, maxResults, opts)
+
+	workers := opts.Workers
+	if workers <= 0 {
+		workers = DefaultSearchWorkers
+	}
+	if workers <= 0 {
+		workers = 1
+	}
+
+	return s.run(basePath, recursive, filter, workers), nil
+}
+
+// searcher runs one search across a pool of workers
+type searcher struct {
+	re           *regexp.Regexp
+	prefix       string // literal every match starts with
+	literal      bool   // the pattern is exactly prefix
+	contextLines int
+	maxResults   int
+	maxFileSize  int64
+	multiline    bool
+	invert       bool
+	output       string
+	candidate    func(path string, d fs.DirEntry) bool
+
+	done     chan struct{} // closed once enough results are collected
+	stopOnce sync.Once
+}
+
+func newSearcher(re *regexp.Regexp, contextLines int, maxResults int, opts SearchOptions) *searcher {
+	if contextLines < 0 {
+		contextLines = 0
+	}
+	prefix, complete := re.LiteralPrefix()
+
+	s := &searcher{
+		re:           re,
+		prefix:       prefix,
+		literal:      complete,
+		contextLines: contextLines,
+		maxResults:   maxResults,
+		maxFileSize:  opts.MaxFileSize,
+		multiline:    opts.Multiline,
+		invert:       opts.Invert,
+		output:       opts.Output,
+		candidate:    opts.Candidate,
+		done:         make(chan struct{}),
+	}
+	if s.maxFileSize == 0 {
+		s.maxFileSize = DefaultSearchMaxFileSize
+	}
+	if s.output == "" {
+		s.output = OutputContent
+	}
+	return s
+}
+
+// fileLimit is the number of results worth finding in a single file
+func (s *searcher) fileLimit() int {
+	switch s.output {
+	case OutputFilesWithMatches:
+		return 1
+	case OutputCount:
+		return 0
+	}
+	return s.maxResults
+}
+
+// searchJob is a file to search, numbered in walk order
+type searchJob struct {
+	seq  int
+	path string
+}
+
+// fileResult holds what was found in one file
+type fileResult struct {
+	path    string
+	matches []SearchMatch
+	count   int
+}
+
+// searchOutcome is the fileResult of a searchJob
+type searchOutcome struct {
+	seq    int
+	result fileResult
+}
+
+func (s *searcher) stop() {
+	s.stopOnce.Do(func() { close(s.done) })
+}
+
+func (s *searcher) stopped() bool {
+	select {
+	case <-s.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// run walks basePath, searches the files on workers and merges their results
+// back into walk order
+func (s *searcher) run(basePath string, recursive bool, filter *entryFilter, workers int) *SearchResult {
+	jobs := make(chan searchJob, workers*4)
+	outcomes := make(chan searchOutcome, workers*4)
+
+	go func() {
+		defer close(jobs)
+		seq := 0
+		walkSearchFiles(basePath, recursive, filter, s.candidate, func(path string) bool {
+			select {
+			case jobs <- searchJob{seq: seq, path: path}:
+				seq++
+				return true
+			case <-s.done:
+				return false
+			}
+		})
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				var result fileResult
+				if !s.stopped() {
+					result = s.searchFile(job.path)
+				}
+				outcomes <- searchOutcome{seq: job.seq, result: result}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(outcomes)
+	}()
+
+	// Collect outcomes in sequence order; later files wait in pending until
+	// every earlier file has finished
+	c := &searchCollector{s: s}
+	pending := make(map[int]fileResult)
+	next := 0
+	for outcome := range outcomes {
+		if s.stopped() {
+			continue // Drain the remaining workers
+		}
+		pending[outcome.seq] = outcome.result
+		for {
+			result, ok := pending[next]
+			if !ok {
+				break
+			}
+			delete(pending, next)
+			next++
+
+			if c.add(result) {
+				s.stop()
+				break
+			}
+		}
+	}
+
+	return c.result()
+}
+
+// searchCollector assembles per-file results into a SearchResult
+type searchCollector struct {
+	s       *searcher
+	matches []SearchMatch
+	files   []string
+	counts  []FileMatchCount
+	total   int
+}
+
+// add records one file's results and reports whether maxResults is reached
+func (c *searchCollector) add(r fileResult) bool {
+	if r.count == 0 {
+		return false
+	}
+	limit := c.s.maxResults
+
+	switch c.s.output {
+	case OutputFilesWithMatches:
+		c.files = append(c.files, r.path)
+		c.total++
+		return limit > 0 && len(c.files) >= limit
+	case OutputCount:
+		c.counts = append(c.counts, FileMatchCount{Path: r.path, Count: r.count})
+		c.total += r.count
+		return limit > 0 && len(c.counts) >= limit
+	}
+
+	c.matches = append(c.matches, r.matches...)
+	if limit > 0 && len(c.matches) >= limit {
+		c.matches = c.matches[:limit]
+		c.total = len(c.matches)
+		return true
+	}
+	c.total = len(c.matches)
+	return false
+}
+
+func (c *searchCollector) result() *SearchResult {
+	return &SearchResult{
+		Matches: c.matches,
+		Files:   c.files,
+		Counts:  c.counts,
+		Total:   c.total,
+	}
+}
+
+// walkSearchFiles calls fn with each searchable file under basePath in
+// lexical walk order, stopping when fn returns false. Files rejected by
+// candidate, if set, are skipped.
+func walkSearchFiles(basePath string, recursive bool, filter *entryFilter, candidate func(string, fs.DirEntry) bool, fn func(path string) bool) {
+	filepath.WalkDir(basePath, func(path string, d os.DirEntry, err error) error {
+		if err != nil || path == basePath {
+			return nil // Skip errors
+		}
+
+		list, descend := filter.visit(path, d.Name(), d.IsDir())
+		if d.IsDir() {
+			if !descend || !recursive {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !list || !filter.allowLink(path, d) {
+			return nil
+		}
+
+		// Skip devices, pipes and sockets, which could block a worker
+		if d.Type()&(fs.ModeType&^fs.ModeSymlink) != 0 {
+			return nil
+		}
+
+		if mimeType := GetMimeType(path); IsBinaryMimeType(mimeType) && !extract.Supported(mimeType) {
+			return nil
+		}
+
+		if candidate != nil && !candidate(path, d) {
+			return nil
+		}
+
+		if !fn(path) {
+			return filepath.SkipAll
+		}
+		return nil
+	})
+}
+
+// searchFile returns the results in one file, or none if it can't be read,
+// is binary or exceeds the size limit
+func (s *searcher) searchFile(path string) fileResult {
+	slashPath := filepath.ToSlash(path)
+
+	file, err := os.Open(path)
+	if err != nil {
+		return fileResult{}
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil || !info.Mode().IsRegular() {
+		return fileResult{}
+	}
+	if s.maxFileSize > 0 && info.Size() > s.maxFileSize {
+		return fileResult{}
+	}
+
+	if mimeType := GetMimeType(path); extract.Supported(mimeType) {
+		// Search the text extracted from documents
+		raw, err := io.ReadAll(file)
+		if err != nil {
+			return fileResult{}
+		}
+		text, err := extract.Extract(mimeType, raw)
+		if err != nil {
+			return fileResult{}
+		}
+		return s.searchText(slashPath, text)
+	}
+
+	// Skip binary files
+	reader := bufio.NewReader(file)
+	if head, _ := reader.Peek(sniffLen); IsBinaryContent(head) {
+		return fileResult{}
+	}
+
+	if s.multiline {
+		data, err := io.ReadAll(reader)
+		if err != nil {
+			return fileResult{}
+		}
+		return s.searchText(slashPath, string(data))
+	}
+
+	m := s.newLineMatcher(slashPath)
+	for n := 1; ; n++ {
+		line, err := reader.ReadString('\n')
+		if len(line) > 0 {
+			line = strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r")
+			if !m.add(line) {
+				break
+			}
+		}
+		if err != nil {
+			break
+		}
+		if n%cancelCheckInterval == 0 && s.stopped() {
+			return fileResult{}
+		}
+	}
+
+	return m.finish()
+}
+
+// searchText searches text that is already in memory, such as extracted
+// document text or an archive entry
+func (s *searcher) searchText(path string, text string) fileResult {
+	if s.multiline {
+		return s.searchMultiline(path, text)
+	}
+
+	m := s.newLineMatcher(path)
+	for _, line := range strings.Split(strings.TrimSuffix(text, "\n"), "\n") {
+		if !m.add(line) {
+			break
+		}
+	}
+	return m.finish()
+}
+
+// searchMultiline matches the pattern against the whole text, reporting
+// each match from its first to its last line
+func (s *searcher) searchMultiline(path string, text string) fileResult {
+	result := fileResult{path: path}
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	if s.prefix != "" && !strings.Contains(text, s.prefix) {
+		return result
+	}
+
+	body := strings.TrimSuffix(text, "\n")
+	lines := strings.Split(body, "\n")
+	starts := make([]int, len(lines))
+	offset := 0
+	for i, line := range lines {
+		starts[i] = offset
+		offset += len(line) + 1
+	}
+	lineAt := func(pos int) int {
+		return sort.Search(len(starts), func(i int) bool { return starts[i] > pos }) - 1
+	}
+
+	limit := s.fileLimit()
+	for _, loc := range s.re.FindAllStringIndex(body, -1) {
+		result.count++
+		if s.output == OutputContent {
+			first := lineAt(loc[0])
+			last := first
+			if loc[1] > loc[0] {
+				last = lineAt(loc[1] - 1)
+			}
+			content := strings.Join(lines[first:last+1], "\n")
+
+			match := SearchMatch{
+				Path:    path,
+				Line:    first + 1,
+				Content: content,
+				Submatches: []Submatch{{
+					Start: loc[0] - starts[first],
+					End:   loc[1] - starts[first],
+				}},
+				Context: s.context(lines, first, last),
+			}
+			if last > first {
+				match.EndLine = last + 1
+			}
+			result.matches = append(result.matches, match)
+		}
+		if limit > 0 && result.count >= limit {
+			break
+		}
+	}
+	return result
+}
+
+// context returns the lines around lines[first:last+1]
+func (s *searcher) context(lines []string, first int, last int) SearchContext {
+	start := first - s.contextLines
+	if start < 0 {
+		start = 0
+	}
+	ctx := SearchContext{Before: append([]string{}, lines[start:first]...)}
+
+	// A match on the final line has no after-context at all
+	if last+1 < len(lines) {
+		end := last + 1 + s.contextLines
+		if end > len(lines) {
+			end = len(lines)
+		}
+		ctx.After = append([]string{}, lines[last+1:end]...)
+	}
+	return ctx
+}
+
+// lineMatcher matches a stream of lines, keeping only the context lines it
+// still needs
+type lineMatcher struct {
+	s     *searcher
+	path  string
+	keep  bool // store matches rather than only counting them
+	limit int
+
+	line    int
+	count   int
+	stopped bool     // add returned false before the input ended
+	before  []string // last contextLines lines
+	open    []int    // matches still collecting after-context
+	matches []SearchMatch
+}
+
+func (s *searcher) newLineMatcher(path string) *lineMatcher {
+	return &lineMatcher{
+		s:     s,
+		path:  path,
+		keep:  s.output == OutputContent,
+		limit: s.fileLimit(),
+	}
+}
+
+// match reports whether a line is selected and, when matches are kept, the
+// byte offsets of the pattern in it. The literal prefix is checked before
+// the regex runs.
+func (m *lineMatcher) match(line string) (bool, []Submatch) {
+	s := m.s
+	if s.prefix != "" && !strings.Contains(line, s.prefix) {
+		return s.invert, nil
+	}
+	if s.invert || !m.keep {
+		return (s.literal || s.re.MatchString(line)) != s.invert, nil
+	}
+
+	locs := s.re.FindAllStringIndex(line, -1)
+	if len(locs) == 0 {
+		return false, nil
+	}
+	submatches := make([]Submatch, len(locs))
+	for i, loc := range locs {
+		submatches[i] = Submatch{Start: loc[0], End: loc[1]}
+	}
+	return true, submatches
+}
+
+// add feeds the next line and reports whether more lines are needed
+func (m *lineMatcher) add(line string) bool {
+	m.line++
+	contextLines := m.s.contextLines
+
+	// Fill the after-context of earlier matches
+	open := m.open[:0]
+	for _, i := range m.open {
+		m.matches[i].Context.After = append(m.matches[i].Context.After, line)
+		if len(m.matches[i].Context.After) < contextLines {
+			open = append(open, i)
+		}
+	}
+	m.open = open
+
+	full := m.limit > 0 && m.count >= m.limit
+	if !full {
+		if selected, submatches := m.match(line); selected {
+			m.count++
+			if m.keep {
+				m.matches = append(m.matches, SearchMatch{
+					Path:       m.path,
+					Line:       m.line,
+					Content:    line,
+					Submatches: submatches,
+					Context: SearchContext{
+						Before: append([]string{}, m.before...),
+						After:  []string{},
+					},
+				})
+				if contextLines > 0 {
+					m.open = append(m.open, len(m.matches)-1)
+				}
+			}
+			full = m.limit > 0 && m.count >= m.limit
+		}
+	}
+
+	if m.keep && contextLines > 0 {
+		if len(m.before) == contextLines {
+			m.before = append(m.before[:0], m.before[1:]...)
+		}
+		m.before = append(m.before, line)
+	}
+
+	m.stopped = full && len(m.open) == 0
+	return !m.stopped
+}
+
+// finish returns the results once the input is exhausted
+func (m *lineMatcher) finish() fileResult {
+	// A match on the final line has no after-context at all
+	if n := len(m.matches); n > 0 && !m.stopped && m.matches[n-1].Line == m.line {
+		m.matches[n-1].Context.After = nil
+	}
+	return fileResult{path: m.path, matches: m.matches, count: m.count}
+}
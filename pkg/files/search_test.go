@@ -0,0 +1,233 @@
+package files
+
+import (
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// searchTree builds a directory with n files, each holding a match on lines 2 and 4
+func searchTree(t *testing.T, n int) string {
+	t.Helper()
+	root := t.TempDir()
+	tree := make(map[string]string)
+	for i := 0; i < n; i++ {
+		tree[fmt.Sprintf("dir%d/file%03d.txt", i%3, i)] = fmt.Sprintf("header\nneedle %d a\nfiller\nneedle %d b\n", i, i)
+	}
+	writeTree(t, root, tree)
+	return root
+}
+
+func matchKeys(result *SearchResult, root string) []string {
+	var keys []string
+	for _, m := range result.Matches {
+		rel, _ := filepath.Rel(root, filepath.FromSlash(m.Path))
+		keys = append(keys, fmt.Sprintf("%s:%d", filepath.ToSlash(rel), m.Line))
+	}
+	return keys
+}
+
+func TestSearchOrderingIsDeterministic(t *testing.T) {
+	root := searchTree(t, 60)
+
+	serial, err := SearchFilesWithOptions(root, "needle", true, nil, 0, 0, SearchOptions{Walk: WalkOptions{}, Workers: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if serial.Total != 120 {
+		t.Fatalf("expected 120 matches, got %d", serial.Total)
+	}
+	want := matchKeys(serial, root)
+	if want[0] != "dir0/file000.txt:2" || want[1] != "dir0/file000.txt:4" {
+		t.Errorf("unexpected first matches %v", want[:2])
+	}
+
+	for i := 0; i < 5; i++ {
+		parallel, err := SearchFilesWithOptions(root, "needle", true, nil, 0, 0, SearchOptions{Walk: WalkOptions{}, Workers: 8})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := matchKeys(parallel, root); !reflect.DeepEqual(got, want) {
+			t.Fatalf("parallel results differ from serial order")
+		}
+	}
+}
+
+func TestSearchMaxResults(t *testing.T) {
+	root := searchTree(t, 60)
+
+	// The limit keeps the first matches in walk order, even mid-file
+	result, err := SearchFilesWithOptions(root, "needle", true, nil, 0, 5, SearchOptions{Walk: WalkOptions{}, Workers: 8})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"dir0/file000.txt:2", "dir0/file000.txt:4", "dir0/file003.txt:2", "dir0/file003.txt:4", "dir0/file006.txt:2"}
+	if got := matchKeys(result, root); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestSearchSkipsLargeAndBinaryFiles(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{
+		"small.txt":  "needle\n",
+		"large.txt":  strings.Repeat("x", 2048) + "\nneedle\n",
+		"binary.dat": "needle\x00\x01\x02",
+	})
+
+	result, err := SearchFilesWithOptions(root, "needle", true, nil, 0, 0, SearchOptions{Walk: WalkOptions{}, MaxFileSize: 1024})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := matchKeys(result, root); !reflect.DeepEqual(got, []string{"small.txt:1"}) {
+		t.Errorf("got %v", got)
+	}
+
+	result, err = SearchFilesWithOptions(root, "needle", true, nil, 0, 0, SearchOptions{Walk: WalkOptions{}, MaxFileSize: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := matchKeys(result, root); !reflect.DeepEqual(got, []string{"large.txt:2", "small.txt:1"}) {
+		t.Errorf("without a limit got %v", got)
+	}
+}
+
+func TestLineMatcherContext(t *testing.T) {
+	s := newSearcher(regexp.MustCompile(`match \d`), 2, 0, SearchOptions{})
+	matches := s.searchText("f", "a\nmatch 1\nb\nmatch 2\nc\nd\nmatch 3\n").matches
+	if len(matches) != 3 {
+		t.Fatalf("expected 3 matches, got %d", len(matches))
+	}
+
+	tests := []struct {
+		before, after []string
+	}{
+		{[]string{"a"}, []string{"b", "match 2"}},
+		{[]string{"match 1", "b"}, []string{"c", "d"}},
+		{[]string{"c", "d"}, nil},
+	}
+	for i, tt := range tests {
+		ctx := matches[i].Context
+		if !reflect.DeepEqual(ctx.Before, tt.before) || !reflect.DeepEqual(ctx.After, tt.after) {
+			t.Errorf("match %d context = %v / %v, want %v / %v", i+1, ctx.Before, ctx.After, tt.before, tt.after)
+		}
+	}
+
+	// The literal prefix prefilter must not change what matches
+	s = newSearcher(regexp.MustCompile(`func\s+\w+\(`), 0, 0, SearchOptions{})
+	if s.prefix != "func" || s.literal {
+		t.Errorf("unexpected prefix %q literal=%v", s.prefix, s.literal)
+	}
+	if got := s.searchText("f", "func  Foo(\nfun Foo(\nx := func(\n// func main()\n").count; got != 2 {
+		t.Errorf("expected 2 matches, got %d", got)
+	}
+}
+
+func TestSearchArgumentErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		pattern string
+		opts    SearchOptions
+	}{
+		{"invalid regex", "(", SearchOptions{}},
+		{"unknown output mode", "x", SearchOptions{Output: "lines"}},
+		{"invert with multiline", "x", SearchOptions{Invert: true, Multiline: true}},
+	}
+	for _, tt := range tests {
+		_, err := tt.opts.Compile(tt.pattern, "/tmp")
+		if fe, ok := err.(*FileError); !ok || fe.Code != ErrInvalidArgument {
+			t.Errorf("%s: expected INVALID_ARGUMENT, got %v", tt.name, err)
+		}
+	}
+
+	for _, opts := range []WalkOptions{{Include: []string{"[abc"}}, {Exclude: []string{"a/[b"}}} {
+		_, err := NewPathFilter(nil, opts)
+		if fe, ok := err.(*FileError); !ok || fe.Code != ErrInvalidArgument {
+			t.Errorf("glob %+v: expected INVALID_ARGUMENT, got %v", opts, err)
+		}
+	}
+}
+
+func TestSearchModes(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{
+		"a.go": "func Open() {}\nvar opened = open(x)\n// a.b+c\n",
+		"b.go": "type Reader interface {\n\tRead(p []byte)\n}\n",
+		"c.go": "package c\n",
+	})
+
+	search := func(pattern string, opts SearchOptions) *SearchResult {
+		t.Helper()
+		opts.Walk = WalkOptions{}
+		result, err := SearchFilesWithOptions(root, pattern, true, nil, 0, 0, opts)
+		if err != nil {
+			t.Fatalf("search %q: %v", pattern, err)
+		}
+		return result
+	}
+
+	tests := []struct {
+		name    string
+		pattern string
+		opts    SearchOptions
+		want    []string
+	}{
+		{"regex", "open", SearchOptions{}, []string{"a.go:2"}},
+		{"ignore case", "open", SearchOptions{IgnoreCase: true}, []string{"a.go:1", "a.go:2"}},
+		{"whole word", "open", SearchOptions{IgnoreCase: true, WholeWord: true}, []string{"a.go:1", "a.go:2"}},
+		{"whole word excludes longer words", "opened", SearchOptions{WholeWord: true}, []string{"a.go:2"}},
+		{"fixed string", "a.b+c", SearchOptions{FixedString: true}, []string{"a.go:3"}},
+		{"invert", "^\\s*$|package|func|var|//", SearchOptions{Invert: true}, []string{"b.go:1", "b.go:2", "b.go:3"}},
+	}
+	for _, tt := range tests {
+		if got := matchKeys(search(tt.pattern, tt.opts), root); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	// Column offsets point at every occurrence on the line
+	result := search("open", SearchOptions{IgnoreCase: true})
+	if got := result.Matches[1].Submatches; !reflect.DeepEqual(got, []Submatch{{4, 8}, {13, 17}}) {
+		t.Errorf("unexpected submatches %v", got)
+	}
+
+	// Multiline matches report their line span
+	result = search(`interface \{\n\s*Read`, SearchOptions{Multiline: true})
+	if result.Total != 1 {
+		t.Fatalf("expected 1 multiline match, got %d", result.Total)
+	}
+	m := result.Matches[0]
+	if m.Line != 1 || m.EndLine != 2 || m.Content != "type Reader interface {\n\tRead(p []byte)" {
+		t.Errorf("unexpected multiline match %+v", m)
+	}
+	if !reflect.DeepEqual(m.Submatches, []Submatch{{12, 29}}) {
+		t.Errorf("unexpected multiline submatches %v", m.Submatches)
+	}
+
+	// CRLF line endings are matched as \n, including by the prefix check
+	crlf := t.TempDir()
+	writeTree(t, crlf, map[string]string{"d.txt": "alpha\r\nbravo\r\n"})
+	if result, err := SearchFilesWithOptions(crlf, `alpha\nbravo`, true, nil, 0, 0, SearchOptions{Multiline: true}); err != nil || result.Total != 1 {
+		t.Errorf("multiline search of a CRLF file = %+v, %v, want 1 match", result, err)
+	}
+
+	// Output modes
+	result = search("^(func|type|package)", SearchOptions{Output: OutputFilesWithMatches})
+	if len(result.Matches) != 0 || result.Total != 3 || len(result.Files) != 3 || !strings.HasSuffix(result.Files[0], "/a.go") {
+		t.Errorf("unexpected filesWithMatches result %+v", result)
+	}
+	result = search("e", SearchOptions{Output: OutputCount})
+	if result.Total != 5 || len(result.Counts) != 3 || result.Counts[0].Count != 2 || result.Counts[2].Count != 1 {
+		t.Errorf("unexpected count result %+v", result)
+	}
+
+	if _, err := SearchFilesWithOptions(root, "x", true, nil, 0, 0, SearchOptions{Multiline: true, Invert: true}); err == nil {
+		t.Error("expected error for multiline with invert")
+	}
+	if _, err := SearchFilesWithOptions(root, "x", true, nil, 0, 0, SearchOptions{Output: "lines"}); err == nil {
+		t.Error("expected error for unknown output mode")
+	}
+}
@@ -0,0 +1,77 @@
+package files
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// SymlinkInfo describes where a symlink found by a listing points
+type SymlinkInfo struct {
+	Target   string `json:"target"`             // the link's content, as stored
+	RealPath string `json:"realPath,omitempty"` // the file it resolves to
+	Broken   bool   `json:"broken,omitempty"`   // the resolved file does not exist
+}
+
+// RealPath returns path with every symlink resolved. Unlike
+// filepath.EvalSymlinks it accepts paths that do not exist yet: the nearest
+// existing parent is resolved and the missing components are appended, and a
+// dangling symlink resolves to the path it points at. This is the file a
+// write to path would create.
+func RealPath(path string) (string, error) {
+	path = filepath.Clean(path)
+	var missing []string
+	for hops := 0; ; {
+		if real, err := filepath.EvalSymlinks(path); err == nil {
+			return filepath.Join(append([]string{real}, missing...)...), nil
+		}
+
+		if info, err := os.Lstat(path); err == nil && info.Mode()&fs.ModeSymlink != 0 {
+			// A dangling or looping link: carry on from what it points at
+			if hops++; hops > maxSymlinkHops {
+				return "", fmt.Errorf("too many levels of symbolic links: %s", path)
+			}
+			link, err := os.Readlink(path)
+			if err != nil {
+				return "", err
+			}
+			if !filepath.IsAbs(link) {
+				dir, err := filepath.EvalSymlinks(filepath.Dir(path))
+				if err != nil {
+					return "", err
+				}
+				link = filepath.Join(dir, link)
+			}
+			path = filepath.Clean(link)
+			continue
+		}
+
+		parent := filepath.Dir(path)
+		if parent == path {
+			return filepath.Join(append([]string{path}, missing...)...), nil
+		}
+		missing = append([]string{filepath.Base(path)}, missing...)
+		path = parent
+	}
+}
+
+// entrySymlink returns the SymlinkInfo of a listed entry, or nil if it is
+// not a symlink
+func entrySymlink(path string, d fs.DirEntry) *SymlinkInfo {
+	if d.Type()&fs.ModeSymlink == 0 {
+		return nil
+	}
+	target, err := os.Readlink(path)
+	if err != nil {
+		return nil
+	}
+	info := &SymlinkInfo{Target: target}
+	if info.RealPath, err = RealPath(path); err != nil {
+		info.RealPath = ""
+	}
+	if _, err := os.Stat(path); err != nil {
+		info.Broken = true
+	}
+	return info
+}
@@ -0,0 +1,85 @@
+package files
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRealPath(t *testing.T) {
+	root, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	outside, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTree(t, root, map[string]string{"src/a.txt": "a\n"})
+	writeTree(t, outside, map[string]string{"secret.txt": "s\n"})
+	path := func(name string) string { return filepath.Join(root, filepath.FromSlash(name)) }
+	link := func(target, name string) {
+		if err := os.Symlink(target, path(name)); err != nil {
+			t.Skipf("symlinks not supported: %v", err)
+		}
+	}
+	link(filepath.Join(outside, "secret.txt"), "file-link")
+	link(outside, "dir-link")
+	link("src", "relative-link")
+	link(filepath.Join(outside, "new.txt"), "dangling")
+	link("loop", "loop")
+
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"src/a.txt", path("src/a.txt")},
+		{"src/new/b.txt", path("src/new/b.txt")},
+		{"file-link", filepath.Join(outside, "secret.txt")},
+		{"dir-link/secret.txt", filepath.Join(outside, "secret.txt")},
+		{"dir-link/new/c.txt", filepath.Join(outside, "new", "c.txt")},
+		{"relative-link/a.txt", path("src/a.txt")},
+		{"dangling", filepath.Join(outside, "new.txt")},
+	}
+	for _, tt := range tests {
+		got, err := RealPath(path(tt.name))
+		if err != nil || got != tt.want {
+			t.Errorf("RealPath(%s) = %q, %v, want %q", tt.name, got, err, tt.want)
+		}
+	}
+	if _, err := RealPath(path("loop")); err == nil {
+		t.Error("RealPath(loop) succeeded")
+	}
+
+	entries, err := ListFilesWithOptions(root, false, nil, false, WalkOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	links := map[string]*SymlinkInfo{}
+	for _, entry := range entries {
+		links[entry.Name] = entry.Symlink
+	}
+	if info := links["file-link"]; info == nil || info.RealPath != filepath.Join(outside, "secret.txt") || info.Broken {
+		t.Errorf("file-link symlink = %+v", info)
+	}
+	if info := links["dangling"]; info == nil || !info.Broken {
+		t.Errorf("dangling symlink = %+v", info)
+	}
+	if links["src"] != nil {
+		t.Errorf("src symlink = %+v, want nil", links["src"])
+	}
+
+	// Symlinks rejected by the walk options are left out
+	entries, err = ListFilesWithOptions(root, true, nil, false, WalkOptions{Symlink: func(string) bool { return false }})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if entry.Symlink != nil {
+			t.Errorf("rejected symlink %s listed", entry.Name)
+		}
+	}
+	if len(entries) != 2 {
+		t.Errorf("listed %d entries, want src and src/a.txt", len(entries))
+	}
+}
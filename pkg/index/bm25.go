@@ -0,0 +1,437 @@
+package index
+
+import (
+	"math"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/JeremyProffitt/go-mcp-file-context-server/pkg/files"
+	lru "github.com/hashicorp/golang-lru/v2"
+)
+
+// BM25 parameters: k1 limits how much repeating a term keeps adding to a
+// file's score, b how strongly long files are penalized
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+// Defaults for Rank
+const (
+	DefaultRankLimit    = 10
+	DefaultRankSnippets = 3
+)
+
+// DefaultMaxTextIndexes is the number of text indexes kept in memory
+// before the least recently used is dropped
+const DefaultMaxTextIndexes = 8
+
+// textDoc is one file in a text index
+type textDoc struct {
+	size    int64
+	modTime int64
+	length  int            // number of terms
+	terms   map[string]int // term -> occurrences
+}
+
+// TextIndex is an in-memory BM25 index of the files under one root. It is
+// built on first use and, before every query, the directory queried is
+// brought up to date by re-reading files whose size or modification time
+// changed.
+type TextIndex struct {
+	root string
+	opts BuildOptions
+
+	mu       sync.Mutex
+	docs     map[string]*textDoc // slash-separated path relative to root
+	df       map[string]int      // term -> number of files containing it
+	totalLen int
+}
+
+// RankOptions controls a ranked search
+type RankOptions struct {
+	Limit        int // files returned; 0 uses DefaultRankLimit
+	Snippets     int // snippets per file; 0 uses DefaultRankSnippets
+	ContextLines int
+
+	// Keep, if set, is called with each file's path; files it rejects are
+	// left out of the ranking
+	Keep func(path string) bool
+}
+
+// RankedFile is a file ranked by relevance to a query
+type RankedFile struct {
+	Path     string              `json:"path"`
+	Score    float64             `json:"score"`
+	Snippets []files.SearchMatch `json:"snippets"`
+}
+
+// RankResult holds the files most relevant to a query
+type RankResult struct {
+	Terms   []string      `json:"terms"` // query terms after tokenization
+	Files   []RankedFile  `json:"files"`
+	Total   int           `json:"total"`   // files matching any term, before the limit
+	Indexed int           `json:"indexed"` // files in the index
+	Refresh *RefreshStats `json:"refresh"`
+}
+
+// RefreshStats reports what bringing a text index up to date did
+type RefreshStats struct {
+	Added     int    `json:"added"`
+	Updated   int    `json:"updated"`
+	Removed   int    `json:"removed"`
+	Unchanged int    `json:"unchanged"`
+	Duration  string `json:"duration"`
+}
+
+// TextIndexes holds one lazily built TextIndex per root, keeping at most a
+// fixed number of them and dropping the least recently used
+type TextIndexes struct {
+	opts BuildOptions
+
+	mu      sync.Mutex
+	indexes *lru.Cache[string, *TextIndex]
+}
+
+// NewTextIndexes creates an empty set of text indexes built with opts,
+// holding up to maxIndexes of them (0 uses DefaultMaxTextIndexes)
+func NewTextIndexes(opts BuildOptions, maxIndexes int) *TextIndexes {
+	if maxIndexes <= 0 {
+		maxIndexes = DefaultMaxTextIndexes
+	}
+	indexes, _ := lru.New[string, *TextIndex](maxIndexes)
+	return &TextIndexes{opts: opts, indexes: indexes}
+}
+
+// Get returns the text index for root, creating an empty one on first use
+func (t *TextIndexes) Get(root string) *TextIndex {
+	root = filepath.Clean(root)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	idx, ok := t.indexes.Get(root)
+	if !ok {
+		idx = &TextIndex{
+			root: root,
+			opts: t.opts,
+			docs: make(map[string]*textDoc),
+			df:   make(map[string]int),
+		}
+		t.indexes.Add(root, idx)
+	}
+	return idx
+}
+
+// Len returns the number of text indexes held
+func (t *TextIndexes) Len() int {
+	return t.indexes.Len()
+}
+
+// Rank refreshes the files under scope (the root or a directory below it)
+// and returns those most relevant to query, best first, each with the lines
+// that match the most query terms. Term statistics come from the whole
+// index, including files outside scope as they were last refreshed.
+func (idx *TextIndex) Rank(query string, scope string, opts RankOptions) (*RankResult, error) {
+	terms := uniqueTerms(Tokenize(query))
+	if len(terms) == 0 {
+		return nil, &files.FileError{Code: files.ErrInvalidPath, Message: "Query has no searchable terms", Path: scope}
+	}
+	if opts.Limit <= 0 {
+		opts.Limit = DefaultRankLimit
+	}
+	if opts.Snippets <= 0 {
+		opts.Snippets = DefaultRankSnippets
+	}
+
+	prefix, err := filepath.Rel(idx.root, scope)
+	prefix = filepath.ToSlash(prefix)
+	if err != nil || prefix == ".." || strings.HasPrefix(prefix, "../") {
+		return nil, &files.FileError{Code: files.ErrInvalidPath, Message: "Path is outside the indexed root", Path: scope}
+	}
+
+	idx.mu.Lock()
+	stats, err := idx.refresh(prefix)
+	if err != nil {
+		idx.mu.Unlock()
+		return nil, err
+	}
+
+	type scored struct {
+		rel   string
+		score float64
+	}
+	var ranked []scored
+	avgLen := float64(idx.totalLen) / math.Max(float64(len(idx.docs)), 1)
+	for rel, doc := range idx.docs {
+		if !inScope(rel, prefix) {
+			continue
+		}
+		score := idx.score(doc, terms, avgLen)
+		if score <= 0 {
+			continue
+		}
+		if opts.Keep != nil && !opts.Keep(idx.absPath(rel)) {
+			continue
+		}
+		ranked = append(ranked, scored{rel, score})
+	}
+	indexed := len(idx.docs)
+	idx.mu.Unlock()
+
+	sort.Slice(ranked, func(i, j int) bool {
+		if ranked[i].score != ranked[j].score {
+			return ranked[i].score > ranked[j].score
+		}
+		return ranked[i].rel < ranked[j].rel
+	})
+
+	result := &RankResult{Terms: terms, Total: len(ranked), Indexed: indexed, Refresh: stats}
+	if len(ranked) > opts.Limit {
+		ranked = ranked[:opts.Limit]
+	}
+	result.Files = make([]RankedFile, 0, len(ranked))
+	for _, r := range ranked {
+		path := idx.absPath(r.rel)
+		result.Files = append(result.Files, RankedFile{
+			Path:     filepath.ToSlash(path),
+			Score:    math.Round(r.score*1000) / 1000,
+			Snippets: snippets(path, terms, opts.Snippets, opts.ContextLines, idx.maxFileSize()),
+		})
+	}
+	return result, nil
+}
+
+// score computes the BM25 score of a file for the query terms
+func (idx *TextIndex) score(doc *textDoc, terms []string, avgLen float64) float64 {
+	n := float64(len(idx.docs))
+	score := 0.0
+	for _, term := range terms {
+		tf := float64(doc.terms[term])
+		if tf == 0 {
+			continue
+		}
+		df := float64(idx.df[term])
+		idf := math.Log(1 + (n-df+0.5)/(df+0.5))
+		score += idf * tf * (bm25K1 + 1) / (tf + bm25K1*(1-bm25B+bm25B*float64(doc.length)/avgLen))
+	}
+	return score
+}
+
+// refresh re-reads new and modified files under prefix, a slash-separated
+// directory relative to the root or ".", and forgets removed ones. The
+// caller must hold idx.mu.
+func (idx *TextIndex) refresh(prefix string) (*RefreshStats, error) {
+	start := time.Now()
+	walk := idx.opts.Walk
+	walk.Include, walk.Exclude = nil, nil
+	entries, err := files.ListFilesWithOptions(idx.absPath(prefix), true, nil, false, walk)
+	if err != nil {
+		return nil, err
+	}
+
+	stats := &RefreshStats{}
+	seen := make(map[string]bool, len(entries))
+	var changed []string
+	current := make(map[string]*textDoc)
+	for _, entry := range entries {
+		if entry.Metadata.IsDirectory {
+			continue
+		}
+		rel, err := filepath.Rel(idx.root, filepath.FromSlash(entry.Path))
+		if err != nil {
+			continue
+		}
+		rel = filepath.ToSlash(rel)
+		seen[rel] = true
+
+		size, modTime := entry.Metadata.Size, entry.Metadata.ModifiedTime.UnixNano()
+		if doc, ok := idx.docs[rel]; ok {
+			if doc.size == size && doc.modTime == modTime {
+				stats.Unchanged++
+				continue
+			}
+			stats.Updated++
+		} else {
+			stats.Added++
+		}
+		changed = append(changed, rel)
+		current[rel] = &textDoc{size: size, modTime: modTime}
+	}
+
+	for rel, doc := range idx.docs {
+		if inScope(rel, prefix) && !seen[rel] {
+			idx.remove(doc)
+			delete(idx.docs, rel)
+			stats.Removed++
+		}
+	}
+
+	// Tokenize changed files concurrently, then swap them in
+	workers := idx.opts.Workers
+	if workers <= 0 {
+		workers = files.DefaultSearchWorkers
+	}
+	jobs := make(chan string)
+	var wg sync.WaitGroup
+	for i := 0; i < max(workers, 1); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for rel := range jobs {
+				doc := current[rel] // Each worker fills in distinct docs
+				doc.terms, doc.length = fileTerms(idx.absPath(rel), idx.maxFileSize())
+			}
+		}()
+	}
+	for _, rel := range changed {
+		jobs <- rel
+	}
+	close(jobs)
+	wg.Wait()
+
+	for _, rel := range changed {
+		if old, ok := idx.docs[rel]; ok {
+			idx.remove(old)
+		}
+		doc := current[rel]
+		idx.docs[rel] = doc
+		idx.totalLen += doc.length
+		for term := range doc.terms {
+			idx.df[term]++
+		}
+	}
+
+	stats.Duration = time.Since(start).Round(time.Millisecond).String()
+	return stats, nil
+}
+
+// remove subtracts a file's terms from the collection statistics
+func (idx *TextIndex) remove(doc *textDoc) {
+	idx.totalLen -= doc.length
+	for term := range doc.terms {
+		if idx.df[term]--; idx.df[term] <= 0 {
+			delete(idx.df, term)
+		}
+	}
+}
+
+// inScope reports whether the relative path rel is under prefix
+func inScope(rel string, prefix string) bool {
+	return prefix == "." || rel == prefix || strings.HasPrefix(rel, prefix+"/")
+}
+
+func (idx *TextIndex) absPath(rel string) string {
+	return filepath.Join(idx.root, filepath.FromSlash(rel))
+}
+
+func (idx *TextIndex) maxFileSize() int64 {
+	if idx.opts.MaxFileSize > 0 {
+		return idx.opts.MaxFileSize
+	}
+	return DefaultMaxFileSize
+}
+
+// fileTerms counts the terms in a file. Binary, unreadable and oversized
+// files have no terms.
+func fileTerms(path string, maxSize int64) (map[string]int, int) {
+	text, ok := fileText(path, maxSize)
+	if !ok || len(text) == 0 {
+		return nil, 0
+	}
+	terms := make(map[string]int)
+	length := 0
+	tokenSpans(string(text), func(term string, start, end int) {
+		terms[term]++
+		length++
+	})
+	return terms, length
+}
+
+// snippets returns up to n lines of a file that contain the most distinct
+// query terms, best first, with surrounding context. Submatches mark the
+// words holding query terms.
+func snippets(path string, terms []string, n, contextLines int, maxSize int64) []files.SearchMatch {
+	text, ok := fileText(path, maxSize)
+	if !ok || len(text) == 0 {
+		return []files.SearchMatch{}
+	}
+	lines := strings.Split(string(text), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSuffix(line, "\r")
+	}
+
+	want := make(map[string]bool, len(terms))
+	for _, term := range terms {
+		want[term] = true
+	}
+
+	type candidate struct {
+		line     int
+		distinct int
+		hits     int
+		spans    []files.Submatch
+	}
+	var candidates []candidate
+	for i, line := range lines {
+		c := candidate{line: i}
+		found := make(map[string]bool)
+		tokenSpans(line, func(term string, start, end int) {
+			if !want[term] {
+				return
+			}
+			c.hits++
+			if !found[term] {
+				found[term] = true
+				c.distinct++
+			}
+			if len(c.spans) == 0 || c.spans[len(c.spans)-1].Start != start {
+				c.spans = append(c.spans, files.Submatch{Start: start, End: end})
+			}
+		})
+		if c.distinct > 0 {
+			candidates = append(candidates, c)
+		}
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].distinct != candidates[j].distinct {
+			return candidates[i].distinct > candidates[j].distinct
+		}
+		return candidates[i].hits > candidates[j].hits
+	})
+	if len(candidates) > n {
+		candidates = candidates[:n]
+	}
+
+	matches := make([]files.SearchMatch, 0, len(candidates))
+	for _, c := range candidates {
+		before := lines[max(c.line-contextLines, 0):c.line]
+		after := lines[c.line+1 : min(c.line+1+contextLines, len(lines))]
+		matches = append(matches, files.SearchMatch{
+			Path:       filepath.ToSlash(path),
+			Line:       c.line + 1,
+			Content:    lines[c.line],
+			Submatches: c.spans,
+			Context: files.SearchContext{
+				Before: append([]string{}, before...),
+				After:  append([]string{}, after...),
+			},
+		})
+	}
+	return matches
+}
+
+// uniqueTerms removes repeated terms, keeping the first occurrence
+func uniqueTerms(terms []string) []string {
+	seen := make(map[string]bool, len(terms))
+	out := terms[:0]
+	for _, term := range terms {
+		if !seen[term] {
+			seen[term] = true
+			out = append(out, term)
+		}
+	}
+	return out
+}
@@ -0,0 +1,116 @@
+package index
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/JeremyProffitt/go-mcp-file-context-server/pkg/files"
+)
+
+func TestTokenize(t *testing.T) {
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"rateLimiter", []string{"ratelimit", "rate", "limit"}},
+		{"rate_limiting x", []string{"ratelimit", "rate", "limit"}},
+		{"HTTPServer.Listen(8080)", []string{"httpserv", "http", "serv", "listen"}},
+		{"class classes", []string{"class", "classe"}},
+		{"user order", []string{"user", "order"}},
+	}
+	for _, tt := range tests {
+		if got := Tokenize(tt.text); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Tokenize(%q) = %v, want %v", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestRank(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{
+		"limiter/limiter.go": "package limiter\n\n// RateLimiter limits the request rate\ntype RateLimiter struct{}\n\nfunc (l *RateLimiter) Allow() bool {\n\treturn l.rateLimit > 0\n}\n",
+		"server/server.go":   "package server\n\nfunc Serve() {\n\t// apply rate limiting to each request\n\tlimiter.Allow()\n}\n",
+		"docs/intro.md":      "Welcome. This project serves files.\n",
+		"secret/limits.go":   "rate limit rate limit rate limit\n",
+	})
+
+	indexes := NewTextIndexes(BuildOptions{Walk: files.WalkOptions{}}, 0)
+	idx := indexes.Get(root)
+	keep := func(path string) bool { return !strings.Contains(path, "secret") }
+
+	result, err := idx.Rank("rate limiting", root, RankOptions{ContextLines: 1, Keep: keep})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(result.Terms, []string{"rate", "limit"}) {
+		t.Errorf("unexpected terms %v", result.Terms)
+	}
+	if result.Total != 2 || result.Indexed != 4 || result.Refresh.Added != 4 {
+		t.Fatalf("unexpected result %+v", result)
+	}
+	if !strings.HasSuffix(result.Files[0].Path, "limiter/limiter.go") {
+		t.Errorf("expected limiter.go first, got %s", result.Files[0].Path)
+	}
+
+	// The best snippet holds both terms, with context and word offsets
+	snippet := result.Files[1].Snippets[0]
+	if snippet.Line != 4 || snippet.Content != "\t// apply rate limiting to each request" {
+		t.Errorf("unexpected snippet %+v", snippet)
+	}
+	if !reflect.DeepEqual(snippet.Context.Before, []string{"func Serve() {"}) ||
+		!reflect.DeepEqual(snippet.Submatches, []files.Submatch{{Start: 10, End: 14}, {Start: 15, End: 23}}) {
+		t.Errorf("unexpected snippet context %+v", snippet)
+	}
+
+	// Scoping to a directory refreshes only that directory
+	writeTree(t, root, map[string]string{"docs/intro.md": "Requests are rate limited.\n"})
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(filepath.Join(root, "docs/intro.md"), later, later); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.RemoveAll(filepath.Join(root, "server")); err != nil {
+		t.Fatal(err)
+	}
+	result, err = idx.Rank("rate limit", filepath.Join(root, "docs"), RankOptions{Keep: keep})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Refresh.Updated != 1 || result.Refresh.Removed != 0 || result.Refresh.Unchanged != 0 || len(result.Files) != 1 ||
+		!strings.HasSuffix(result.Files[0].Path, "docs/intro.md") {
+		t.Errorf("unexpected result after changes %+v", result)
+	}
+	result, err = idx.Rank("rate limit", root, RankOptions{Keep: keep})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Refresh.Removed != 1 || result.Indexed != 3 {
+		t.Errorf("unexpected result after refreshing the root %+v", result.Refresh)
+	}
+
+	if _, err := idx.Rank("a ! 1", root, RankOptions{}); err == nil {
+		t.Error("expected error for a query without terms")
+	}
+	if _, err := idx.Rank("rate", filepath.Dir(root), RankOptions{}); err == nil {
+		t.Error("expected error for a scope outside the root")
+	}
+}
+
+func TestTextIndexesEvict(t *testing.T) {
+	indexes := NewTextIndexes(BuildOptions{}, 2)
+	first := indexes.Get("/a")
+	indexes.Get("/b")
+	if indexes.Get("/a") != first {
+		t.Error("Get() returned a new index for a held root")
+	}
+	indexes.Get("/c")
+	if n := indexes.Len(); n != 2 {
+		t.Errorf("Len() = %d after three roots, want 2", n)
+	}
+	if indexes.Get("/a") != first {
+		t.Error("the least recently used index was not the one dropped")
+	}
+}
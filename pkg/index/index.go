@@ -0,0 +1,504 @@
+// Package index maintains indexes over the files under a root directory:
+// persistent trigram indexes that narrow the files a regex search has to
+// scan, and in-memory BM25 text indexes that rank files by relevance.
+//
+// A trigram index maps every three-byte sequence (ASCII letters folded to
+// lower case) to the files containing it. A regex is reduced to the
+// trigrams any match must contain, and only files holding them are
+// searched. Files that changed since the index was built, or that it does
+// not know, are always searched, so a stale index only costs speed, never
+// results.
+package index
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/JeremyProffitt/go-mcp-file-context-server/pkg/extract"
+	"github.com/JeremyProffitt/go-mcp-file-context-server/pkg/files"
+)
+
+// formatVersion is bumped whenever the on-disk layout or what is indexed
+// changes; older index files are rebuilt from scratch
+const formatVersion = 2
+
+// DefaultMaxFileSize is the largest file indexed; larger files are recorded
+// but always searched
+const DefaultMaxFileSize = files.DefaultSearchMaxFileSize
+
+// fileRecord is one file known to the index
+type fileRecord struct {
+	Path    string // slash-separated, relative to the root
+	Size    int64
+	ModTime int64 // UnixNano
+	Indexed bool  // false for files too large or unreadable when built
+}
+
+// diskIndex is the gob-encoded form of an Index
+type diskIndex struct {
+	Version  int
+	Root     string
+	BuiltAt  time.Time
+	Files    []fileRecord
+	Postings map[uint32][]uint32
+}
+
+// Index is a trigram index of the files under one root directory
+type Index struct {
+	Root    string
+	BuiltAt time.Time
+
+	files    []fileRecord
+	postings map[uint32][]uint32 // trigram -> sorted file IDs
+	byPath   map[string]uint32
+}
+
+// BuildOptions controls which files an index covers
+type BuildOptions struct {
+	// Walk selects the files indexed; include/exclude globs are ignored so
+	// the index serves every search under the root
+	Walk files.WalkOptions
+
+	// MaxFileSize is the largest file indexed; 0 uses DefaultMaxFileSize
+	MaxFileSize int64
+
+	// Workers is the number of files read concurrently; 0 uses
+	// files.DefaultSearchWorkers
+	Workers int
+}
+
+// BuildStats reports what an index build did
+type BuildStats struct {
+	Root      string `json:"root"`
+	Files     int    `json:"files"`
+	Added     int    `json:"added"`
+	Updated   int    `json:"updated"`
+	Removed   int    `json:"removed"`
+	Unchanged int    `json:"unchanged"`
+	Skipped   int    `json:"skipped"` // files recorded but not indexed
+	Trigrams  int    `json:"trigrams"`
+	Duration  string `json:"duration"`
+}
+
+// Build indexes the files under root. With a previous index, files whose
+// size and modification time are unchanged keep their postings and only
+// new or modified files are read.
+func Build(root string, prev *Index, opts BuildOptions) (*Index, *BuildStats, error) {
+	start := time.Now()
+	if opts.MaxFileSize == 0 {
+		opts.MaxFileSize = DefaultMaxFileSize
+	}
+	workers := opts.Workers
+	if workers <= 0 {
+		workers = files.DefaultSearchWorkers
+	}
+	if workers <= 0 {
+		workers = 1
+	}
+
+	idx, stats, remap, toRead, err := diff(root, prev, opts.Walk)
+	if err != nil {
+		return nil, nil, err
+	}
+	idx.BuiltAt = start
+
+	// Remap the postings of unchanged files; IDs stay sorted because files
+	// keep their relative walk order
+	if prev != nil {
+		for t, ids := range prev.postings {
+			var kept []uint32
+			for _, oldID := range ids {
+				if newID, ok := remap[oldID]; ok {
+					kept = append(kept, newID)
+				}
+			}
+			if len(kept) > 0 {
+				idx.postings[t] = kept
+			}
+		}
+	}
+
+	// Read new and modified files concurrently
+	type readResult struct {
+		id       uint32
+		trigrams []uint32
+		ok       bool
+	}
+	jobs := make(chan uint32)
+	results := make(chan readResult)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for id := range jobs {
+				path := filepath.Join(root, filepath.FromSlash(idx.files[id].Path))
+				trigrams, ok := fileTrigrams(path, opts.MaxFileSize)
+				results <- readResult{id: id, trigrams: trigrams, ok: ok}
+			}
+		}()
+	}
+	go func() {
+		for _, id := range toRead {
+			jobs <- id
+		}
+		close(jobs)
+		wg.Wait()
+		close(results)
+	}()
+
+	for r := range results {
+		idx.files[r.id].Indexed = r.ok
+		if !r.ok {
+			continue
+		}
+		for _, t := range r.trigrams {
+			idx.postings[t] = append(idx.postings[t], r.id)
+		}
+	}
+
+	// Newly read IDs were appended out of order
+	for _, ids := range idx.postings {
+		if !sort.SliceIsSorted(ids, func(i, j int) bool { return ids[i] < ids[j] }) {
+			sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+		}
+	}
+
+	for _, f := range idx.files {
+		if !f.Indexed {
+			stats.Skipped++
+		}
+	}
+	stats.Files = len(idx.files)
+	stats.Trigrams = len(idx.postings)
+	stats.Duration = time.Since(start).Round(time.Millisecond).String()
+	return idx, stats, nil
+}
+
+// diff walks root and compares it with a previous index. It returns the new
+// file table, the previous IDs of unchanged indexed files mapped to their new
+// IDs, and the IDs of files that must be read.
+func diff(root string, prev *Index, walk files.WalkOptions) (*Index, *BuildStats, map[uint32]uint32, []uint32, error) {
+	walk.Include, walk.Exclude = nil, nil
+	entries, err := files.ListFilesWithOptions(root, true, nil, false, walk)
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+
+	idx := &Index{
+		Root:     root,
+		postings: make(map[uint32][]uint32),
+		byPath:   make(map[string]uint32),
+	}
+	stats := &BuildStats{Root: root}
+
+	// Carry over unchanged files and note which need reading
+	remap := make(map[uint32]uint32) // previous ID -> new ID
+	var toRead []uint32
+	for _, entry := range entries {
+		if entry.Metadata.IsDirectory {
+			continue
+		}
+		rel, err := filepath.Rel(root, filepath.FromSlash(entry.Path))
+		if err != nil {
+			continue
+		}
+		record := fileRecord{
+			Path:    filepath.ToSlash(rel),
+			Size:    entry.Metadata.Size,
+			ModTime: entry.Metadata.ModifiedTime.UnixNano(),
+		}
+
+		id := uint32(len(idx.files))
+		if prev != nil {
+			if prevID, ok := prev.byPath[record.Path]; ok {
+				old := prev.files[prevID]
+				if old.Size == record.Size && old.ModTime == record.ModTime {
+					idx.files = append(idx.files, old)
+					idx.byPath[record.Path] = id
+					if old.Indexed {
+						remap[prevID] = id
+					}
+					stats.Unchanged++
+					continue
+				}
+				stats.Updated++
+			} else {
+				stats.Added++
+			}
+		} else {
+			stats.Added++
+		}
+
+		idx.files = append(idx.files, record)
+		idx.byPath[record.Path] = id
+		toRead = append(toRead, id)
+	}
+	if prev != nil {
+		stats.Removed = len(prev.files) - stats.Unchanged - stats.Updated
+	}
+	return idx, stats, remap, toRead, nil
+}
+
+// fileTrigrams returns the distinct trigrams of a file's searchable text.
+// ok is false if the file is too large or can't be read, so it must always
+// be searched.
+func fileTrigrams(path string, maxSize int64) ([]uint32, bool) {
+	text, ok := fileText(path, maxSize)
+	if !ok {
+		return nil, false
+	}
+	return collectTrigrams(text), true
+}
+
+// fileText returns the text search_context would scan in a file: extracted
+// text for documents, nothing for binary files. ok is false if the file is
+// too large or can't be read.
+func fileText(path string, maxSize int64) ([]byte, bool) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, false
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil || !info.Mode().IsRegular() || info.Size() > maxSize {
+		return nil, false
+	}
+
+	if mimeType := files.GetMimeType(path); extract.Supported(mimeType) {
+		raw, err := io.ReadAll(file)
+		if err != nil {
+			return nil, false
+		}
+		text, err := extract.Extract(mimeType, raw)
+		if err != nil {
+			return nil, false
+		}
+		return []byte(text), true
+	}
+
+	data, err := io.ReadAll(bufio.NewReader(file))
+	if err != nil {
+		return nil, false
+	}
+	if files.IsBinaryContent(data) {
+		return nil, true // Binary files are never searched
+	}
+	return data, true
+}
+
+// collectTrigrams returns the distinct folded trigrams in data. Multiline
+// search reads CRLF line endings as \n, so a file with them is also indexed
+// as if it had LF endings.
+func collectTrigrams(data []byte) []uint32 {
+	seen := make(map[uint32]struct{})
+	addTrigrams(seen, data)
+	if bytes.Contains(data, []byte("\r\n")) {
+		addTrigrams(seen, bytes.ReplaceAll(data, []byte("\r\n"), []byte("\n")))
+	}
+
+	out := make([]uint32, 0, len(seen))
+	for t := range seen {
+		out = append(out, t)
+	}
+	return out
+}
+
+// addTrigrams adds the folded trigrams in data to seen
+func addTrigrams(seen map[uint32]struct{}, data []byte) {
+	var a, b byte
+	for i, c := range data {
+		if 'A' <= c && c <= 'Z' {
+			c += 'a' - 'A'
+		}
+		if i >= 2 {
+			seen[packTrigram(a, b, c)] = struct{}{}
+		}
+		a, b = b, c
+	}
+}
+
+// Candidates returns a files.SearchOptions Candidate function that rejects
+// files the index proves cannot match re. ok is false when the regex has no
+// usable trigrams, in which case every file must be searched.
+func (idx *Index) Candidates(re *regexp.Regexp) (func(path string, d fs.DirEntry) bool, bool) {
+	ids, all := idx.eval(regexpQuery(re))
+	if all {
+		return nil, false
+	}
+	matching := make(map[uint32]bool, len(ids))
+	for _, id := range ids {
+		matching[id] = true
+	}
+
+	return func(path string, d fs.DirEntry) bool {
+		rel, err := filepath.Rel(idx.Root, path)
+		if err != nil {
+			return true
+		}
+		id, ok := idx.byPath[filepath.ToSlash(rel)]
+		if !ok {
+			return true // Not indexed: new or outside the indexed walk
+		}
+		record := idx.files[id]
+		if !record.Indexed || stale(record, d) {
+			return true
+		}
+		return matching[id]
+	}, true
+}
+
+// stale reports whether a file may have changed since it was indexed.
+// Symlinks are always treated as stale, since their targets can change
+// without the link's own metadata changing.
+func stale(record fileRecord, d fs.DirEntry) bool {
+	if d.Type()&fs.ModeSymlink != 0 {
+		return true
+	}
+	info, err := d.Info()
+	if err != nil {
+		return true
+	}
+	return info.Size() != record.Size || info.ModTime().UnixNano() != record.ModTime
+}
+
+// eval returns the sorted IDs of files that satisfy q, or all=true if q
+// does not restrict the files
+func (idx *Index) eval(q *query) (ids []uint32, all bool) {
+	switch q.op {
+	case queryAnd:
+		all = true
+		for _, t := range q.trigrams {
+			ids, all = intersect(ids, all, idx.postings[t]), false
+			if len(ids) == 0 {
+				return nil, false
+			}
+		}
+		for _, sub := range q.subs {
+			subIDs, subAll := idx.eval(sub)
+			if subAll {
+				continue
+			}
+			ids, all = intersect(ids, all, subIDs), false
+			if len(ids) == 0 {
+				return nil, false
+			}
+		}
+		return ids, all
+
+	case queryOr:
+		set := make(map[uint32]bool)
+		for _, sub := range q.subs {
+			subIDs, subAll := idx.eval(sub)
+			if subAll {
+				return nil, true
+			}
+			for _, id := range subIDs {
+				set[id] = true
+			}
+		}
+		for id := range set {
+			ids = append(ids, id)
+		}
+		sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+		return ids, false
+	}
+
+	return nil, true
+}
+
+// intersect intersects sorted ID lists; all=true means a is unrestricted
+func intersect(a []uint32, all bool, b []uint32) []uint32 {
+	if all {
+		return b
+	}
+	var out []uint32
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		switch {
+		case a[i] < b[j]:
+			i++
+		case a[i] > b[j]:
+			j++
+		default:
+			out = append(out, a[i])
+			i++
+			j++
+		}
+	}
+	return out
+}
+
+// Save writes the index to path, replacing any existing file atomically
+func (idx *Index) Save(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".index-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	w := bufio.NewWriter(tmp)
+	err = gob.NewEncoder(w).Encode(diskIndex{
+		Version:  formatVersion,
+		Root:     idx.Root,
+		BuiltAt:  idx.BuiltAt,
+		Files:    idx.files,
+		Postings: idx.postings,
+	})
+	if err == nil {
+		err = w.Flush()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Load reads an index written by Save
+func Load(path string) (*Index, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var d diskIndex
+	if err := gob.NewDecoder(bufio.NewReader(file)).Decode(&d); err != nil {
+		return nil, fmt.Errorf("corrupt index %s: %w", path, err)
+	}
+	if d.Version != formatVersion {
+		return nil, fmt.Errorf("index %s has format version %d, expected %d", path, d.Version, formatVersion)
+	}
+
+	idx := &Index{
+		Root:     d.Root,
+		BuiltAt:  d.BuiltAt,
+		files:    d.Files,
+		postings: d.Postings,
+		byPath:   make(map[string]uint32, len(d.Files)),
+	}
+	if idx.postings == nil {
+		idx.postings = make(map[uint32][]uint32)
+	}
+	for i, f := range idx.files {
+		idx.byPath[f.Path] = uint32(i)
+	}
+	return idx, nil
+}
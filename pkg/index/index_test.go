@@ -0,0 +1,220 @@
+package index
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/JeremyProffitt/go-mcp-file-context-server/pkg/files"
+)
+
+func writeTree(t *testing.T, root string, tree map[string]string) {
+	t.Helper()
+	for name, content := range tree {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// candidates returns the root-relative files the index lets a search of
+// pattern through, or nil with ok=false if the pattern is not narrowed
+func candidates(t *testing.T, idx *Index, pattern string) ([]string, bool) {
+	t.Helper()
+	match, ok := idx.Candidates(regexp.MustCompile(pattern))
+	if !ok {
+		return nil, false
+	}
+	var out []string
+	err := filepath.WalkDir(idx.Root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		if match(path, d) {
+			rel, _ := filepath.Rel(idx.Root, path)
+			out = append(out, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(out)
+	return out, true
+}
+
+func TestRegexpQuery(t *testing.T) {
+	tests := []struct {
+		pattern string
+		all     bool
+	}{
+		{"needle", false},
+		{"ab", true},
+		{"foo|bar", false},
+		{"foo|b", true},
+		{"(?i)needle", false},
+		{`func\s+\w+`, false},
+		{`\w+`, true},
+		{"(?:abc)?", true},
+		{"(?i)ünïcode", true},
+	}
+	for _, tt := range tests {
+		q := regexpQuery(regexp.MustCompile(tt.pattern))
+		if got := q.op == queryAll; got != tt.all {
+			t.Errorf("%q: matches all = %v, want %v", tt.pattern, got, tt.all)
+		}
+	}
+
+	// Adjacent literals are merged and folded before splitting into trigrams
+	q := regexpQuery(regexp.MustCompile("ABcd"))
+	want := []uint32{packTrigram('a', 'b', 'c'), packTrigram('b', 'c', 'd')}
+	if !reflect.DeepEqual(q.trigrams, want) {
+		t.Errorf("unexpected trigrams %v", q.trigrams)
+	}
+}
+
+func TestCandidates(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{
+		"a.go":     "package a\nfunc OpenFile() {}\n",
+		"b.go":     "package b\nfunc closeFile() {}\n",
+		"c/d.txt":  "nothing to see\n",
+		"crlf.txt": "alpha\r\nbravo\r\n",
+		"bin.dat":  "OpenFile\x00\x01\x02",
+		"large.go": "func OpenFile() {}\n" + string(make([]byte, 64)),
+	})
+
+	idx, stats, err := Build(root, nil, BuildOptions{Walk: files.WalkOptions{}, MaxFileSize: 64})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Files != 6 || stats.Added != 6 || stats.Skipped != 1 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+
+	tests := []struct {
+		pattern string
+		want    []string
+	}{
+		{"OpenFile", []string{"a.go", "large.go"}},
+		{"(?i)openfile", []string{"a.go", "large.go"}},
+		{"Open|close", []string{"a.go", "b.go", "large.go"}},
+		{`func\s+\w+File`, []string{"a.go", "b.go", "large.go"}},
+		{"missing", []string{"large.go"}},
+		{`alpha\nbravo`, []string{"crlf.txt", "large.go"}},
+		{`alpha\r\nbravo`, []string{"crlf.txt", "large.go"}},
+	}
+	for _, tt := range tests {
+		got, ok := candidates(t, idx, tt.pattern)
+		if !ok || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q: got %v (ok=%v), want %v", tt.pattern, got, ok, tt.want)
+		}
+	}
+	if _, ok := candidates(t, idx, `\d+`); ok {
+		t.Error("expected a pattern without trigrams to search every file")
+	}
+
+	// Modified and new files are searched until the index is rebuilt
+	writeTree(t, root, map[string]string{
+		"c/d.txt": "now mentions OpenFile\n",
+		"e.go":    "OpenFile()\n",
+	})
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(filepath.Join(root, "c/d.txt"), later, later); err != nil {
+		t.Fatal(err)
+	}
+	got, _ := candidates(t, idx, "OpenFile")
+	if want := []string{"a.go", "c/d.txt", "e.go", "large.go"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("after changes got %v, want %v", got, want)
+	}
+}
+
+func TestIncrementalBuild(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{
+		"keep.txt":   "unchanged content\n",
+		"change.txt": "old content\n",
+		"remove.txt": "going away\n",
+	})
+	first, _, err := Build(root, nil, BuildOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeTree(t, root, map[string]string{
+		"change.txt": "new content with marker\n",
+		"add.txt":    "another marker\n",
+	})
+	if err := os.Remove(filepath.Join(root, "remove.txt")); err != nil {
+		t.Fatal(err)
+	}
+
+	idx, stats, err := Build(root, first, BuildOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Added != 1 || stats.Updated != 1 || stats.Removed != 1 || stats.Unchanged != 1 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+
+	for pattern, want := range map[string][]string{
+		"marker":    {"add.txt", "change.txt"},
+		"unchanged": {"keep.txt"},
+		"going":     nil,
+	} {
+		if got, _ := candidates(t, idx, pattern); !reflect.DeepEqual(got, want) {
+			t.Errorf("%q: got %v, want %v", pattern, got, want)
+		}
+	}
+}
+
+func TestManager(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{"sub/a.txt": "needle\n", "b.txt": "hay\n"})
+	m := NewManager(t.TempDir())
+
+	if m.Lookup(filepath.Join(root, "sub")) != nil {
+		t.Fatal("expected no index before building")
+	}
+	if _, err := m.Status(root, BuildOptions{}); !os.IsNotExist(err) {
+		t.Errorf("expected not-exist error, got %v", err)
+	}
+
+	if _, err := m.Build(root, BuildOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// A fresh manager loads the saved index for any path under the root
+	idx := NewManager(m.Dir()).Lookup(filepath.Join(root, "sub"))
+	if idx == nil || idx.Root != root {
+		t.Fatalf("expected index for %s, got %+v", root, idx)
+	}
+	if got, _ := candidates(t, idx, "needle"); !reflect.DeepEqual(got, []string{"sub/a.txt"}) {
+		t.Errorf("loaded index candidates %v", got)
+	}
+
+	writeTree(t, root, map[string]string{"c.txt": "new\n"})
+	status, err := m.Status(root, BuildOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Files != 2 || status.Stale != 1 || status.SizeBytes == 0 {
+		t.Errorf("unexpected status %+v", status)
+	}
+
+	if err := m.Drop(root); err != nil {
+		t.Fatal(err)
+	}
+	if m.Lookup(root) != nil {
+		t.Error("expected no index after dropping")
+	}
+}
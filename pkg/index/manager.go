@@ -0,0 +1,188 @@
+package index
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// DefaultDir returns the default directory for index files
+func DefaultDir(appName string) string {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		// Fallback to current directory
+		return filepath.Join(".", appName, "index")
+	}
+	return filepath.Join(homeDir, appName, "index")
+}
+
+// Status describes a stored index
+type Status struct {
+	Root      string    `json:"root"`
+	IndexFile string    `json:"indexFile"`
+	SizeBytes int64     `json:"sizeBytes"`
+	BuiltAt   time.Time `json:"builtAt"`
+	Files     int       `json:"files"`
+	Skipped   int       `json:"skipped"`
+	Trigrams  int       `json:"trigrams"`
+	Postings  int       `json:"postings"`
+
+	// Stale counts files added, modified or removed since the index was
+	// built; they are searched directly until the index is rebuilt
+	Stale int `json:"stale"`
+}
+
+// Manager stores one index per root directory and keeps loaded indexes in
+// memory
+type Manager struct {
+	dir string
+
+	mu     sync.Mutex
+	loaded map[string]*loadedIndex
+}
+
+// loadedIndex is an index with the modification time of the file it was
+// loaded from, so indexes rebuilt by another process are reloaded
+type loadedIndex struct {
+	index   *Index
+	modTime time.Time
+}
+
+// NewManager creates a Manager storing index files in dir
+func NewManager(dir string) *Manager {
+	return &Manager{dir: dir, loaded: make(map[string]*loadedIndex)}
+}
+
+// Dir returns the directory holding the index files
+func (m *Manager) Dir() string {
+	return m.dir
+}
+
+// indexFile returns the index file path for a root
+func (m *Manager) indexFile(root string) string {
+	sum := sha256.Sum256([]byte(filepath.Clean(root)))
+	return filepath.Join(m.dir, hex.EncodeToString(sum[:8])+".idx")
+}
+
+// Build creates or incrementally updates the index for root and saves it
+func (m *Manager) Build(root string, opts BuildOptions) (*BuildStats, error) {
+	root = filepath.Clean(root)
+
+	prev, _ := m.load(root) // A missing or unreadable index is rebuilt from scratch
+	idx, stats, err := Build(root, prev, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	path := m.indexFile(root)
+	if err := idx.Save(path); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if info, err := os.Stat(path); err == nil {
+		m.loaded[root] = &loadedIndex{index: idx, modTime: info.ModTime()}
+	}
+	return stats, nil
+}
+
+// Status reports on the index for root, walking the root to count stale
+// files. It returns an os.ErrNotExist error if root has no index.
+func (m *Manager) Status(root string, opts BuildOptions) (*Status, error) {
+	root = filepath.Clean(root)
+	idx, err := m.load(root)
+	if err != nil {
+		return nil, err
+	}
+
+	path := m.indexFile(root)
+	status := &Status{
+		Root:      idx.Root,
+		IndexFile: path,
+		BuiltAt:   idx.BuiltAt,
+		Files:     len(idx.files),
+		Trigrams:  len(idx.postings),
+	}
+	if info, err := os.Stat(path); err == nil {
+		status.SizeBytes = info.Size()
+	}
+	for _, f := range idx.files {
+		if !f.Indexed {
+			status.Skipped++
+		}
+	}
+	for _, ids := range idx.postings {
+		status.Postings += len(ids)
+	}
+
+	// Comparing a fresh walk with the index tells which files changed
+	if _, stats, _, _, err := diff(root, idx, opts.Walk); err == nil {
+		status.Stale = stats.Added + stats.Updated + stats.Removed
+	}
+	return status, nil
+}
+
+// Drop deletes the index for root. It returns an os.ErrNotExist error if
+// root has no index.
+func (m *Manager) Drop(root string) error {
+	root = filepath.Clean(root)
+
+	m.mu.Lock()
+	delete(m.loaded, root)
+	m.mu.Unlock()
+
+	return os.Remove(m.indexFile(root))
+}
+
+// Lookup returns the index of the nearest root containing path, or nil if
+// there is none
+func (m *Manager) Lookup(path string) *Index {
+	dir := filepath.Clean(path)
+	for {
+		if idx, err := m.load(dir); err == nil {
+			return idx
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return nil
+		}
+		dir = parent
+	}
+}
+
+// load returns the index for root, reading it from disk if it is not loaded
+// or the file changed since it was loaded
+func (m *Manager) load(root string) (*Index, error) {
+	path := m.indexFile(root)
+	info, err := os.Stat(path)
+	if err != nil {
+		m.mu.Lock()
+		delete(m.loaded, root)
+		m.mu.Unlock()
+		return nil, err
+	}
+
+	m.mu.Lock()
+	cached, ok := m.loaded[root]
+	m.mu.Unlock()
+	if ok && cached.modTime.Equal(info.ModTime()) {
+		return cached.index, nil
+	}
+
+	idx, err := Load(path)
+	if err != nil {
+		return nil, err
+	}
+	if idx.Root != root {
+		return nil, os.ErrNotExist // Hash collision with another root
+	}
+
+	m.mu.Lock()
+	m.loaded[root] = &loadedIndex{index: idx, modTime: info.ModTime()}
+	m.mu.Unlock()
+	return idx, nil
+}
@@ -0,0 +1,159 @@
+package index
+
+import (
+	"regexp"
+	"regexp/syntax"
+	"unicode/utf8"
+)
+
+// queryOp combines the parts of a query
+type queryOp int
+
+const (
+	queryAll queryOp = iota // any file may match
+	queryAnd                // every trigram and sub-query must match
+	queryOr                 // at least one sub-query must match
+)
+
+// query describes which trigrams a file must contain to possibly match a
+// regex. It only ever over-approximates: a file rejected by the query cannot
+// match the regex.
+type query struct {
+	op       queryOp
+	trigrams []uint32
+	subs     []*query
+}
+
+var matchAll = &query{op: queryAll}
+
+// regexpQuery builds the trigram query for re. Literal strings of three or
+// more bytes that every match must contain become required trigrams;
+// alternations become OR queries; anything else matches all files.
+func regexpQuery(re *regexp.Regexp) *query {
+	parsed, err := syntax.Parse(re.String(), syntax.Perl)
+	if err != nil {
+		return matchAll
+	}
+	return analyze(parsed.Simplify())
+}
+
+func analyze(re *syntax.Regexp) *query {
+	switch re.Op {
+	case syntax.OpLiteral:
+		lit, ok := literal(re)
+		if !ok {
+			return matchAll
+		}
+		return literalQuery(lit)
+
+	case syntax.OpConcat:
+		// Adjacent literals form one run, so "ab" + "c" yields trigram "abc"
+		q := &query{op: queryAnd}
+		run := ""
+		flush := func() {
+			q.trigrams = append(q.trigrams, trigramsOf(run)...)
+			run = ""
+		}
+		for _, sub := range re.Sub {
+			if sub.Op == syntax.OpLiteral {
+				if lit, ok := literal(sub); ok {
+					run += lit
+					continue
+				}
+			}
+			flush()
+			if sq := analyze(sub); sq.op != queryAll {
+				q.subs = append(q.subs, sq)
+			}
+		}
+		flush()
+		return q.simplify()
+
+	case syntax.OpCapture, syntax.OpPlus:
+		return analyze(re.Sub[0])
+
+	case syntax.OpRepeat:
+		if re.Min >= 1 {
+			return analyze(re.Sub[0])
+		}
+		return matchAll
+
+	case syntax.OpAlternate:
+		q := &query{op: queryOr}
+		for _, sub := range re.Sub {
+			sq := analyze(sub)
+			if sq.op == queryAll {
+				return matchAll
+			}
+			q.subs = append(q.subs, sq)
+		}
+		return q
+	}
+
+	return matchAll
+}
+
+// literal returns the text of a literal node as it is indexed. Case-folded
+// literals are only usable when they are ASCII, since the index folds ASCII
+// letters only. (This ignores the two non-ASCII runes Unicode folds onto
+// ASCII letters, the Kelvin sign and long s.)
+func literal(re *syntax.Regexp) (string, bool) {
+	s := string(re.Rune)
+	if re.Flags&syntax.FoldCase != 0 {
+		for i := 0; i < len(s); i++ {
+			if s[i] >= utf8.RuneSelf {
+				return "", false
+			}
+		}
+	}
+	return foldASCII(s), true
+}
+
+func literalQuery(lit string) *query {
+	trigrams := trigramsOf(lit)
+	if len(trigrams) == 0 {
+		return matchAll
+	}
+	return &query{op: queryAnd, trigrams: trigrams}
+}
+
+// simplify turns an AND without conditions into matchAll
+func (q *query) simplify() *query {
+	if q.op == queryAnd && len(q.trigrams) == 0 && len(q.subs) == 0 {
+		return matchAll
+	}
+	if q.op == queryAnd && len(q.trigrams) == 0 && len(q.subs) == 1 {
+		return q.subs[0]
+	}
+	return q
+}
+
+// trigramsOf returns the distinct trigrams of an already folded string
+func trigramsOf(s string) []uint32 {
+	var out []uint32
+	seen := make(map[uint32]bool)
+	for i := 0; i+3 <= len(s); i++ {
+		t := packTrigram(s[i], s[i+1], s[i+2])
+		if !seen[t] {
+			seen[t] = true
+			out = append(out, t)
+		}
+	}
+	return out
+}
+
+func packTrigram(a, b, c byte) uint32 {
+	return uint32(a)<<16 | uint32(b)<<8 | uint32(c)
+}
+
+// foldASCII lower-cases ASCII letters, leaving other bytes unchanged so
+// offsets and UTF-8 sequences are preserved
+func foldASCII(s string) string {
+	b := []byte(s)
+	for i, c := range b {
+		if 'A' <= c && c <= 'Z' {
+			b[i] = c + 'a' - 'A'
+		}
+	}
+	return string(b)
+}
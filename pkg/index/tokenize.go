@@ -0,0 +1,122 @@
+package index
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// minTermLength drops one-letter terms, which are mostly loop variables
+// and noise
+const minTermLength = 2
+
+// Tokenize splits text into the terms the text index stores: identifiers
+// and words, lower-cased and lightly stemmed. Identifiers are also split at
+// camelCase humps and underscores, so "rateLimiter" and "rate_limiter"
+// both yield "rate" and "limit" as well as the whole identifier.
+func Tokenize(text string) []string {
+	var terms []string
+	tokenSpans(text, func(term string, start, end int) {
+		terms = append(terms, term)
+	})
+	return terms
+}
+
+// tokenSpans calls fn for each term in text with the byte span of the
+// identifier it came from
+func tokenSpans(text string, fn func(term string, start, end int)) {
+	for i := 0; i < len(text); {
+		r, size := utf8.DecodeRuneInString(text[i:])
+		if !isIdentRune(r) {
+			i += size
+			continue
+		}
+		start := i
+		for i < len(text) {
+			r, size := utf8.DecodeRuneInString(text[i:])
+			if !isIdentRune(r) {
+				break
+			}
+			i += size
+		}
+
+		ident := text[start:i]
+		parts := splitIdentifier(ident)
+		if len(parts) > 1 {
+			emitTerm(strings.Join(parts, ""), start, i, fn)
+		}
+		for _, part := range parts {
+			emitTerm(part, start, i, fn)
+		}
+	}
+}
+
+func emitTerm(word string, start, end int, fn func(term string, start, end int)) {
+	if utf8.RuneCountInString(word) < minTermLength || isNumber(word) {
+		return
+	}
+	fn(stem(word), start, end)
+}
+
+// splitIdentifier splits an identifier into lower-cased words at
+// underscores, lower-to-upper case changes and the end of acronyms
+// ("HTTPServer" -> "http", "server")
+func splitIdentifier(ident string) []string {
+	var parts []string
+	runes := []rune(ident)
+	begin := 0
+	flush := func(end int) {
+		if end > begin {
+			parts = append(parts, strings.ToLower(string(runes[begin:end])))
+		}
+		begin = end
+	}
+	for i, r := range runes {
+		if r == '_' {
+			flush(i)
+			begin = i + 1
+			continue
+		}
+		if i == begin {
+			continue
+		}
+		prev := runes[i-1]
+		switch {
+		case unicode.IsLower(prev) && unicode.IsUpper(r):
+			flush(i)
+		case unicode.IsUpper(prev) && unicode.IsUpper(r) && i+1 < len(runes) && unicode.IsLower(runes[i+1]):
+			flush(i)
+		}
+	}
+	flush(len(runes))
+	return parts
+}
+
+// stem strips a few common English suffixes so "limiting", "limiter" and
+// "limits" share the term "limit". It is deliberately conservative: the
+// remaining stem must keep at least four letters.
+func stem(word string) string {
+	for _, suffix := range []string{"ing", "ers", "er", "ed", "s"} {
+		if !strings.HasSuffix(word, suffix) || len(word)-len(suffix) < 4 {
+			continue
+		}
+		if suffix == "s" && strings.HasSuffix(word, "ss") {
+			return word
+		}
+		return word[:len(word)-len(suffix)]
+	}
+	return word
+}
+
+func isIdentRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+func isNumber(word string) bool {
+	for _, r := range word {
+		if !unicode.IsDigit(r) {
+			return false
+		}
+	}
+	return true
+}
@@ -361,6 +361,12 @@ func (l *Logger) Search(path string, pattern string, resultsCount int, err error
 	}
 }
 
+// SecretFound logs a secret detected in content returned by a tool, and
+// what was done about it (rule and location only, NEVER the secret)
+func (l *Logger) SecretFound(tool string, path string, rule string, line int, action string) {
+	l.Warn("SECRET tool=%q path=%q rule=%q line=%d action=%s", tool, path, rule, line, action)
+}
+
 // CacheHit logs a cache hit
 func (l *Logger) CacheHit(path string) {
 	l.Debug("CACHE_HIT path=%q", path)
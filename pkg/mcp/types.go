@@ -117,11 +117,28 @@ type CallToolParams struct {
 type CallToolResult struct {
 	Content []ContentItem `json:"content"`
 	IsError bool          `json:"isError,omitempty"`
+
+	// StructuredContent is a JSON object returned alongside Content; failed
+	// calls carry their error object here
+	StructuredContent map[string]interface{} `json:"structuredContent,omitempty"`
 }
 
+// ContentItem is a single piece of tool result content: "text", "image"
+// (base64 data with a MIME type) or an embedded "resource"
 type ContentItem struct {
-	Type string `json:"type"`
-	Text string `json:"text,omitempty"`
+	Type     string            `json:"type"`
+	Text     string            `json:"text,omitempty"`
+	Data     string            `json:"data,omitempty"`
+	MimeType string            `json:"mimeType,omitempty"`
+	Resource *ResourceContents `json:"resource,omitempty"`
+}
+
+// ResourceContents is the content of an embedded resource, either text or a base64 blob
+type ResourceContents struct {
+	URI      string `json:"uri"`
+	MimeType string `json:"mimeType,omitempty"`
+	Text     string `json:"text,omitempty"`
+	Blob     string `json:"blob,omitempty"`
 }
 
 // Error codes
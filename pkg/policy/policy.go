@@ -0,0 +1,198 @@
+// Package policy decides which changes the write tools may make: how much
+// content a write may produce, which paths are read-only, which file
+// extensions may be written, and whether content may contain secrets.
+package policy
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/JeremyProffitt/go-mcp-file-context-server/pkg/files"
+	"github.com/JeremyProffitt/go-mcp-file-context-server/pkg/secrets"
+	"github.com/bmatcuk/doublestar/v4"
+)
+
+// DefaultReadOnly are the paths writes may not change unless configured
+// otherwise: lock files, git internals and Go checksums, which tools
+// rather than people should update
+var DefaultReadOnly = []string{
+	"**/*.lock",
+	".git/**",
+	"go.sum",
+}
+
+// DefaultMaxBytes is the largest content a single write may produce
+const DefaultMaxBytes = 10 * 1024 * 1024
+
+// WritePolicy limits what writes may change. A nil *WritePolicy allows
+// everything.
+type WritePolicy struct {
+	// MaxBytes is the largest content a write may produce; 0 is unlimited
+	MaxBytes int64
+
+	// ReadOnly are doublestar globs of paths that may not be created,
+	// changed, moved or deleted. Like blocked patterns, a glob matches a
+	// path if it matches its base name or any trailing part of it, so
+	// ".git/**" covers every repository's .git directory.
+	ReadOnly []string
+
+	// Extensions, if set, are the only file extensions (without the dot,
+	// case-insensitive) that writes may create or change. An empty string
+	// or "." allows files without an extension. Directories are not checked.
+	Extensions []string
+
+	// Secrets, if set, refuses content in which it finds a secret
+	Secrets *secrets.Scanner
+}
+
+// Validate checks that the policy's globs are well-formed
+func (p *WritePolicy) Validate() error {
+	if p == nil {
+		return nil
+	}
+	for _, pattern := range p.ReadOnly {
+		if !doublestar.ValidatePattern(filepath.ToSlash(pattern)) {
+			return fmt.Errorf("invalid read-only glob %q", pattern)
+		}
+	}
+	return nil
+}
+
+// CheckPath refuses changes to a read-only path and, unless isDir, to a
+// file whose extension is not allowed. A path through a symlink is checked
+// as written and as resolved, since writes go through to the target.
+func (p *WritePolicy) CheckPath(path string, isDir bool) error {
+	if p == nil {
+		return nil
+	}
+	if err := p.checkPath(path, isDir); err != nil {
+		return err
+	}
+	if real, err := files.RealPath(path); err == nil && real != filepath.Clean(path) {
+		return p.checkPath(real, isDir)
+	}
+	return nil
+}
+
+// checkPath applies CheckPath to path as given
+func (p *WritePolicy) checkPath(path string, isDir bool) error {
+	if pattern, ok := p.readOnly(path); ok {
+		return &files.FileError{Code: files.ErrReadOnlyPath, Message: fmt.Sprintf("Path matches read-only pattern %q", pattern), Path: path}
+	}
+	if !isDir && len(p.Extensions) > 0 && !p.allowedExtension(path) {
+		return &files.FileError{Code: files.ErrExtension, Message: fmt.Sprintf("Writes are limited to these extensions: %s", strings.Join(p.Extensions, ", ")), Path: path}
+	}
+	return nil
+}
+
+// CheckContent refuses content over MaxBytes or containing a secret
+func (p *WritePolicy) CheckContent(path string, data []byte) error {
+	if p == nil {
+		return nil
+	}
+	if p.MaxBytes > 0 && int64(len(data)) > p.MaxBytes {
+		return tooLarge(path, int64(len(data)), p.MaxBytes)
+	}
+	if findings := p.Secrets.Scan(path, string(data)); len(findings) > 0 {
+		return &files.FileError{
+			Code:    files.ErrSecretDetected,
+			Message: fmt.Sprintf("Content matches secret rules (%s) on line %d", strings.Join(secrets.RuleIDs(findings), ", "), findings[0].Line),
+			Path:    path,
+		}
+	}
+	return nil
+}
+
+// CheckWrite applies CheckPath and CheckContent to a file write
+func (p *WritePolicy) CheckWrite(path string, data []byte) error {
+	if err := p.CheckPath(path, false); err != nil {
+		return err
+	}
+	return p.CheckContent(path, data)
+}
+
+// CheckPlan applies the policy to the files a copy, move or delete would
+// touch, as listed by files.PlanCopy, PlanMove or PlanDelete. Each
+// destination is checked with the content of its source; a move also
+// checks the source it removes.
+func (p *WritePolicy) CheckPlan(planned []files.PlannedFile, move bool) error {
+	if p == nil {
+		return nil
+	}
+	for _, file := range planned {
+		if err := p.CheckPath(file.Path, file.IsDirectory); err != nil {
+			return err
+		}
+		if file.Source == "" {
+			continue
+		}
+		if move {
+			if err := p.CheckPath(file.Source, file.IsDirectory); err != nil {
+				return err
+			}
+		}
+		if file.IsDirectory {
+			continue
+		}
+		if p.MaxBytes > 0 && file.Size > p.MaxBytes {
+			return tooLarge(file.Path, file.Size, p.MaxBytes)
+		}
+		if p.Secrets != nil {
+			data, err := readLimited(file.Source, p.MaxBytes)
+			if err != nil {
+				return &files.FileError{Code: files.ErrUnknown, Message: err.Error(), Path: file.Source}
+			}
+			if err := p.CheckContent(file.Path, data); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// readOnly returns the read-only glob path matches
+func (p *WritePolicy) readOnly(path string) (string, bool) {
+	normalized := filepath.ToSlash(path)
+	parts := strings.Split(normalized, "/")
+	for _, pattern := range p.ReadOnly {
+		pattern = filepath.ToSlash(pattern)
+		for i := range parts {
+			if ok, _ := doublestar.Match(pattern, strings.Join(parts[i:], "/")); ok {
+				return pattern, true
+			}
+		}
+	}
+	return "", false
+}
+
+// allowedExtension reports whether path has one of the allowed extensions
+func (p *WritePolicy) allowedExtension(path string) bool {
+	ext := strings.TrimPrefix(filepath.Ext(path), ".")
+	for _, allowed := range p.Extensions {
+		if strings.EqualFold(strings.TrimPrefix(allowed, "."), ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// tooLarge returns the error for content of size bytes over limit
+func tooLarge(path string, size int64, limit int64) error {
+	return &files.FileError{Code: files.ErrContentTooLarge, Message: fmt.Sprintf("Content is %d bytes, over the %d byte write limit", size, limit), Path: path}
+}
+
+// readLimited reads path, or its first limit+1 bytes when limit is set
+func readLimited(path string, limit int64) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if limit > 0 {
+		return io.ReadAll(io.LimitReader(f, limit+1))
+	}
+	return io.ReadAll(f)
+}
@@ -0,0 +1,67 @@
+package secrets
+
+// DefaultRules detect common credentials. A rules file adds to them unless
+// it sets "defaults" to false.
+var DefaultRules = []Rule{
+	{
+		ID:          "aws-access-key-id",
+		Description: "AWS access key ID",
+		Pattern:     `\b((?:AKIA|ASIA|ABIA|ACCA|AGPA|AIDA|AIPA|ANPA|ANVA|AROA|APKA)[A-Z2-7]{16})\b`,
+		SecretGroup: 1,
+	},
+	{
+		ID:          "aws-secret-access-key",
+		Description: "AWS secret access key assigned to a key-like name",
+		Pattern:     `(?i)aws_?(?:secret_?access_?key|secret_?key|secret)["']?\s*[:=]\s*["']?([A-Za-z0-9/+=]{40})\b`,
+		SecretGroup: 1,
+	},
+	{
+		ID:          "private-key",
+		Description: "PEM private key block",
+		Pattern:     `-----BEGIN[ A-Z0-9]*PRIVATE KEY(?: BLOCK)?-----[\s\S]*?(?:-----END[ A-Z0-9]*PRIVATE KEY(?: BLOCK)?-----|$)`,
+	},
+	{
+		ID:          "jwt",
+		Description: "JSON Web Token",
+		Pattern:     `\beyJ[A-Za-z0-9_-]{8,}\.eyJ[A-Za-z0-9_-]{8,}\.[A-Za-z0-9_-]{8,}`,
+	},
+	{
+		ID:          "github-token",
+		Description: "GitHub personal access, OAuth or app token",
+		Pattern:     `\b(?:gh[pousr]_[A-Za-z0-9]{36,255}|github_pat_[A-Za-z0-9_]{22,255})\b`,
+	},
+	{
+		ID:          "slack-token",
+		Description: "Slack bot, user or app token",
+		Pattern:     `\bxox[abposr]-[A-Za-z0-9-]{10,}`,
+	},
+	{
+		ID:          "generic-secret",
+		Description: "High-entropy value with a digit assigned to a secret-like name",
+		Pattern:     `(?i)(?:secret|token|passw(?:or)?d|pwd|api_?key|access_?key|auth_?key|client_?secret|credentials?)[A-Za-z0-9_.-]*["']?\s*(?::|=|:=|=>)\s*["']?([A-Za-z0-9+/=_~-]*[0-9][A-Za-z0-9+/=_~-]*)`,
+		SecretGroup: 1,
+		MinEntropy:  3.5,
+	},
+	{
+		ID:          "high-entropy-token",
+		Description: "Long random-looking token",
+		Pattern:     `\b([A-Za-z0-9+/_-]{32,}={0,2})`,
+		SecretGroup: 1,
+		MinEntropy:  4.5,
+	},
+}
+
+// DefaultAllowlist skips documentation placeholders and lock files, whose
+// checksums look like random tokens
+var DefaultAllowlist = Allowlist{
+	Regexes: []string{
+		`EXAMPLE`,
+		`(?i)^x{8,}$`,
+	},
+	Paths: []string{
+		"**/go.sum",
+		"**/*.lock",
+		"**/package-lock.json",
+		"**/pnpm-lock.yaml",
+	},
+}
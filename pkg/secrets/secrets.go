@@ -0,0 +1,260 @@
+// Package secrets detects credentials such as cloud access keys, private
+// keys and tokens in text, so they can be redacted before content leaves
+// the server.
+package secrets
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/bmatcuk/doublestar/v4"
+)
+
+// Rule detects one kind of secret
+type Rule struct {
+	ID          string `json:"id"`
+	Description string `json:"description,omitempty"`
+	// Pattern is a Go regular expression matching the secret or the text
+	// around it
+	Pattern string `json:"pattern"`
+	// SecretGroup is the capture group holding the secret itself; 0 is the
+	// whole match
+	SecretGroup int `json:"secretGroup,omitempty"`
+	// MinEntropy is the Shannon entropy, in bits per character, the secret
+	// must reach to count; it keeps placeholders and words from matching
+	// generic rules
+	MinEntropy float64 `json:"minEntropy,omitempty"`
+
+	re *regexp.Regexp
+}
+
+// Allowlist exempts matches from detection
+type Allowlist struct {
+	// Regexes are matched against each secret found; a match is ignored
+	Regexes []string `json:"regexes,omitempty"`
+	// Paths are doublestar globs matched against file paths; files that
+	// match are not scanned
+	Paths []string `json:"paths,omitempty"`
+}
+
+// Config is the content of a rules file
+type Config struct {
+	// Defaults includes DefaultRules and DefaultAllowlist; true if unset
+	Defaults  *bool     `json:"defaults,omitempty"`
+	Rules     []Rule    `json:"rules"`
+	Allowlist Allowlist `json:"allowlist"`
+}
+
+// Finding is one secret found in a piece of text. Start and End are byte
+// offsets (end exclusive) of the secret; the secret itself is never kept.
+type Finding struct {
+	RuleID string `json:"rule"`
+	Line   int    `json:"line"`
+	Start  int    `json:"-"`
+	End    int    `json:"-"`
+}
+
+// Scanner finds secrets in text with a set of rules
+type Scanner struct {
+	rules      []Rule
+	allow      []*regexp.Regexp
+	allowPaths []string
+}
+
+// New compiles the rules and allowlist of cfg into a Scanner
+func New(cfg Config) (*Scanner, error) {
+	rules := cfg.Rules
+	allow := cfg.Allowlist
+	if cfg.Defaults == nil || *cfg.Defaults {
+		rules = append(append([]Rule{}, DefaultRules...), rules...)
+		allow.Regexes = append(append([]string{}, DefaultAllowlist.Regexes...), allow.Regexes...)
+		allow.Paths = append(append([]string{}, DefaultAllowlist.Paths...), allow.Paths...)
+	}
+
+	s := &Scanner{}
+	for _, rule := range rules {
+		if rule.ID == "" {
+			return nil, fmt.Errorf("secret rule with pattern %q has no id", rule.Pattern)
+		}
+		re, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("secret rule %s: %w", rule.ID, err)
+		}
+		if rule.SecretGroup < 0 || rule.SecretGroup > re.NumSubexp() {
+			return nil, fmt.Errorf("secret rule %s: pattern has no group %d", rule.ID, rule.SecretGroup)
+		}
+		rule.re = re
+		s.rules = append(s.rules, rule)
+	}
+	for _, pattern := range allow.Regexes {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("secret allowlist: %w", err)
+		}
+		s.allow = append(s.allow, re)
+	}
+	for _, pattern := range allow.Paths {
+		if !doublestar.ValidatePattern(pattern) {
+			return nil, fmt.Errorf("secret allowlist: invalid path glob %q", pattern)
+		}
+		s.allowPaths = append(s.allowPaths, pattern)
+	}
+	return s, nil
+}
+
+// Load reads a JSON rules file and returns its Scanner. An empty path
+// returns a Scanner with the default rules.
+func Load(path string) (*Scanner, error) {
+	if path == "" {
+		return New(Config{})
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read secret rules: %w", err)
+	}
+	var cfg Config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse secret rules %s: %w", path, err)
+	}
+	return New(cfg)
+}
+
+// Rules returns the number of rules the scanner applies
+func (s *Scanner) Rules() int {
+	return len(s.rules)
+}
+
+// Scan returns the secrets in text, ordered by position, with line numbers
+// counted from 1. path is only used for the path allowlist and may be empty.
+// Overlapping findings of different rules are merged into the first.
+func (s *Scanner) Scan(path string, text string) []Finding {
+	if s == nil || text == "" || s.allowedPath(path) {
+		return nil
+	}
+
+	var findings []Finding
+	for _, rule := range s.rules {
+		for _, loc := range rule.re.FindAllStringSubmatchIndex(text, -1) {
+			start, end := loc[2*rule.SecretGroup], loc[2*rule.SecretGroup+1]
+			if start < 0 || start == end {
+				continue
+			}
+			secret := text[start:end]
+			if rule.MinEntropy > 0 && Entropy(secret) < rule.MinEntropy {
+				continue
+			}
+			if s.allowed(secret) {
+				continue
+			}
+			findings = append(findings, Finding{RuleID: rule.ID, Start: start, End: end})
+		}
+	}
+	if len(findings) == 0 {
+		return nil
+	}
+
+	sort.SliceStable(findings, func(i, j int) bool { return findings[i].Start < findings[j].Start })
+	merged := findings[:1]
+	for _, f := range findings[1:] {
+		last := &merged[len(merged)-1]
+		if f.Start < last.End {
+			if f.End > last.End {
+				last.End = f.End
+			}
+			continue
+		}
+		merged = append(merged, f)
+	}
+
+	line, offset := 1, 0
+	for i := range merged {
+		line += strings.Count(text[offset:merged[i].Start], "\n")
+		offset = merged[i].Start
+		merged[i].Line = line
+	}
+	return merged
+}
+
+// allowed reports whether a secret matches the allowlist
+func (s *Scanner) allowed(secret string) bool {
+	for _, re := range s.allow {
+		if re.MatchString(secret) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowedPath reports whether path matches an allowlisted glob
+func (s *Scanner) allowedPath(path string) bool {
+	if path == "" {
+		return false
+	}
+	path = filepath.ToSlash(path)
+	for _, pattern := range s.allowPaths {
+		if ok, _ := doublestar.Match(pattern, path); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Redact returns text with each finding replaced by a [REDACTED:rule]
+// marker
+func Redact(text string, findings []Finding) string {
+	if len(findings) == 0 {
+		return text
+	}
+	var b strings.Builder
+	offset := 0
+	for _, f := range findings {
+		b.WriteString(text[offset:f.Start])
+		b.WriteString(Marker(f.RuleID))
+		offset = f.End
+	}
+	b.WriteString(text[offset:])
+	return b.String()
+}
+
+// Marker returns the text that replaces a redacted secret
+func Marker(ruleID string) string {
+	return "[REDACTED:" + ruleID + "]"
+}
+
+// RuleIDs returns the distinct rules of findings, in order of appearance
+func RuleIDs(findings []Finding) []string {
+	var ids []string
+	seen := make(map[string]bool)
+	for _, f := range findings {
+		if !seen[f.RuleID] {
+			seen[f.RuleID] = true
+			ids = append(ids, f.RuleID)
+		}
+	}
+	return ids
+}
+
+// Entropy returns the Shannon entropy of s in bits per character
+func Entropy(s string) float64 {
+	if s == "" {
+		return 0
+	}
+	counts := make(map[rune]int)
+	n := 0
+	for _, r := range s {
+		counts[r]++
+		n++
+	}
+	var entropy float64
+	for _, c := range counts {
+		p := float64(c) / float64(n)
+		entropy -= p * math.Log2(p)
+	}
+	return entropy
+}
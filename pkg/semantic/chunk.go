@@ -0,0 +1,132 @@
+package semantic
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/JeremyProffitt/go-mcp-file-context-server/pkg/analysis"
+)
+
+// Chunk sizes, in lines
+const (
+	maxChunkLines = 80 // longer functions are split into pieces this long
+	windowLines   = 40 // files without an outline are split into windows this long
+	minChunkLines = 3  // shorter pieces are merged into the chunk before them
+)
+
+// maxEmbedBytes caps the text embedded for one chunk
+const maxEmbedBytes = 8 * 1024
+
+// Chunk is a range of lines in a file, usually one function or type
+type Chunk struct {
+	Name      string // function or type starting the chunk, if any
+	StartLine int    // 1-based, inclusive
+	EndLine   int    // 1-based, inclusive
+	Text      string
+}
+
+// ChunkFile splits content read from path along the function and type
+// boundaries GenerateOutline finds. Text before the first boundary forms a
+// chunk of its own. Files without an outline are split into fixed windows.
+func ChunkFile(path string, content string) []Chunk {
+	lines := strings.Split(content, "\n")
+	if len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) == 0 {
+		return nil
+	}
+
+	type boundary struct {
+		line int
+		name string
+	}
+	var boundaries []boundary
+	seen := make(map[int]bool)
+	outline := analysis.OutlineContent(path, content)
+	for _, c := range outline.Classes {
+		if !seen[c.Line] {
+			seen[c.Line] = true
+			boundaries = append(boundaries, boundary{c.Line, c.Name})
+		}
+	}
+	for _, f := range outline.Functions {
+		if !seen[f.Line] {
+			seen[f.Line] = true
+			boundaries = append(boundaries, boundary{f.Line, f.Name})
+		}
+	}
+	sort.Slice(boundaries, func(i, j int) bool { return boundaries[i].line < boundaries[j].line })
+
+	// Doc comments, attributes and decorators belong to the declaration below
+	for i := range boundaries {
+		floor := 1
+		if i > 0 {
+			floor = boundaries[i-1].line + 1
+		}
+		for boundaries[i].line > floor && isLeadingLine(lines[boundaries[i].line-2]) {
+			boundaries[i].line--
+		}
+	}
+
+	var chunks []Chunk
+	add := func(name string, start, end, size int) {
+		// Leading and trailing blank lines don't belong to the chunk
+		for start <= end && strings.TrimSpace(lines[start-1]) == "" {
+			start++
+		}
+		for end >= start && strings.TrimSpace(lines[end-1]) == "" {
+			end--
+		}
+		if start > end {
+			return
+		}
+		if n := len(chunks); n > 0 && end-start+1 < minChunkLines && chunks[n-1].EndLine-chunks[n-1].StartLine+1 < size {
+			chunks[n-1].EndLine = end
+			return
+		}
+		for ; start <= end; start += size {
+			chunks = append(chunks, Chunk{Name: name, StartLine: start, EndLine: min(start+size-1, end)})
+		}
+	}
+
+	if len(boundaries) == 0 {
+		add("", 1, len(lines), windowLines)
+	} else {
+		add("", 1, boundaries[0].line-1, maxChunkLines)
+		for i, b := range boundaries {
+			end := len(lines)
+			if i+1 < len(boundaries) {
+				end = boundaries[i+1].line - 1
+			}
+			add(b.name, b.line, end, maxChunkLines)
+		}
+	}
+
+	for i := range chunks {
+		chunks[i].Text = strings.Join(lines[chunks[i].StartLine-1:chunks[i].EndLine], "\n")
+	}
+	return chunks
+}
+
+// isLeadingLine reports whether a line is a comment, attribute or decorator
+// that introduces the declaration after it
+func isLeadingLine(line string) bool {
+	line = strings.TrimSpace(line)
+	for _, prefix := range []string{"//", "/*", "*", "#", "@"} {
+		if strings.HasPrefix(line, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// embedText is the text embedded for a chunk: its path and name give
+// context the body may lack
+func embedText(rel string, chunk Chunk) string {
+	text := rel + " " + chunk.Name + "\n" + chunk.Text
+	if len(text) > maxEmbedBytes {
+		text = strings.ToValidUTF8(text[:maxEmbedBytes], "")
+	}
+	return text
+}
@@ -0,0 +1,240 @@
+// Package semantic implements natural-language search over code: files are
+// split into chunks along outline boundaries, each chunk is turned into a
+// vector by an Embedder, and queries are answered by cosine similarity
+// against a vector index stored on disk.
+package semantic
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/JeremyProffitt/go-mcp-file-context-server/pkg/index"
+)
+
+// Embedder turns texts into fixed-length vectors whose cosine similarity
+// reflects how related the texts are
+type Embedder interface {
+	// Name identifies the embedding model; an index built with a different
+	// name is rebuilt, since vectors from different models don't compare
+	Name() string
+
+	// Embed returns one vector per text, in order
+	Embed(texts []string) ([][]float32, error)
+}
+
+// DefaultHashDimensions is the vector size of the built-in embedder
+const DefaultHashDimensions = 512
+
+// HashEmbedder is an offline Embedder that hashes words and character
+// n-grams into a fixed number of buckets. Words are split with the same
+// code-aware tokenizer as rank_files, so identifiers match their parts;
+// character trigrams add tolerance for spelling and word-form differences.
+// It captures lexical rather than conceptual similarity, but needs no model
+// or network.
+type HashEmbedder struct {
+	dims int
+}
+
+// NewHashEmbedder creates a hashed n-gram embedder with dims dimensions;
+// 0 uses DefaultHashDimensions
+func NewHashEmbedder(dims int) *HashEmbedder {
+	if dims <= 0 {
+		dims = DefaultHashDimensions
+	}
+	return &HashEmbedder{dims: dims}
+}
+
+// Name implements Embedder
+func (e *HashEmbedder) Name() string {
+	return fmt.Sprintf("hash-ngram-%d", e.dims)
+}
+
+// Embed implements Embedder
+func (e *HashEmbedder) Embed(texts []string) ([][]float32, error) {
+	vectors := make([][]float32, len(texts))
+	for i, text := range texts {
+		vectors[i] = e.embed(text)
+	}
+	return vectors, nil
+}
+
+func (e *HashEmbedder) embed(text string) []float32 {
+	counts := make(map[string]float64)
+	for _, term := range index.Tokenize(text) {
+		counts["w:"+term]++
+		padded := "^" + term + "$"
+		for i := 0; i+3 <= len(padded); i++ {
+			counts["g:"+padded[i:i+3]] += 0.5
+		}
+	}
+
+	vector := make([]float32, e.dims)
+	for feature, count := range counts {
+		h := fnv.New64a()
+		h.Write([]byte(feature))
+		sum := h.Sum64()
+
+		// The sign bit keeps colliding features from always adding up
+		weight := float32(1 + math.Log(count))
+		if sum>>63 == 1 {
+			weight = -weight
+		}
+		vector[sum%uint64(e.dims)] += weight
+	}
+	normalize(vector)
+	return vector
+}
+
+// DefaultHTTPBatchSize is the number of texts sent per embedding request
+const DefaultHTTPBatchSize = 32
+
+// HTTPEmbedder calls a local embedding server. Requests are JSON
+// {"model": ..., "input": [...]}; responses may use the Ollama shape
+// {"embeddings": [[...]]} or the OpenAI shape {"data": [{"embedding": [...]}]},
+// so both Ollama's /api/embed and OpenAI-compatible /v1/embeddings
+// endpoints work.
+type HTTPEmbedder struct {
+	url    string
+	model  string
+	remote bool
+	client *http.Client
+}
+
+// NewHTTPEmbedder creates an embedder posting to endpoint with the given
+// model name. The chunks it embeds are file contents, so endpoint must be on
+// this machine (localhost or a loopback address) unless allowRemote is set.
+func NewHTTPEmbedder(endpoint string, model string, allowRemote bool) (*HTTPEmbedder, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid embedding URL %q: expected http(s)://host/path", endpoint)
+	}
+	remote := !isLoopback(u.Hostname())
+	if remote && !allowRemote {
+		return nil, fmt.Errorf("embedding URL %q is not on this machine: file contents would be sent to %s", endpoint, u.Host)
+	}
+	return &HTTPEmbedder{
+		url:    endpoint,
+		model:  model,
+		remote: remote,
+		client: &http.Client{Timeout: 2 * time.Minute},
+	}, nil
+}
+
+// isLoopback reports whether host is localhost or a loopback IP address.
+// Other names are not resolved, since DNS could point them anywhere.
+func isLoopback(host string) bool {
+	if strings.EqualFold(host, "localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// Remote reports whether the embedding server is on another machine
+func (e *HTTPEmbedder) Remote() bool {
+	return e.remote
+}
+
+// Name implements Embedder
+func (e *HTTPEmbedder) Name() string {
+	return fmt.Sprintf("http:%s@%s", e.model, e.url)
+}
+
+// Embed implements Embedder
+func (e *HTTPEmbedder) Embed(texts []string) ([][]float32, error) {
+	vectors := make([][]float32, 0, len(texts))
+	for start := 0; start < len(texts); start += DefaultHTTPBatchSize {
+		end := min(start+DefaultHTTPBatchSize, len(texts))
+		batch, err := e.embedBatch(texts[start:end])
+		if err != nil {
+			return nil, err
+		}
+		vectors = append(vectors, batch...)
+	}
+	return vectors, nil
+}
+
+func (e *HTTPEmbedder) embedBatch(texts []string) ([][]float32, error) {
+	body, err := json.Marshal(map[string]interface{}{"model": e.model, "input": texts})
+	if err != nil {
+		return nil, err
+	}
+	resp, err := e.client.Post(e.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("embedding request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 256<<20))
+	if err != nil {
+		return nil, fmt.Errorf("embedding request failed: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("embedding server returned %s: %s", resp.Status, bytes.TrimSpace(data[:min(len(data), 512)]))
+	}
+
+	var parsed struct {
+		Embeddings [][]float32 `json:"embeddings"`
+		Data       []struct {
+			Index     int       `json:"index"`
+			Embedding []float32 `json:"embedding"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(data, &parsed); err != nil {
+		return nil, fmt.Errorf("invalid embedding response: %w", err)
+	}
+
+	vectors := parsed.Embeddings
+	if vectors == nil && parsed.Data != nil {
+		vectors = make([][]float32, len(parsed.Data))
+		for _, d := range parsed.Data {
+			if d.Index < 0 || d.Index >= len(vectors) {
+				return nil, fmt.Errorf("invalid embedding response: index %d out of range", d.Index)
+			}
+			vectors[d.Index] = d.Embedding
+		}
+	}
+	if len(vectors) != len(texts) {
+		return nil, fmt.Errorf("invalid embedding response: got %d vectors for %d texts", len(vectors), len(texts))
+	}
+	for _, v := range vectors {
+		if len(v) == 0 {
+			return nil, fmt.Errorf("invalid embedding response: empty vector")
+		}
+		normalize(v)
+	}
+	return vectors, nil
+}
+
+// normalize scales v to unit length, so cosine similarity is a dot product
+func normalize(v []float32) {
+	var sum float64
+	for _, x := range v {
+		sum += float64(x) * float64(x)
+	}
+	if sum == 0 {
+		return
+	}
+	scale := float32(1 / math.Sqrt(sum))
+	for i := range v {
+		v[i] *= scale
+	}
+}
+
+// dot returns the dot product of two vectors of equal length
+func dot(a, b []float32) float32 {
+	var sum float32
+	for i := range a {
+		sum += a[i] * b[i]
+	}
+	return sum
+}
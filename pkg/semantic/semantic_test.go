@@ -0,0 +1,204 @@
+package semantic
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/JeremyProffitt/go-mcp-file-context-server/pkg/files"
+)
+
+const limiterSource = `package limiter
+
+import "time"
+
+// RateLimiter throttles requests to a fixed rate
+type RateLimiter struct {
+	rate  int
+	every time.Duration
+}
+
+// Allow reports whether another request may proceed under the rate limit
+func (l *RateLimiter) Allow() bool {
+	return l.rate > 0
+}
+
+func helper() {}
+
+// ParseConfig reads the configuration file
+func ParseConfig(path string) error {
+	return nil
+}
+`
+
+func writeTree(t *testing.T, root string, tree map[string]string) {
+	t.Helper()
+	for name, content := range tree {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestChunkFile(t *testing.T) {
+	type span struct {
+		name       string
+		start, end int
+	}
+	var got []span
+	for _, c := range ChunkFile("limiter.go", limiterSource) {
+		got = append(got, span{c.Name, c.StartLine, c.EndLine})
+	}
+	// Doc comments start their declaration's chunk, and the one-line
+	// helper is merged into the function before it
+	want := []span{{"", 1, 3}, {"RateLimiter", 5, 9}, {"Allow", 11, 16}, {"ParseConfig", 18, 21}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	// Files without an outline are split into windows
+	text := strings.Repeat("line\n", 100)
+	chunks := ChunkFile("notes.txt", text)
+	if len(chunks) != 3 || chunks[1].StartLine != 41 || chunks[2].EndLine != 100 {
+		t.Errorf("unexpected windows %+v", chunks)
+	}
+}
+
+func TestHashEmbedder(t *testing.T) {
+	e := NewHashEmbedder(0)
+	vectors, err := e.Embed([]string{
+		"how are requests rate limited",
+		"type RateLimiter struct { rate int } // throttles requests",
+		"func ParseConfig(path string) reads the configuration file",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(vectors[0]) != DefaultHashDimensions {
+		t.Fatalf("expected %d dimensions, got %d", DefaultHashDimensions, len(vectors[0]))
+	}
+	if related, unrelated := dot(vectors[0], vectors[1]), dot(vectors[0], vectors[2]); related <= unrelated {
+		t.Errorf("expected related text to score higher: %f <= %f", related, unrelated)
+	}
+	if self := dot(vectors[1], vectors[1]); self < 0.999 || self > 1.001 {
+		t.Errorf("expected unit vectors, got norm %f", self)
+	}
+}
+
+func TestHTTPEmbedder(t *testing.T) {
+	var requests []map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&req)
+		requests = append(requests, req)
+		switch r.URL.Path {
+		case "/api/embed":
+			w.Write([]byte(`{"embeddings": [[3, 4], [0, 2]]}`))
+		case "/v1/embeddings":
+			w.Write([]byte(`{"data": [{"index": 1, "embedding": [0, 1]}, {"index": 0, "embedding": [1, 0]}]}`))
+		default:
+			http.Error(w, "no such model", http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	for _, path := range []string{"/api/embed", "/v1/embeddings"} {
+		e, err := NewHTTPEmbedder(server.URL+path, "nomic-embed-text", false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		vectors, err := e.Embed([]string{"a", "b"})
+		if err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		if len(vectors) != 2 || vectors[0][0] <= 0 || vectors[1][1] != 1 {
+			t.Errorf("%s: unexpected vectors %v", path, vectors)
+		}
+	}
+	if requests[0]["model"] != "nomic-embed-text" || len(requests[0]["input"].([]interface{})) != 2 {
+		t.Errorf("unexpected request %v", requests[0])
+	}
+
+	e, _ := NewHTTPEmbedder(server.URL+"/missing", "m", false)
+	if _, err := e.Embed([]string{"a"}); err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("expected a 404 error, got %v", err)
+	}
+	if _, err := NewHTTPEmbedder("localhost:11434", "m", false); err == nil {
+		t.Error("expected error for a URL without scheme")
+	}
+
+	// Chunk text only leaves the machine when remote servers are allowed
+	if _, err := NewHTTPEmbedder("http://localhost:11434/api/embed", "m", false); err != nil {
+		t.Errorf("localhost URL refused: %v", err)
+	}
+	if _, err := NewHTTPEmbedder("http://[::1]:11434/api/embed", "m", false); err != nil {
+		t.Errorf("IPv6 loopback URL refused: %v", err)
+	}
+	if _, err := NewHTTPEmbedder("https://embed.example.com/v1/embeddings", "m", false); err == nil {
+		t.Error("expected error for a remote URL")
+	}
+	if e, err := NewHTTPEmbedder("https://embed.example.com/v1/embeddings", "m", true); err != nil || !e.Remote() {
+		t.Errorf("NewHTTPEmbedder(remote allowed) = %v, %v", e, err)
+	}
+}
+
+func TestSearch(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{
+		"limiter/limiter.go": limiterSource,
+		"docs/guide.md":      "# Guide\n\nInstall the server and edit the configuration file.\n",
+		"secret/keys.go":     "package secret\n\n// RateLimiter rate limit requests throttle\nvar key = 1\n",
+	})
+	dir := t.TempDir()
+	opts := Options{Walk: files.WalkOptions{}}
+	keep := func(path string) bool { return !strings.Contains(path, "secret") }
+
+	x := NewIndexes(dir, NewHashEmbedder(0), opts)
+	result, err := x.Search(root, root, "rate limit requests", SearchOptions{Limit: 2, Keep: keep, IncludeContent: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Refresh.Added != 3 || result.Refresh.Embedded == 0 || len(result.Matches) != 2 {
+		t.Fatalf("unexpected result %+v", result)
+	}
+	best := result.Matches[0]
+	if !strings.HasSuffix(best.Path, "limiter/limiter.go") || best.Name != "RateLimiter" || best.StartLine != 5 || best.EndLine != 9 {
+		t.Errorf("unexpected best match %+v", best)
+	}
+	if !strings.HasPrefix(best.Content, "// RateLimiter throttles") {
+		t.Errorf("unexpected content %q", best.Content)
+	}
+
+	// A new process loads the stored vectors instead of embedding again, and
+	// only the directory searched is refreshed
+	x = NewIndexes(dir, NewHashEmbedder(0), opts)
+	result, err = x.Search(root, filepath.Join(root, "docs"), "configuration file", SearchOptions{Keep: keep})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Refresh.Unchanged != 1 || result.Refresh.Embedded != 0 {
+		t.Errorf("expected the stored index to be reused, got %+v", result.Refresh)
+	}
+	if len(result.Matches) != 1 || !strings.HasSuffix(result.Matches[0].Path, "docs/guide.md") {
+		t.Errorf("expected only the docs chunk, got %+v", result.Matches)
+	}
+
+	// A different embedder rebuilds the index
+	x = NewIndexes(dir, NewHashEmbedder(64), opts)
+	result, err = x.Search(root, root, "configuration", SearchOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Refresh.Added != 3 {
+		t.Errorf("expected a rebuild for a new embedder, got %+v", result.Refresh)
+	}
+}
@@ -0,0 +1,427 @@
+package semantic
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/JeremyProffitt/go-mcp-file-context-server/pkg/files"
+	lru "github.com/hashicorp/golang-lru/v2"
+)
+
+// formatVersion is bumped whenever the on-disk layout changes; older
+// vector files are rebuilt from scratch
+const formatVersion = 1
+
+// Defaults for Search
+const (
+	DefaultLimit       = 10
+	DefaultMaxFileSize = 1024 * 1024 // 1MB; larger files are not chunked
+	DefaultMaxIndexes  = 8           // indexes kept in memory; others stay on disk
+)
+
+// embedBatch is the number of chunks embedded at a time while refreshing
+const embedBatch = 256
+
+// vecChunk is a chunk's location and vector
+type vecChunk struct {
+	Name      string
+	StartLine int
+	EndLine   int
+	Vector    []float32
+}
+
+// vecFile is one file in a vector index. Files that could not be chunked
+// (binary, too large) are kept without chunks so they are not re-read
+// until they change.
+type vecFile struct {
+	Path    string // slash-separated, relative to the root
+	Size    int64
+	ModTime int64 // UnixNano
+	Chunks  []vecChunk
+}
+
+// diskVectors is the gob-encoded form of a vector index
+type diskVectors struct {
+	Version  int
+	Root     string
+	Embedder string
+	BuiltAt  time.Time
+	Files    []vecFile
+}
+
+// Options configures the vector indexes
+type Options struct {
+	Walk        files.WalkOptions
+	MaxFileSize int64 // 0 uses DefaultMaxFileSize
+	MaxIndexes  int   // 0 uses DefaultMaxIndexes
+}
+
+// SearchOptions controls a semantic search
+type SearchOptions struct {
+	Limit          int  // chunks returned; 0 uses DefaultLimit
+	IncludeContent bool // return each chunk's text
+
+	// Keep, if set, is called with each file's path; chunks of files it
+	// rejects are left out of the results
+	Keep func(path string) bool
+}
+
+// Match is a chunk similar to the query
+type Match struct {
+	Path      string  `json:"path"`
+	Name      string  `json:"name,omitempty"`
+	StartLine int     `json:"startLine"`
+	EndLine   int     `json:"endLine"`
+	Score     float64 `json:"score"`
+	Content   string  `json:"content,omitempty"`
+}
+
+// RefreshStats reports what bringing a vector index up to date did
+type RefreshStats struct {
+	Added     int    `json:"added"`
+	Updated   int    `json:"updated"`
+	Removed   int    `json:"removed"`
+	Unchanged int    `json:"unchanged"`
+	Embedded  int    `json:"embedded"` // chunks embedded
+	Duration  string `json:"duration"`
+}
+
+// SearchResult holds the chunks most similar to a query
+type SearchResult struct {
+	Embedder string        `json:"embedder"`
+	Matches  []Match       `json:"matches"`
+	Chunks   int           `json:"chunks"` // chunks compared with the query
+	Refresh  *RefreshStats `json:"refresh"`
+}
+
+// Indexes stores one vector index per root directory on disk, building
+// each on first use and refreshing the directory searched by modification
+// time on every search. The most recently used indexes are kept in memory.
+type Indexes struct {
+	dir      string
+	embedder Embedder
+	opts     Options
+
+	mu     sync.Mutex
+	loaded *lru.Cache[string, *vectorIndex]
+}
+
+// vectorIndex is the in-memory form of a root's vector index
+type vectorIndex struct {
+	mu      sync.Mutex
+	root    string
+	builtAt time.Time
+	files   map[string]*vecFile
+}
+
+// NewIndexes creates vector indexes stored in dir and built with embedder
+func NewIndexes(dir string, embedder Embedder, opts Options) *Indexes {
+	if opts.MaxFileSize <= 0 {
+		opts.MaxFileSize = DefaultMaxFileSize
+	}
+	if opts.MaxIndexes <= 0 {
+		opts.MaxIndexes = DefaultMaxIndexes
+	}
+	loaded, _ := lru.New[string, *vectorIndex](opts.MaxIndexes)
+	return &Indexes{dir: dir, embedder: embedder, opts: opts, loaded: loaded}
+}
+
+// Embedder returns the embedder the indexes are built with
+func (x *Indexes) Embedder() Embedder {
+	return x.embedder
+}
+
+// indexFile returns the vector file path for a root
+func (x *Indexes) indexFile(root string) string {
+	sum := sha256.Sum256([]byte(filepath.Clean(root)))
+	return filepath.Join(x.dir, hex.EncodeToString(sum[:8])+".vec")
+}
+
+// Search refreshes the files under scope (root or a directory below it) in
+// the index of root and returns their chunks most similar to query, best
+// first
+func (x *Indexes) Search(root string, scope string, query string, opts SearchOptions) (*SearchResult, error) {
+	root = filepath.Clean(root)
+	if strings.TrimSpace(query) == "" {
+		return nil, &files.FileError{Code: files.ErrInvalidPath, Message: "Query must not be empty", Path: scope}
+	}
+	if opts.Limit <= 0 {
+		opts.Limit = DefaultLimit
+	}
+	prefix, err := filepath.Rel(root, scope)
+	prefix = filepath.ToSlash(prefix)
+	if err != nil || prefix == ".." || strings.HasPrefix(prefix, "../") {
+		return nil, &files.FileError{Code: files.ErrInvalidPath, Message: "Path is outside the indexed root", Path: scope}
+	}
+
+	idx := x.get(root)
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	stats, err := x.refresh(idx, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	vectors, err := x.embedder.Embed([]string{query})
+	if err != nil {
+		return nil, err
+	}
+	q := vectors[0]
+
+	result := &SearchResult{Embedder: x.embedder.Name(), Refresh: stats}
+	for rel, f := range idx.files {
+		if len(f.Chunks) == 0 {
+			continue
+		}
+		if !inScope(rel, prefix) {
+			continue
+		}
+		path := filepath.Join(root, filepath.FromSlash(rel))
+		if opts.Keep != nil && !opts.Keep(path) {
+			continue
+		}
+		for _, c := range f.Chunks {
+			if len(c.Vector) != len(q) {
+				continue
+			}
+			result.Chunks++
+			result.Matches = append(result.Matches, Match{
+				Path:      filepath.ToSlash(path),
+				Name:      c.Name,
+				StartLine: c.StartLine,
+				EndLine:   c.EndLine,
+				Score:     math.Round(float64(dot(q, c.Vector))*10000) / 10000,
+			})
+		}
+	}
+
+	sort.Slice(result.Matches, func(i, j int) bool {
+		a, b := result.Matches[i], result.Matches[j]
+		if a.Score != b.Score {
+			return a.Score > b.Score
+		}
+		if a.Path != b.Path {
+			return a.Path < b.Path
+		}
+		return a.StartLine < b.StartLine
+	})
+	if len(result.Matches) > opts.Limit {
+		result.Matches = result.Matches[:opts.Limit]
+	}
+	if result.Matches == nil {
+		result.Matches = []Match{}
+	}
+
+	if opts.IncludeContent {
+		for i := range result.Matches {
+			result.Matches[i].Content = chunkContent(result.Matches[i], x.opts.MaxFileSize)
+		}
+	}
+	return result, nil
+}
+
+// get returns the in-memory index for root, loading it from disk if a
+// vector file built with the same embedder exists
+func (x *Indexes) get(root string) *vectorIndex {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if idx, ok := x.loaded.Get(root); ok {
+		return idx
+	}
+
+	idx := &vectorIndex{root: root, files: make(map[string]*vecFile)}
+	if d, err := load(x.indexFile(root)); err == nil && d.Root == root && d.Embedder == x.embedder.Name() {
+		idx.builtAt = d.BuiltAt
+		for i := range d.Files {
+			idx.files[d.Files[i].Path] = &d.Files[i]
+		}
+	}
+	x.loaded.Add(root, idx)
+	return idx
+}
+
+// inScope reports whether the relative path rel is under prefix
+func inScope(rel string, prefix string) bool {
+	return prefix == "." || rel == prefix || strings.HasPrefix(rel, prefix+"/")
+}
+
+// refresh chunks and embeds new and modified files under prefix, a
+// slash-separated directory relative to the root or ".", forgets removed
+// ones and saves the index if anything changed. The caller must hold idx.mu.
+func (x *Indexes) refresh(idx *vectorIndex, prefix string) (*RefreshStats, error) {
+	start := time.Now()
+	walk := x.opts.Walk
+	walk.Include, walk.Exclude = nil, nil
+	entries, err := files.ListFilesWithOptions(filepath.Join(idx.root, filepath.FromSlash(prefix)), true, nil, false, walk)
+	if err != nil {
+		return nil, err
+	}
+
+	stats := &RefreshStats{}
+	seen := make(map[string]bool, len(entries))
+	var changed []*vecFile
+	for _, entry := range entries {
+		if entry.Metadata.IsDirectory {
+			continue
+		}
+		rel, err := filepath.Rel(idx.root, filepath.FromSlash(entry.Path))
+		if err != nil {
+			continue
+		}
+		rel = filepath.ToSlash(rel)
+		seen[rel] = true
+
+		size, modTime := entry.Metadata.Size, entry.Metadata.ModifiedTime.UnixNano()
+		if f, ok := idx.files[rel]; ok {
+			if f.Size == size && f.ModTime == modTime {
+				stats.Unchanged++
+				continue
+			}
+			stats.Updated++
+		} else {
+			stats.Added++
+		}
+		changed = append(changed, &vecFile{Path: rel, Size: size, ModTime: modTime})
+	}
+
+	// Embed changed files in batches; the index only takes them once all
+	// embedding succeeded, so a failing embedding server leaves it intact
+	var pending []*vecChunk
+	var texts []string
+	flush := func() error {
+		if len(texts) == 0 {
+			return nil
+		}
+		vectors, err := x.embedder.Embed(texts)
+		if err != nil {
+			return err
+		}
+		for i, v := range vectors {
+			pending[i].Vector = v
+		}
+		stats.Embedded += len(texts)
+		pending, texts = pending[:0], texts[:0]
+		return nil
+	}
+	for _, f := range changed {
+		content, err := files.ReadFile(filepath.Join(idx.root, filepath.FromSlash(f.Path)), x.opts.MaxFileSize)
+		if err != nil {
+			continue // Binary, too large or unreadable: kept without chunks
+		}
+		chunks := ChunkFile(f.Path, content.Content)
+		f.Chunks = make([]vecChunk, len(chunks))
+		for i, c := range chunks {
+			f.Chunks[i] = vecChunk{Name: c.Name, StartLine: c.StartLine, EndLine: c.EndLine}
+			pending = append(pending, &f.Chunks[i])
+			texts = append(texts, embedText(f.Path, c))
+		}
+		if len(texts) >= embedBatch {
+			if err := flush(); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		return nil, err
+	}
+
+	for rel := range idx.files {
+		if inScope(rel, prefix) && !seen[rel] {
+			delete(idx.files, rel)
+			stats.Removed++
+		}
+	}
+	for _, f := range changed {
+		idx.files[f.Path] = f
+	}
+
+	if len(changed) > 0 || stats.Removed > 0 || idx.builtAt.IsZero() {
+		idx.builtAt = start
+		if err := x.save(idx); err != nil {
+			return nil, err
+		}
+	}
+	stats.Duration = time.Since(start).Round(time.Millisecond).String()
+	return stats, nil
+}
+
+// save writes the index to its vector file, replacing it atomically
+func (x *Indexes) save(idx *vectorIndex) error {
+	path := x.indexFile(idx.root)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	d := diskVectors{
+		Version:  formatVersion,
+		Root:     idx.root,
+		Embedder: x.embedder.Name(),
+		BuiltAt:  idx.builtAt,
+		Files:    make([]vecFile, 0, len(idx.files)),
+	}
+	for _, f := range idx.files {
+		d.Files = append(d.Files, *f)
+	}
+	sort.Slice(d.Files, func(i, j int) bool { return d.Files[i].Path < d.Files[j].Path })
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".vectors-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	w := bufio.NewWriter(tmp)
+	err = gob.NewEncoder(w).Encode(d)
+	if err == nil {
+		err = w.Flush()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// load reads a vector file written by save
+func load(path string) (*diskVectors, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var d diskVectors
+	if err := gob.NewDecoder(bufio.NewReader(file)).Decode(&d); err != nil {
+		return nil, fmt.Errorf("corrupt vector index %s: %w", path, err)
+	}
+	if d.Version != formatVersion {
+		return nil, fmt.Errorf("vector index %s has format version %d, expected %d", path, d.Version, formatVersion)
+	}
+	return &d, nil
+}
+
+// chunkContent re-reads the lines of a matched chunk
+func chunkContent(m Match, maxSize int64) string {
+	content, err := files.ReadFile(filepath.FromSlash(m.Path), maxSize)
+	if err != nil {
+		return ""
+	}
+	lines := strings.Split(content.Content, "\n")
+	if m.StartLine < 1 || m.StartLine > len(lines) {
+		return ""
+	}
+	return strings.Join(lines[m.StartLine-1:min(m.EndLine, len(lines))], "\n")
+}
@@ -0,0 +1,299 @@
+package tokens
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Estimator approximates the number of LLM tokens in a piece of text
+type Estimator interface {
+	// Name returns the estimator identifier (bytes, words, vocab)
+	Name() string
+	// Count returns the estimated number of tokens in text
+	Count(text string) int
+}
+
+// Estimator kinds accepted by NewEstimator
+const (
+	KindBytes = "bytes"
+	KindWords = "words"
+	KindVocab = "vocab"
+)
+
+// DefaultBytesPerToken is the average number of bytes per token for English text and code
+const DefaultBytesPerToken = 4
+
+// NewEstimator creates an estimator of the given kind.
+// vocabPath is only used (and required) for the vocab estimator.
+func NewEstimator(kind string, vocabPath string) (Estimator, error) {
+	switch strings.ToLower(strings.TrimSpace(kind)) {
+	case "", KindBytes:
+		return &ByteEstimator{BytesPerToken: DefaultBytesPerToken}, nil
+	case KindWords:
+		return &WordEstimator{}, nil
+	case KindVocab:
+		if vocabPath == "" {
+			return nil, fmt.Errorf("vocab estimator requires a vocabulary file")
+		}
+		return LoadVocabEstimator(vocabPath)
+	default:
+		return nil, fmt.Errorf("unknown tokenizer %q (expected bytes, words or vocab)", kind)
+	}
+}
+
+// ByteEstimator estimates tokens as a fixed number of bytes per token
+type ByteEstimator struct {
+	BytesPerToken int
+}
+
+// Name returns the estimator identifier
+func (e *ByteEstimator) Name() string {
+	return KindBytes
+}
+
+// Count returns the estimated number of tokens in text
+func (e *ByteEstimator) Count(text string) int {
+	per := e.BytesPerToken
+	if per <= 0 {
+		per = DefaultBytesPerToken
+	}
+	return (len(text) + per - 1) / per
+}
+
+// WordEstimator approximates BPE tokenizers by splitting text into words,
+// numbers and punctuation. Short words count as one token, longer words
+// cost one token per four characters, and each punctuation rune is a token.
+type WordEstimator struct{}
+
+// Name returns the estimator identifier
+func (e *WordEstimator) Name() string {
+	return KindWords
+}
+
+// Count returns the estimated number of tokens in text
+func (e *WordEstimator) Count(text string) int {
+	count := 0
+	for _, piece := range pretokenize(text) {
+		if isWordPiece(piece) {
+			n := utf8.RuneCountInString(strings.TrimLeft(piece, " "))
+			count += (n + 3) / 4
+		} else {
+			count++
+		}
+	}
+	return count
+}
+
+// VocabEstimator counts tokens by greedy longest-match against a local vocabulary
+type VocabEstimator struct {
+	vocab  map[string]struct{}
+	maxLen int
+}
+
+// LoadVocabEstimator loads a vocabulary file with one token per line.
+// Only the first tab-separated field of each line is used, so files of the
+// form "token<TAB>id" are accepted. The byte-level BPE marker "Ġ" (U+0120)
+// is treated as a leading space, matching common BPE vocabulary dumps.
+func LoadVocabEstimator(path string) (*VocabEstimator, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open vocabulary file: %w", err)
+	}
+	defer file.Close()
+
+	e := &VocabEstimator{vocab: make(map[string]struct{})}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		token := scanner.Text()
+		if i := strings.IndexByte(token, '\t'); i >= 0 {
+			token = token[:i]
+		}
+		token = strings.ReplaceAll(token, "Ġ", " ")
+		if token == "" {
+			continue
+		}
+		e.vocab[token] = struct{}{}
+		if len(token) > e.maxLen {
+			e.maxLen = len(token)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read vocabulary file: %w", err)
+	}
+	if len(e.vocab) == 0 {
+		return nil, fmt.Errorf("vocabulary file %q contains no tokens", path)
+	}
+
+	return e, nil
+}
+
+// Name returns the estimator identifier
+func (e *VocabEstimator) Name() string {
+	return KindVocab
+}
+
+// Size returns the number of tokens in the vocabulary
+func (e *VocabEstimator) Size() int {
+	return len(e.vocab)
+}
+
+// Count returns the estimated number of tokens in text
+func (e *VocabEstimator) Count(text string) int {
+	count := 0
+	for _, piece := range pretokenize(text) {
+		for i := 0; i < len(piece); {
+			end := i + e.maxLen
+			if end > len(piece) {
+				end = len(piece)
+			}
+			matched := 0
+			for j := end; j > i; j-- {
+				if _, ok := e.vocab[piece[i:j]]; ok {
+					matched = j - i
+					break
+				}
+			}
+			if matched == 0 {
+				_, size := utf8.DecodeRuneInString(piece[i:])
+				matched = size
+			}
+			i += matched
+			count++
+		}
+	}
+	return count
+}
+
+// pretokenize splits text into pieces the way BPE pre-tokenizers do:
+// words and numbers (with at most one leading space), runs of whitespace,
+// and individual punctuation runes.
+func pretokenize(text string) []string {
+	var pieces []string
+	start := -1
+	for i, r := range text {
+		if isWordRune(r) {
+			if start < 0 {
+				start = i
+				if i > 0 && text[i-1] == ' ' && len(pieces) > 0 && pieces[len(pieces)-1] == " " {
+					pieces = pieces[:len(pieces)-1]
+					start = i - 1
+				}
+			}
+			continue
+		}
+		if start >= 0 {
+			pieces = append(pieces, text[start:i])
+			start = -1
+		}
+		if unicode.IsSpace(r) {
+			if r == ' ' || len(pieces) == 0 || strings.TrimSpace(pieces[len(pieces)-1]) != "" {
+				pieces = append(pieces, string(r))
+			} else {
+				pieces[len(pieces)-1] += string(r)
+			}
+			continue
+		}
+		pieces = append(pieces, string(r))
+	}
+	if start >= 0 {
+		pieces = append(pieces, text[start:])
+	}
+	return pieces
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_'
+}
+
+func isWordPiece(piece string) bool {
+	r, _ := utf8.DecodeRuneInString(strings.TrimLeft(piece, " "))
+	return isWordRune(r)
+}
+
+// Chunk splits text into consecutive chunks of at most maxTokens tokens each.
+// Chunks break on line boundaries where possible; lines that are larger than
+// the budget on their own are split at rune boundaries.
+func Chunk(text string, maxTokens int, est Estimator) []string {
+	if maxTokens <= 0 || text == "" {
+		return []string{text}
+	}
+
+	var chunks []string
+	var current strings.Builder
+	currentTokens := 0
+
+	flush := func() {
+		if current.Len() > 0 {
+			chunks = append(chunks, current.String())
+			current.Reset()
+			currentTokens = 0
+		}
+	}
+
+	for _, line := range strings.SplitAfter(text, "\n") {
+		if line == "" {
+			continue
+		}
+		lineTokens := est.Count(line)
+		if lineTokens > maxTokens {
+			flush()
+			chunks = append(chunks, splitLine(line, maxTokens, est)...)
+			continue
+		}
+		if currentTokens+lineTokens > maxTokens {
+			flush()
+		}
+		current.WriteString(line)
+		currentTokens += lineTokens
+	}
+	flush()
+
+	if len(chunks) == 0 {
+		return []string{""}
+	}
+	return chunks
+}
+
+// splitLine breaks a single oversized line into pieces of at most maxTokens
+func splitLine(line string, maxTokens int, est Estimator) []string {
+	var pieces []string
+	for line != "" {
+		// Binary search for the longest prefix that fits, then align to a rune boundary
+		lo, hi := 0, len(line)
+		for lo < hi {
+			mid := (lo + hi + 1) / 2
+			if est.Count(line[:mid]) <= maxTokens {
+				lo = mid
+			} else {
+				hi = mid - 1
+			}
+		}
+		cut := lo
+		for cut > 0 && cut < len(line) && !utf8.RuneStart(line[cut]) {
+			cut--
+		}
+		if cut == 0 {
+			_, cut = utf8.DecodeRuneInString(line)
+		}
+		pieces = append(pieces, line[:cut])
+		line = line[cut:]
+	}
+	return pieces
+}
+
+// Truncate returns the longest prefix of text, cut on a line boundary where
+// possible, that fits in maxTokens. The second return value reports whether
+// any text was dropped.
+func Truncate(text string, maxTokens int, est Estimator) (string, bool) {
+	if est.Count(text) <= maxTokens {
+		return text, false
+	}
+	if maxTokens <= 0 {
+		return "", true
+	}
+	return Chunk(text, maxTokens, est)[0], true
+}
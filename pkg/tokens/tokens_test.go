@@ -0,0 +1,137 @@
+package tokens
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestNewEstimator(t *testing.T) {
+	tests := []struct {
+		kind     string
+		expected string
+		wantErr  bool
+	}{
+		{"", KindBytes, false},
+		{"bytes", KindBytes, false},
+		{"WORDS", KindWords, false},
+		{"vocab", "", true}, // missing vocabulary file
+		{"unknown", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.kind, func(t *testing.T) {
+			est, err := NewEstimator(tt.kind, "")
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("NewEstimator(%q) expected error", tt.kind)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("NewEstimator(%q) failed: %v", tt.kind, err)
+			}
+			if est.Name() != tt.expected {
+				t.Errorf("Name() = %s, want %s", est.Name(), tt.expected)
+			}
+		})
+	}
+}
+
+func TestByteEstimator(t *testing.T) {
+	est := &ByteEstimator{BytesPerToken: 4}
+
+	if got := est.Count(""); got != 0 {
+		t.Errorf("Count(\"\") = %d, want 0", got)
+	}
+	if got := est.Count("abcde"); got != 2 {
+		t.Errorf("Count(\"abcde\") = %d, want 2", got)
+	}
+}
+
+func TestWordEstimator(t *testing.T) {
+	est := &WordEstimator{}
+
+	// "func" "main" "(" ")" "{" "}" plus whitespace runs
+	got := est.Count("func main() {}")
+	if got < 6 || got > 8 {
+		t.Errorf("Count = %d, want between 6 and 8", got)
+	}
+
+	// Long identifiers cost more than short words
+	if est.Count("internationalization") <= est.Count("go") {
+		t.Error("Expected long word to cost more tokens than a short word")
+	}
+}
+
+func TestVocabEstimator(t *testing.T) {
+	tmpDir := t.TempDir()
+	vocabFile := filepath.Join(tmpDir, "vocab.txt")
+	vocab := "hello\t0\nĠworld\t1\nwor\t2\nld\t3\n"
+	if err := os.WriteFile(vocabFile, []byte(vocab), 0644); err != nil {
+		t.Fatalf("Failed to create vocab file: %v", err)
+	}
+
+	est, err := NewEstimator("vocab", vocabFile)
+	if err != nil {
+		t.Fatalf("NewEstimator failed: %v", err)
+	}
+
+	// "hello" + " world" are both single vocabulary entries
+	if got := est.Count("hello world"); got != 2 {
+		t.Errorf("Count(\"hello world\") = %d, want 2", got)
+	}
+
+	// "world" without a leading space splits into "wor" + "ld"
+	if got := est.Count("world"); got != 2 {
+		t.Errorf("Count(\"world\") = %d, want 2", got)
+	}
+
+	// Unknown characters cost one token each
+	if got := est.Count("xyz"); got != 3 {
+		t.Errorf("Count(\"xyz\") = %d, want 3", got)
+	}
+}
+
+func TestChunk(t *testing.T) {
+	est := &ByteEstimator{BytesPerToken: 1}
+	text := strings.Repeat("line\n", 10) // 50 bytes
+
+	chunks := Chunk(text, 12, est)
+	if len(chunks) != 5 {
+		t.Fatalf("Expected 5 chunks, got %d", len(chunks))
+	}
+	if strings.Join(chunks, "") != text {
+		t.Error("Chunks do not reassemble to the original text")
+	}
+	for i, chunk := range chunks {
+		if est.Count(chunk) > 12 {
+			t.Errorf("Chunk %d has %d tokens, want <= 12", i, est.Count(chunk))
+		}
+	}
+
+	// A single line longer than the budget is split
+	long := strings.Repeat("x", 25)
+	chunks = Chunk(long, 10, est)
+	if len(chunks) != 3 {
+		t.Errorf("Expected 3 chunks for long line, got %d", len(chunks))
+	}
+	if strings.Join(chunks, "") != long {
+		t.Error("Long line chunks do not reassemble to the original text")
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	est := &ByteEstimator{BytesPerToken: 1}
+
+	text, truncated := Truncate("short", 10, est)
+	if truncated || text != "short" {
+		t.Errorf("Truncate short text = (%q, %v), want (\"short\", false)", text, truncated)
+	}
+
+	text, truncated = Truncate("aaaa\nbbbb\ncccc\n", 10, est)
+	if !truncated || text != "aaaa\nbbbb\n" {
+		t.Errorf("Truncate = (%q, %v), want (\"aaaa\\nbbbb\\n\", true)", text, truncated)
+	}
+}
@@ -1808,6 +1808,54 @@ function deprecated() {
 		}
 	}
 
+	return setupSearchCorpus(testDir)
+}
+
+// setupSearchCorpus creates a larger tree for the search engine benchmarks:
+// 1000 source-like files, a 5MB log and a few binary files. It lives outside
+// the bench directory so the other benchmarks keep their sizes.
+func setupSearchCorpus(testDir string) error {
+	corpusDir := filepath.Join(testDir, "search_corpus")
+
+	body := strings.Repeat(`func handleUserRequest(w http.ResponseWriter, r *http.Request) {
+	if err := validate(r); err != nil {
+		log.Printf("error: invalid request: %v", err)
+		return
+	}
+	process(r)
+}
+
+`, 40)
+
+	for i := 0; i < 20; i++ {
+		pkgDir := filepath.Join(corpusDir, fmt.Sprintf("pkg%02d", i))
+		if err := os.MkdirAll(pkgDir, 0755); err != nil {
+			return err
+		}
+		for j := 0; j < 50; j++ {
+			content := fmt.Sprintf("package pkg%02d\n\n%s", i, body)
+			if (i*50+j)%250 == 0 {
+				content += "// RARE_TOKEN_42 marks this file\n"
+			}
+			if err := os.WriteFile(filepath.Join(pkgDir, fmt.Sprintf("file%02d.go", j)), []byte(content), 0644); err != nil {
+				return err
+			}
+		}
+
+		binary := make([]byte, 64*1024)
+		for k := range binary {
+			binary[k] = byte(k % 7)
+		}
+		if err := os.WriteFile(filepath.Join(pkgDir, "data.bin"), binary, 0644); err != nil {
+			return err
+		}
+	}
+
+	logContent := strings.Repeat("2024-01-01T00:00:00Z INFO request served in 12ms path=/api/items status=200\n", 65000)
+	if err := os.WriteFile(filepath.Join(corpusDir, "server.log"), []byte(logContent), 0644); err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -1998,6 +2046,56 @@ func runSearchBenchmarks(b *Benchmarker, testDir string, iterations int) {
 		}
 		return 0, nil
 	})
+
+	// Search engine benchmarks over the larger corpus
+	corpusDir := filepath.Join(testDir, "search_corpus")
+	corpusSearches := []struct {
+		name string
+		args map[string]interface{}
+	}{
+		// Literal pattern found in only a few files: every file is scanned
+		{"search_context: corpus rare literal", map[string]interface{}{
+			"pattern":    "RARE_TOKEN_42",
+			"maxResults": 10000,
+		}},
+		// Common pattern with a small limit: the search stops early
+		{"search_context: corpus early termination", map[string]interface{}{
+			"pattern":    "handleUserRequest",
+			"maxResults": 10,
+		}},
+		// Regex with a literal prefix, filtered before the regex runs
+		{"search_context: corpus prefixed regex", map[string]interface{}{
+			"pattern":    "handle\\w+Request\\(",
+			"maxResults": 10000,
+		}},
+		// Case-insensitive regex, which has no literal prefix
+		{"search_context: corpus case-insensitive regex", map[string]interface{}{
+			"pattern":    "(?i)INVALID REQUEST",
+			"maxResults": 10000,
+		}},
+		// Single large file scanned line by line
+		{"search_context: corpus large log file", map[string]interface{}{
+			"pattern":    "status=500",
+			"fileTypes":  []string{"log"},
+			"maxResults": 10000,
+		}},
+	}
+	for _, search := range corpusSearches {
+		args := search.args
+		args["path"] = corpusDir
+		args["recursive"] = true
+		args["contextLines"] = 0
+		b.Run(search.name, iterations, func() (int64, error) {
+			resp, err := b.client.CallTool("search_context", args)
+			if err != nil {
+				return 0, err
+			}
+			if resp.Error != nil {
+				return 0, fmt.Errorf("%s", resp.Error.Message)
+			}
+			return 0, nil
+		})
+	}
 }
 
 func runAnalysisBenchmarks(b *Benchmarker, testDir string, iterations int) {